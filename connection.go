@@ -18,12 +18,12 @@ import (
 	"context"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"os"
-	"path"
-	"runtime"
 	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
 
 	"github.com/jacobsa/fuse/fuseops"
 	"github.com/jacobsa/fuse/internal/buffer"
@@ -57,26 +57,112 @@ const maxReadahead = 1 << 20
 // Connection represents a connection to the fuse kernel process. It is used to
 // receive and reply to requests from the kernel.
 type Connection struct {
-	cfg         MountConfig
-	debugLogger *log.Logger
-	errorLogger *log.Logger
+	cfg MountConfig
 
-	// The device through which we're talking to the kernel, and the protocol
-	// version that we're using to talk to it.
-	dev      *os.File
+	// The handler to which we log, or nil. See MountConfig.LogHandler.
+	logHandler slog.Handler
+
+	// The protocol version that we're using to talk to the kernel.
 	protocol fusekernel.Protocol
 
+	// The readers through which we're talking to the kernel, one per
+	// /dev/fuse descriptor. The first uses the descriptor we were created
+	// with; the rest use clones of it (see MountConfig.NumReaders). Readers
+	// not currently in use by ReadOp wait in idleReaders.
+	readers     []*reader
+	idleReaders chan *reader
+
+	// Whether we read requests and write ReadFileOp replies using splice(2).
+	// See MountConfig.EnableSpliceWrites and EnableSpliceReads.
+	spliceWrites bool
+	spliceReads  bool
+
+	// Whether the kernel accepts backing files for passthrough. See
+	// MountConfig.EnablePassthrough.
+	passthrough bool
+
+	// Whether the kernel sends SetxattrOp in its extended form. See
+	// MountConfig.EnableSetxattrExt.
+	setxattrExt bool
+
 	mu sync.Mutex
 
-	// A map from fuse "unique" request ID (*not* the op ID for logging used
-	// above) to a function that cancel's its associated context.
+	// A map from fuse "unique" request ID to the state of the op, including a
+	// function that cancels its associated context.
+	//
+	// GUARDED_BY(mu)
+	inFlight map[uint64]*inFlightOp
+
+	// Interrupts for requests that another reader may have read but not yet
+	// recorded in inFlight, each with the readers that might have done so.
+	// See handleInterrupt.
+	//
+	// GUARDED_BY(mu)
+	parkedInterrupts map[uint64]map[*reader]bool
+
+	// The handles that the file system has handed out and the kernel not yet
+	// released. See drain.go.
+	//
+	// GUARDED_BY(mu)
+	openHandles map[openHandleKey]*openHandle
+
+	// Non-nil once MountedFileSystem.Shutdown has been called, until
+	// MountedFileSystem.CancelShutdown is, and closed once there are no ops in
+	// flight and no open handles.
 	//
 	// GUARDED_BY(mu)
-	cancelFuncs map[uint64]func()
+	drained chan struct{}
+
+	// Closed by close, to stop the watchdog.
+	closed chan struct{}
+}
+
+// State kept for each op returned by ReadOp, other than ForgetInodeOp, until
+// it is replied to.
+type inFlightOp struct {
+	opCode uint32
+	fuseID uint64
+	inode  fuseops.InodeID
+	op     interface{}
+	start  time.Time
+
+	// Cancels the op's context.
+	cancel func()
+
+	// The op's timeout (see MountConfig.OpTimeout), or zero, and the timer
+	// enforcing it.
+	timeout time.Duration
+	timer   *time.Timer
+
+	// Set by whichever of Reply and the timer gets to the op first. The other
+	// leaves the kernel alone.
+	replied atomic.Bool
+
+	// Whether the watchdog has reported the op as stuck.
+	//
+	// GUARDED_BY(Connection.mu)
+	reported bool
+}
+
+// A single /dev/fuse descriptor along with the buffers used to talk over it.
+// Each reader has its own freelists so that concurrent calls to ReadOp don't
+// contend with each other.
+type reader struct {
+	dev *os.File
+
+	// Whether a call to ReadOp is using the reader, and has yet to record the
+	// op for the message it is reading in Connection.inFlight. Only tracked
+	// when there are several readers; see Connection.handleInterrupt.
+	//
+	// GUARDED_BY(Connection.mu)
+	reading bool
+
+	mu sync.Mutex
 
 	// Freelists, serviced by freelists.go.
 	inMessages  freelist.Freelist // GUARDED_BY(mu)
 	outMessages freelist.Freelist // GUARDED_BY(mu)
+	pipes       freelist.Freelist // GUARDED_BY(mu)
 }
 
 // State that is maintained for each in-flight op. This is stuffed into the
@@ -85,34 +171,98 @@ type opState struct {
 	inMsg  *buffer.InMessage
 	outMsg *buffer.OutMessage
 	op     interface{}
+
+	// The reader from which the op was read. The kernel expects the reply on
+	// the same descriptor.
+	reader *reader
+
+	// The pipe holding the data for a spliced WriteFileOp, or nil.
+	pipe *pipe
+
+	// The op's entry in Connection.inFlight, or nil for ForgetInodeOp.
+	flight *inFlightOp
+
+	// When the op was read from the kernel.
+	start time.Time
+
+	// The information given to MountConfig.OpTracer when the op started.
+	// Unused if there is no tracer.
+	traceInfo OpInfo
 }
 
 // Create a connection wrapping the supplied file descriptor connected to the
 // kernel. You must eventually call c.close().
 //
-// The loggers may be nil.
+// The log handler may be nil.
 func newConnection(
 	cfg MountConfig,
-	debugLogger *log.Logger,
-	errorLogger *log.Logger,
+	logHandler slog.Handler,
 	dev *os.File) (*Connection, error) {
+	numReaders := cfg.NumReaders
+	if numReaders < 1 {
+		numReaders = 1
+	}
+
 	c := &Connection{
 		cfg:         cfg,
-		debugLogger: debugLogger,
-		errorLogger: errorLogger,
-		dev:         dev,
-		cancelFuncs: make(map[uint64]func()),
+		logHandler:  logHandler,
+		readers:     []*reader{{dev: dev}},
+		idleReaders: make(chan *reader, numReaders),
+		inFlight:    make(map[uint64]*inFlightOp),
+		closed:      make(chan struct{}),
 	}
 
+	c.idleReaders <- c.readers[0]
+
 	// Initialize.
 	if err := c.Init(); err != nil {
 		c.close()
 		return nil, fmt.Errorf("Init: %v", err)
 	}
 
+	// Set up any additional readers. The kernel won't accept clones of the
+	// device until it has been initialized.
+	for len(c.readers) < numReaders {
+		clone, err := cloneDevice(dev)
+		if err != nil {
+			c.close()
+			return nil, fmt.Errorf("cloneDevice: %v", err)
+		}
+
+		r := &reader{dev: clone}
+		c.readers = append(c.readers, r)
+		c.idleReaders <- r
+	}
+
+	// Make sure that we can create pipes large enough for splicing, falling
+	// back to ordinary reads and writes if not.
+	if c.spliceWrites || c.spliceReads {
+		r := c.readers[0]
+		p, err := r.getPipe()
+		if err != nil {
+			c.log(slog.LevelError, 1, "Not splicing", slog.Any(logKeyError, err))
+
+			c.spliceWrites = false
+			c.spliceReads = false
+		} else {
+			r.putPipe(p)
+		}
+	}
+
+	if cfg.WatchdogThreshold > 0 {
+		go c.watchdog(cfg.WatchdogThreshold)
+	}
+
 	return c, nil
 }
 
+// NumReaders returns the number of calls to ReadOp that may proceed
+// concurrently, as configured by MountConfig.NumReaders. A Server should run
+// this many loops calling ReadOp in order to make full use of the connection.
+func (c *Connection) NumReaders() int {
+	return len(c.readers)
+}
+
 // Init performs the work necessary to cause the mount process to complete.
 func (c *Connection) Init() error {
 	// Read the init op.
@@ -151,6 +301,17 @@ func (c *Connection) Init() error {
 	cacheSymlinks := initOp.Flags&fusekernel.InitCacheSymlinks > 0
 	noOpenSupport := initOp.Flags&fusekernel.InitNoOpenSupport > 0
 	noOpendirSupport := initOp.Flags&fusekernel.InitNoOpendirSupport > 0
+	posixLocks := initOp.Flags&fusekernel.InitPosixLocks > 0
+	flockLocks := initOp.Flags&fusekernel.InitFlockLocks > 0
+	readdirplus := initOp.Flags&fusekernel.InitDoReaddirplus > 0
+	readdirplusAuto := initOp.Flags&fusekernel.InitReaddirplusAuto > 0
+	initExt := initOp.Flags&fusekernel.InitInitExt > 0
+	handleKillprivV2 := initOp.Flags&fusekernel.InitHandleKillprivV2 > 0
+	setxattrExt := initOp.Flags&fusekernel.InitSetxattrExt > 0
+	inodeDAX := initOp.Flags&fusekernel.InitHasInodeDAX > 0
+	spliceRead := initOp.Flags&fusekernel.InitSpliceRead > 0
+	spliceWrite := initOp.Flags&fusekernel.InitSpliceWrite > 0
+	passthrough := initOp.Flags&fusekernel.InitPassthrough > 0
 
 	// Respond to the init op.
 	initOp.Library = c.protocol
@@ -170,8 +331,15 @@ func (c *Connection) Init() error {
 	initOp.Flags |= fusekernel.InitMaxPages
 	initOp.MaxPages = 256
 
+	// Passthrough can't be combined with writeback caching, so decide on it
+	// first. Its flag lives above bit 31, and so needs the init extension.
+	c.passthrough = c.cfg.EnablePassthrough &&
+		c.protocol.HasPassthrough() &&
+		initExt &&
+		passthrough
+
 	// Enable writeback caching if the user hasn't asked us not to.
-	if !c.cfg.DisableWritebackCaching {
+	if !c.cfg.DisableWritebackCaching && !c.passthrough {
 		initOp.Flags |= fusekernel.InitWritebackCache
 	}
 
@@ -193,67 +361,106 @@ func (c *Connection) Init() error {
 		initOp.Flags |= fusekernel.InitNoOpendirSupport
 	}
 
-	c.Reply(ctx, nil)
-	return nil
-}
+	// Tell the kernel to send POSIX lock requests to us rather than handling
+	// them locally, if the user opted into it.
+	if c.cfg.EnablePosixLocks && posixLocks {
+		initOp.Flags |= fusekernel.InitPosixLocks
+	}
 
-// Log information for an operation with the given ID. calldepth is the depth
-// to use when recovering file:line information with runtime.Caller.
-func (c *Connection) debugLog(
-	fuseID uint64,
-	calldepth int,
-	format string,
-	v ...interface{}) {
-	if c.debugLogger == nil {
-		return
+	// Likewise for flock(2) locks (Linux >= 3.1).
+	if c.cfg.EnableFlockLocks && flockLocks {
+		initOp.Flags |= fusekernel.InitFlockLocks
 	}
 
-	// Get file:line info.
-	var file string
-	var line int
-	var ok bool
+	// Send directory entries along with their attributes, if the user opted
+	// into it (Linux >= 3.9). Auto mode only makes sense on top of that.
+	if c.cfg.EnableReaddirplus && readdirplus {
+		initOp.Flags |= fusekernel.InitDoReaddirplus
 
-	_, file, line, ok = runtime.Caller(calldepth)
-	if !ok {
-		file = "???"
+		if c.cfg.EnableReaddirplusAuto && readdirplusAuto {
+			initOp.Flags |= fusekernel.InitReaddirplusAuto
+		}
 	}
 
-	fileLine := fmt.Sprintf("%v:%v", path.Base(file), line)
+	// The remaining flags are Linux-specific, and only meaningful with a
+	// protocol version new enough to define them; OS X uses some of the same
+	// bits for other purposes.
+	if c.protocol.HasInitExt() && initExt {
+		// Required in order to send flags above bit 31.
+		initOp.Flags |= fusekernel.InitInitExt
+	}
 
-	// Format the actual message to be printed.
-	msg := fmt.Sprintf(
-		"Op 0x%08x %24s] %v",
-		fuseID,
-		fileLine,
-		fmt.Sprintf(format, v...))
+	if c.cfg.EnableHandleKillprivV2 && c.protocol.HasHandleKillprivV2() && handleKillprivV2 {
+		initOp.Flags |= fusekernel.InitHandleKillprivV2
+	}
 
-	// Print it.
-	c.debugLogger.Println(msg)
+	if c.cfg.EnableSetxattrExt && c.protocol.HasSetxattrExt() && setxattrExt {
+		initOp.Flags |= fusekernel.InitSetxattrExt
+		c.setxattrExt = true
+	}
+
+	if c.cfg.EnableInodeDAX && c.protocol.HasInodeDAX() && inodeDAX {
+		initOp.Flags |= fusekernel.InitHasInodeDAX
+	}
+
+	// The kernel names the splice flags from our side of the device: splicing
+	// when reading requests is what lets us splice the data of writes, and
+	// vice versa.
+	if c.cfg.EnableSpliceWrites && c.protocol.HasSplice() && spliceRead {
+		initOp.Flags |= fusekernel.InitSpliceRead
+		c.spliceWrites = true
+	}
+
+	if c.cfg.EnableSpliceReads && c.protocol.HasSplice() && spliceWrite {
+		initOp.Flags |= fusekernel.InitSpliceWrite
+		c.spliceReads = true
+	}
+
+	// Backing files may live on any file system that isn't itself stacked on
+	// another.
+	if c.passthrough {
+		initOp.Flags |= fusekernel.InitPassthrough
+		initOp.MaxStackDepth = 1
+	}
+
+	c.Reply(ctx, nil)
+	return nil
 }
 
 // LOCKS_EXCLUDED(c.mu)
-func (c *Connection) recordCancelFunc(
+func (c *Connection) recordInFlight(
 	fuseID uint64,
-	f func()) {
+	f *inFlightOp) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	if _, ok := c.cancelFuncs[fuseID]; ok {
-		panic(fmt.Sprintf("Already have cancel func for request %v", fuseID))
+	if _, ok := c.inFlight[fuseID]; ok {
+		panic(fmt.Sprintf("Already have state for request %v", fuseID))
 	}
 
-	c.cancelFuncs[fuseID] = f
+	c.inFlight[fuseID] = f
+
+	// Apply any interrupt that overtook the request.
+	if _, ok := c.parkedInterrupts[fuseID]; ok {
+		delete(c.parkedInterrupts, fuseID)
+		f.cancel()
+	}
 }
 
 // Set up state for an op that is about to be returned to the user, given its
-// underlying fuse opcode and request ID.
+// underlying fuse opcode and request ID, the inode in the request's header,
+// and when it was read.
 //
-// Return a context that should be used for the op.
+// Return a context that should be used for the op, and the op's entry in
+// c.inFlight if it has one.
 //
 // LOCKS_EXCLUDED(c.mu)
 func (c *Connection) beginOp(
 	opCode uint32,
-	fuseID uint64) context.Context {
+	fuseID uint64,
+	inode fuseops.InodeID,
+	op interface{},
+	start time.Time) (context.Context, *inFlightOp) {
 	// Start with the parent context.
 	ctx := c.cfg.OpContext
 
@@ -265,13 +472,31 @@ func (c *Connection) beginOp(
 	// should not record any state keyed on their ID.
 	//
 	// Cf. https://github.com/osxfuse/osxfuse/issues/208
-	if opCode != fusekernel.OpForget {
-		var cancel func()
-		ctx, cancel = context.WithCancel(ctx)
-		c.recordCancelFunc(fuseID, cancel)
+	if opCode == fusekernel.OpForget {
+		return ctx, nil
 	}
 
-	return ctx
+	f := &inFlightOp{
+		opCode: opCode,
+		fuseID: fuseID,
+		inode:  inode,
+		op:     op,
+		start:  start,
+	}
+
+	// Batch forgets get no reply either, so there's nothing to time out.
+	if c.cfg.OpTimeout != nil && opCode != fusekernel.OpBatchForget && mayTimeOut(op) {
+		f.timeout = c.cfg.OpTimeout(op)
+	}
+
+	if f.timeout > 0 {
+		ctx, f.cancel = context.WithTimeout(ctx, f.timeout)
+	} else {
+		ctx, f.cancel = context.WithCancel(ctx)
+	}
+
+	c.recordInFlight(fuseID, f)
+	return ctx, f
 }
 
 // Clean up all state associated with an op to which the user has responded,
@@ -293,18 +518,57 @@ func (c *Connection) finishOp(
 	// Special case: we don't do this for Forget requests. See the note in
 	// beginOp above.
 	if opCode != fusekernel.OpForget {
-		cancel, ok := c.cancelFuncs[fuseID]
+		f, ok := c.inFlight[fuseID]
 		if !ok {
 			panic(fmt.Sprintf("Unknown request ID in finishOp: %v", fuseID))
 		}
 
-		cancel()
-		delete(c.cancelFuncs, fuseID)
+		f.cancel()
+		delete(c.inFlight, fuseID)
+		c.checkDrained()
 	}
 }
 
+// Note that r is about to read a message.
+//
 // LOCKS_EXCLUDED(c.mu)
-func (c *Connection) handleInterrupt(fuseID uint64) {
+func (c *Connection) startReading(r *reader) {
+	if len(c.readers) == 1 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	r.reading = true
+}
+
+// Note that r has finished with the message it read, if any, having recorded
+// the resulting op in c.inFlight if need be. Interrupts that have been waiting
+// only on r to do so are for requests that have already been replied to.
+//
+// LOCKS_EXCLUDED(c.mu)
+func (c *Connection) doneReading(r *reader) {
+	if len(c.readers) == 1 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	r.reading = false
+	for fuseID, readers := range c.parkedInterrupts {
+		delete(readers, r)
+		if len(readers) == 0 {
+			delete(c.parkedInterrupts, fuseID)
+		}
+	}
+}
+
+// Handle an interrupt for the request with the given ID, read by r.
+//
+// LOCKS_EXCLUDED(c.mu)
+func (c *Connection) handleInterrupt(r *reader, fuseID uint64) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
@@ -317,29 +581,54 @@ func (c *Connection) handleInterrupt(fuseID uint64) {
 	// race and EAGAIN appears to be aimed at userspace programs that
 	// concurrently process requests (cf. http://goo.gl/BES2rs).
 	//
-	// So in this method if we can't find the ID to be interrupted, it means that
-	// the request has already been replied to.
+	// So with a single reader, if we can't find the ID to be interrupted, it
+	// means that the request has already been replied to.
 	//
 	// Cf. https://github.com/osxfuse/osxfuse/issues/208
 	// Cf. http://comments.gmane.org/gmane.comp.file-systems.fuse.devel/14675
-	cancel, ok := c.cancelFuncs[fuseID]
-	if !ok {
+	if f, ok := c.inFlight[fuseID]; ok {
+		f.cancel()
 		return
 	}
 
-	cancel()
+	// With several readers, though, another reader may have read the request
+	// without having recorded it yet. Replying EAGAIN wouldn't help, since the
+	// kernel only accepts the reply on the descriptor from which the request
+	// was read. Instead hold on to the interrupt until each reader that was
+	// part way through reading a message has recorded it, applying it in
+	// recordInFlight if the request turns up.
+	var readers map[*reader]bool
+	for _, other := range c.readers {
+		if other != r && other.reading {
+			if readers == nil {
+				readers = make(map[*reader]bool)
+			}
+
+			readers[other] = true
+		}
+	}
+
+	if readers == nil {
+		return
+	}
+
+	if c.parkedInterrupts == nil {
+		c.parkedInterrupts = make(map[uint64]map[*reader]bool)
+	}
+
+	c.parkedInterrupts[fuseID] = readers
 }
 
 // Read the next message from the kernel. The message must later be destroyed
 // using destroyInMessage.
-func (c *Connection) readMessage() (*buffer.InMessage, error) {
+func (r *reader) readMessage() (*buffer.InMessage, error) {
 	// Allocate a message.
-	m := c.getInMessage()
+	m := r.getInMessage()
 
 	// Loop past transient errors.
 	for {
 		// Attempt a read.
-		err := m.Init(c.dev)
+		err := m.Init(r.dev)
 
 		// Special cases:
 		//
@@ -360,7 +649,7 @@ func (c *Connection) readMessage() (*buffer.InMessage, error) {
 		}
 
 		if err != nil {
-			c.putInMessage(m)
+			r.putInMessage(m)
 			return nil, err
 		}
 
@@ -369,9 +658,9 @@ func (c *Connection) readMessage() (*buffer.InMessage, error) {
 }
 
 // Write the supplied message to the kernel.
-func (c *Connection) writeMessage(msg []byte) error {
+func (r *reader) writeMessage(msg []byte) error {
 	// Avoid the retry loop in os.File.Write.
-	n, err := syscall.Write(int(c.dev.Fd()), msg)
+	n, err := syscall.Write(int(r.dev.Fd()), msg)
 	if err != nil {
 		return err
 	}
@@ -390,41 +679,123 @@ func (c *Connection) writeMessage(msg []byte) error {
 // If err != nil, the user is responsible for later calling c.Reply with the
 // returned context.
 //
-// This function delivers ops in exactly the order they are received from
-// /dev/fuse. It must not be called multiple times concurrently.
+// This function may be called concurrently up to NumReaders times, with each
+// call reading from its own /dev/fuse descriptor; further calls block until
+// one of those returns. With a single reader, ops are delivered in exactly the
+// order they are received from /dev/fuse. Otherwise there is no ordering
+// between ops returned by different calls.
 //
 // LOCKS_EXCLUDED(c.mu)
 func (c *Connection) ReadOp() (_ context.Context, op interface{}, _ error) {
+	// Claim a reader for the duration of the call.
+	r := <-c.idleReaders
+	defer func() { c.idleReaders <- r }()
+
 	// Keep going until we find a request we know how to convert.
 	for {
 		// Read the next message from the kernel.
-		inMsg, err := c.readMessage()
+		var inMsg *buffer.InMessage
+		var p *pipe
+		var err error
+		c.startReading(r)
+		if c.spliceWrites {
+			inMsg, p, err = r.readSplicedMessage(c.protocol)
+		} else {
+			inMsg, err = r.readMessage()
+		}
+
 		if err != nil {
+			c.doneReading(r)
 			return nil, nil, err
 		}
 
 		// Convert the message to an op.
-		outMsg := c.getOutMessage()
-		op, err = convertInMessage(&c.cfg, inMsg, outMsg, c.protocol)
+		outMsg := r.getOutMessage()
+		op, err = convertInMessage(&c.cfg, inMsg, outMsg, c.protocol, c.setxattrExt)
 		if err != nil {
-			c.putOutMessage(outMsg)
+			r.putOutMessage(outMsg)
+			if p != nil {
+				r.putPipe(p)
+			}
+
+			c.doneReading(r)
 			return nil, nil, fmt.Errorf("convertInMessage: %v", err)
 		}
 
-		// Choose an ID for this operation for the purposes of logging, and log it.
-		if c.debugLogger != nil {
-			c.debugLog(inMsg.Header().Unique, 1, "<- %s", describeRequest(op))
+		// Hand over any data that was left in the pipe.
+		if p != nil {
+			if wo, ok := op.(*fuseops.WriteFileOp); ok {
+				wo.Spliced = &fuseops.SplicedData{
+					Pipe: p.r,
+					Size: inMsg.Pending(),
+				}
+			}
+		}
+
+		// Log the op.
+		start := time.Now()
+		if c.logEnabled(slog.LevelDebug) {
+			c.log(
+				slog.LevelDebug,
+				1,
+				"<- "+describeRequest(op),
+				opAttrs(
+					inMsg.Header().Unique,
+					fuseops.InodeID(inMsg.Header().Nodeid),
+					op)...)
 		}
 
 		// Special case: handle interrupt requests inline.
 		if interruptOp, ok := op.(*interruptOp); ok {
-			c.handleInterrupt(interruptOp.FuseID)
+			c.handleInterrupt(r, interruptOp.FuseID)
+			c.doneReading(r)
 			continue
 		}
 
 		// Set up a context that remembers information about this op.
-		ctx := c.beginOp(inMsg.Header().Opcode, inMsg.Header().Unique)
-		ctx = context.WithValue(ctx, contextKey, opState{inMsg, outMsg, op})
+		ctx, flight := c.beginOp(
+			inMsg.Header().Opcode,
+			inMsg.Header().Unique,
+			fuseops.InodeID(inMsg.Header().Nodeid),
+			op,
+			start)
+
+		c.doneReading(r)
+
+		state := opState{
+			inMsg:  inMsg,
+			outMsg: outMsg,
+			op:     op,
+			reader: r,
+			pipe:   p,
+			start:  start,
+			flight: flight,
+		}
+
+		// Let the tracer know, and let it decorate the context.
+		if c.cfg.OpTracer != nil {
+			state.traceInfo = OpInfo{
+				Name:   opName(op),
+				FuseID: inMsg.Header().Unique,
+				Inode:  fuseops.InodeID(inMsg.Header().Nodeid),
+				Op:     op,
+			}
+
+			ctx = c.cfg.OpTracer.StartOp(ctx, state.traceInfo)
+		}
+
+		ctx = context.WithValue(ctx, contextKey, state)
+
+		// Special case: refuse opens while shutting down.
+		if c.refuseWhileDraining(op) {
+			c.Reply(ctx, syscall.ENOTCONN)
+			continue
+		}
+
+		// Reply on the file system's behalf if it takes too long.
+		if flight != nil && flight.timeout > 0 {
+			flight.timer = time.AfterFunc(flight.timeout, func() { c.timeOut(ctx) })
+		}
 
 		// Return the op to the user.
 		return ctx, op, nil
@@ -441,7 +812,7 @@ func (c *Connection) shouldLogError(
 	}
 
 	// We can't log if there's nothing to log to.
-	if c.errorLogger == nil {
+	if !c.logEnabled(slog.LevelError) {
 		return false
 	}
 
@@ -457,6 +828,39 @@ func (c *Connection) shouldLogError(
 		if err == syscall.ENODATA || err == syscall.ERANGE {
 			return false
 		}
+	case *fuseops.AccessOp:
+		// ENOSYS is the documented way to tell the kernel to stop asking, and
+		// EACCES is an ordinary answer to the question.
+		if err == syscall.ENOSYS || err == syscall.EACCES {
+			return false
+		}
+	case *fuseops.SetFileLockOp:
+		// Contention and interrupted waits are part of normal locking.
+		if err == syscall.EAGAIN || err == syscall.EINTR || err == context.Canceled {
+			return false
+		}
+	case *fuseops.PollOp:
+		// ENOSYS is the documented way to opt out of polling.
+		if err == syscall.ENOSYS {
+			return false
+		}
+	case *fuseops.CopyFileRangeOp:
+		// ENOSYS tells the kernel to fall back to reading and writing.
+		if err == syscall.ENOSYS {
+			return false
+		}
+	case *fuseops.SeekFileOp:
+		// ENXIO is the normal answer when there is no more data, and ENOSYS
+		// tells the kernel to handle seeks itself.
+		if err == syscall.ENXIO || err == syscall.ENOSYS {
+			return false
+		}
+	case *fuseops.IoctlOp:
+		// Programs routinely probe files with ioctls that they may not support,
+		// e.g. isatty(3) issuing TCGETS.
+		if err == syscall.ENOTTY || err == syscall.ENOSYS {
+			return false
+		}
 	case *unknownOp:
 		// Don't bother the user with methods we intentionally don't support.
 		if err == syscall.ENOSYS {
@@ -483,44 +887,186 @@ func (c *Connection) Reply(ctx context.Context, opErr error) {
 	op := state.op
 	inMsg := state.inMsg
 	outMsg := state.outMsg
+	r := state.reader
 	fuseID := inMsg.Header().Unique
 
 	// Make sure we destroy the messages when we're done.
-	defer c.putInMessage(inMsg)
-	defer c.putOutMessage(outMsg)
+	defer r.putInMessage(inMsg)
+	defer r.putOutMessage(outMsg)
+	if state.pipe != nil {
+		defer r.putPipe(state.pipe)
+	}
+
+	// If the op timed out, the kernel has already had its reply.
+	if f := state.flight; f != nil {
+		if !f.replied.CompareAndSwap(false, true) {
+			if bf, _ := backingFile(op); bf != nil {
+				bf.Close()
+			}
+
+			c.log(
+				slog.LevelDebug,
+				1,
+				"-> Dropped after timing out",
+				append(
+					opAttrs(fuseID, fuseops.InodeID(inMsg.Header().Nodeid), op),
+					slog.Duration(logKeyDuration, time.Since(state.start)))...)
+
+			return
+		}
+
+		if f.timer != nil {
+			f.timer.Stop()
+		}
+	}
+
+	// Fetch the data for a read that the file system satisfied with a range of
+	// a file.
+	var data *pipe
+	var dataLen int
+	if o, ok := op.(*fuseops.ReadFileOp); ok && o.FileData != nil && opErr == nil {
+		// Never send more than was asked for.
+		fr := *o.FileData
+		if fr.Size > o.Size {
+			fr.Size = o.Size
+		}
+
+		if c.spliceReads {
+			data, dataLen, opErr = r.spliceFileData(fr)
+			if data != nil {
+				defer r.putPipe(data)
+				o.BytesRead = dataLen
+			}
+		} else {
+			opErr = readFileData(o, fr)
+		}
+	}
+
+	// Register any backing file that the file system supplied. The kernel
+	// needs the ID to remain valid until it has processed the reply, and takes
+	// its own reference to the file.
+	var backingID int32
+	if f, ok := backingFile(op); f != nil {
+		defer f.Close()
+
+		if ok && opErr == nil && c.passthrough {
+			id, err := openBackingFile(r.dev, f)
+			if err != nil {
+				c.log(
+					slog.LevelError,
+					1,
+					"openBackingFile",
+					slog.Uint64(logKeyUnique, fuseID),
+					slog.Any(logKeyError, err))
+			} else {
+				backingID = id
+				defer closeBackingFile(r.dev, id)
+			}
+		}
+	}
 
 	// Clean up state for this op.
+	c.trackHandles(op, opErr)
 	c.finishOp(inMsg.Header().Opcode, inMsg.Header().Unique)
 
 	// Debug logging
-	if c.debugLogger != nil {
+	if c.logEnabled(slog.LevelDebug) {
+		attrs := opAttrs(fuseID, fuseops.InodeID(inMsg.Header().Nodeid), op)
+		attrs = append(attrs, slog.Duration(logKeyDuration, time.Since(state.start)))
+
 		if opErr == nil {
-			c.debugLog(fuseID, 1, "-> OK (%s)", describeResponse(op))
+			c.log(slog.LevelDebug, 1, "-> OK ("+describeResponse(op)+")", attrs...)
 		} else {
-			c.debugLog(fuseID, 1, "-> Error: %q", opErr.Error())
+			attrs = append(attrs, slog.Any(logKeyError, opErr))
+			c.log(slog.LevelDebug, 1, fmt.Sprintf("-> Error: %q", opErr.Error()), attrs...)
 		}
 	}
 
 	// Error logging
 	if c.shouldLogError(op, opErr) {
-		c.errorLogger.Printf("%T error: %v", op, opErr)
+		attrs := opAttrs(fuseID, fuseops.InodeID(inMsg.Header().Nodeid), op)
+		attrs = append(
+			attrs,
+			slog.Duration(logKeyDuration, time.Since(state.start)),
+			slog.Any(logKeyError, opErr))
+
+		c.log(slog.LevelError, 1, "error", attrs...)
 	}
 
 	// Send the reply to the kernel, if one is required.
 	noResponse := c.kernelResponse(outMsg, inMsg.Header().Unique, op, opErr)
+	if backingID != 0 && outMsg.OutHeader().Error == 0 {
+		setBackingID(outMsg, op, backingID)
+	}
 
 	if !noResponse {
 		var err error
-		if outMsg.Sglist != nil {
-			_, err = writev(int(c.dev.Fd()), outMsg.Sglist)
+		if data != nil && outMsg.OutHeader().Error == 0 {
+			outMsg.OutHeader().Len += uint32(dataLen)
+			err = r.writeSplicedMessage(outMsg.OutHeaderBytes(), data, dataLen)
+		} else if outMsg.Sglist != nil {
+			_, err = writev(int(r.dev.Fd()), outMsg.Sglist)
 		} else {
-			err = c.writeMessage(outMsg.OutHeaderBytes())
+			err = r.writeMessage(outMsg.OutHeaderBytes())
 		}
-		if err != nil && c.errorLogger != nil {
-			c.errorLogger.Printf("writeMessage: %v %v", err, outMsg.OutHeaderBytes())
+		if err != nil {
+			c.log(
+				slog.LevelError,
+				1,
+				"writeMessage",
+				slog.Uint64(logKeyUnique, fuseID),
+				writeErrorAttr(err, outMsg.OutHeaderBytes()))
 		}
 		outMsg.Sglist = nil
 	}
+
+	// Tell the tracer how it went.
+	if c.cfg.OpTracer != nil {
+		var errno syscall.Errno
+		if !noResponse {
+			errno = syscall.Errno(-outMsg.OutHeader().Error)
+		}
+
+		c.cfg.OpTracer.FinishOp(
+			ctx,
+			state.traceInfo,
+			time.Since(state.start),
+			errno)
+	}
+}
+
+// Return the backing file that the file system supplied in reply to op, if
+// any, and whether the kernel would accept it for passthrough.
+func backingFile(op interface{}) (*os.File, bool) {
+	switch o := op.(type) {
+	case *fuseops.OpenFileOp:
+		// The kernel refuses passthrough for direct IO.
+		return o.BackingFile, !o.UseDirectIO
+
+	case *fuseops.CreateFileOp:
+		return o.BackingFile, true
+	}
+
+	return nil, false
+}
+
+// Satisfy a ReadFileOp by reading the supplied range of a file into the op's
+// buffer.
+func readFileData(o *fuseops.ReadFileOp, fr fuseops.FileRange) error {
+	// For vectored reads there is no buffer to reuse.
+	dst := o.Dst
+	if dst == nil {
+		dst = make([]byte, fr.Size)
+		o.Data = [][]byte{dst}
+	}
+
+	n, err := fr.File.ReadAt(dst[:fr.Size], fr.Offset)
+	if err != nil && err != io.EOF {
+		return err
+	}
+
+	o.BytesRead = n
+	return nil
 }
 
 // Close the connection. Must not be called until operations that were read
@@ -529,5 +1075,17 @@ func (c *Connection) close() error {
 	// Posix doesn't say that close can be called concurrently with read or
 	// write, but luckily we exclude the possibility of a race by requiring the
 	// user to respond to all ops first.
-	return c.dev.Close()
+	if c.closed != nil {
+		close(c.closed)
+	}
+
+	var firstErr error
+	for _, r := range c.readers {
+		r.closePipes()
+		if err := r.dev.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
 }
@@ -16,8 +16,10 @@ package fuse
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
+	"math"
 	"os"
 	"reflect"
 	"syscall"
@@ -26,6 +28,7 @@ import (
 
 	"github.com/jacobsa/fuse/fuseops"
 	"github.com/jacobsa/fuse/internal/buffer"
+	"github.com/jacobsa/fuse/internal/fuseconv"
 	"github.com/jacobsa/fuse/internal/fusekernel"
 )
 
@@ -34,14 +37,16 @@ import (
 ////////////////////////////////////////////////////////////////////////
 
 // Convert a kernel message to an appropriate op. If the op is unknown, a
-// special unexported type will be used.
+// special unexported type will be used. setxattrExt says whether the kernel
+// agreed to send SetxattrOp in its extended form.
 //
 // The caller is responsible for arranging for the message to be destroyed.
 func convertInMessage(
 	config *MountConfig,
 	inMsg *buffer.InMessage,
 	outMsg *buffer.OutMessage,
-	protocol fusekernel.Protocol) (o interface{}, err error) {
+	protocol fusekernel.Protocol,
+	setxattrExt bool) (o interface{}, err error) {
 	switch inMsg.Header().Opcode {
 	case fusekernel.OpLookup:
 		buf := inMsg.ConsumeBytes(inMsg.Len())
@@ -53,13 +58,13 @@ func convertInMessage(
 		o = &fuseops.LookUpInodeOp{
 			Parent:    fuseops.InodeID(inMsg.Header().Nodeid),
 			Name:      string(buf[:n-1]),
-			OpContext: fuseops.OpContext{Pid: inMsg.Header().Pid},
+			OpContext: convertOpContext(inMsg.Header()),
 		}
 
 	case fusekernel.OpGetattr:
 		o = &fuseops.GetInodeAttributesOp{
 			Inode:     fuseops.InodeID(inMsg.Header().Nodeid),
-			OpContext: fuseops.OpContext{Pid: inMsg.Header().Pid},
+			OpContext: convertOpContext(inMsg.Header()),
 		}
 
 	case fusekernel.OpSetattr:
@@ -71,7 +76,7 @@ func convertInMessage(
 
 		to := &fuseops.SetInodeAttributesOp{
 			Inode:     fuseops.InodeID(inMsg.Header().Nodeid),
-			OpContext: fuseops.OpContext{Pid: inMsg.Header().Pid},
+			OpContext: convertOpContext(inMsg.Header()),
 		}
 		o = to
 
@@ -100,6 +105,8 @@ func convertInMessage(
 			to.Handle = &t
 		}
 
+		to.KillSuidgid = valid.KillSuidgid()
+
 	case fusekernel.OpForget:
 		type input fusekernel.ForgetIn
 		in := (*input)(inMsg.Consume(unsafe.Sizeof(input{})))
@@ -110,7 +117,7 @@ func convertInMessage(
 		o = &fuseops.ForgetInodeOp{
 			Inode:     fuseops.InodeID(inMsg.Header().Nodeid),
 			N:         in.Nlookup,
-			OpContext: fuseops.OpContext{Pid: inMsg.Header().Pid},
+			OpContext: convertOpContext(inMsg.Header()),
 		}
 
 	case fusekernel.OpBatchForget:
@@ -136,7 +143,7 @@ func convertInMessage(
 
 		o = &fuseops.BatchForgetOp{
 			Entries:   entries,
-			OpContext: fuseops.OpContext{Pid: inMsg.Header().Pid},
+			OpContext: convertOpContext(inMsg.Header()),
 		}
 
 	case fusekernel.OpMkdir:
@@ -163,7 +170,7 @@ func convertInMessage(
 			// opcode is mkdir. But we want the correct mode to go through, so ensure
 			// that os.ModeDir is set.
 			Mode:      convertFileMode(in.Mode) | os.ModeDir,
-			OpContext: fuseops.OpContext{Pid: inMsg.Header().Pid},
+			OpContext: convertOpContext(inMsg.Header()),
 		}
 
 	case fusekernel.OpMknod:
@@ -183,7 +190,7 @@ func convertInMessage(
 			Parent:    fuseops.InodeID(inMsg.Header().Nodeid),
 			Name:      string(name),
 			Mode:      convertFileMode(in.Mode),
-			OpContext: fuseops.OpContext{Pid: inMsg.Header().Pid},
+			OpContext: convertOpContext(inMsg.Header()),
 		}
 
 	case fusekernel.OpCreate:
@@ -203,7 +210,7 @@ func convertInMessage(
 			Parent:    fuseops.InodeID(inMsg.Header().Nodeid),
 			Name:      string(name),
 			Mode:      convertFileMode(in.Mode),
-			OpContext: fuseops.OpContext{Pid: inMsg.Header().Pid},
+			OpContext: convertOpContext(inMsg.Header()),
 		}
 
 	case fusekernel.OpSymlink:
@@ -222,7 +229,7 @@ func convertInMessage(
 			Parent:    fuseops.InodeID(inMsg.Header().Nodeid),
 			Name:      string(newName),
 			Target:    string(target),
-			OpContext: fuseops.OpContext{Pid: inMsg.Header().Pid},
+			OpContext: convertOpContext(inMsg.Header()),
 		}
 
 	case fusekernel.OpRename:
@@ -264,7 +271,7 @@ func convertInMessage(
 			OldName:   string(oldName),
 			NewParent: fuseops.InodeID(in.Newdir),
 			NewName:   string(newName),
-			OpContext: fuseops.OpContext{Pid: inMsg.Header().Pid},
+			OpContext: convertOpContext(inMsg.Header()),
 		}
 
 	case fusekernel.OpUnlink:
@@ -277,7 +284,7 @@ func convertInMessage(
 		o = &fuseops.UnlinkOp{
 			Parent:    fuseops.InodeID(inMsg.Header().Nodeid),
 			Name:      string(buf[:n-1]),
-			OpContext: fuseops.OpContext{Pid: inMsg.Header().Pid},
+			OpContext: convertOpContext(inMsg.Header()),
 		}
 
 	case fusekernel.OpRmdir:
@@ -290,19 +297,26 @@ func convertInMessage(
 		o = &fuseops.RmDirOp{
 			Parent:    fuseops.InodeID(inMsg.Header().Nodeid),
 			Name:      string(buf[:n-1]),
-			OpContext: fuseops.OpContext{Pid: inMsg.Header().Pid},
+			OpContext: convertOpContext(inMsg.Header()),
 		}
 
 	case fusekernel.OpOpen:
+		type input fusekernel.OpenIn
+		in := (*input)(inMsg.Consume(unsafe.Sizeof(input{})))
+		if in == nil {
+			return nil, errors.New("Corrupt OpOpen")
+		}
+
 		o = &fuseops.OpenFileOp{
-			Inode:     fuseops.InodeID(inMsg.Header().Nodeid),
-			OpContext: fuseops.OpContext{Pid: inMsg.Header().Pid},
+			Inode:       fuseops.InodeID(inMsg.Header().Nodeid),
+			KillSuidgid: fusekernel.OpenInFlags(in.OpenFlags)&fusekernel.OpenKillSuidgid != 0,
+			OpContext:   convertOpContext(inMsg.Header()),
 		}
 
 	case fusekernel.OpOpendir:
 		o = &fuseops.OpenDirOp{
 			Inode:     fuseops.InodeID(inMsg.Header().Nodeid),
-			OpContext: fuseops.OpContext{Pid: inMsg.Header().Pid},
+			OpContext: convertOpContext(inMsg.Header()),
 		}
 
 	case fusekernel.OpRead:
@@ -316,7 +330,7 @@ func convertInMessage(
 			Handle:    fuseops.HandleID(in.Fh),
 			Offset:    int64(in.Offset),
 			Size:      int64(in.Size),
-			OpContext: fuseops.OpContext{Pid: inMsg.Header().Pid},
+			OpContext: convertOpContext(inMsg.Header()),
 		}
 		if !config.UseVectoredRead {
 			// Use part of the incoming message storage as the read buffer
@@ -335,7 +349,32 @@ func convertInMessage(
 			Inode:     fuseops.InodeID(inMsg.Header().Nodeid),
 			Handle:    fuseops.HandleID(in.Fh),
 			Offset:    fuseops.DirOffset(in.Offset),
-			OpContext: fuseops.OpContext{Pid: inMsg.Header().Pid},
+			OpContext: convertOpContext(inMsg.Header()),
+		}
+		o = to
+
+		readSize := int(in.Size)
+		p := outMsg.Grow(readSize)
+		if p == nil {
+			return nil, fmt.Errorf("Can't grow for %d-byte read", readSize)
+		}
+
+		sh := (*reflect.SliceHeader)(unsafe.Pointer(&to.Dst))
+		sh.Data = uintptr(p)
+		sh.Len = readSize
+		sh.Cap = readSize
+
+	case fusekernel.OpReaddirplus:
+		in := (*fusekernel.ReadIn)(inMsg.Consume(fusekernel.ReadInSize(protocol)))
+		if in == nil {
+			return nil, errors.New("Corrupt OpReaddirplus")
+		}
+
+		to := &fuseops.ReadDirPlusOp{
+			Inode:     fuseops.InodeID(inMsg.Header().Nodeid),
+			Handle:    fuseops.HandleID(in.Fh),
+			Offset:    fuseops.DirOffset(in.Offset),
+			OpContext: convertOpContext(inMsg.Header()),
 		}
 		o = to
 
@@ -358,8 +397,11 @@ func convertInMessage(
 		}
 
 		o = &fuseops.ReleaseFileHandleOp{
-			Handle:    fuseops.HandleID(in.Fh),
-			OpContext: fuseops.OpContext{Pid: inMsg.Header().Pid},
+			Inode:       fuseops.InodeID(inMsg.Header().Nodeid),
+			Handle:      fuseops.HandleID(in.Fh),
+			FlockUnlock: fusekernel.ReleaseFlags(in.ReleaseFlags)&fusekernel.ReleaseFlockUnlock != 0,
+			LockOwner:   in.LockOwner,
+			OpContext:   convertOpContext(inMsg.Header()),
 		}
 
 	case fusekernel.OpReleasedir:
@@ -371,7 +413,7 @@ func convertInMessage(
 
 		o = &fuseops.ReleaseDirHandleOp{
 			Handle:    fuseops.HandleID(in.Fh),
-			OpContext: fuseops.OpContext{Pid: inMsg.Header().Pid},
+			OpContext: convertOpContext(inMsg.Header()),
 		}
 
 	case fusekernel.OpWrite:
@@ -380,17 +422,25 @@ func convertInMessage(
 			return nil, errors.New("Corrupt OpWrite")
 		}
 
-		buf := inMsg.ConsumeBytes(inMsg.Len())
-		if len(buf) < int(in.Size) {
+		// If the message was spliced, the data may have been left in the pipe.
+		// The caller fills in WriteFileOp.Spliced in that case.
+		var buf []byte
+		if inMsg.Pending() == 0 {
+			buf = inMsg.ConsumeBytes(inMsg.Len())
+			if len(buf) < int(in.Size) {
+				return nil, errors.New("Corrupt OpWrite")
+			}
+		} else if inMsg.Len() != 0 || inMsg.Pending() != int(in.Size) {
 			return nil, errors.New("Corrupt OpWrite")
 		}
 
 		o = &fuseops.WriteFileOp{
-			Inode:     fuseops.InodeID(inMsg.Header().Nodeid),
-			Handle:    fuseops.HandleID(in.Fh),
-			Data:      buf,
-			Offset:    int64(in.Offset),
-			OpContext: fuseops.OpContext{Pid: inMsg.Header().Pid},
+			Inode:       fuseops.InodeID(inMsg.Header().Nodeid),
+			Handle:      fuseops.HandleID(in.Fh),
+			Data:        buf,
+			Offset:      int64(in.Offset),
+			KillSuidgid: fusekernel.WriteFlags(in.WriteFlags)&fusekernel.WriteKillSuidgid != 0,
+			OpContext:   convertOpContext(inMsg.Header()),
 		}
 
 	case fusekernel.OpFsync, fusekernel.OpFsyncdir:
@@ -403,7 +453,7 @@ func convertInMessage(
 		o = &fuseops.SyncFileOp{
 			Inode:     fuseops.InodeID(inMsg.Header().Nodeid),
 			Handle:    fuseops.HandleID(in.Fh),
-			OpContext: fuseops.OpContext{Pid: inMsg.Header().Pid},
+			OpContext: convertOpContext(inMsg.Header()),
 		}
 
 	case fusekernel.OpFlush:
@@ -416,13 +466,14 @@ func convertInMessage(
 		o = &fuseops.FlushFileOp{
 			Inode:     fuseops.InodeID(inMsg.Header().Nodeid),
 			Handle:    fuseops.HandleID(in.Fh),
-			OpContext: fuseops.OpContext{Pid: inMsg.Header().Pid},
+			LockOwner: in.LockOwner,
+			OpContext: convertOpContext(inMsg.Header()),
 		}
 
 	case fusekernel.OpReadlink:
 		o = &fuseops.ReadSymlinkOp{
 			Inode:     fuseops.InodeID(inMsg.Header().Nodeid),
-			OpContext: fuseops.OpContext{Pid: inMsg.Header().Pid},
+			OpContext: convertOpContext(inMsg.Header()),
 		}
 
 	case fusekernel.OpStatfs:
@@ -446,10 +497,25 @@ func convertInMessage(
 			return nil, errors.New("Corrupt OpInit")
 		}
 
+		kernel := fusekernel.Protocol{in.Major, in.Minor}
+		flags := fusekernel.InitFlags(in.Flags)
+
+		// Newer kernels send the high flags separately. (Older OS X kernels use
+		// the bit for something else, hence the version check.)
+		if kernel.HasInitExt() && flags&fusekernel.InitInitExt != 0 {
+			type ext fusekernel.InitInExt
+			e := (*ext)(inMsg.Consume(unsafe.Sizeof(ext{})))
+			if e == nil {
+				return nil, errors.New("Corrupt OpInit")
+			}
+
+			flags |= fusekernel.InitFlags(e.Flags2) << 32
+		}
+
 		o = &initOp{
-			Kernel:       fusekernel.Protocol{in.Major, in.Minor},
+			Kernel:       kernel,
 			MaxReadahead: in.MaxReadahead,
-			Flags:        fusekernel.InitFlags(in.Flags),
+			Flags:        flags,
 		}
 
 	case fusekernel.OpLink:
@@ -473,7 +539,7 @@ func convertInMessage(
 			Parent:    fuseops.InodeID(inMsg.Header().Nodeid),
 			Name:      string(name),
 			Target:    fuseops.InodeID(in.Oldnodeid),
-			OpContext: fuseops.OpContext{Pid: inMsg.Header().Pid},
+			OpContext: convertOpContext(inMsg.Header()),
 		}
 
 	case fusekernel.OpRemovexattr:
@@ -486,7 +552,7 @@ func convertInMessage(
 		o = &fuseops.RemoveXattrOp{
 			Inode:     fuseops.InodeID(inMsg.Header().Nodeid),
 			Name:      string(buf[:n-1]),
-			OpContext: fuseops.OpContext{Pid: inMsg.Header().Pid},
+			OpContext: convertOpContext(inMsg.Header()),
 		}
 
 	case fusekernel.OpGetxattr:
@@ -506,7 +572,7 @@ func convertInMessage(
 		to := &fuseops.GetXattrOp{
 			Inode:     fuseops.InodeID(inMsg.Header().Nodeid),
 			Name:      string(name),
-			OpContext: fuseops.OpContext{Pid: inMsg.Header().Pid},
+			OpContext: convertOpContext(inMsg.Header()),
 		}
 		o = to
 
@@ -534,7 +600,7 @@ func convertInMessage(
 
 		to := &fuseops.ListXattrOp{
 			Inode:     fuseops.InodeID(inMsg.Header().Nodeid),
-			OpContext: fuseops.OpContext{Pid: inMsg.Header().Pid},
+			OpContext: convertOpContext(inMsg.Header()),
 		}
 		o = to
 
@@ -556,6 +622,18 @@ func convertInMessage(
 			return nil, errors.New("Corrupt OpSetxattr")
 		}
 
+		// The extended request carries extra flags before the payload.
+		var setxattrFlags fusekernel.SetxattrFlags
+		if setxattrExt {
+			type ext fusekernel.SetxattrInExt
+			e := (*ext)(inMsg.Consume(unsafe.Sizeof(ext{})))
+			if e == nil {
+				return nil, errors.New("Corrupt OpSetxattr")
+			}
+
+			setxattrFlags = fusekernel.SetxattrFlags(e.SetxattrFlags)
+		}
+
 		payload := inMsg.ConsumeBytes(inMsg.Len())
 		// payload should be "name\x00value"
 		if len(payload) < 3 {
@@ -573,7 +651,8 @@ func convertInMessage(
 			Name:      string(name),
 			Value:     value,
 			Flags:     in.Flags,
-			OpContext: fuseops.OpContext{Pid: inMsg.Header().Pid},
+			KillSGID:  setxattrFlags&fusekernel.SetxattrACLKillSgid != 0,
+			OpContext: convertOpContext(inMsg.Header()),
 		}
 	case fusekernel.OpFallocate:
 		type input fusekernel.FallocateIn
@@ -588,7 +667,126 @@ func convertInMessage(
 			Offset:    in.Offset,
 			Length:    in.Length,
 			Mode:      in.Mode,
-			OpContext: fuseops.OpContext{Pid: inMsg.Header().Pid},
+			OpContext: convertOpContext(inMsg.Header()),
+		}
+
+	case fusekernel.OpIoctl:
+		type input fusekernel.IoctlIn
+		in := (*input)(inMsg.Consume(unsafe.Sizeof(input{})))
+		if in == nil {
+			return nil, errors.New("Corrupt OpIoctl")
+		}
+
+		var inData []byte
+		if in.InSize != 0 {
+			inData = inMsg.ConsumeBytes(uintptr(in.InSize))
+			if inData == nil {
+				return nil, errors.New("Corrupt OpIoctl")
+			}
+		}
+
+		o = &fuseops.IoctlOp{
+			Inode:     fuseops.InodeID(inMsg.Header().Nodeid),
+			Handle:    fuseops.HandleID(in.Fh),
+			Cmd:       in.Cmd,
+			Arg:       in.Arg,
+			InData:    inData,
+			OutSize:   in.OutSize,
+			OpContext: convertOpContext(inMsg.Header()),
+		}
+
+	case fusekernel.OpPoll:
+		type input fusekernel.PollIn
+		in := (*input)(inMsg.Consume(unsafe.Sizeof(input{})))
+		if in == nil {
+			return nil, errors.New("Corrupt OpPoll")
+		}
+
+		o = &fuseops.PollOp{
+			Inode:          fuseops.InodeID(inMsg.Header().Nodeid),
+			Handle:         fuseops.HandleID(in.Fh),
+			PollHandle:     fuseops.PollHandle(in.Kh),
+			ScheduleNotify: fusekernel.PollFlags(in.Flags)&fusekernel.PollScheduleNotify != 0,
+			Events:         in.Events,
+			OpContext:      convertOpContext(inMsg.Header()),
+		}
+
+	case fusekernel.OpLseek:
+		type input fusekernel.LseekIn
+		in := (*input)(inMsg.Consume(unsafe.Sizeof(input{})))
+		if in == nil {
+			return nil, errors.New("Corrupt OpLseek")
+		}
+
+		o = &fuseops.SeekFileOp{
+			Inode:     fuseops.InodeID(inMsg.Header().Nodeid),
+			Handle:    fuseops.HandleID(in.Fh),
+			Offset:    int64(in.Offset),
+			Whence:    int(in.Whence),
+			OpContext: convertOpContext(inMsg.Header()),
+		}
+
+	case fusekernel.OpCopyFileRange:
+		type input fusekernel.CopyFileRangeIn
+		in := (*input)(inMsg.Consume(unsafe.Sizeof(input{})))
+		if in == nil {
+			return nil, errors.New("Corrupt OpCopyFileRange")
+		}
+
+		o = &fuseops.CopyFileRangeOp{
+			SrcInode:  fuseops.InodeID(inMsg.Header().Nodeid),
+			SrcHandle: fuseops.HandleID(in.FhIn),
+			SrcOffset: in.OffIn,
+			DstInode:  fuseops.InodeID(in.NodeidOut),
+			DstHandle: fuseops.HandleID(in.FhOut),
+			DstOffset: in.OffOut,
+			Length:    in.Len,
+			Flags:     in.Flags,
+			OpContext: convertOpContext(inMsg.Header()),
+		}
+
+	case fusekernel.OpAccess:
+		type input fusekernel.AccessIn
+		in := (*input)(inMsg.Consume(unsafe.Sizeof(input{})))
+		if in == nil {
+			return nil, errors.New("Corrupt OpAccess")
+		}
+
+		o = &fuseops.AccessOp{
+			Inode:     fuseops.InodeID(inMsg.Header().Nodeid),
+			Mask:      in.Mask,
+			OpContext: convertOpContext(inMsg.Header()),
+		}
+
+	case fusekernel.OpGetlk:
+		in := (*fusekernel.LkIn)(inMsg.Consume(fusekernel.LkInSize(protocol)))
+		if in == nil {
+			return nil, errors.New("Corrupt OpGetlk")
+		}
+
+		o = &fuseops.GetFileLockOp{
+			Inode:     fuseops.InodeID(inMsg.Header().Nodeid),
+			Handle:    fuseops.HandleID(in.Fh),
+			Owner:     in.Owner,
+			Lock:      convertFileLock(in.Lk.Start, in.Lk.End, in.Lk.Type, in.Lk.Pid),
+			Conflict:  fuseops.FileLock{Type: fuseops.LockUnlock},
+			OpContext: convertOpContext(inMsg.Header()),
+		}
+
+	case fusekernel.OpSetlk, fusekernel.OpSetlkw:
+		in := (*fusekernel.LkIn)(inMsg.Consume(fusekernel.LkInSize(protocol)))
+		if in == nil {
+			return nil, errors.New("Corrupt OpSetlk")
+		}
+
+		o = &fuseops.SetFileLockOp{
+			Inode:     fuseops.InodeID(inMsg.Header().Nodeid),
+			Handle:    fuseops.HandleID(in.Fh),
+			Owner:     in.Owner,
+			Lock:      convertFileLock(in.Lk.Start, in.Lk.End, in.Lk.Type, in.Lk.Pid),
+			Block:     inMsg.Header().Opcode == fusekernel.OpSetlkw,
+			Flock:     fusekernel.LkFlags(in.LkFlags)&fusekernel.LkFlock != 0,
+			OpContext: convertOpContext(inMsg.Header()),
 		}
 
 	default:
@@ -628,11 +826,34 @@ func (c *Connection) kernelResponse(
 		return true
 	}
 
+	// The kernel would reject an ioctl reply carrying more data than the caller
+	// asked for, so reject it here where we can say why.
+	if o, ok := op.(*fuseops.IoctlOp); ok && opErr == nil && len(o.OutData) > int(o.OutSize) {
+		opErr = fmt.Errorf(
+			"IoctlOp: %d bytes of output for %d-byte request",
+			len(o.OutData),
+			o.OutSize)
+	}
+
+	// Likewise for a copy whose size can't be represented in the reply.
+	if o, ok := op.(*fuseops.CopyFileRangeOp); ok && opErr == nil && o.BytesCopied > math.MaxUint32 {
+		opErr = fmt.Errorf(
+			"CopyFileRangeOp: %d bytes copied exceeds the maximum reply size",
+			o.BytesCopied)
+	}
+
 	// If the user returned the error, fill in the error field of the outgoing
 	// message header.
 	if opErr != nil {
 		handled := false
 
+		// A blocking lock request that was interrupted by the kernel must be
+		// answered with EINTR, but the file system is likely to notice the
+		// interruption by way of its context being cancelled.
+		if _, ok := op.(*fuseops.SetFileLockOp); ok && opErr == context.Canceled {
+			opErr = syscall.EINTR
+		}
+
 		if !handled {
 			m.OutHeader().Error = -int32(syscall.EIO)
 			if errno, ok := opErr.(syscall.Errno); ok {
@@ -657,6 +878,22 @@ func (c *Connection) kernelResponse(
 	return false
 }
 
+// Enable passthrough in a successful response to an OpenFileOp or
+// CreateFileOp that was built by kernelResponse, using the supplied backing
+// ID.
+func setBackingID(m *buffer.OutMessage, op interface{}, id int32) {
+	// The OpenOut follows the header, and for CreateFileOp an EntryOut, each
+	// in its own element of the scatter/gather list.
+	i := 1
+	if _, ok := op.(*fuseops.CreateFileOp); ok {
+		i = 2
+	}
+
+	oo := (*fusekernel.OpenOut)(unsafe.Pointer(&m.Sglist[i][0]))
+	oo.OpenFlags |= uint32(fusekernel.OpenPassthrough)
+	oo.BackingID = id
+}
+
 // Like kernelResponse, but assumes the user replied with a nil error to the
 // op.
 func (c *Connection) kernelResponseForOp(
@@ -667,37 +904,37 @@ func (c *Connection) kernelResponseForOp(
 	case *fuseops.LookUpInodeOp:
 		size := int(fusekernel.EntryOutSize(c.protocol))
 		out := (*fusekernel.EntryOut)(m.Grow(size))
-		convertChildInodeEntry(&o.Entry, out)
+		fuseconv.ConvertChildInodeEntry(&o.Entry, out)
 
 	case *fuseops.GetInodeAttributesOp:
 		size := int(fusekernel.AttrOutSize(c.protocol))
 		out := (*fusekernel.AttrOut)(m.Grow(size))
-		out.AttrValid, out.AttrValidNsec = convertExpirationTime(
+		out.AttrValid, out.AttrValidNsec = fuseconv.ConvertExpirationTime(
 			o.AttributesExpiration)
-		convertAttributes(o.Inode, &o.Attributes, &out.Attr)
+		fuseconv.ConvertAttributes(o.Inode, &o.Attributes, &out.Attr)
 
 	case *fuseops.SetInodeAttributesOp:
 		size := int(fusekernel.AttrOutSize(c.protocol))
 		out := (*fusekernel.AttrOut)(m.Grow(size))
-		out.AttrValid, out.AttrValidNsec = convertExpirationTime(
+		out.AttrValid, out.AttrValidNsec = fuseconv.ConvertExpirationTime(
 			o.AttributesExpiration)
-		convertAttributes(o.Inode, &o.Attributes, &out.Attr)
+		fuseconv.ConvertAttributes(o.Inode, &o.Attributes, &out.Attr)
 
 	case *fuseops.MkDirOp:
 		size := int(fusekernel.EntryOutSize(c.protocol))
 		out := (*fusekernel.EntryOut)(m.Grow(size))
-		convertChildInodeEntry(&o.Entry, out)
+		fuseconv.ConvertChildInodeEntry(&o.Entry, out)
 
 	case *fuseops.MkNodeOp:
 		size := int(fusekernel.EntryOutSize(c.protocol))
 		out := (*fusekernel.EntryOut)(m.Grow(size))
-		convertChildInodeEntry(&o.Entry, out)
+		fuseconv.ConvertChildInodeEntry(&o.Entry, out)
 
 	case *fuseops.CreateFileOp:
 		eSize := int(fusekernel.EntryOutSize(c.protocol))
 
 		e := (*fusekernel.EntryOut)(m.Grow(eSize))
-		convertChildInodeEntry(&o.Entry, e)
+		fuseconv.ConvertChildInodeEntry(&o.Entry, e)
 
 		oo := (*fusekernel.OpenOut)(m.Grow(int(unsafe.Sizeof(fusekernel.OpenOut{}))))
 		oo.Fh = uint64(o.Handle)
@@ -705,12 +942,12 @@ func (c *Connection) kernelResponseForOp(
 	case *fuseops.CreateSymlinkOp:
 		size := int(fusekernel.EntryOutSize(c.protocol))
 		out := (*fusekernel.EntryOut)(m.Grow(size))
-		convertChildInodeEntry(&o.Entry, out)
+		fuseconv.ConvertChildInodeEntry(&o.Entry, out)
 
 	case *fuseops.CreateLinkOp:
 		size := int(fusekernel.EntryOutSize(c.protocol))
 		out := (*fusekernel.EntryOut)(m.Grow(size))
-		convertChildInodeEntry(&o.Entry, out)
+		fuseconv.ConvertChildInodeEntry(&o.Entry, out)
 
 	case *fuseops.RenameOp:
 		// Empty response
@@ -731,6 +968,10 @@ func (c *Connection) kernelResponseForOp(
 		// much the user read.
 		m.ShrinkTo(buffer.OutMessageHeaderSize + o.BytesRead)
 
+	case *fuseops.ReadDirPlusOp:
+		// As with ReadDirOp, the data is already in place.
+		m.ShrinkTo(buffer.OutMessageHeaderSize + o.BytesRead)
+
 	case *fuseops.ReleaseDirHandleOp:
 		// Empty response
 
@@ -747,6 +988,12 @@ func (c *Connection) kernelResponseForOp(
 		}
 
 	case *fuseops.ReadFileOp:
+		// When splicing, data from FileData is sent separately by
+		// Connection.Reply.
+		if o.FileData != nil && c.spliceReads {
+			break
+		}
+
 		if o.Dst != nil {
 			m.Append(o.Dst)
 		} else {
@@ -757,6 +1004,9 @@ func (c *Connection) kernelResponseForOp(
 	case *fuseops.WriteFileOp:
 		out := (*fusekernel.WriteOut)(m.Grow(int(unsafe.Sizeof(fusekernel.WriteOut{}))))
 		out.Size = uint32(len(o.Data))
+		if o.Spliced != nil {
+			out.Size = uint32(o.Spliced.Size)
+		}
 
 	case *fuseops.SyncFileOp:
 		// Empty response
@@ -833,6 +1083,38 @@ func (c *Connection) kernelResponseForOp(
 	case *fuseops.FallocateOp:
 		// Empty response
 
+	case *fuseops.SeekFileOp:
+		out := (*fusekernel.LseekOut)(m.Grow(int(unsafe.Sizeof(fusekernel.LseekOut{}))))
+		out.Offset = uint64(o.NewOffset)
+
+	case *fuseops.CopyFileRangeOp:
+		out := (*fusekernel.WriteOut)(m.Grow(int(unsafe.Sizeof(fusekernel.WriteOut{}))))
+		out.Size = uint32(o.BytesCopied)
+
+	case *fuseops.PollOp:
+		out := (*fusekernel.PollOut)(m.Grow(int(unsafe.Sizeof(fusekernel.PollOut{}))))
+		out.Revents = o.Revents
+
+	case *fuseops.IoctlOp:
+		out := (*fusekernel.IoctlOut)(m.Grow(int(unsafe.Sizeof(fusekernel.IoctlOut{}))))
+		out.Result = o.Result
+		if len(o.OutData) != 0 {
+			m.Append(o.OutData)
+		}
+
+	case *fuseops.AccessOp:
+		// Empty response
+
+	case *fuseops.GetFileLockOp:
+		out := (*fusekernel.LkOut)(m.Grow(int(unsafe.Sizeof(fusekernel.LkOut{}))))
+		out.Lk.Start = o.Conflict.Start
+		out.Lk.End = o.Conflict.End
+		out.Lk.Type = uint32(o.Conflict.Type)
+		out.Lk.Pid = o.Conflict.Pid
+
+	case *fuseops.SetFileLockOp:
+		// Empty response
+
 	case *initOp:
 		out := (*fusekernel.InitOut)(m.Grow(int(unsafe.Sizeof(fusekernel.InitOut{}))))
 
@@ -840,12 +1122,16 @@ func (c *Connection) kernelResponseForOp(
 		out.Minor = o.Library.Minor
 		out.MaxReadahead = o.MaxReadahead
 		out.Flags = uint32(o.Flags)
+		if o.Flags&fusekernel.InitInitExt != 0 {
+			out.Flags2 = uint32(o.Flags >> 32)
+		}
 		// Default values
 		out.MaxBackground = 12
 		out.CongestionThreshold = 9
 		out.MaxWrite = o.MaxWrite
 		out.TimeGran = 1
 		out.MaxPages = o.MaxPages
+		out.MaxStackDepth = o.MaxStackDepth
 
 	default:
 		panic(fmt.Sprintf("Unexpected op: %#v", op))
@@ -858,79 +1144,26 @@ func (c *Connection) kernelResponseForOp(
 // General conversions
 ////////////////////////////////////////////////////////////////////////
 
-func convertTime(t time.Time) (secs uint64, nsec uint32) {
-	totalNano := t.UnixNano()
-	secs = uint64(totalNano / 1e9)
-	nsec = uint32(totalNano % 1e9)
-	return secs, nsec
-}
-
-func convertAttributes(
-	inodeID fuseops.InodeID,
-	in *fuseops.InodeAttributes,
-	out *fusekernel.Attr) {
-	out.Ino = uint64(inodeID)
-	out.Size = in.Size
-	out.Atime, out.AtimeNsec = convertTime(in.Atime)
-	out.Mtime, out.MtimeNsec = convertTime(in.Mtime)
-	out.Ctime, out.CtimeNsec = convertTime(in.Ctime)
-	out.SetCrtime(convertTime(in.Crtime))
-	out.Nlink = in.Nlink
-	out.Uid = in.Uid
-	out.Gid = in.Gid
-	// round up to the nearest 512 boundary
-	out.Blocks = (in.Size + 512 - 1) / 512
-
-	// Set the mode.
-	out.Mode = uint32(in.Mode) & 0777
-	switch {
-	default:
-		out.Mode |= syscall.S_IFREG
-	case in.Mode&os.ModeDir != 0:
-		out.Mode |= syscall.S_IFDIR
-	case in.Mode&os.ModeDevice != 0:
-		if in.Mode&os.ModeCharDevice != 0 {
-			out.Mode |= syscall.S_IFCHR
-		} else {
-			out.Mode |= syscall.S_IFBLK
-		}
-	case in.Mode&os.ModeNamedPipe != 0:
-		out.Mode |= syscall.S_IFIFO
-	case in.Mode&os.ModeSymlink != 0:
-		out.Mode |= syscall.S_IFLNK
-	case in.Mode&os.ModeSocket != 0:
-		out.Mode |= syscall.S_IFSOCK
-	}
-	if in.Mode&os.ModeSetuid != 0 {
-		out.Mode |= syscall.S_ISUID
+func convertFileLock(
+	start uint64,
+	end uint64,
+	typ uint32,
+	pid uint32) fuseops.FileLock {
+	return fuseops.FileLock{
+		Start: start,
+		End:   end,
+		Type:  fuseops.LockType(typ),
+		Pid:   pid,
 	}
 }
 
-// Convert an absolute cache expiration time to a relative time from now for
-// consumption by the fuse kernel module.
-func convertExpirationTime(t time.Time) (secs uint64, nsecs uint32) {
-	// Fuse represents durations as unsigned 64-bit counts of seconds and 32-bit
-	// counts of nanoseconds (cf. http://goo.gl/EJupJV). So negative durations
-	// are right out. There is no need to cap the positive magnitude, because
-	// 2^64 seconds is well longer than the 2^63 ns range of time.Duration.
-	d := t.Sub(time.Now())
-	if d > 0 {
-		secs = uint64(d / time.Second)
-		nsecs = uint32((d % time.Second) / time.Nanosecond)
+// Extract the identity of the calling process from an incoming message header.
+func convertOpContext(h *fusekernel.InHeader) fuseops.OpContext {
+	return fuseops.OpContext{
+		Pid: h.Pid,
+		Uid: h.Uid,
+		Gid: h.Gid,
 	}
-
-	return secs, nsecs
-}
-
-func convertChildInodeEntry(
-	in *fuseops.ChildInodeEntry,
-	out *fusekernel.EntryOut) {
-	out.Nodeid = uint64(in.Child)
-	out.Generation = uint64(in.Generation)
-	out.EntryValid, out.EntryValidNsec = convertExpirationTime(in.EntryExpiration)
-	out.AttrValid, out.AttrValidNsec = convertExpirationTime(in.AttributesExpiration)
-
-	convertAttributes(in.Child, &in.Attributes, &out.Attr)
 }
 
 func convertFileMode(unixMode uint32) os.FileMode {
@@ -0,0 +1,570 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fuse
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"math"
+	"syscall"
+	"testing"
+	"unsafe"
+
+	"github.com/jacobsa/fuse/fuseops"
+	"github.com/jacobsa/fuse/internal/buffer"
+	"github.com/jacobsa/fuse/internal/fusekernel"
+)
+
+// Build an incoming message with the given opcode whose body is the
+// concatenation of the supplied structs.
+func newInMessage(t *testing.T, opcode uint32, body ...interface{}) *buffer.InMessage {
+	var b bytes.Buffer
+	for _, v := range body {
+		if err := binary.Write(&b, binary.LittleEndian, v); err != nil {
+			t.Fatalf("binary.Write: %v", err)
+		}
+	}
+
+	h := fusekernel.InHeader{
+		Len:    uint32(fusekernel.InHeaderSize + b.Len()),
+		Opcode: opcode,
+		Unique: 1,
+	}
+
+	var msg bytes.Buffer
+	binary.Write(&msg, binary.LittleEndian, h)
+	msg.Write(b.Bytes())
+
+	m := buffer.NewInMessage()
+	if err := m.Init(&msg); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+
+	return m
+}
+
+func convertInit(t *testing.T, m *buffer.InMessage) *initOp {
+	var outMsg buffer.OutMessage
+	outMsg.Reset()
+
+	op, err := convertInMessage(&MountConfig{}, m, &outMsg, fusekernel.Protocol{}, false)
+	if err != nil {
+		t.Fatalf("convertInMessage: %v", err)
+	}
+
+	return op.(*initOp)
+}
+
+func TestConvertInit_Legacy(t *testing.T) {
+	m := newInMessage(t, fusekernel.OpInit, fusekernel.InitIn{
+		Major: 7,
+		Minor: 31,
+		Flags: uint32(fusekernel.InitAsyncRead | fusekernel.InitInitExt),
+	})
+
+	op := convertInit(t, m)
+
+	// Kernels that predate the extension don't have it, even if they happen to
+	// set the bit.
+	want := fusekernel.InitAsyncRead | fusekernel.InitInitExt
+	if op.Flags != want {
+		t.Errorf("Flags = %v, want %v", op.Flags, want)
+	}
+}
+
+func TestConvertInit_Ext(t *testing.T) {
+	in := fusekernel.InitIn{
+		Major: 7,
+		Minor: 38,
+		Flags: uint32(fusekernel.InitAsyncRead | fusekernel.InitInitExt),
+	}
+
+	ext := fusekernel.InitInExt{
+		Flags2: uint32(fusekernel.InitHasInodeDAX >> 32),
+	}
+
+	op := convertInit(t, newInMessage(t, fusekernel.OpInit, in, ext))
+
+	want := fusekernel.InitAsyncRead |
+		fusekernel.InitInitExt |
+		fusekernel.InitHasInodeDAX
+
+	if op.Flags != want {
+		t.Errorf("Flags = %v, want %v", op.Flags, want)
+	}
+}
+
+func TestInitResponse_Flags2(t *testing.T) {
+	c := &Connection{}
+
+	var m buffer.OutMessage
+	m.Reset()
+
+	op := &initOp{
+		Library: fusekernel.Protocol{Major: 7, Minor: 38},
+		Flags:   fusekernel.InitBigWrites | fusekernel.InitInitExt | fusekernel.InitHasInodeDAX,
+	}
+
+	c.kernelResponse(&m, 1, op, nil)
+
+	var b []byte
+	for _, s := range m.Sglist {
+		b = append(b, s...)
+	}
+
+	b = b[buffer.OutMessageHeaderSize:]
+	if got, want := len(b), int(unsafe.Sizeof(fusekernel.InitOut{})); got != want {
+		t.Fatalf("len = %d, want %d", got, want)
+	}
+
+	out := (*fusekernel.InitOut)(unsafe.Pointer(&b[0]))
+	if got, want := fusekernel.InitFlags(out.Flags), fusekernel.InitBigWrites|fusekernel.InitInitExt; got != want {
+		t.Errorf("Flags = %v, want %v", got, want)
+	}
+
+	if got, want := out.Flags2, uint32(fusekernel.InitHasInodeDAX>>32); got != want {
+		t.Errorf("Flags2 = %#x, want %#x", got, want)
+	}
+}
+
+func TestSetBackingID(t *testing.T) {
+	c := &Connection{}
+
+	testCases := []interface{}{
+		&fuseops.OpenFileOp{Handle: 17},
+		&fuseops.CreateFileOp{Handle: 17},
+	}
+
+	for _, op := range testCases {
+		var m buffer.OutMessage
+		m.Reset()
+
+		c.kernelResponse(&m, 1, op, nil)
+		setBackingID(&m, op, 23)
+
+		b := m.Sglist[len(m.Sglist)-1]
+		if got, want := len(b), int(unsafe.Sizeof(fusekernel.OpenOut{})); got != want {
+			t.Fatalf("%T: len = %d, want %d", op, got, want)
+		}
+
+		out := (*fusekernel.OpenOut)(unsafe.Pointer(&b[0]))
+		if out.Fh != 17 {
+			t.Errorf("%T: Fh = %d, want 17", op, out.Fh)
+		}
+
+		if out.OpenFlags&uint32(fusekernel.OpenPassthrough) == 0 {
+			t.Errorf("%T: OpenFlags = %v, missing OpenPassthrough", op, fusekernel.OpenResponseFlags(out.OpenFlags))
+		}
+
+		if out.BackingID != 23 {
+			t.Errorf("%T: BackingID = %d, want 23", op, out.BackingID)
+		}
+	}
+}
+
+// Convert the given message as a connection speaking the latest protocol
+// version would.
+func convertOp(t *testing.T, m *buffer.InMessage) interface{} {
+	var outMsg buffer.OutMessage
+	outMsg.Reset()
+
+	protocol := fusekernel.Protocol{
+		Major: fusekernel.ProtoVersionMaxMajor,
+		Minor: fusekernel.ProtoVersionMaxMinor,
+	}
+
+	op, err := convertInMessage(&MountConfig{}, m, &outMsg, protocol, false)
+	if err != nil {
+		t.Fatalf("convertInMessage: %v", err)
+	}
+
+	return op
+}
+
+// Build the reply to the given op, returning its header and body.
+func kernelResponse(
+	t *testing.T,
+	op interface{},
+	opErr error) (*fusekernel.OutHeader, []byte) {
+	c := &Connection{}
+
+	var m buffer.OutMessage
+	m.Reset()
+
+	c.kernelResponse(&m, 1, op, opErr)
+
+	// The first segment, if any, is the header.
+	var b []byte
+	for i := 1; i < len(m.Sglist); i++ {
+		b = append(b, m.Sglist[i]...)
+	}
+
+	return m.OutHeader(), b
+}
+
+func TestConvertCopyFileRange(t *testing.T) {
+	m := newInMessage(t, fusekernel.OpCopyFileRange, fusekernel.CopyFileRangeIn{
+		FhIn:      3,
+		OffIn:     4096,
+		NodeidOut: 19,
+		FhOut:     5,
+		OffOut:    8192,
+		Len:       1 << 20,
+		Flags:     7,
+	})
+	m.Header().Nodeid = 17
+
+	op, ok := convertOp(t, m).(*fuseops.CopyFileRangeOp)
+	if !ok {
+		t.Fatalf("Unexpected op type: %T", op)
+	}
+
+	want := fuseops.CopyFileRangeOp{
+		SrcInode:  17,
+		SrcHandle: 3,
+		SrcOffset: 4096,
+		DstInode:  19,
+		DstHandle: 5,
+		DstOffset: 8192,
+		Length:    1 << 20,
+		Flags:     7,
+		OpContext: op.OpContext,
+	}
+
+	if *op != want {
+		t.Errorf("op = %+v, want %+v", *op, want)
+	}
+}
+
+func TestCopyFileRangeResponse(t *testing.T) {
+	h, b := kernelResponse(t, &fuseops.CopyFileRangeOp{BytesCopied: 17}, nil)
+	if h.Error != 0 {
+		t.Fatalf("Error = %d", h.Error)
+	}
+
+	if got, want := len(b), int(unsafe.Sizeof(fusekernel.WriteOut{})); got != want {
+		t.Fatalf("len = %d, want %d", got, want)
+	}
+
+	if out := (*fusekernel.WriteOut)(unsafe.Pointer(&b[0])); out.Size != 17 {
+		t.Errorf("Size = %d, want 17", out.Size)
+	}
+
+	// A size that doesn't fit in the reply is an error rather than being
+	// truncated.
+	h, b = kernelResponse(t, &fuseops.CopyFileRangeOp{BytesCopied: math.MaxUint32 + 1}, nil)
+	if h.Error != -int32(syscall.EIO) {
+		t.Errorf("Error = %d, want %d", h.Error, -int32(syscall.EIO))
+	}
+
+	if len(b) != 0 {
+		t.Errorf("Unexpected body: %v", b)
+	}
+}
+
+func TestConvertFileLocks(t *testing.T) {
+	var in fusekernel.LkIn
+	in.Fh = 3
+	in.Owner = 0xdeadbeef
+	in.Lk.Start = 4096
+	in.Lk.End = math.MaxInt64
+	in.Lk.Type = syscall.F_WRLCK
+	in.Lk.Pid = 23
+
+	lock := fuseops.FileLock{
+		Start: 4096,
+		End:   math.MaxInt64,
+		Type:  fuseops.LockWrite,
+		Pid:   23,
+	}
+
+	// F_GETLK
+	m := newInMessage(t, fusekernel.OpGetlk, in)
+	m.Header().Nodeid = 17
+
+	getOp, ok := convertOp(t, m).(*fuseops.GetFileLockOp)
+	if !ok {
+		t.Fatalf("Unexpected op type: %T", getOp)
+	}
+
+	wantGet := fuseops.GetFileLockOp{
+		Inode:     17,
+		Handle:    3,
+		Owner:     0xdeadbeef,
+		Lock:      lock,
+		Conflict:  fuseops.FileLock{Type: fuseops.LockUnlock},
+		OpContext: getOp.OpContext,
+	}
+
+	if *getOp != wantGet {
+		t.Errorf("op = %+v, want %+v", *getOp, wantGet)
+	}
+
+	// A file system that finds no conflict needn't say so.
+	_, b := kernelResponse(t, getOp, nil)
+	if out := (*fusekernel.LkOut)(unsafe.Pointer(&b[0])); out.Lk.Type != syscall.F_UNLCK {
+		t.Errorf("Type = %d, want %d", out.Lk.Type, syscall.F_UNLCK)
+	}
+
+	// F_SETLK, F_SETLKW, and flock(2)
+	testCases := []struct {
+		opcode  uint32
+		lkFlags fusekernel.LkFlags
+		block   bool
+		flock   bool
+	}{
+		{fusekernel.OpSetlk, 0, false, false},
+		{fusekernel.OpSetlkw, 0, true, false},
+		{fusekernel.OpSetlk, fusekernel.LkFlock, false, true},
+		{fusekernel.OpSetlkw, fusekernel.LkFlock, true, true},
+	}
+
+	for _, tc := range testCases {
+		in.LkFlags = uint32(tc.lkFlags)
+		m := newInMessage(t, tc.opcode, in)
+		m.Header().Nodeid = 17
+
+		op, ok := convertOp(t, m).(*fuseops.SetFileLockOp)
+		if !ok {
+			t.Fatalf("Unexpected op type: %T", op)
+		}
+
+		want := fuseops.SetFileLockOp{
+			Inode:     17,
+			Handle:    3,
+			Owner:     0xdeadbeef,
+			Lock:      lock,
+			Block:     tc.block,
+			Flock:     tc.flock,
+			OpContext: op.OpContext,
+		}
+
+		if *op != want {
+			t.Errorf("op = %+v, want %+v", *op, want)
+		}
+	}
+}
+
+func TestGetFileLockResponse(t *testing.T) {
+	op := &fuseops.GetFileLockOp{
+		Conflict: fuseops.FileLock{
+			Start: 10,
+			End:   19,
+			Type:  fuseops.LockRead,
+			Pid:   23,
+		},
+	}
+
+	h, b := kernelResponse(t, op, nil)
+	if h.Error != 0 {
+		t.Fatalf("Error = %d", h.Error)
+	}
+
+	if got, want := len(b), int(unsafe.Sizeof(fusekernel.LkOut{})); got != want {
+		t.Fatalf("len = %d, want %d", got, want)
+	}
+
+	out := (*fusekernel.LkOut)(unsafe.Pointer(&b[0]))
+	if out.Lk.Start != 10 || out.Lk.End != 19 || out.Lk.Type != syscall.F_RDLCK || out.Lk.Pid != 23 {
+		t.Errorf("Lk = %+v", out.Lk)
+	}
+}
+
+func TestSetFileLockResponse(t *testing.T) {
+	testCases := []struct {
+		op    interface{}
+		opErr error
+		want  syscall.Errno
+	}{
+		{&fuseops.SetFileLockOp{}, nil, 0},
+		{&fuseops.SetFileLockOp{}, syscall.EAGAIN, syscall.EAGAIN},
+
+		// A blocking request cancelled by an interrupt is answered with EINTR,
+		// whether the file system says so itself or returns its context's error.
+		{&fuseops.SetFileLockOp{Block: true}, syscall.EINTR, syscall.EINTR},
+		{&fuseops.SetFileLockOp{Block: true}, context.Canceled, syscall.EINTR},
+
+		// Other ops get no such treatment.
+		{&fuseops.GetFileLockOp{}, context.Canceled, syscall.EIO},
+	}
+
+	for _, tc := range testCases {
+		h, b := kernelResponse(t, tc.op, tc.opErr)
+		if h.Error != -int32(tc.want) {
+			t.Errorf("%T, %v: Error = %d, want %d", tc.op, tc.opErr, h.Error, -int32(tc.want))
+		}
+
+		if len(b) != 0 {
+			t.Errorf("%T, %v: unexpected body: %v", tc.op, tc.opErr, b)
+		}
+	}
+}
+
+func TestConvertReadDirPlus(t *testing.T) {
+	m := newInMessage(t, fusekernel.OpReaddirplus, fusekernel.ReadIn{
+		Fh:     3,
+		Offset: 5,
+		Size:   4096,
+	})
+	m.Header().Nodeid = 17
+
+	var outMsg buffer.OutMessage
+	outMsg.Reset()
+
+	protocol := fusekernel.Protocol{
+		Major: fusekernel.ProtoVersionMaxMajor,
+		Minor: fusekernel.ProtoVersionMaxMinor,
+	}
+
+	o, err := convertInMessage(&MountConfig{}, m, &outMsg, protocol, false)
+	if err != nil {
+		t.Fatalf("convertInMessage: %v", err)
+	}
+
+	op, ok := o.(*fuseops.ReadDirPlusOp)
+	if !ok {
+		t.Fatalf("Unexpected op type: %T", o)
+	}
+
+	if op.Inode != 17 || op.Handle != 3 || op.Offset != 5 {
+		t.Errorf("op = %+v", *op)
+	}
+
+	if len(op.Dst) != 4096 {
+		t.Fatalf("len(Dst) = %d, want 4096", len(op.Dst))
+	}
+
+	// The file system writes directly into the reply, which is cut down to
+	// what it wrote.
+	op.BytesRead = copy(op.Dst, "taco")
+
+	c := &Connection{}
+	c.kernelResponse(&outMsg, 1, op, nil)
+
+	if got, want := outMsg.Len(), buffer.OutMessageHeaderSize+4; got != want {
+		t.Fatalf("Len = %d, want %d", got, want)
+	}
+
+	if got := string(outMsg.Sglist[len(outMsg.Sglist)-1]); got != "taco" {
+		t.Errorf("Body = %q, want %q", got, "taco")
+	}
+}
+
+func TestConvertSetxattr(t *testing.T) {
+	protocol := fusekernel.Protocol{
+		Major: fusekernel.ProtoVersionMaxMajor,
+		Minor: fusekernel.ProtoVersionMaxMinor,
+	}
+
+	var in fusekernel.SetxattrIn
+	in.Size = 4
+	in.Flags = 1
+	ext := fusekernel.SetxattrInExt{
+		SetxattrFlags: uint32(fusekernel.SetxattrACLKillSgid),
+	}
+
+	payload := []byte("user.foo\x00taco")
+
+	testCases := []struct {
+		setxattrExt bool
+		body        []interface{}
+		killSGID    bool
+	}{
+		// Unless the extension was agreed on, the payload follows directly.
+		{false, []interface{}{in, payload}, false},
+		{true, []interface{}{in, ext, payload}, true},
+	}
+
+	for _, tc := range testCases {
+		m := newInMessage(t, fusekernel.OpSetxattr, tc.body...)
+		m.Header().Nodeid = 17
+
+		var outMsg buffer.OutMessage
+		outMsg.Reset()
+
+		o, err := convertInMessage(&MountConfig{EnableSetxattrExt: true}, m, &outMsg, protocol, tc.setxattrExt)
+		if err != nil {
+			t.Fatalf("convertInMessage: %v", err)
+		}
+
+		op, ok := o.(*fuseops.SetXattrOp)
+		if !ok {
+			t.Fatalf("Unexpected op type: %T", o)
+		}
+
+		if op.Inode != 17 || op.Name != "user.foo" || string(op.Value) != "taco" || op.Flags != 1 {
+			t.Errorf("setxattrExt %v: op = %+v", tc.setxattrExt, *op)
+		}
+
+		if op.KillSGID != tc.killSGID {
+			t.Errorf("setxattrExt %v: KillSGID = %v, want %v", tc.setxattrExt, op.KillSGID, tc.killSGID)
+		}
+	}
+}
+
+func TestConvertAccess(t *testing.T) {
+	m := newInMessage(t, fusekernel.OpAccess, fusekernel.AccessIn{Mask: 6})
+	m.Header().Nodeid = 17
+	m.Header().Uid = 1000
+	m.Header().Gid = 1001
+	m.Header().Pid = 23
+
+	op, ok := convertOp(t, m).(*fuseops.AccessOp)
+	if !ok {
+		t.Fatalf("Unexpected op type: %T", op)
+	}
+
+	want := fuseops.AccessOp{
+		Inode:     17,
+		Mask:      6,
+		OpContext: fuseops.OpContext{Pid: 23, Uid: 1000, Gid: 1001},
+	}
+
+	if *op != want {
+		t.Errorf("op = %+v, want %+v", *op, want)
+	}
+
+	// A truncated request is rejected.
+	var outMsg buffer.OutMessage
+	outMsg.Reset()
+
+	m = newInMessage(t, fusekernel.OpAccess, uint32(6))
+	if _, err := convertInMessage(&MountConfig{}, m, &outMsg, fusekernel.Protocol{}, false); err == nil {
+		t.Errorf("convertInMessage succeeded on a truncated request")
+	}
+}
+
+func TestAccessResponse(t *testing.T) {
+	testCases := []struct {
+		opErr error
+		want  syscall.Errno
+	}{
+		{nil, 0},
+		{syscall.EACCES, syscall.EACCES},
+		{syscall.ENOSYS, syscall.ENOSYS},
+	}
+
+	for _, tc := range testCases {
+		h, b := kernelResponse(t, &fuseops.AccessOp{Mask: 4}, tc.opErr)
+		if h.Error != -int32(tc.want) {
+			t.Errorf("%v: Error = %d, want %d", tc.opErr, h.Error, -int32(tc.want))
+		}
+
+		if len(b) != 0 {
+			t.Errorf("%v: unexpected body: %v", tc.opErr, b)
+		}
+	}
+}
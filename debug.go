@@ -100,7 +100,11 @@ func describeRequest(op interface{}) (s string) {
 	case *fuseops.WriteFileOp:
 		addComponent("handle %d", typed.Handle)
 		addComponent("offset %d", typed.Offset)
-		addComponent("%d bytes", len(typed.Data))
+		if typed.Spliced != nil {
+			addComponent("%d bytes spliced", typed.Spliced.Size)
+		} else {
+			addComponent("%d bytes", len(typed.Data))
+		}
 
 	case *fuseops.RemoveXattrOp:
 		addComponent("name %s", typed.Name)
@@ -115,6 +119,47 @@ func describeRequest(op interface{}) (s string) {
 		addComponent("offset %d", typed.Offset)
 		addComponent("length %d", typed.Length)
 		addComponent("mode %d", typed.Mode)
+
+	case *fuseops.IoctlOp:
+		addComponent("handle %d", typed.Handle)
+		addComponent("cmd %#x", typed.Cmd)
+		addComponent("%d bytes in", len(typed.InData))
+		addComponent("%d bytes out", typed.OutSize)
+
+	case *fuseops.SeekFileOp:
+		addComponent("handle %d", typed.Handle)
+		addComponent("offset %d", typed.Offset)
+		addComponent("whence %d", typed.Whence)
+
+	case *fuseops.CopyFileRangeOp:
+		addComponent("src handle %d", typed.SrcHandle)
+		addComponent("src offset %d", typed.SrcOffset)
+		addComponent("dst inode %d", typed.DstInode)
+		addComponent("dst handle %d", typed.DstHandle)
+		addComponent("dst offset %d", typed.DstOffset)
+		addComponent("length %d", typed.Length)
+
+	case *fuseops.PollOp:
+		addComponent("handle %d", typed.Handle)
+		addComponent("events %#x", typed.Events)
+		if typed.ScheduleNotify {
+			addComponent("notify %#x", typed.PollHandle)
+		}
+
+	case *fuseops.AccessOp:
+		addComponent("mask %#o", typed.Mask)
+
+	case *fuseops.GetFileLockOp:
+		addComponent("handle %d", typed.Handle)
+		addComponent("owner %#x", typed.Owner)
+		addComponent("lock %+v", typed.Lock)
+
+	case *fuseops.SetFileLockOp:
+		addComponent("handle %d", typed.Handle)
+		addComponent("owner %#x", typed.Owner)
+		addComponent("lock %+v", typed.Lock)
+		addComponent("block %v", typed.Block)
+		addComponent("flock %v", typed.Flock)
 	}
 
 	// Use just the name if there is no extra info.
@@ -0,0 +1,34 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fuse
+
+import (
+	"errors"
+	"os"
+)
+
+// OS X has no way to attach several descriptors to one session.
+func cloneDevice(dev *os.File) (*os.File, error) {
+	return nil, errors.New("Multiple readers are not supported on OS X")
+}
+
+// Passthrough is Linux-specific.
+func openBackingFile(dev *os.File, f *os.File) (int32, error) {
+	return 0, errors.New("Passthrough is not supported on OS X")
+}
+
+func closeBackingFile(dev *os.File, id int32) error {
+	return errors.New("Passthrough is not supported on OS X")
+}
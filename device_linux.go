@@ -0,0 +1,91 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fuse
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+
+	"github.com/jacobsa/fuse/internal/fusekernel"
+	"golang.org/x/sys/unix"
+)
+
+// Cf. FUSE_DEV_IOC_* in linux/fuse.h.
+const (
+	fuseDevIocClone        = 0x8004e500 // _IOR(229, 0, uint32_t)
+	fuseDevIocBackingOpen  = 0x4010e501 // _IOW(229, 1, struct fuse_backing_map)
+	fuseDevIocBackingClose = 0x4004e502 // _IOW(229, 2, uint32_t)
+)
+
+// Open a new descriptor for /dev/fuse attached to the same session as the
+// supplied one (Linux >= 4.2). Requests may be read from either descriptor,
+// but each must be answered on the descriptor it was read from.
+func cloneDevice(dev *os.File) (*os.File, error) {
+	// As in directmount, use syscall.Open so that the file is opened in
+	// blocking mode.
+	fd, err := syscall.Open("/dev/fuse", syscall.O_RDWR|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	src := uint32(dev.Fd())
+	_, _, errno := unix.Syscall(
+		unix.SYS_IOCTL,
+		uintptr(fd),
+		fuseDevIocClone,
+		uintptr(unsafe.Pointer(&src)))
+
+	if errno != 0 {
+		syscall.Close(fd)
+		return nil, errno
+	}
+
+	return os.NewFile(uintptr(fd), "/dev/fuse"), nil
+}
+
+// Register the supplied file with the kernel as a backing file for
+// passthrough (Linux >= 6.9), returning its ID for use in OpenOut. This
+// requires CAP_SYS_ADMIN.
+func openBackingFile(dev *os.File, f *os.File) (int32, error) {
+	m := fusekernel.BackingMap{Fd: int32(f.Fd())}
+	id, _, errno := unix.Syscall(
+		unix.SYS_IOCTL,
+		dev.Fd(),
+		fuseDevIocBackingOpen,
+		uintptr(unsafe.Pointer(&m)))
+
+	if errno != 0 {
+		return 0, errno
+	}
+
+	return int32(id), nil
+}
+
+// Release an ID returned by openBackingFile. Files that were opened with it
+// keep using the backing file.
+func closeBackingFile(dev *os.File, id int32) error {
+	_, _, errno := unix.Syscall(
+		unix.SYS_IOCTL,
+		dev.Fd(),
+		fuseDevIocBackingClose,
+		uintptr(unsafe.Pointer(&id)))
+
+	if errno != 0 {
+		return errno
+	}
+
+	return nil
+}
@@ -0,0 +1,183 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fuse
+
+import (
+	"sort"
+
+	"github.com/jacobsa/fuse/fuseops"
+)
+
+// Identifies a handle handed out by the file system. Files and directories
+// have separate handle spaces.
+type openHandleKey struct {
+	dir    bool
+	handle fuseops.HandleID
+}
+
+// The inode that a handle was opened for, and how many times the file system
+// has handed it out without it being released.
+type openHandle struct {
+	inode fuseops.InodeID
+	count int
+}
+
+// Keep track of the handles opened and released by the given op, to which the
+// file system has replied with the given error.
+//
+// LOCKS_EXCLUDED(c.mu)
+func (c *Connection) trackHandles(op interface{}, opErr error) {
+	if opErr != nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch o := op.(type) {
+	case *fuseops.OpenFileOp:
+		c.addHandle(openHandleKey{handle: o.Handle}, o.Inode)
+
+	case *fuseops.CreateFileOp:
+		c.addHandle(openHandleKey{handle: o.Handle}, o.Entry.Child)
+
+	case *fuseops.OpenDirOp:
+		c.addHandle(openHandleKey{dir: true, handle: o.Handle}, o.Inode)
+
+	case *fuseops.ReleaseFileHandleOp:
+		c.removeHandle(openHandleKey{handle: o.Handle})
+
+	case *fuseops.ReleaseDirHandleOp:
+		c.removeHandle(openHandleKey{dir: true, handle: o.Handle})
+	}
+}
+
+// EXCLUSIVE_LOCKS_REQUIRED(c.mu)
+func (c *Connection) addHandle(key openHandleKey, inode fuseops.InodeID) {
+	if c.openHandles == nil {
+		c.openHandles = make(map[openHandleKey]*openHandle)
+	}
+
+	h := c.openHandles[key]
+	if h == nil {
+		h = &openHandle{inode: inode}
+		c.openHandles[key] = h
+	}
+
+	h.count++
+}
+
+// EXCLUSIVE_LOCKS_REQUIRED(c.mu)
+func (c *Connection) removeHandle(key openHandleKey) {
+	h := c.openHandles[key]
+	if h == nil {
+		return
+	}
+
+	h.count--
+	if h.count == 0 {
+		delete(c.openHandles, key)
+	}
+}
+
+// Start refusing to open files and directories, returning a channel that is
+// closed once no ops are in flight and no handles are open. May be called
+// more than once.
+//
+// LOCKS_EXCLUDED(c.mu)
+func (c *Connection) startDraining() <-chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.drained == nil {
+		c.drained = make(chan struct{})
+		c.checkDrained()
+	}
+
+	return c.drained
+}
+
+// Stop refusing to open files and directories.
+//
+// LOCKS_EXCLUDED(c.mu)
+func (c *Connection) stopDraining() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.drained = nil
+}
+
+// Return whether op must be refused because the connection is draining.
+//
+// LOCKS_EXCLUDED(c.mu)
+func (c *Connection) refuseWhileDraining(op interface{}) bool {
+	switch op.(type) {
+	case *fuseops.OpenFileOp, *fuseops.CreateFileOp, *fuseops.OpenDirOp:
+	default:
+		return false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.drained != nil
+}
+
+// Close c.drained if draining has finished.
+//
+// EXCLUSIVE_LOCKS_REQUIRED(c.mu)
+func (c *Connection) checkDrained() {
+	if c.drained == nil || len(c.inFlight) != 0 || len(c.openHandles) != 0 {
+		return
+	}
+
+	select {
+	case <-c.drained:
+	default:
+		close(c.drained)
+	}
+}
+
+// Return the inodes of the file and directory handles that are open, with
+// one entry per handle, and the names of the ops in flight.
+//
+// LOCKS_EXCLUDED(c.mu)
+func (c *Connection) drainStatus() (
+	files []fuseops.InodeID,
+	dirs []fuseops.InodeID,
+	ops []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, h := range c.openHandles {
+		for i := 0; i < h.count; i++ {
+			if key.dir {
+				dirs = append(dirs, h.inode)
+			} else {
+				files = append(files, h.inode)
+			}
+		}
+	}
+
+	for _, f := range c.inFlight {
+		ops = append(ops, opName(f.op))
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i] < files[j] })
+	sort.Slice(dirs, func(i, j int) bool { return dirs[i] < dirs[j] })
+	sort.Strings(ops)
+
+	return files, dirs, ops
+}
@@ -0,0 +1,141 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fuse
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/jacobsa/fuse/fuseops"
+)
+
+func isClosed(c <-chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestDrain(t *testing.T) {
+	c, _ := newPipeConnection(t)
+	c.inFlight = make(map[uint64]*inFlightOp)
+	c.cfg.OpContext = context.Background()
+
+	// Open a file.
+	ctx := beginTestOp(t, c, &fuseops.OpenFileOp{Inode: 17, Handle: 3})
+	c.Reply(ctx, nil)
+
+	// Start a read, and begin draining while it's in flight.
+	readCtx := beginTestOp(t, c, &fuseops.ReadFileOp{Inode: 17, Handle: 3})
+	drained := c.startDraining()
+
+	if !c.refuseWhileDraining(&fuseops.OpenDirOp{}) {
+		t.Errorf("OpenDirOp not refused while draining")
+	}
+
+	if c.refuseWhileDraining(&fuseops.LookUpInodeOp{}) {
+		t.Errorf("LookUpInodeOp refused while draining")
+	}
+
+	files, dirs, ops := c.drainStatus()
+	if !reflect.DeepEqual(files, []fuseops.InodeID{17}) ||
+		len(dirs) != 0 ||
+		!reflect.DeepEqual(ops, []string{"ReadFile"}) {
+		t.Errorf("drainStatus() = %v, %v, %v", files, dirs, ops)
+	}
+
+	c.Reply(readCtx, nil)
+	if isClosed(drained) {
+		t.Fatalf("Drained with a handle open")
+	}
+
+	// Releasing the handle finishes draining.
+	ctx = beginTestOp(t, c, &fuseops.ReleaseFileHandleOp{Inode: 17, Handle: 3})
+	c.Reply(ctx, nil)
+	if !isClosed(drained) {
+		t.Fatalf("Not drained")
+	}
+
+	if !isClosed(c.startDraining()) {
+		t.Errorf("Not drained on second call")
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	c, _ := newPipeConnection(t)
+	c.inFlight = make(map[uint64]*inFlightOp)
+	c.cfg.OpContext = context.Background()
+
+	// Nothing is mounted on the directory, so unmounting it fails.
+	mfs := &MountedFileSystem{dir: t.TempDir(), conn: c}
+
+	ctx := beginTestOp(t, c, &fuseops.OpenFileOp{Inode: 17, Handle: 3})
+	c.Reply(ctx, nil)
+
+	// Running out of time leaves the file system mounted, still refusing
+	// opens.
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := mfs.Shutdown(cancelled)
+	var se *ShutdownError
+	if !errors.As(err, &se) {
+		t.Fatalf("Shutdown: %v", err)
+	}
+
+	if se.Err != context.Canceled ||
+		se.Unmounted ||
+		!reflect.DeepEqual(se.OpenFiles, []fuseops.InodeID{17}) {
+		t.Errorf("Shutdown: %+v", se)
+	}
+
+	if !c.refuseWhileDraining(&fuseops.OpenFileOp{}) {
+		t.Errorf("OpenFileOp not refused after timing out")
+	}
+
+	// Asked to unmount lazily, it reports the failure to do so.
+	mfs.lazyUnmount = true
+	err = mfs.Shutdown(cancelled)
+	if !errors.As(err, &se) {
+		t.Fatalf("Shutdown: %v", err)
+	}
+
+	if se.Err == context.Canceled || se.Unmounted || len(se.OpenFiles) != 1 {
+		t.Errorf("Shutdown with LazyUnmount: %+v", se)
+	}
+
+	// Once drained, the unmount itself fails.
+	ctx = beginTestOp(t, c, &fuseops.ReleaseFileHandleOp{Inode: 17, Handle: 3})
+	c.Reply(ctx, nil)
+
+	err = mfs.Shutdown(context.Background())
+	if !errors.As(err, &se) {
+		t.Fatalf("Shutdown: %v", err)
+	}
+
+	if se.Err == nil || se.Unmounted || len(se.OpenFiles) != 0 {
+		t.Errorf("Shutdown after draining: %+v", se)
+	}
+
+	// The file system can be put back into service.
+	mfs.CancelShutdown()
+	if c.refuseWhileDraining(&fuseops.OpenFileOp{}) {
+		t.Errorf("OpenFileOp refused after CancelShutdown")
+	}
+}
@@ -0,0 +1,46 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fuse
+
+import (
+	"context"
+	"os"
+
+	"github.com/jacobsa/fuse/internal/fusekernel"
+)
+
+// NewTestConnection returns a connection reading from and writing to dev,
+// which stands in for /dev/fuse, as if it had been initialized with the
+// latest protocol version.
+func NewTestConnection(cfg MountConfig, dev *os.File) *Connection {
+	if cfg.OpContext == nil {
+		cfg.OpContext = context.Background()
+	}
+
+	c := &Connection{
+		cfg: cfg,
+		protocol: fusekernel.Protocol{
+			Major: fusekernel.ProtoVersionMaxMajor,
+			Minor: fusekernel.ProtoVersionMaxMinor,
+		},
+		readers:     []*reader{{dev: dev}},
+		idleReaders: make(chan *reader, 1),
+		inFlight:    make(map[uint64]*inFlightOp),
+		closed:      make(chan struct{}),
+	}
+
+	c.idleReaders <- c.readers[0]
+	return c
+}
@@ -24,11 +24,11 @@ import (
 // buffer.InMessage
 ////////////////////////////////////////////////////////////////////////
 
-// LOCKS_EXCLUDED(c.mu)
-func (c *Connection) getInMessage() *buffer.InMessage {
-	c.mu.Lock()
-	x := (*buffer.InMessage)(c.inMessages.Get())
-	c.mu.Unlock()
+// LOCKS_EXCLUDED(r.mu)
+func (r *reader) getInMessage() *buffer.InMessage {
+	r.mu.Lock()
+	x := (*buffer.InMessage)(r.inMessages.Get())
+	r.mu.Unlock()
 
 	if x == nil {
 		x = buffer.NewInMessage()
@@ -37,22 +37,22 @@ func (c *Connection) getInMessage() *buffer.InMessage {
 	return x
 }
 
-// LOCKS_EXCLUDED(c.mu)
-func (c *Connection) putInMessage(x *buffer.InMessage) {
-	c.mu.Lock()
-	c.inMessages.Put(unsafe.Pointer(x))
-	c.mu.Unlock()
+// LOCKS_EXCLUDED(r.mu)
+func (r *reader) putInMessage(x *buffer.InMessage) {
+	r.mu.Lock()
+	r.inMessages.Put(unsafe.Pointer(x))
+	r.mu.Unlock()
 }
 
 ////////////////////////////////////////////////////////////////////////
 // buffer.OutMessage
 ////////////////////////////////////////////////////////////////////////
 
-// LOCKS_EXCLUDED(c.mu)
-func (c *Connection) getOutMessage() *buffer.OutMessage {
-	c.mu.Lock()
-	x := (*buffer.OutMessage)(c.outMessages.Get())
-	c.mu.Unlock()
+// LOCKS_EXCLUDED(r.mu)
+func (r *reader) getOutMessage() *buffer.OutMessage {
+	r.mu.Lock()
+	x := (*buffer.OutMessage)(r.outMessages.Get())
+	r.mu.Unlock()
 
 	if x == nil {
 		x = new(buffer.OutMessage)
@@ -62,9 +62,59 @@ func (c *Connection) getOutMessage() *buffer.OutMessage {
 	return x
 }
 
-// LOCKS_EXCLUDED(c.mu)
-func (c *Connection) putOutMessage(x *buffer.OutMessage) {
-	c.mu.Lock()
-	c.outMessages.Put(unsafe.Pointer(x))
-	c.mu.Unlock()
+// LOCKS_EXCLUDED(r.mu)
+func (r *reader) putOutMessage(x *buffer.OutMessage) {
+	r.mu.Lock()
+	r.outMessages.Put(unsafe.Pointer(x))
+	r.mu.Unlock()
+}
+
+////////////////////////////////////////////////////////////////////////
+// pipe
+////////////////////////////////////////////////////////////////////////
+
+// LOCKS_EXCLUDED(r.mu)
+func (r *reader) getPipe() (*pipe, error) {
+	r.mu.Lock()
+	x := (*pipe)(r.pipes.Get())
+	r.mu.Unlock()
+
+	if x == nil {
+		return newPipe()
+	}
+
+	return x, nil
+}
+
+// Return a pipe to the freelist if it's empty. Pipes with data left in them,
+// e.g. because a file system didn't consume all of WriteFileOp.Spliced, are
+// closed instead.
+//
+// LOCKS_EXCLUDED(r.mu)
+func (r *reader) putPipe(x *pipe) {
+	if n, err := x.buffered(); err != nil || n != 0 {
+		x.close()
+		return
+	}
+
+	r.mu.Lock()
+	r.pipes.Put(unsafe.Pointer(x))
+	r.mu.Unlock()
+}
+
+// Close all of the pipes in the freelist.
+//
+// LOCKS_EXCLUDED(r.mu)
+func (r *reader) closePipes() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for {
+		x := (*pipe)(r.pipes.Get())
+		if x == nil {
+			return
+		}
+
+		x.close()
+	}
 }
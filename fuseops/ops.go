@@ -29,6 +29,18 @@ type OpContext struct {
 	// PID of the process that is invoking the operation.
 	// Not filled in case of a writepage operation.
 	Pid uint32
+
+	// The effective user and group IDs of the process that is invoking the
+	// operation. As with Pid, these are not filled in case of a writepage
+	// operation.
+	//
+	// These are useful for file systems mounted with
+	// MountConfig.DisableDefaultPermissions that want to perform their own
+	// permission checking, and for setting the ownership of newly-created
+	// inodes. Supplementary groups are not sent by the kernel; see
+	// fuseutil.SupplementaryGroups.
+	Uid uint32
+	Gid uint32
 }
 
 // Return statistics about the file system's capacity and available resources.
@@ -164,6 +176,11 @@ type SetInodeAttributesOp struct {
 	Atime *time.Time
 	Mtime *time.Time
 
+	// Set if the file system should clear the setuid and setgid bits of the
+	// inode as part of this change. Sent only when
+	// MountConfig.EnableHandleKillprivV2 is set; see notes there.
+	KillSuidgid bool
+
 	// Set by the file system: the new attributes for the inode, and the time at
 	// which they should expire. See notes on
 	// ChildInodeEntry.AttributesExpiration for more.
@@ -172,6 +189,29 @@ type SetInodeAttributesOp struct {
 	OpContext            OpContext
 }
 
+// Check whether the calling process has permission to access an inode. The
+// kernel sends this in response to access(2) and friends, and when checking
+// whether the caller may chdir(2) into a directory.
+//
+// This op is only sent when the file system is mounted with
+// MountConfig.DisableDefaultPermissions; otherwise the kernel performs
+// permission checks itself based on InodeAttributes.Mode, Uid, and Gid.
+//
+// Return nil if access is allowed, or EACCES if not. If the file system
+// returns ENOSYS, the kernel treats this and all future access checks as
+// successful, and stops sending this op.
+type AccessOp struct {
+	// The inode of interest.
+	Inode InodeID
+
+	// The access being requested, as a bitwise OR of R_OK (4), W_OK (2), and
+	// X_OK (1). A value of F_OK (0) asks only whether the inode exists.
+	Mask uint32
+
+	// The identity of the caller, against which to check permissions.
+	OpContext OpContext
+}
+
 // Decrement the reference count for an inode ID previously issued by the file
 // system.
 //
@@ -337,8 +377,12 @@ type CreateFileOp struct {
 	// The handle may be supplied in future ops like ReadFileOp that contain a
 	// file handle. The file system must ensure this ID remains valid until a
 	// later call to ReleaseFileHandle.
-	Handle    HandleID
-	OpContext OpContext
+	Handle HandleID
+
+	// Set by the file system: a file to which reads and writes should be
+	// passed through by the kernel. See the notes on OpenFileOp.BackingFile.
+	BackingFile *os.File
+	OpContext   OpContext
 }
 
 // Create a symlink inode. If the name already exists, the file system should
@@ -582,6 +626,39 @@ type ReadDirOp struct {
 	OpContext OpContext
 }
 
+// Read entries from a directory previously opened with OpenDir, along with
+// the information that would be returned by LookUpInodeOp for each of them.
+// The kernel sends this instead of ReadDirOp only if MountConfig asks for it
+// (see EnableReaddirplus), saving a round trip per entry for listings that
+// go on to stat each child, like `ls -l`.
+//
+// Each returned entry with a non-zero inode ID other than "." and ".."
+// increments that inode's lookup count, exactly as a successful
+// LookUpInodeOp would. File systems must account for this in the same way
+// they do for lookups; see notes on ForgetInodeOp.
+type ReadDirPlusOp struct {
+	// The directory inode that we are reading, and the handle previously
+	// returned by OpenDir when opening that inode.
+	Inode  InodeID
+	Handle HandleID
+
+	// The offset within the directory at which to read. See notes on
+	// ReadDirOp.Offset.
+	Offset DirOffset
+
+	// The destination buffer, whose length gives the size of the read.
+	//
+	// The output data should consist of a sequence of FUSE direntplus records
+	// in the format generated by fuse_add_direntry_plus, which is consumed by
+	// parse_dirplusfile. Use fuseutil.WriteDirentPlus to generate this data.
+	Dst []byte
+
+	// Set by the file system: the number of bytes read into Dst. See notes on
+	// ReadDirOp.BytesRead.
+	BytesRead int
+	OpContext OpContext
+}
+
 // Release a previously-minted directory handle. The kernel sends this when
 // there are no more references to an open directory: all file descriptors are
 // closed and all memory mappings are unmapped.
@@ -646,6 +723,23 @@ type OpenFileOp struct {
 	// advance, for example, because contents are generated on the fly.
 	UseDirectIO bool
 
+	// Set if the file is being truncated by the open and the file system
+	// should clear its setuid and setgid bits. Sent only when
+	// MountConfig.EnableHandleKillprivV2 is set; see notes there.
+	KillSuidgid bool
+
+	// Set by the file system: a local file holding the contents of the inode,
+	// to which the kernel should pass reads and writes on this handle through
+	// directly, without sending ReadFileOps and WriteFileOps. This takes
+	// effect only when MountConfig.EnablePassthrough is set, the kernel
+	// supports it, and UseDirectIO is false; otherwise it is ignored, so the
+	// file system must still be prepared to serve reads and writes itself.
+	//
+	// The connection takes ownership of the file, closing it once it has
+	// replied to the op; the kernel keeps its own reference for as long as it
+	// needs one.
+	BackingFile *os.File
+
 	OpContext OpContext
 }
 
@@ -685,6 +779,16 @@ type ReadFileOp struct {
 	//
 	// If direct IO is enabled, semantics should match those of read(2).
 	BytesRead int
+
+	// Set by the file system, as an alternative to filling in Dst or Data: a
+	// range of an open file whose contents should be sent back to the client.
+	// If MountConfig.EnableSpliceReads is set, the data is moved from the file
+	// to the kernel with splice(2) without being copied through user space;
+	// otherwise it is read into a buffer as usual. Either way BytesRead is
+	// ignored, and the number of bytes that the file yields is used instead.
+	//
+	// The file must remain open until the op has been replied to.
+	FileData  *FileRange
 	OpContext OpContext
 }
 
@@ -744,8 +848,26 @@ type WriteFileOp struct {
 	// be written, except on error (http://goo.gl/KUpwwn). This appears to be
 	// because it uses file mmapping machinery (http://goo.gl/SGxnaN) to write a
 	// page at a time.
-	Data      []byte
-	OpContext OpContext
+	//
+	// This is nil if the data was instead left in a pipe; see Spliced.
+	Data []byte
+
+	// Set instead of Data when MountConfig.EnableSpliceWrites is in effect: the
+	// data to write, held in a pipe from which the file system may splice(2)
+	// it into a backing file descriptor without copying it through user
+	// space. As with Data, a successful reply indicates that all of it was
+	// written; anything left in the pipe afterward is discarded.
+	//
+	// Servers created with fuseutil.NewFileSystemServer read the data into
+	// Data instead unless given fuseutil.WithSplicedWrites, so file systems
+	// served that way see this only if they ask for it.
+	Spliced *SplicedData
+
+	// Set if the file system should clear the setuid and setgid bits of the
+	// file along with the write. Sent only when
+	// MountConfig.EnableHandleKillprivV2 is set; see notes there.
+	KillSuidgid bool
+	OpContext   OpContext
 }
 
 // Synchronize the current contents of an open file to storage.
@@ -821,8 +943,13 @@ type SyncFileOp struct {
 // return any errors that occur.
 type FlushFileOp struct {
 	// The file and handle being flushed.
-	Inode     InodeID
-	Handle    HandleID
+	Inode  InodeID
+	Handle HandleID
+
+	// The lock owner associated with the file descriptor being closed, as in
+	// SetFileLockOp.Owner. If MountConfig.EnablePosixLocks is set, the file
+	// system should release any POSIX locks held by this owner on the inode.
+	LockOwner uint64
 	OpContext OpContext
 }
 
@@ -835,10 +962,100 @@ type FlushFileOp struct {
 //
 // Errors from this op are ignored by the kernel (cf. http://goo.gl/RL38Do).
 type ReleaseFileHandleOp struct {
+	// The inode with which the handle was associated.
+	Inode InodeID
+
 	// The handle ID to be released. The kernel guarantees that this ID will not
 	// be used in further calls to the file system (unless it is reissued by the
 	// file system).
-	Handle    HandleID
+	Handle HandleID
+
+	// If MountConfig.EnableFlockLocks is set and the file being released may
+	// hold a flock(2) lock, FlockUnlock is true and LockOwner identifies the
+	// owner (cf. SetFileLockOp.Owner) whose flock locks on the inode must be
+	// released.
+	FlockUnlock bool
+	LockOwner   uint64
+	OpContext   OpContext
+}
+
+////////////////////////////////////////////////////////////////////////
+// File locks
+////////////////////////////////////////////////////////////////////////
+
+// Test for a POSIX byte-range lock on a file previously opened with
+// CreateFile or OpenFile. The kernel sends this in response to fcntl(2) with
+// F_GETLK, and only if MountConfig.EnablePosixLocks is set; otherwise locks
+// are managed by the kernel and are visible only to processes on the same
+// machine.
+//
+// The file system should look for an existing lock that would conflict with
+// Lock, ignoring locks held by Owner itself.
+type GetFileLockOp struct {
+	// The file inode and handle on which the lock is being tested.
+	Inode  InodeID
+	Handle HandleID
+
+	// An opaque identifier for the lock owner. POSIX locks are owned by a
+	// process (or, for open file description locks, by an open file), and the
+	// kernel condenses that identity into this value.
+	Owner uint64
+
+	// The lock that the caller would like to acquire.
+	Lock FileLock
+
+	// Set by the file system: a lock that conflicts with Lock, if there is one.
+	// Initially of type LockUnlock, meaning that there is no such lock.
+	Conflict  FileLock
+	OpContext OpContext
+}
+
+// Acquire, change, or release a POSIX byte-range lock on a file previously
+// opened with CreateFile or OpenFile. The kernel sends this in response to
+// fcntl(2) with F_SETLK or F_SETLKW, and only if MountConfig.EnablePosixLocks
+// is set.
+//
+// Locks are identified by Owner. Acquiring a lock that overlaps a lock already
+// held by the same owner replaces the overlapping portion, and releasing
+// (Lock.Type == LockUnlock) may split an existing lock, as with fcntl(2).
+//
+// Note that the kernel does not send an explicit unlock when a file
+// descriptor is closed. Instead FlushFileOp carries the lock owner, and the
+// file system should release all POSIX locks held by that owner on the inode
+// when it receives a FlushFileOp with a non-zero LockOwner.
+type SetFileLockOp struct {
+	// The file inode and handle on which the lock is being set.
+	Inode  InodeID
+	Handle HandleID
+
+	// An opaque identifier for the lock owner. See notes on
+	// GetFileLockOp.Owner.
+	Owner uint64
+
+	// The lock to acquire or release.
+	Lock FileLock
+
+	// If false (F_SETLK), the file system should return EAGAIN immediately if
+	// the lock conflicts with one held by another owner. If true (F_SETLKW), it
+	// should instead wait until the lock can be acquired.
+	//
+	// A blocked caller may be interrupted by a signal, in which case the
+	// context for the op is cancelled. The file system should then stop
+	// waiting and return either EINTR or the context's error; the latter is
+	// translated to EINTR for this op.
+	Block bool
+
+	// If true, this is a flock(2) lock rather than a POSIX record lock. This is
+	// only sent if MountConfig.EnableFlockLocks is set.
+	//
+	// flock(2) locks always cover the whole file, and are owned by the open
+	// file description rather than by the process, so Owner is the same for
+	// all file descriptors that share an open file (e.g. via dup(2) or fork(2)).
+	// Lock types are LockRead for LOCK_SH, LockWrite for LOCK_EX, and
+	// LockUnlock for LOCK_UN. Unlike POSIX locks, converting between shared and
+	// exclusive is not atomic, and a lock is released implicitly only when the
+	// owning file is released (see ReleaseFileHandleOp.FlockUnlock).
+	Flock     bool
 	OpContext OpContext
 }
 
@@ -934,7 +1151,12 @@ type SetXattrOp struct {
 	// If Flags is 0x2, and the attribute does not exist, ENOATTR should be returned.
 	// If Flags is 0x0, the extended attribute will be created if need be, or will
 	// simply replace the value if the attribute exists.
-	Flags     uint32
+	Flags uint32
+
+	// Set if Name is a POSIX access ACL and the file system should clear the
+	// setgid bit of the inode along with setting it. Sent only when
+	// MountConfig.EnableSetxattrExt is set.
+	KillSGID  bool
 	OpContext OpContext
 }
 
@@ -957,3 +1179,146 @@ type FallocateOp struct {
 	Mode      uint32
 	OpContext OpContext
 }
+
+// Perform an ioctl(2) on an open file or directory.
+//
+// Only "restricted" ioctls are supported: the kernel derives the sizes of the
+// input and output data from the direction and size bits encoded in the
+// command (cf. _IOC_DIR and _IOC_SIZE in linux/ioctl.h), copying the input
+// from the caller's argument pointer before the op is sent and copying the
+// output back to it afterward. Ioctls whose argument is a plain integer
+// arrive with both sizes zero and the integer in Arg.
+//
+// File systems that don't recognize Cmd should return ENOTTY, which is what
+// the caller sees for an unsupported ioctl on any other file.
+type IoctlOp struct {
+	// The inode and handle on which the ioctl was called. The handle was
+	// returned by OpenFile or OpenDir, depending on the type of the inode.
+	Inode  InodeID
+	Handle HandleID
+
+	// The ioctl request code and argument, as passed to ioctl(2). For ioctls
+	// that transfer data, Arg is a pointer in the caller's address space and
+	// is of little use to the file system.
+	Cmd uint32
+	Arg uint64
+
+	// The data copied from the caller for commands encoded with _IOC_WRITE. The
+	// slice is only valid until the op is responded to.
+	InData []byte
+
+	// The number of bytes the caller expects back, for commands encoded with
+	// _IOC_READ.
+	OutSize uint32
+
+	// Set by the file system: the value that ioctl(2) should return to the
+	// caller, and the data to be copied back to it. OutData must not be longer
+	// than OutSize; if it is, the caller sees EIO.
+	Result    int32
+	OutData   []byte
+	OpContext OpContext
+}
+
+// Check an open file for readiness, on behalf of poll(2), select(2), or
+// epoll(7).
+//
+// The file system should report which of the requested events are currently
+// ready. If ScheduleNotify is set, the kernel is also waiting to be told when
+// that changes: the file system must remember PollHandle, and once the file
+// becomes ready (or whenever it is unsure) call
+// fuse.Connection.NotifyPollWakeup with it. The kernel will then send a fresh
+// PollOp to find out what is ready. Waking a handle that is no longer of
+// interest to the kernel is harmless.
+//
+// If the file system returns ENOSYS, the kernel stops sending PollOp for the
+// whole mount and treats every file as always ready.
+type PollOp struct {
+	// The inode and handle being polled.
+	Inode  InodeID
+	Handle HandleID
+
+	// The kernel's handle for this waiter, and whether the kernel wants a
+	// wakeup notification for it.
+	PollHandle     PollHandle
+	ScheduleNotify bool
+
+	// The events the caller is interested in, as a mask of POLLIN, POLLOUT,
+	// etc. (cf. poll(2)). Kernels older than Linux 3.10 don't send this, in
+	// which case it is zero and all events should be considered of interest.
+	Events uint32
+
+	// Set by the file system: the mask of events that are currently ready.
+	Revents   uint32
+	OpContext OpContext
+}
+
+// Whence values for SeekFileOp, as used by Linux (cf. lseek(2)).
+const (
+	SeekData = 3
+	SeekHole = 4
+)
+
+// Find the next data or hole in a sparse file, on behalf of lseek(2) with
+// SEEK_DATA or SEEK_HOLE. The kernel handles the other whence values itself.
+//
+// For SeekData, the file system should return the smallest offset at or after
+// Offset that lies within data. For SeekHole, it should return the smallest
+// such offset that lies within a hole, where the end of the file counts as a
+// hole. In both cases, ENXIO should be returned if Offset is at or beyond the
+// end of the file, or for SeekData if there is no data after Offset.
+//
+// File systems that don't track holes may treat the whole file as data.
+//
+// If the file system returns ENOSYS, the kernel stops sending SeekFileOp for
+// the whole mount and does exactly that itself (Linux >= 4.5).
+type SeekFileOp struct {
+	// The file inode and handle being seeked.
+	Inode  InodeID
+	Handle HandleID
+
+	// The offset from which to search, and one of SeekData or SeekHole.
+	Offset int64
+	Whence int
+
+	// Set by the file system: the offset found.
+	NewOffset int64
+	OpContext OpContext
+}
+
+// Copy a range of bytes from one open file to another within the file system,
+// on behalf of copy_file_range(2). This allows the file system to perform the
+// copy without the data passing through the kernel, e.g. by asking a remote
+// server to do it.
+//
+// The two files may be the same, in which case the ranges may not overlap.
+// Like WriteFileOp, the file system should extend the destination file if
+// the copy goes past its end.
+//
+// If the file system returns ENOSYS, the kernel stops sending
+// CopyFileRangeOp for the whole mount and falls back to copying the data
+// itself by way of ReadFileOp and WriteFileOp (Linux >= 4.20).
+type CopyFileRangeOp struct {
+	// The source file inode and handle, and the offset within it.
+	SrcInode  InodeID
+	SrcHandle HandleID
+	SrcOffset uint64
+
+	// The destination file inode and handle, and the offset within it.
+	DstInode  InodeID
+	DstHandle HandleID
+	DstOffset uint64
+
+	// The number of bytes to copy.
+	Length uint64
+
+	// The flags argument to copy_file_range(2). No flags are currently defined,
+	// so this is always zero.
+	Flags uint64
+
+	// Set by the file system: the number of bytes copied, which may be less
+	// than Length (for example, when the end of the source file is reached).
+	// Because the kernel receives this as a 32-bit quantity, it must not exceed
+	// math.MaxUint32.
+	BytesCopied uint64
+	OpContext   OpContext
+}
@@ -17,6 +17,7 @@ package fuseops
 import (
 	"fmt"
 	"os"
+	"syscall"
 	"time"
 
 	"github.com/jacobsa/fuse/internal/fusekernel"
@@ -96,6 +97,10 @@ type InodeAttributes struct {
 	// Ownership information
 	Uid uint32
 	Gid uint32
+
+	// Whether to access the file by way of DAX, when the file system is mounted
+	// in per-file DAX mode and MountConfig.EnableInodeDAX is set (Linux only).
+	DAX bool
 }
 
 func (a *InodeAttributes) DebugString() string {
@@ -132,11 +137,47 @@ type GenerationNumber uint64
 // This corresponds to fuse_file_info::fh.
 type HandleID uint64
 
+// PollHandle is an opaque 64-bit number chosen by the kernel to identify a
+// waiter registered by PollOp. The file system hands it back to the kernel to
+// wake that waiter; see fuse.Connection.NotifyPollWakeup.
+//
+// This corresponds to fuse_pollhandle::kh.
+type PollHandle uint64
+
 // DirOffset is an offset into an open directory handle. This is opaque to
 // FUSE, and can be used for whatever purpose the file system desires. See
 // notes on ReadDirOp.Offset for details.
 type DirOffset uint64
 
+// LockType is the type of a byte-range lock, using the same values as the
+// l_type field of struct flock (cf. fcntl(2)).
+type LockType uint32
+
+const (
+	LockRead   LockType = syscall.F_RDLCK
+	LockWrite  LockType = syscall.F_WRLCK
+	LockUnlock LockType = syscall.F_UNLCK
+)
+
+// FileLock describes a byte-range lock on a file, in the manner of struct
+// flock (cf. fcntl(2)). It is shared by GetFileLockOp and SetFileLockOp.
+type FileLock struct {
+	// The first and last bytes (inclusive) of the range covered by the lock. A
+	// lock extending to the end of the file, however large it may grow, has an
+	// End of math.MaxInt64.
+	Start uint64
+	End   uint64
+
+	// The type of the lock. LockUnlock is used to release a lock, and in the
+	// response to GetFileLockOp to indicate that there is no conflicting lock.
+	Type LockType
+
+	// The PID of the process holding the lock. In requests this is the caller;
+	// in responses to GetFileLockOp it should be the holder of the conflicting
+	// lock, if known.
+	Pid uint32
+}
+
 // ChildInodeEntry contains information about a child inode within its parent
 // directory. It is shared by LookUpInodeOp, MkDirOp, CreateFileOp, etc, and is
 // consumed by the kernel in order to set up a dcache entry.
@@ -220,3 +261,25 @@ type ChildInodeEntry struct {
 	// default. See notes on MountConfig.EnableVnodeCaching for more.
 	EntryExpiration time.Time
 }
+
+// FileRange identifies a range of bytes within an open file. See
+// ReadFileOp.FileData.
+type FileRange struct {
+	File   *os.File
+	Offset int64
+	Size   int64
+}
+
+// SplicedData describes data that the kernel left in a pipe rather than
+// copying it into user space, so that the file system can move it onwards
+// with splice(2). See WriteFileOp.Spliced.
+//
+// The pipe belongs to the connection. The file system may read from it, but
+// must not close it or use it after replying to the op.
+type SplicedData struct {
+	// The read end of the pipe.
+	Pipe *os.File
+
+	// The number of bytes of data in the pipe.
+	Size int
+}
@@ -19,6 +19,8 @@ import (
 	"unsafe"
 
 	"github.com/jacobsa/fuse/fuseops"
+	"github.com/jacobsa/fuse/internal/fuseconv"
+	"github.com/jacobsa/fuse/internal/fusekernel"
 )
 
 type DirentType uint32
@@ -103,3 +105,82 @@ func WriteDirent(buf []byte, d Dirent) (n int) {
 
 	return n
 }
+
+// Parse the directory entry at the start of the given buffer, in the format
+// written by WriteDirent, returning the number of bytes it occupies. Return
+// zero if the buffer doesn't hold a complete entry.
+func parseDirent(buf []byte) (d Dirent, n int) {
+	const direntAlignment = 8
+	const direntSize = 8 + 8 + 4 + 4
+
+	if len(buf) < direntSize {
+		return d, 0
+	}
+
+	ino := *(*uint64)(unsafe.Pointer(&buf[0]))
+	off := *(*uint64)(unsafe.Pointer(&buf[8]))
+	namelen := int(*(*uint32)(unsafe.Pointer(&buf[16])))
+	type_ := *(*uint32)(unsafe.Pointer(&buf[20]))
+
+	n = direntSize + namelen
+	if n%direntAlignment != 0 {
+		n += direntAlignment - n%direntAlignment
+	}
+
+	if direntSize+namelen > len(buf) {
+		return d, 0
+	}
+
+	d = Dirent{
+		Offset: fuseops.DirOffset(off),
+		Inode:  fuseops.InodeID(ino),
+		Name:   string(buf[direntSize : direntSize+namelen]),
+		Type:   DirentType(type_),
+	}
+
+	// The padding after the last entry may be missing.
+	if n > len(buf) {
+		n = len(buf)
+	}
+
+	return d, n
+}
+
+// A directory entry along with the result of looking it up, for use with
+// fuseops.ReadDirPlusOp. See notes on WriteDirentPlus.
+type DirentPlus struct {
+	Dirent Dirent
+
+	// The information that LookUpInodeOp would return for the child. The inode
+	// ID should match Dirent.Inode. Returning an entry whose Child is non-zero
+	// increments that inode's lookup count; see notes on
+	// fuseops.ReadDirPlusOp.
+	Entry fuseops.ChildInodeEntry
+}
+
+// Write the supplied directory entry and its lookup result into the given
+// buffer in the format expected in fuseops.ReadDirPlusOp.Dst, returning the
+// number of bytes written. Return zero if the entry would not fit.
+func WriteDirentPlus(buf []byte, d DirentPlus) (n int) {
+	// The layout is that of fuse_direntplus: a fuse_entry_out followed by a
+	// fuse_dirent as written by WriteDirent. fuse_entry_out is a multiple of
+	// eight bytes long, so alignment is preserved.
+	const entrySize = int(unsafe.Sizeof(fusekernel.EntryOut{}))
+
+	// Do we have enough room? Leave WriteDirent to check its own part.
+	if entrySize > len(buf) {
+		return n
+	}
+
+	direntLen := WriteDirent(buf[entrySize:], d.Dirent)
+	if direntLen == 0 {
+		return n
+	}
+
+	out := (*fusekernel.EntryOut)(unsafe.Pointer(&buf[0]))
+	*out = fusekernel.EntryOut{}
+	fuseconv.ConvertChildInodeEntry(&d.Entry, out)
+
+	n = entrySize + direntLen
+	return n
+}
@@ -0,0 +1,101 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fuseutil_test
+
+import (
+	"encoding/binary"
+	"os"
+	"testing"
+	"unsafe"
+
+	"github.com/jacobsa/fuse/fuseops"
+	"github.com/jacobsa/fuse/fuseutil"
+	"github.com/jacobsa/fuse/internal/fusekernel"
+)
+
+func TestWriteDirentPlus(t *testing.T) {
+	d := fuseutil.DirentPlus{
+		Dirent: fuseutil.Dirent{
+			Offset: 3,
+			Inode:  17,
+			Name:   "taco",
+			Type:   fuseutil.DT_File,
+		},
+		Entry: fuseops.ChildInodeEntry{
+			Child:      17,
+			Generation: 2,
+			Attributes: fuseops.InodeAttributes{
+				Size:  1234,
+				Nlink: 1,
+				Mode:  0644,
+			},
+		},
+	}
+
+	const entrySize = int(unsafe.Sizeof(fusekernel.EntryOut{}))
+
+	// fuse_entry_out, then fuse_dirent (24 bytes) and the name padded to 8.
+	buf := make([]byte, 4096)
+	n := fuseutil.WriteDirentPlus(buf, d)
+	if got, want := n, entrySize+24+8; got != want {
+		t.Fatalf("n = %d, want %d", got, want)
+	}
+
+	out := (*fusekernel.EntryOut)(unsafe.Pointer(&buf[0]))
+	if got, want := out.Nodeid, uint64(17); got != want {
+		t.Errorf("Nodeid = %d, want %d", got, want)
+	}
+
+	if got, want := out.Generation, uint64(2); got != want {
+		t.Errorf("Generation = %d, want %d", got, want)
+	}
+
+	if got, want := out.Attr.Size, uint64(1234); got != want {
+		t.Errorf("Attr.Size = %d, want %d", got, want)
+	}
+
+	if got, want := os.FileMode(out.Attr.Mode&0777), os.FileMode(0644); got != want {
+		t.Errorf("Attr.Mode = %v, want %v", got, want)
+	}
+
+	dirent := buf[entrySize:n]
+	if got, want := binary.LittleEndian.Uint64(dirent[0:8]), uint64(17); got != want {
+		t.Errorf("ino = %d, want %d", got, want)
+	}
+
+	if got, want := binary.LittleEndian.Uint64(dirent[8:16]), uint64(3); got != want {
+		t.Errorf("off = %d, want %d", got, want)
+	}
+
+	if got, want := string(dirent[24:28]), "taco"; got != want {
+		t.Errorf("name = %q, want %q", got, want)
+	}
+}
+
+func TestWriteDirentPlus_DoesNotFit(t *testing.T) {
+	d := fuseutil.DirentPlus{
+		Dirent: fuseutil.Dirent{Inode: 17, Name: "taco"},
+		Entry:  fuseops.ChildInodeEntry{Child: 17},
+	}
+
+	const entrySize = int(unsafe.Sizeof(fusekernel.EntryOut{}))
+
+	for _, size := range []int{0, entrySize - 1, entrySize, entrySize + 24 + 7} {
+		buf := make([]byte, size)
+		if n := fuseutil.WriteDirentPlus(buf, d); n != 0 {
+			t.Errorf("size %d: n = %d, want 0", size, n)
+		}
+	}
+}
@@ -50,6 +50,7 @@ type FileSystem interface {
 	Unlink(context.Context, *fuseops.UnlinkOp) error
 	OpenDir(context.Context, *fuseops.OpenDirOp) error
 	ReadDir(context.Context, *fuseops.ReadDirOp) error
+	ReadDirPlus(context.Context, *fuseops.ReadDirPlusOp) error
 	ReleaseDirHandle(context.Context, *fuseops.ReleaseDirHandleOp) error
 	OpenFile(context.Context, *fuseops.OpenFileOp) error
 	ReadFile(context.Context, *fuseops.ReadFileOp) error
@@ -63,6 +64,13 @@ type FileSystem interface {
 	ListXattr(context.Context, *fuseops.ListXattrOp) error
 	SetXattr(context.Context, *fuseops.SetXattrOp) error
 	Fallocate(context.Context, *fuseops.FallocateOp) error
+	Ioctl(context.Context, *fuseops.IoctlOp) error
+	Poll(context.Context, *fuseops.PollOp) error
+	SeekFile(context.Context, *fuseops.SeekFileOp) error
+	CopyFileRange(context.Context, *fuseops.CopyFileRangeOp) error
+	AccessInode(context.Context, *fuseops.AccessOp) error
+	GetFileLock(context.Context, *fuseops.GetFileLockOp) error
+	SetFileLock(context.Context, *fuseops.SetFileLockOp) error
 
 	// Regard all inodes (including the root inode) as having their lookup counts
 	// decremented to zero, and clean up any resources associated with the file
@@ -70,6 +78,17 @@ type FileSystem interface {
 	Destroy()
 }
 
+// An optional interface that a FileSystem may implement in order to learn
+// about the connection on which it is being served. This allows it to later
+// push changes into the kernel's caches using methods such as
+// fuse.Connection.InvalidateInode and fuse.Connection.InvalidateEntry.
+//
+// SetConnection is called exactly once by the server returned by
+// NewFileSystemServer, before any other method is called.
+type ConnectionSetter interface {
+	SetConnection(*fuse.Connection)
+}
+
 // Create a fuse.Server that handles ops by calling the associated FileSystem
 // method.Respond with the resulting error. Unsupported ops are responded to
 // directly with ENOSYS.
@@ -77,21 +96,47 @@ type FileSystem interface {
 // Each call to a FileSystem method (except ForgetInode) is made on
 // its own goroutine, and is free to block. ForgetInode may be called
 // synchronously, and should not depend on calls to other methods
-// being received concurrently.
+// being received concurrently. (With WithInodeSerialization it too gets a
+// goroutine of its own.) When MountConfig.NumReaders is greater than
+// one, calls to ForgetInode may be concurrent with each other.
 //
 // (It is safe to naively process ops concurrently because the kernel
 // guarantees to serialize operations that the user expects to happen in order,
 // cf. http://goo.gl/jnkHPO, fuse-devel thread "Fuse guarantees on concurrent
 // requests").
-func NewFileSystemServer(fs FileSystem) fuse.Server {
-	return &fileSystemServer{
+//
+// By default there is no limit on the number of ops handled at once. See
+// WithMaxOpsInFlight, WithDataOpWorkers, and WithMetadataOpWorkers for ways
+// to impose one, and WithInodeSerialization for a way to avoid handling ops
+// on the same inode concurrently.
+func NewFileSystemServer(fs FileSystem, opts ...ServerOption) fuse.Server {
+	s := &fileSystemServer{
 		fs: fs,
 	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
 }
 
 type fileSystemServer struct {
 	fs          FileSystem
 	opsInFlight sync.WaitGroup
+
+	// Limits on the number of ops held by the server, and on the number of
+	// data and metadata ops calling the file system. See server_options.go.
+	opSlots         semaphore
+	dataWorkers     semaphore
+	metadataWorkers semaphore
+
+	// Per-inode locks, or nil. See WithInodeSerialization.
+	inodeLocks *inodeLocks
+
+	// Whether the file system handles WriteFileOp.Spliced itself. See
+	// WithSplicedWrites.
+	splicedWrites bool
 }
 
 func (s *fileSystemServer) ServeOps(c *fuse.Connection) {
@@ -102,9 +147,32 @@ func (s *fileSystemServer) ServeOps(c *fuse.Connection) {
 		s.fs.Destroy()
 	}()
 
+	if cs, ok := s.fs.(ConnectionSetter); ok {
+		cs.SetConnection(c)
+	}
+
+	// Read from each of the connection's descriptors concurrently.
+	var readers sync.WaitGroup
+	for i := 0; i < c.NumReaders(); i++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			s.readOps(c)
+		}()
+	}
+
+	readers.Wait()
+}
+
+func (s *fileSystemServer) readOps(c *fuse.Connection) {
 	for {
+		// Wait for room before reading, so that the kernel holds on to
+		// requests while we're saturated.
+		s.opSlots.acquire()
+
 		ctx, op, err := c.ReadOp()
 		if err == io.EOF {
+			s.opSlots.release()
 			break
 		}
 
@@ -113,11 +181,13 @@ func (s *fileSystemServer) ServeOps(c *fuse.Connection) {
 		}
 
 		s.opsInFlight.Add(1)
-		if _, ok := op.(*fuseops.ForgetInodeOp); ok {
+		if _, ok := op.(*fuseops.ForgetInodeOp); ok && s.inodeLocks == nil {
 			// Special case: call in this goroutine for
 			// forget inode ops, which may come in a
 			// flurry from the kernel and are generally
-			// cheap for the file system to handle
+			// cheap for the file system to handle. Not
+			// so when they may have to wait for other
+			// ops on the inode.
 			s.handleOp(c, ctx, op)
 		} else {
 			go s.handleOp(c, ctx, op)
@@ -131,6 +201,53 @@ func (s *fileSystemServer) handleOp(
 	op interface{}) {
 	defer s.opsInFlight.Done()
 
+	// The op holds a slot until it has been replied to, except while it waits
+	// for others. See WithMaxOpsInFlight.
+	holdingSlot := true
+	releaseSlot := func() {
+		if holdingSlot {
+			holdingSlot = false
+			s.opSlots.release()
+		}
+	}
+
+	defer releaseSlot()
+
+	// A blocking lock request holds neither a slot nor a worker while it
+	// waits. See WithMaxOpsInFlight.
+	blockingLock := false
+	if o, ok := op.(*fuseops.SetFileLockOp); ok && o.Block {
+		blockingLock = true
+		releaseSlot()
+	}
+
+	// Wait for other ops on the same inodes, before taking up a worker.
+	if s.inodeLocks != nil {
+		switch op.(type) {
+		case *fuseops.GetFileLockOp, *fuseops.SetFileLockOp:
+		default:
+			ids := OpInodes(op)
+			s.inodeLocks.lock(ids)
+			defer s.inodeLocks.unlock(ids)
+		}
+	}
+
+	// Wait for a worker of the appropriate kind. Forgets may be handled by the
+	// reader, so mustn't wait behind other ops.
+	if _, ok := op.(*fuseops.ForgetInodeOp); !ok && !blockingLock {
+		workers := s.metadataWorkers
+		if IsDataOp(op) {
+			workers = s.dataWorkers
+		}
+
+		if !workers.tryAcquire() {
+			releaseSlot()
+			workers.acquire()
+		}
+
+		defer workers.release()
+	}
+
 	// Dispatch to the appropriate method.
 	var err error
 	switch typed := op.(type) {
@@ -198,6 +315,9 @@ func (s *fileSystemServer) handleOp(
 	case *fuseops.ReadDirOp:
 		err = s.fs.ReadDir(ctx, typed)
 
+	case *fuseops.ReadDirPlusOp:
+		err = s.fs.ReadDirPlus(ctx, typed)
+
 	case *fuseops.ReleaseDirHandleOp:
 		err = s.fs.ReleaseDirHandle(ctx, typed)
 
@@ -208,7 +328,13 @@ func (s *fileSystemServer) handleOp(
 		err = s.fs.ReadFile(ctx, typed)
 
 	case *fuseops.WriteFileOp:
-		err = s.fs.WriteFile(ctx, typed)
+		if typed.Spliced != nil && !s.splicedWrites {
+			err = readSplicedData(typed)
+		}
+
+		if err == nil {
+			err = s.fs.WriteFile(ctx, typed)
+		}
 
 	case *fuseops.SyncFileOp:
 		err = s.fs.SyncFile(ctx, typed)
@@ -236,6 +362,27 @@ func (s *fileSystemServer) handleOp(
 
 	case *fuseops.FallocateOp:
 		err = s.fs.Fallocate(ctx, typed)
+
+	case *fuseops.IoctlOp:
+		err = s.fs.Ioctl(ctx, typed)
+
+	case *fuseops.PollOp:
+		err = s.fs.Poll(ctx, typed)
+
+	case *fuseops.SeekFileOp:
+		err = s.fs.SeekFile(ctx, typed)
+
+	case *fuseops.CopyFileRangeOp:
+		err = s.fs.CopyFileRange(ctx, typed)
+
+	case *fuseops.AccessOp:
+		err = s.fs.AccessInode(ctx, typed)
+
+	case *fuseops.GetFileLockOp:
+		err = s.fs.GetFileLock(ctx, typed)
+
+	case *fuseops.SetFileLockOp:
+		err = s.fs.SetFileLock(ctx, typed)
 	}
 
 	c.Reply(ctx, err)
@@ -0,0 +1,31 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fuseutil
+
+// Return the supplementary group IDs of the process with the given PID,
+// typically taken from fuseops.OpContext.Pid. The kernel sends only the
+// caller's effective UID and GID with each op, so file systems that perform
+// their own permission checks (cf. fuse.MountConfig.DisableDefaultPermissions)
+// need this in order to honor group membership.
+//
+// On Linux this is read from /proc/<pid>/status. On other systems it returns
+// fuse.ENOSYS.
+//
+// Beware that the process may have exited (or its PID may have been reused)
+// by the time this is called, and that PID zero is used for ops that are not
+// attributable to a particular process (such as writeback of dirty pages).
+func SupplementaryGroups(pid uint32) ([]uint32, error) {
+	return supplementaryGroups(pid)
+}
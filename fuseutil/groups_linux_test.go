@@ -0,0 +1,60 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fuseutil_test
+
+import (
+	"os"
+	"sort"
+	"testing"
+
+	"github.com/jacobsa/fuse/fuseutil"
+)
+
+func TestSupplementaryGroups(t *testing.T) {
+	groups, err := fuseutil.SupplementaryGroups(uint32(os.Getpid()))
+	if err != nil {
+		t.Fatalf("SupplementaryGroups: %v", err)
+	}
+
+	want, err := os.Getgroups()
+	if err != nil {
+		t.Fatalf("os.Getgroups: %v", err)
+	}
+
+	var got []int
+	for _, g := range groups {
+		got = append(got, int(g))
+	}
+
+	sort.Ints(got)
+	sort.Ints(want)
+
+	if len(got) != len(want) {
+		t.Fatalf("SupplementaryGroups = %v, want %v", got, want)
+	}
+
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("SupplementaryGroups = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestSupplementaryGroups_NoSuchProcess(t *testing.T) {
+	// PIDs are capped well below this on Linux (cf. PID_MAX_LIMIT).
+	if _, err := fuseutil.SupplementaryGroups(1 << 30); err == nil {
+		t.Errorf("Expected an error for a nonexistent process")
+	}
+}
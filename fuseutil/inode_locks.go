@@ -0,0 +1,209 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fuseutil
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/jacobsa/fuse/fuseops"
+)
+
+// WithInodeSerialization makes the server call the file system for at most
+// one op at a time per inode, while ops on unrelated inodes still run in
+// parallel. This lets a file system reason about each inode as if it were
+// accessed by a single goroutine, rather than guarding everything with one
+// big lock.
+//
+// An op holds the inodes returned by OpInodes for as long as the file system
+// method handling it runs. For ops naming a child by its parent and name, such
+// as LookUpInodeOp and UnlinkOp, that is the parent only; RenameOp holds both
+// parents, and CreateLinkOp both the parent and the target. Inodes are
+// acquired in increasing order, so ops holding more than one can't deadlock
+// with each other.
+//
+// The server can't know which inode a name refers to before the file system
+// has looked it up, so ops that name a child don't hold it. Whatever state of
+// the child they touch, such as its lookup count (LookUpInodeOp, MkDirOp,
+// MkNodeOp, CreateFileOp, CreateSymlinkOp), its link count (UnlinkOp, RmDirOp,
+// RenameOp when it replaces an existing entry) or its parent (RenameOp), may
+// meanwhile be used by ops on the child itself and by ops through its other
+// links, and needs a lock of its own.
+//
+// GetFileLockOp and SetFileLockOp are never serialized, since a blocking lock
+// request waits on ops for the same inode that release the lock.
+//
+// ForgetInodeOp is handled on a goroutine of its own rather than by the
+// goroutine reading from the kernel, so that reading doesn't pause while a
+// forgotten inode is held by another op.
+func WithInodeSerialization() ServerOption {
+	return func(s *fileSystemServer) {
+		s.inodeLocks = &inodeLocks{}
+	}
+}
+
+// OpInodes returns the inodes that op concerns for the purposes of
+// WithInodeSerialization, in increasing order and without duplicates. For ops
+// that name a child of a directory, these don't include the child; see
+// WithInodeSerialization.
+func OpInodes(op interface{}) []fuseops.InodeID {
+	var ids []fuseops.InodeID
+	switch typed := op.(type) {
+	case *fuseops.LookUpInodeOp:
+		ids = append(ids, typed.Parent)
+	case *fuseops.GetInodeAttributesOp:
+		ids = append(ids, typed.Inode)
+	case *fuseops.SetInodeAttributesOp:
+		ids = append(ids, typed.Inode)
+	case *fuseops.AccessOp:
+		ids = append(ids, typed.Inode)
+	case *fuseops.ForgetInodeOp:
+		ids = append(ids, typed.Inode)
+	case *fuseops.BatchForgetOp:
+		for _, e := range typed.Entries {
+			ids = append(ids, e.Inode)
+		}
+	case *fuseops.MkDirOp:
+		ids = append(ids, typed.Parent)
+	case *fuseops.MkNodeOp:
+		ids = append(ids, typed.Parent)
+	case *fuseops.CreateFileOp:
+		ids = append(ids, typed.Parent)
+	case *fuseops.CreateSymlinkOp:
+		ids = append(ids, typed.Parent)
+	case *fuseops.CreateLinkOp:
+		ids = append(ids, typed.Parent, typed.Target)
+	case *fuseops.RenameOp:
+		ids = append(ids, typed.OldParent, typed.NewParent)
+	case *fuseops.RmDirOp:
+		ids = append(ids, typed.Parent)
+	case *fuseops.UnlinkOp:
+		ids = append(ids, typed.Parent)
+	case *fuseops.OpenDirOp:
+		ids = append(ids, typed.Inode)
+	case *fuseops.ReadDirOp:
+		ids = append(ids, typed.Inode)
+	case *fuseops.ReadDirPlusOp:
+		ids = append(ids, typed.Inode)
+	case *fuseops.OpenFileOp:
+		ids = append(ids, typed.Inode)
+	case *fuseops.ReadFileOp:
+		ids = append(ids, typed.Inode)
+	case *fuseops.WriteFileOp:
+		ids = append(ids, typed.Inode)
+	case *fuseops.SyncFileOp:
+		ids = append(ids, typed.Inode)
+	case *fuseops.FlushFileOp:
+		ids = append(ids, typed.Inode)
+	case *fuseops.ReleaseFileHandleOp:
+		ids = append(ids, typed.Inode)
+	case *fuseops.ReadSymlinkOp:
+		ids = append(ids, typed.Inode)
+	case *fuseops.RemoveXattrOp:
+		ids = append(ids, typed.Inode)
+	case *fuseops.GetXattrOp:
+		ids = append(ids, typed.Inode)
+	case *fuseops.ListXattrOp:
+		ids = append(ids, typed.Inode)
+	case *fuseops.SetXattrOp:
+		ids = append(ids, typed.Inode)
+	case *fuseops.FallocateOp:
+		ids = append(ids, typed.Inode)
+	case *fuseops.IoctlOp:
+		ids = append(ids, typed.Inode)
+	case *fuseops.PollOp:
+		ids = append(ids, typed.Inode)
+	case *fuseops.SeekFileOp:
+		ids = append(ids, typed.Inode)
+	case *fuseops.CopyFileRangeOp:
+		ids = append(ids, typed.SrcInode, typed.DstInode)
+	}
+
+	if len(ids) < 2 {
+		return ids
+	}
+
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	// Remove duplicates.
+	n := 1
+	for _, id := range ids[1:] {
+		if id != ids[n-1] {
+			ids[n] = id
+			n++
+		}
+	}
+
+	return ids[:n]
+}
+
+// A set of mutexes keyed by inode, created on demand and discarded when no
+// longer in use.
+type inodeLocks struct {
+	mu sync.Mutex
+
+	// GUARDED_BY(mu)
+	locks map[fuseops.InodeID]*inodeLock
+}
+
+type inodeLock struct {
+	mu sync.Mutex
+
+	// The number of goroutines holding or waiting for mu.
+	//
+	// GUARDED_BY(inodeLocks.mu)
+	refs int
+}
+
+// Lock the given inodes, which must be in increasing order.
+//
+// LOCKS_EXCLUDED(l.mu)
+func (l *inodeLocks) lock(ids []fuseops.InodeID) {
+	for _, id := range ids {
+		l.mu.Lock()
+		if l.locks == nil {
+			l.locks = make(map[fuseops.InodeID]*inodeLock)
+		}
+
+		il := l.locks[id]
+		if il == nil {
+			il = &inodeLock{}
+			l.locks[id] = il
+		}
+
+		il.refs++
+		l.mu.Unlock()
+
+		il.mu.Lock()
+	}
+}
+
+// Unlock inodes previously locked with lock.
+//
+// LOCKS_EXCLUDED(l.mu)
+func (l *inodeLocks) unlock(ids []fuseops.InodeID) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, id := range ids {
+		il := l.locks[id]
+		il.mu.Unlock()
+
+		il.refs--
+		if il.refs == 0 {
+			delete(l.locks, id)
+		}
+	}
+}
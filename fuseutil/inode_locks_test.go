@@ -0,0 +1,79 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fuseutil
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jacobsa/fuse/fuseops"
+)
+
+func TestOpInodes(t *testing.T) {
+	testCases := []struct {
+		op   interface{}
+		want []fuseops.InodeID
+	}{
+		{&fuseops.StatFSOp{}, nil},
+		{&fuseops.LookUpInodeOp{Parent: 3}, []fuseops.InodeID{3}},
+		{&fuseops.RenameOp{OldParent: 7, NewParent: 2}, []fuseops.InodeID{2, 7}},
+		{&fuseops.RenameOp{OldParent: 7, NewParent: 7}, []fuseops.InodeID{7}},
+		{&fuseops.CreateLinkOp{Parent: 4, Target: 9}, []fuseops.InodeID{4, 9}},
+		{
+			&fuseops.BatchForgetOp{Entries: []fuseops.BatchForgetEntry{
+				{Inode: 5}, {Inode: 3}, {Inode: 5},
+			}},
+			[]fuseops.InodeID{3, 5},
+		},
+	}
+
+	for _, tc := range testCases {
+		if got := OpInodes(tc.op); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("OpInodes(%T) = %v, want %v", tc.op, got, tc.want)
+		}
+	}
+}
+
+func TestInodeLocks(t *testing.T) {
+	var l inodeLocks
+
+	l.lock([]fuseops.InodeID{1, 2})
+
+	// An unrelated inode can be locked meanwhile.
+	l.lock([]fuseops.InodeID{3})
+	l.unlock([]fuseops.InodeID{3})
+
+	// An overlapping set must wait.
+	locked := make(chan struct{})
+	go func() {
+		l.lock([]fuseops.InodeID{2, 3})
+		close(locked)
+	}()
+
+	select {
+	case <-locked:
+		t.Fatalf("Locked inode 2 twice")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	l.unlock([]fuseops.InodeID{1, 2})
+	<-locked
+	l.unlock([]fuseops.InodeID{2, 3})
+
+	if len(l.locks) != 0 {
+		t.Errorf("%d locks left over", len(l.locks))
+	}
+}
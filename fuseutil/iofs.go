@@ -0,0 +1,287 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fuseutil
+
+import (
+	"context"
+	"io"
+	iofs "io/fs"
+	"os"
+	"sync"
+	"syscall"
+
+	"github.com/jacobsa/fuse"
+	"github.com/jacobsa/fuse/fuseops"
+)
+
+// The methods of io/fs.ReadLinkFS, which a file system implements in order to
+// expose symlinks.
+type readLinkFS interface {
+	iofs.FS
+	ReadLink(name string) (string, error)
+	Lstat(name string) (iofs.FileInfo, error)
+}
+
+// NewIOFSServer creates a fuse.Server that serves the given io/fs file system
+// read-only. It should be mounted with MountConfig.ReadOnly set.
+//
+// Files are read with ReadAt where they support it. Files that don't are read
+// sequentially, and reopened when a read goes backwards, so random access to
+// them may be slow.
+//
+// If fsys has the methods ReadLink and Lstat, as defined by io/fs.ReadLinkFS,
+// they are used to expose symlinks. Otherwise symlinks are followed, as by
+// io/fs.Stat.
+//
+// Files are owned by the user running the server. Files and directories
+// whose permission bits are all zero, as in fstest.MapFS entries that don't
+// set a mode, are given modes 0444 and 0555 respectively.
+func NewIOFSServer(fsys iofs.FS, opts ...ServerOption) fuse.Server {
+	return NewFileSystemServer(
+		NewPathFileSystem(&ioFS{
+			fsys: fsys,
+			uid:  uint32(os.Getuid()),
+			gid:  uint32(os.Getgid()),
+		}),
+		opts...)
+}
+
+type ioFS struct {
+	NotImplementedPathFileSystem
+
+	fsys iofs.FS
+	uid  uint32
+	gid  uint32
+}
+
+var _ PathFileSystem = &ioFS{}
+
+// Convert the type bits of a file mode to the type of a directory entry.
+func modeDirentType(mode iofs.FileMode) DirentType {
+	switch mode & iofs.ModeType {
+	case 0:
+		return DT_File
+	case iofs.ModeDir:
+		return DT_Directory
+	case iofs.ModeSymlink:
+		return DT_Link
+	case iofs.ModeNamedPipe:
+		return DT_FIFO
+	case iofs.ModeSocket:
+		return DT_Socket
+	case iofs.ModeDevice:
+		return DT_Block
+	case iofs.ModeDevice | iofs.ModeCharDevice:
+		return DT_Char
+	}
+
+	return DT_Unknown
+}
+
+func (fs *ioFS) attributes(fi iofs.FileInfo) fuseops.InodeAttributes {
+	mode := fi.Mode()
+	if mode.Perm() == 0 {
+		if mode.IsDir() {
+			mode |= 0555
+		} else {
+			mode |= 0444
+		}
+	}
+
+	mtime := fi.ModTime()
+	return fuseops.InodeAttributes{
+		Size:  uint64(fi.Size()),
+		Nlink: 1,
+		Mode:  mode,
+		Atime: mtime,
+		Mtime: mtime,
+		Ctime: mtime,
+		Uid:   fs.uid,
+		Gid:   fs.gid,
+	}
+}
+
+// Report an empty file system rather than ENOSYS, which would make statfs(2)
+// fail.
+func (fs *ioFS) StatFS(
+	ctx context.Context,
+	op *fuseops.StatFSOp) error {
+	return nil
+}
+
+func (fs *ioFS) Stat(
+	ctx context.Context,
+	path string) (fuseops.InodeAttributes, error) {
+	var fi iofs.FileInfo
+	var err error
+	if rl, ok := fs.fsys.(readLinkFS); ok {
+		fi, err = rl.Lstat(path)
+	} else {
+		fi, err = iofs.Stat(fs.fsys, path)
+	}
+
+	if err != nil {
+		return fuseops.InodeAttributes{}, err
+	}
+
+	return fs.attributes(fi), nil
+}
+
+func (fs *ioFS) ReadDir(
+	ctx context.Context,
+	path string) ([]PathDirent, error) {
+	dirEntries, err := iofs.ReadDir(fs.fsys, path)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]PathDirent, len(dirEntries))
+	for i, de := range dirEntries {
+		entries[i] = PathDirent{
+			Name: de.Name(),
+			Type: modeDirentType(de.Type()),
+		}
+	}
+
+	return entries, nil
+}
+
+func (fs *ioFS) ReadSymlink(
+	ctx context.Context,
+	path string) (string, error) {
+	rl, ok := fs.fsys.(readLinkFS)
+	if !ok {
+		return "", syscall.EINVAL
+	}
+
+	return rl.ReadLink(path)
+}
+
+func (fs *ioFS) Open(
+	ctx context.Context,
+	path string) (PathFile, error) {
+	f, err := fs.fsys.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	if r, ok := f.(io.ReaderAt); ok {
+		return &ioFSFile{File: f, size: fi.Size(), readerAt: r}, nil
+	}
+
+	return &ioFSFile{File: f, size: fi.Size(), fsys: fs.fsys, path: path}, nil
+}
+
+// A PathFile for an io/fs file, which can't be written.
+type ioFSFile struct {
+	iofs.File
+
+	// The size of the file when it was opened. The contents of an io/fs file
+	// system are assumed not to change.
+	size int64
+
+	// The file's ReadAt method, if it has one.
+	readerAt io.ReaderAt
+
+	// Otherwise, where to find the file in order to reopen it.
+	fsys iofs.FS
+	path string
+
+	mu sync.Mutex
+
+	// The offset of the next byte that File.Read will return.
+	//
+	// GUARDED_BY(mu)
+	offset int64
+}
+
+func (f *ioFSFile) ReadAt(p []byte, off int64) (int, error) {
+	// Some implementations reject reads starting past the end of the file.
+	if off >= f.size {
+		return 0, io.EOF
+	}
+
+	if f.readerAt != nil {
+		return f.readerAt.ReadAt(p, off)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.seek(off); err != nil {
+		return 0, err
+	}
+
+	n, err := io.ReadFull(f.File, p)
+	f.offset += int64(n)
+
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+
+	return n, err
+}
+
+// Position the file so that the next read starts at the given offset, seeking
+// if it can and otherwise reopening it or skipping forward.
+//
+// EXCLUSIVE_LOCKS_REQUIRED(f.mu)
+func (f *ioFSFile) seek(off int64) error {
+	if off == f.offset {
+		return nil
+	}
+
+	if s, ok := f.File.(io.Seeker); ok {
+		if _, err := s.Seek(off, io.SeekStart); err != nil {
+			return err
+		}
+
+		f.offset = off
+		return nil
+	}
+
+	if off < f.offset {
+		newFile, err := f.fsys.Open(f.path)
+		if err != nil {
+			return err
+		}
+
+		f.File.Close()
+		f.File = newFile
+		f.offset = 0
+	}
+
+	n, err := io.CopyN(io.Discard, f.File, off-f.offset)
+	f.offset += n
+
+	return err
+}
+
+func (f *ioFSFile) WriteAt(p []byte, off int64) (int, error) {
+	return 0, syscall.EROFS
+}
+
+func (f *ioFSFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.File.Close()
+}
@@ -0,0 +1,630 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fuseutil
+
+import (
+	"context"
+	"io"
+	iofs "io/fs"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/jacobsa/fuse/fuseops"
+)
+
+// The number of symlinks followed when resolving a path before giving up, as
+// in the Linux kernel.
+const maxSymlinks = 40
+
+// NewIOFS returns an io/fs file system whose files are those of the given
+// FileSystem, without mounting it. Opening and reading files calls the
+// FileSystem's methods directly, as the kernel would, which makes it possible
+// to test a FileSystem with packages such as testing/fstest without root
+// privileges or /dev/fuse.
+//
+// Ops are sent with an OpContext naming the calling process and its user and
+// group, as if it had made the corresponding system calls. Each lookup is
+// balanced by a ForgetInodeOp once the adapter is done with the inode, so a
+// FileSystem that tracks lookup counts should see them return to zero when
+// all files have been closed.
+//
+// Symlinks are followed when opening a path, as long as their targets are
+// relative and stay within the file system. The returned file system also
+// has the methods of io/fs.ReadLinkFS, and implements io/fs.StatFS.
+func NewIOFS(fs FileSystem) iofs.FS {
+	return &clientFS{
+		fs: fs,
+		opContext: fuseops.OpContext{
+			Pid: uint32(os.Getpid()),
+			Uid: uint32(os.Getuid()),
+			Gid: uint32(os.Getgid()),
+		},
+	}
+}
+
+type clientFS struct {
+	fs        FileSystem
+	opContext fuseops.OpContext
+}
+
+var _ iofs.StatFS = &clientFS{}
+
+// Release the adapter's reference to the given inode, obtained by looking it
+// up.
+func (c *clientFS) forget(ctx context.Context, id fuseops.InodeID) {
+	if id != fuseops.RootInodeID {
+		c.fs.ForgetInode(ctx, &fuseops.ForgetInodeOp{
+			Inode:     id,
+			N:         1,
+			OpContext: c.opContext,
+		})
+	}
+}
+
+func (c *clientFS) readSymlink(
+	ctx context.Context,
+	id fuseops.InodeID) (string, error) {
+	op := &fuseops.ReadSymlinkOp{Inode: id, OpContext: c.opContext}
+	if err := c.fs.ReadSymlink(ctx, op); err != nil {
+		return "", err
+	}
+
+	return op.Target, nil
+}
+
+// Resolve the given valid path to an inode, following symlinks along the way
+// and, if followFinal is set, at the end. Unless the result is the root, the
+// caller must forget it when done with it.
+func (c *clientFS) walk(
+	ctx context.Context,
+	name string,
+	followFinal bool) (fuseops.InodeID, fuseops.InodeAttributes, error) {
+	getRootAttrs := &fuseops.GetInodeAttributesOp{
+		Inode:     fuseops.RootInodeID,
+		OpContext: c.opContext,
+	}
+	if err := c.fs.GetInodeAttributes(ctx, getRootAttrs); err != nil {
+		return 0, fuseops.InodeAttributes{}, err
+	}
+
+	var id fuseops.InodeID = fuseops.RootInodeID
+	attrs := getRootAttrs.Attributes
+
+	// The path of id, and the rest of the path to be resolved from there.
+	dir := "."
+	rest := name
+
+	for symlinks := 0; rest != "."; {
+		elem, remainder, _ := strings.Cut(rest, "/")
+		if remainder == "" {
+			remainder = "."
+		}
+
+		// As for the kernel, only directories are searched.
+		if !attrs.Mode.IsDir() {
+			c.forget(ctx, id)
+			return 0, fuseops.InodeAttributes{}, syscall.ENOTDIR
+		}
+
+		op := &fuseops.LookUpInodeOp{
+			Parent:    id,
+			Name:      elem,
+			OpContext: c.opContext,
+		}
+		err := c.fs.LookUpInode(ctx, op)
+		c.forget(ctx, id)
+		if err != nil {
+			return 0, fuseops.InodeAttributes{}, err
+		}
+
+		id = op.Entry.Child
+		attrs = op.Entry.Attributes
+
+		if attrs.Mode&os.ModeSymlink == 0 || (remainder == "." && !followFinal) {
+			dir = path.Join(dir, elem)
+			rest = remainder
+			continue
+		}
+
+		// Start again from the root with the symlink's target substituted.
+		target, err := c.readSymlink(ctx, id)
+		c.forget(ctx, id)
+		if err != nil {
+			return 0, fuseops.InodeAttributes{}, err
+		}
+
+		symlinks++
+		if symlinks > maxSymlinks {
+			return 0, fuseops.InodeAttributes{}, syscall.ELOOP
+		}
+
+		rest = path.Join(dir, target, remainder)
+		if path.IsAbs(target) || !iofs.ValidPath(rest) {
+			return 0, fuseops.InodeAttributes{}, iofs.ErrInvalid
+		}
+
+		id = fuseops.RootInodeID
+		attrs = getRootAttrs.Attributes
+		dir = "."
+	}
+
+	return id, attrs, nil
+}
+
+// Resolve the given path and return its attributes, without keeping a
+// reference to the inode.
+func (c *clientFS) stat(
+	op string,
+	name string,
+	followFinal bool) (iofs.FileInfo, error) {
+	if !iofs.ValidPath(name) {
+		return nil, &iofs.PathError{Op: op, Path: name, Err: iofs.ErrInvalid}
+	}
+
+	ctx := context.Background()
+	id, attrs, err := c.walk(ctx, name, followFinal)
+	if err != nil {
+		return nil, &iofs.PathError{Op: op, Path: name, Err: err}
+	}
+
+	c.forget(ctx, id)
+	return &clientFileInfo{name: path.Base(name), attrs: attrs}, nil
+}
+
+func (c *clientFS) Open(name string) (iofs.File, error) {
+	if !iofs.ValidPath(name) {
+		return nil, &iofs.PathError{Op: "open", Path: name, Err: iofs.ErrInvalid}
+	}
+
+	ctx := context.Background()
+	id, attrs, err := c.walk(ctx, name, true)
+	if err != nil {
+		return nil, &iofs.PathError{Op: "open", Path: name, Err: err}
+	}
+
+	f := &clientFile{
+		fs:    c,
+		name:  name,
+		inode: id,
+		isDir: attrs.Mode.IsDir(),
+	}
+
+	if f.isDir {
+		op := &fuseops.OpenDirOp{Inode: id, OpContext: c.opContext}
+		err = c.fs.OpenDir(ctx, op)
+		f.handle = op.Handle
+	} else {
+		op := &fuseops.OpenFileOp{Inode: id, OpContext: c.opContext}
+		err = c.fs.OpenFile(ctx, op)
+		f.handle = op.Handle
+
+		// Reads are made through ReadFile rather than passed through.
+		if op.BackingFile != nil {
+			op.BackingFile.Close()
+		}
+	}
+
+	if err != nil {
+		c.forget(ctx, id)
+		return nil, &iofs.PathError{Op: "open", Path: name, Err: err}
+	}
+
+	return f, nil
+}
+
+func (c *clientFS) Stat(name string) (iofs.FileInfo, error) {
+	return c.stat("stat", name, true)
+}
+
+func (c *clientFS) Lstat(name string) (iofs.FileInfo, error) {
+	return c.stat("lstat", name, false)
+}
+
+func (c *clientFS) ReadLink(name string) (string, error) {
+	if !iofs.ValidPath(name) {
+		return "", &iofs.PathError{Op: "readlink", Path: name, Err: iofs.ErrInvalid}
+	}
+
+	ctx := context.Background()
+	id, attrs, err := c.walk(ctx, name, false)
+	if err != nil {
+		return "", &iofs.PathError{Op: "readlink", Path: name, Err: err}
+	}
+
+	defer c.forget(ctx, id)
+
+	if attrs.Mode&os.ModeSymlink == 0 {
+		return "", &iofs.PathError{Op: "readlink", Path: name, Err: iofs.ErrInvalid}
+	}
+
+	target, err := c.readSymlink(ctx, id)
+	if err != nil {
+		return "", &iofs.PathError{Op: "readlink", Path: name, Err: err}
+	}
+
+	return target, nil
+}
+
+////////////////////////////////////////////////////////////////////////
+// Files
+////////////////////////////////////////////////////////////////////////
+
+// An open file or directory, whose inode the adapter holds a reference to
+// until it is closed.
+type clientFile struct {
+	fs     *clientFS
+	name   string
+	inode  fuseops.InodeID
+	handle fuseops.HandleID
+	isDir  bool
+
+	mu sync.Mutex
+
+	// GUARDED_BY(mu)
+	closed bool
+
+	// The offset of the next read, for files.
+	//
+	// GUARDED_BY(mu)
+	offset int64
+
+	// For directories, the offset from which to read more entries, entries
+	// that have been read but not yet returned, and whether the end has been
+	// reached.
+	//
+	// GUARDED_BY(mu)
+	dirOffset fuseops.DirOffset
+	pending   []Dirent
+	dirEOF    bool
+}
+
+var _ iofs.ReadDirFile = &clientFile{}
+var _ io.ReaderAt = &clientFile{}
+var _ io.Seeker = &clientFile{}
+
+func (f *clientFile) Stat() (iofs.FileInfo, error) {
+	op := &fuseops.GetInodeAttributesOp{Inode: f.inode, OpContext: f.fs.opContext}
+	if err := f.fs.fs.GetInodeAttributes(context.Background(), op); err != nil {
+		return nil, &iofs.PathError{Op: "stat", Path: f.name, Err: err}
+	}
+
+	return &clientFileInfo{name: path.Base(f.name), attrs: op.Attributes}, nil
+}
+
+// Issue a single ReadFileOp, returning the number of bytes read.
+func (f *clientFile) readOnce(
+	ctx context.Context,
+	p []byte,
+	off int64) (int, error) {
+	op := &fuseops.ReadFileOp{
+		Inode:     f.inode,
+		Handle:    f.handle,
+		Offset:    off,
+		Size:      int64(len(p)),
+		Dst:       p,
+		OpContext: f.fs.opContext,
+	}
+
+	if err := f.fs.fs.ReadFile(ctx, op); err != nil {
+		return 0, err
+	}
+
+	switch {
+	case op.FileData != nil:
+		fr := op.FileData
+		if fr.Size < int64(len(p)) {
+			p = p[:fr.Size]
+		}
+
+		n, err := fr.File.ReadAt(p, fr.Offset)
+		if err == io.EOF {
+			err = nil
+		}
+
+		return n, err
+
+	case op.Data != nil:
+		var n int
+		for _, b := range op.Data {
+			n += copy(p[n:], b)
+		}
+
+		return n, nil
+	}
+
+	return op.BytesRead, nil
+}
+
+func (f *clientFile) ReadAt(p []byte, off int64) (int, error) {
+	if f.isDir {
+		return 0, &iofs.PathError{Op: "read", Path: f.name, Err: syscall.EISDIR}
+	}
+
+	if off < 0 {
+		return 0, &iofs.PathError{Op: "read", Path: f.name, Err: iofs.ErrInvalid}
+	}
+
+	// The file system may return short reads, so keep going until the buffer
+	// is full or the end of the file is reached.
+	var n int
+	for n < len(p) {
+		m, err := f.readOnce(context.Background(), p[n:], off+int64(n))
+		if err != nil {
+			return n, &iofs.PathError{Op: "read", Path: f.name, Err: err}
+		}
+
+		if m == 0 {
+			return n, io.EOF
+		}
+
+		n += m
+	}
+
+	return n, nil
+}
+
+func (f *clientFile) Read(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return 0, &iofs.PathError{Op: "read", Path: f.name, Err: iofs.ErrClosed}
+	}
+
+	n, err := f.ReadAt(p, f.offset)
+	f.offset += int64(n)
+
+	// Unlike ReadAt, Read may return fewer bytes without an error.
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+
+	return n, err
+}
+
+func (f *clientFile) Seek(offset int64, whence int) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+
+	case io.SeekEnd:
+		fi, err := f.Stat()
+		if err != nil {
+			return 0, err
+		}
+
+		offset += fi.Size()
+
+	default:
+		return 0, &iofs.PathError{Op: "seek", Path: f.name, Err: iofs.ErrInvalid}
+	}
+
+	if offset < 0 {
+		return 0, &iofs.PathError{Op: "seek", Path: f.name, Err: iofs.ErrInvalid}
+	}
+
+	f.offset = offset
+	return offset, nil
+}
+
+// Read more entries from the directory into f.pending.
+//
+// EXCLUSIVE_LOCKS_REQUIRED(f.mu)
+func (f *clientFile) readDirents(ctx context.Context) error {
+	op := &fuseops.ReadDirOp{
+		Inode:     f.inode,
+		Handle:    f.handle,
+		Offset:    f.dirOffset,
+		Dst:       make([]byte, 8192),
+		OpContext: f.fs.opContext,
+	}
+
+	if err := f.fs.fs.ReadDir(ctx, op); err != nil {
+		return err
+	}
+
+	if op.BytesRead == 0 {
+		f.dirEOF = true
+		return nil
+	}
+
+	for buf := op.Dst[:op.BytesRead]; len(buf) > 0; {
+		d, n := parseDirent(buf)
+		if n == 0 {
+			break
+		}
+
+		f.pending = append(f.pending, d)
+		f.dirOffset = d.Offset
+		buf = buf[n:]
+	}
+
+	return nil
+}
+
+func (f *clientFile) ReadDir(count int) ([]iofs.DirEntry, error) {
+	if !f.isDir {
+		return nil, &iofs.PathError{Op: "readdir", Path: f.name, Err: syscall.ENOTDIR}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return nil, &iofs.PathError{Op: "readdir", Path: f.name, Err: iofs.ErrClosed}
+	}
+
+	var entries []iofs.DirEntry
+	for count <= 0 || len(entries) < count {
+		if len(f.pending) == 0 {
+			if f.dirEOF {
+				break
+			}
+
+			if err := f.readDirents(context.Background()); err != nil {
+				return entries, &iofs.PathError{Op: "readdir", Path: f.name, Err: err}
+			}
+
+			continue
+		}
+
+		d := f.pending[0]
+		f.pending = f.pending[1:]
+
+		if d.Name == "." || d.Name == ".." {
+			continue
+		}
+
+		entries = append(entries, &clientDirEntry{
+			fs:     f.fs,
+			path:   path.Join(f.name, d.Name),
+			dirent: d,
+		})
+	}
+
+	if count > 0 && len(entries) == 0 {
+		return nil, io.EOF
+	}
+
+	return entries, nil
+}
+
+func (f *clientFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return &iofs.PathError{Op: "close", Path: f.name, Err: iofs.ErrClosed}
+	}
+
+	f.closed = true
+	ctx := context.Background()
+
+	// The kernel ignores errors from releasing handles, so a file system that
+	// doesn't implement it has nothing to release.
+	var err error
+	if f.isDir {
+		err = f.fs.fs.ReleaseDirHandle(ctx, &fuseops.ReleaseDirHandleOp{
+			Handle:    f.handle,
+			OpContext: f.fs.opContext,
+		})
+		if err == syscall.ENOSYS {
+			err = nil
+		}
+	} else {
+		// As for the kernel, a file system that doesn't implement flushing has
+		// nothing to flush.
+		err = f.fs.fs.FlushFile(ctx, &fuseops.FlushFileOp{
+			Inode:     f.inode,
+			Handle:    f.handle,
+			OpContext: f.fs.opContext,
+		})
+		if err == syscall.ENOSYS {
+			err = nil
+		}
+
+		releaseErr := f.fs.fs.ReleaseFileHandle(ctx, &fuseops.ReleaseFileHandleOp{
+			Handle:    f.handle,
+			OpContext: f.fs.opContext,
+		})
+		if err == nil && releaseErr != syscall.ENOSYS {
+			err = releaseErr
+		}
+	}
+
+	f.fs.forget(ctx, f.inode)
+
+	if err != nil {
+		return &iofs.PathError{Op: "close", Path: f.name, Err: err}
+	}
+
+	return nil
+}
+
+////////////////////////////////////////////////////////////////////////
+// File info
+////////////////////////////////////////////////////////////////////////
+
+type clientFileInfo struct {
+	name  string
+	attrs fuseops.InodeAttributes
+}
+
+func (fi *clientFileInfo) Name() string        { return fi.name }
+func (fi *clientFileInfo) Size() int64         { return int64(fi.attrs.Size) }
+func (fi *clientFileInfo) Mode() iofs.FileMode { return fi.attrs.Mode }
+func (fi *clientFileInfo) ModTime() time.Time  { return fi.attrs.Mtime }
+func (fi *clientFileInfo) IsDir() bool         { return fi.attrs.Mode.IsDir() }
+
+// Sys returns the fuseops.InodeAttributes.
+func (fi *clientFileInfo) Sys() interface{} { return fi.attrs }
+
+// An entry returned by ReadDir, which is looked up when its info is needed.
+type clientDirEntry struct {
+	fs     *clientFS
+	path   string
+	dirent Dirent
+}
+
+// Convert the type of a directory entry to the type bits of a file mode, with
+// ok false if the type isn't known.
+func direntTypeMode(t DirentType) (mode iofs.FileMode, ok bool) {
+	switch t {
+	case DT_File:
+		return 0, true
+	case DT_Directory:
+		return iofs.ModeDir, true
+	case DT_Link:
+		return iofs.ModeSymlink, true
+	case DT_FIFO:
+		return iofs.ModeNamedPipe, true
+	case DT_Socket:
+		return iofs.ModeSocket, true
+	case DT_Block:
+		return iofs.ModeDevice, true
+	case DT_Char:
+		return iofs.ModeDevice | iofs.ModeCharDevice, true
+	}
+
+	return 0, false
+}
+
+func (e *clientDirEntry) Name() string { return e.dirent.Name }
+func (e *clientDirEntry) IsDir() bool  { return e.Type().IsDir() }
+
+func (e *clientDirEntry) Type() iofs.FileMode {
+	if mode, ok := direntTypeMode(e.dirent.Type); ok {
+		return mode
+	}
+
+	fi, err := e.Info()
+	if err != nil {
+		return 0
+	}
+
+	return fi.Mode().Type()
+}
+
+func (e *clientDirEntry) Info() (iofs.FileInfo, error) {
+	return e.fs.stat("lstat", e.path, false)
+}
@@ -0,0 +1,87 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fuseutil
+
+import (
+	"errors"
+	iofs "io/fs"
+	"syscall"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestIOFSClient(t *testing.T) {
+	mtime := time.Date(2015, 3, 7, 0, 0, 0, 0, time.UTC)
+	fs := newIOFSFileSystem(fstest.MapFS{
+		"foo":         {Data: []byte("taco"), ModTime: mtime},
+		"dir/bar":     {Data: []byte("burrito"), Mode: 0600, ModTime: mtime},
+		"dir/sub/baz": {Data: make([]byte, 20000), ModTime: mtime},
+		"empty":       {Mode: iofs.ModeDir | 0700, ModTime: mtime},
+	})
+
+	if err := fstest.TestFS(NewIOFS(fs), "foo", "dir/bar", "dir/sub/baz", "empty"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every lookup should have been forgotten.
+	if inodes := fs.(*pathFileSystem).inodes; len(inodes) != 1 {
+		t.Errorf("%d inodes still known after closing all files", len(inodes))
+	}
+}
+
+func TestIOFSClientSymlinks(t *testing.T) {
+	fsys := NewIOFS(newIOFSFileSystem(symlinkMapFS{fstest.MapFS{
+		"dir/foo":  {Data: []byte("taco")},
+		"dirlink":  {Data: []byte("dir"), Mode: iofs.ModeSymlink | 0777},
+		"filelink": {Data: []byte("dirlink/foo"), Mode: iofs.ModeSymlink | 0777},
+		"loop":     {Data: []byte("loop"), Mode: iofs.ModeSymlink | 0777},
+		"escape":   {Data: []byte("../foo"), Mode: iofs.ModeSymlink | 0777},
+	}}))
+
+	for _, name := range []string{"dir/foo", "dirlink/foo", "filelink"} {
+		contents, err := iofs.ReadFile(fsys, name)
+		if err != nil || string(contents) != "taco" {
+			t.Errorf("ReadFile(%q): %q, %v", name, contents, err)
+		}
+	}
+
+	for _, name := range []string{"loop", "escape"} {
+		if _, err := fsys.Open(name); err == nil {
+			t.Errorf("Open(%q) succeeded", name)
+		}
+	}
+
+	fi, err := fsys.(*clientFS).Lstat("filelink")
+	if err != nil || fi.Mode().Type() != iofs.ModeSymlink {
+		t.Errorf("Lstat(filelink): %v, %v", fi, err)
+	}
+
+	target, err := fsys.(*clientFS).ReadLink("filelink")
+	if err != nil || target != "dirlink/foo" {
+		t.Errorf("ReadLink(filelink): %q, %v", target, err)
+	}
+}
+
+func TestIOFSClientNotADirectory(t *testing.T) {
+	fsys := NewIOFS(newIOFSFileSystem(fstest.MapFS{
+		"foo": {Data: []byte("taco")},
+	}))
+
+	_, err := fsys.Open("foo/bar")
+	if !errors.Is(err, syscall.ENOTDIR) {
+		t.Errorf("Open(foo/bar): %v, want ENOTDIR", err)
+	}
+}
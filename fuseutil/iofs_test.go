@@ -0,0 +1,194 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fuseutil
+
+import (
+	"context"
+	iofs "io/fs"
+	"os"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/jacobsa/fuse/fuseops"
+)
+
+// An fstest.MapFS that exposes symlinks, whose targets are their data.
+type symlinkMapFS struct {
+	fstest.MapFS
+}
+
+func (fsys symlinkMapFS) ReadLink(name string) (string, error) {
+	f := fsys.MapFS[name]
+	if f == nil || f.Mode&iofs.ModeSymlink == 0 {
+		return "", &iofs.PathError{Op: "readlink", Path: name, Err: iofs.ErrInvalid}
+	}
+
+	return string(f.Data), nil
+}
+
+type symlinkInfo struct {
+	iofs.FileInfo
+}
+
+func (fi symlinkInfo) Mode() iofs.FileMode {
+	return iofs.ModeSymlink | 0777
+}
+
+func (fsys symlinkMapFS) Lstat(name string) (iofs.FileInfo, error) {
+	f := fsys.MapFS[name]
+	if f == nil || f.Mode&iofs.ModeSymlink == 0 {
+		return fsys.MapFS.Stat(name)
+	}
+
+	// Stat a regular file in place of the symlink, so as not to follow it.
+	fi, err := fstest.MapFS{name: &fstest.MapFile{Data: f.Data}}.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return symlinkInfo{fi}, nil
+}
+
+// An fstest.MapFS whose files support neither ReadAt nor Seek.
+type sequentialMapFS struct {
+	fstest.MapFS
+}
+
+type sequentialFile struct {
+	iofs.File
+}
+
+func (fsys sequentialMapFS) Open(name string) (iofs.File, error) {
+	f, err := fsys.MapFS.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return sequentialFile{f}, nil
+}
+
+func newIOFSFileSystem(fsys iofs.FS) FileSystem {
+	return NewPathFileSystem(&ioFS{fsys: fsys})
+}
+
+func readFile(
+	t *testing.T,
+	fs FileSystem,
+	inode fuseops.InodeID,
+	offsets ...int64) []string {
+	t.Helper()
+	ctx := context.Background()
+
+	openFile := &fuseops.OpenFileOp{Inode: inode}
+	if err := fs.OpenFile(ctx, openFile); err != nil {
+		t.Fatalf("OpenFile: %v", err)
+	}
+
+	defer fs.ReleaseFileHandle(ctx, &fuseops.ReleaseFileHandleOp{Handle: openFile.Handle})
+
+	var results []string
+	for _, off := range offsets {
+		op := &fuseops.ReadFileOp{
+			Inode:  inode,
+			Handle: openFile.Handle,
+			Offset: off,
+			Dst:    make([]byte, 4),
+		}
+
+		if err := fs.ReadFile(ctx, op); err != nil {
+			t.Fatalf("ReadFile(%d): %v", off, err)
+		}
+
+		results = append(results, string(op.Dst[:op.BytesRead]))
+	}
+
+	return results
+}
+
+func TestIOFS(t *testing.T) {
+	ctx := context.Background()
+	mtime := time.Date(2015, 3, 7, 0, 0, 0, 0, time.UTC)
+	fs := newIOFSFileSystem(symlinkMapFS{fstest.MapFS{
+		"dir/foo": {Data: []byte("taco burrito"), Mode: 0640, ModTime: mtime},
+		"link":    {Data: []byte("dir/foo"), Mode: iofs.ModeSymlink | 0777},
+	}})
+
+	dir := lookUp(t, fs, fuseops.RootInodeID, "dir")
+	if dir.Attributes.Mode != os.ModeDir|0555 {
+		t.Errorf("dir mode: %v", dir.Attributes.Mode)
+	}
+
+	foo := lookUp(t, fs, dir.Child, "foo")
+	if foo.Attributes.Mode != 0640 ||
+		foo.Attributes.Size != 12 ||
+		!foo.Attributes.Mtime.Equal(mtime) {
+		t.Errorf("foo attributes: %v", foo.Attributes)
+	}
+
+	got := readFile(t, fs, foo.Child, 5, 0, 10, 20)
+	want := []string{"burr", "taco", "to", ""}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Read %d: %q, want %q", i, got[i], want[i])
+		}
+	}
+
+	link := lookUp(t, fs, fuseops.RootInodeID, "link")
+	if link.Attributes.Mode&os.ModeSymlink == 0 {
+		t.Errorf("link mode: %v", link.Attributes.Mode)
+	}
+
+	readSymlink := &fuseops.ReadSymlinkOp{Inode: link.Child}
+	if err := fs.ReadSymlink(ctx, readSymlink); err != nil {
+		t.Fatalf("ReadSymlink: %v", err)
+	}
+
+	if readSymlink.Target != "dir/foo" {
+		t.Errorf("Target: %q", readSymlink.Target)
+	}
+
+	openDir := &fuseops.OpenDirOp{Inode: fuseops.RootInodeID}
+	if err := fs.OpenDir(ctx, openDir); err != nil {
+		t.Fatalf("OpenDir: %v", err)
+	}
+
+	entries := fs.(*pathFileSystem).dirHandle(openDir.Handle).entries
+	if len(entries) != 2 ||
+		entries[0].Name != "dir" || entries[0].Type != DT_Directory ||
+		entries[1].Name != "link" || entries[1].Type != DT_Link {
+		t.Errorf("Entries: %+v", entries)
+	}
+}
+
+func TestIOFSSequentialFile(t *testing.T) {
+	fs := newIOFSFileSystem(sequentialMapFS{fstest.MapFS{
+		"foo": {Data: []byte("taco burrito")},
+	}})
+
+	foo := lookUp(t, fs, fuseops.RootInodeID, "foo")
+	if foo.Attributes.Mode != 0444 {
+		t.Errorf("foo mode: %v", foo.Attributes.Mode)
+	}
+
+	// Reads going backwards reopen the file.
+	got := readFile(t, fs, foo.Child, 0, 4, 10, 2, 20)
+	want := []string{"taco", " bur", "to", "co b", ""}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Read %d: %q, want %q", i, got[i], want[i])
+		}
+	}
+}
@@ -126,6 +126,12 @@ func (fs *NotImplementedFileSystem) ReadDir(
 	return fuse.ENOSYS
 }
 
+func (fs *NotImplementedFileSystem) ReadDirPlus(
+	ctx context.Context,
+	op *fuseops.ReadDirPlusOp) error {
+	return fuse.ENOSYS
+}
+
 func (fs *NotImplementedFileSystem) ReleaseDirHandle(
 	ctx context.Context,
 	op *fuseops.ReleaseDirHandleOp) error {
@@ -204,5 +210,47 @@ func (fs *NotImplementedFileSystem) Fallocate(
 	return fuse.ENOSYS
 }
 
+func (fs *NotImplementedFileSystem) Ioctl(
+	ctx context.Context,
+	op *fuseops.IoctlOp) error {
+	return fuse.ENOSYS
+}
+
+func (fs *NotImplementedFileSystem) Poll(
+	ctx context.Context,
+	op *fuseops.PollOp) error {
+	return fuse.ENOSYS
+}
+
+func (fs *NotImplementedFileSystem) SeekFile(
+	ctx context.Context,
+	op *fuseops.SeekFileOp) error {
+	return fuse.ENOSYS
+}
+
+func (fs *NotImplementedFileSystem) CopyFileRange(
+	ctx context.Context,
+	op *fuseops.CopyFileRangeOp) error {
+	return fuse.ENOSYS
+}
+
+func (fs *NotImplementedFileSystem) AccessInode(
+	ctx context.Context,
+	op *fuseops.AccessOp) error {
+	return fuse.ENOSYS
+}
+
+func (fs *NotImplementedFileSystem) GetFileLock(
+	ctx context.Context,
+	op *fuseops.GetFileLockOp) error {
+	return fuse.ENOSYS
+}
+
+func (fs *NotImplementedFileSystem) SetFileLock(
+	ctx context.Context,
+	op *fuseops.SetFileLockOp) error {
+	return fuse.ENOSYS
+}
+
 func (fs *NotImplementedFileSystem) Destroy() {
 }
@@ -0,0 +1,810 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fuseutil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	iofs "io/fs"
+	"os"
+	"path"
+	"sync"
+	"syscall"
+
+	"github.com/jacobsa/fuse/fuseops"
+)
+
+// The inode number reported by ReadDir for entries the kernel hasn't looked
+// up, and which therefore have no inode ID yet.
+const unknownInodeID = ^fuseops.InodeID(0)
+
+// NewPathFileSystem returns a FileSystem that serves the given PathFileSystem,
+// for use with NewFileSystemServer.
+func NewPathFileSystem(fs PathFileSystem) FileSystem {
+	root := &pathInode{
+		id:          fuseops.RootInodeID,
+		name:        ".",
+		children:    make(map[string]*pathInode),
+		fileType:    os.ModeDir,
+		lookupCount: 1,
+	}
+
+	return &pathFileSystem{
+		fs: fs,
+		inodes: map[fuseops.InodeID]*pathInode{
+			fuseops.RootInodeID: root,
+		},
+		nextID:     fuseops.RootInodeID + 1,
+		handles:    make(map[fuseops.HandleID]interface{}),
+		nextHandle: 1,
+	}
+}
+
+// An inode known to the kernel, or the ancestor of one.
+type pathInode struct {
+	id         fuseops.InodeID
+	generation fuseops.GenerationNumber
+
+	// The inode's parent and name within it. The parent is nil for the root,
+	// and for inodes that have been removed or replaced by a rename.
+	parent *pathInode
+	name   string
+
+	// Children that the kernel knows about, by name.
+	children map[string]*pathInode
+
+	// The type bits of the mode, and the attributes last returned by Stat.
+	// The attributes are reported for inodes that have been removed.
+	fileType os.FileMode
+	attrs    fuseops.InodeAttributes
+
+	lookupCount uint64
+}
+
+// An inode ID that has been forgotten, along with the generation number it
+// last had.
+type freeInodeID struct {
+	id         fuseops.InodeID
+	generation fuseops.GenerationNumber
+}
+
+type pathFileHandle struct {
+	file PathFile
+}
+
+type pathDirHandle struct {
+	mu sync.Mutex
+
+	// The directory's entries, read when the directory was opened or last
+	// read from the start.
+	//
+	// GUARDED_BY(mu)
+	entries []Dirent
+}
+
+type pathFileSystem struct {
+	NotImplementedFileSystem
+
+	fs PathFileSystem
+
+	mu sync.Mutex
+
+	// The inodes that the kernel knows about.
+	//
+	// INVARIANT: For each k/v, v.id == k
+	// INVARIANT: For each v other than the root, v.lookupCount > 0
+	//
+	// GUARDED_BY(mu)
+	inodes map[fuseops.InodeID]*pathInode
+
+	// IDs available for reuse, and the next ID to hand out when there are none.
+	//
+	// GUARDED_BY(mu)
+	freeIDs []freeInodeID
+	nextID  fuseops.InodeID
+
+	// Open handles, each either a *pathFileHandle or a *pathDirHandle.
+	//
+	// GUARDED_BY(mu)
+	handles    map[fuseops.HandleID]interface{}
+	nextHandle fuseops.HandleID
+}
+
+////////////////////////////////////////////////////////////////////////
+// Helpers
+////////////////////////////////////////////////////////////////////////
+
+// Translate an error from the PathFileSystem into one for the kernel.
+func pathErrno(err error) error {
+	var errno syscall.Errno
+	switch {
+	case err == nil:
+		return nil
+
+	case errors.As(err, &errno):
+		return errno
+
+	case errors.Is(err, iofs.ErrNotExist):
+		return syscall.ENOENT
+
+	case errors.Is(err, iofs.ErrExist):
+		return syscall.EEXIST
+
+	case errors.Is(err, iofs.ErrPermission):
+		return syscall.EACCES
+
+	case errors.Is(err, iofs.ErrInvalid):
+		return syscall.EINVAL
+	}
+
+	return err
+}
+
+// Find the inode with the given ID, which the kernel must know about.
+//
+// EXCLUSIVE_LOCKS_REQUIRED(fs.mu)
+func (fs *pathFileSystem) getInodeOrDie(id fuseops.InodeID) *pathInode {
+	in := fs.inodes[id]
+	if in == nil {
+		panic(fmt.Sprintf("Unknown inode: %v", id))
+	}
+
+	return in
+}
+
+// Return the current path of the given inode, or ENOENT if it or one of its
+// ancestors has been removed.
+//
+// LOCKS_EXCLUDED(fs.mu)
+func (fs *pathFileSystem) path(id fuseops.InodeID) (string, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	var names []string
+	for in := fs.getInodeOrDie(id); in.id != fuseops.RootInodeID; in = in.parent {
+		if in.parent == nil {
+			return "", syscall.ENOENT
+		}
+
+		names = append(names, in.name)
+	}
+
+	// Reverse the names into root-first order.
+	for i, j := 0, len(names)-1; i < j; i, j = i+1, j-1 {
+		names[i], names[j] = names[j], names[i]
+	}
+
+	return path.Join(append([]string{"."}, names...)...), nil
+}
+
+// Return the path of the child with the given name of the given directory.
+//
+// LOCKS_EXCLUDED(fs.mu)
+func (fs *pathFileSystem) childPath(
+	parent fuseops.InodeID,
+	name string) (string, error) {
+	p, err := fs.path(parent)
+	if err != nil {
+		return "", err
+	}
+
+	return path.Join(p, name), nil
+}
+
+// Call Stat, fixing up the result for the kernel.
+func (fs *pathFileSystem) stat(
+	ctx context.Context,
+	p string) (fuseops.InodeAttributes, error) {
+	attrs, err := fs.fs.Stat(ctx, p)
+	if err != nil {
+		return fuseops.InodeAttributes{}, pathErrno(err)
+	}
+
+	if attrs.Nlink == 0 {
+		attrs.Nlink = 1
+	}
+
+	return attrs, nil
+}
+
+// Record that the kernel has looked up the child with the given name of the
+// given directory, which has the given attributes, and fill in the entry for
+// the reply.
+//
+// LOCKS_EXCLUDED(fs.mu)
+func (fs *pathFileSystem) lookedUp(
+	parentID fuseops.InodeID,
+	name string,
+	attrs fuseops.InodeAttributes,
+	entry *fuseops.ChildInodeEntry) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	parent := fs.getInodeOrDie(parentID)
+	child := parent.children[name]
+
+	// If the file has been replaced by one of a different type behind our
+	// back, the kernel won't accept the old ID for it.
+	if child != nil && child.fileType != attrs.Mode&os.ModeType {
+		fs.detach(child)
+		child = nil
+	}
+
+	if child == nil {
+		child = &pathInode{
+			parent:   parent,
+			name:     name,
+			children: make(map[string]*pathInode),
+			fileType: attrs.Mode & os.ModeType,
+		}
+
+		if n := len(fs.freeIDs); n > 0 {
+			free := fs.freeIDs[n-1]
+			fs.freeIDs = fs.freeIDs[:n-1]
+			child.id = free.id
+			child.generation = free.generation + 1
+		} else {
+			child.id = fs.nextID
+			fs.nextID++
+		}
+
+		fs.inodes[child.id] = child
+		parent.children[name] = child
+	}
+
+	child.attrs = attrs
+	child.lookupCount++
+
+	entry.Child = child.id
+	entry.Generation = child.generation
+	entry.Attributes = attrs
+}
+
+// Stat the child with the given name of the given directory, and record that
+// the kernel has looked it up.
+//
+// LOCKS_EXCLUDED(fs.mu)
+func (fs *pathFileSystem) lookUp(
+	ctx context.Context,
+	parent fuseops.InodeID,
+	name string,
+	entry *fuseops.ChildInodeEntry) error {
+	p, err := fs.childPath(parent, name)
+	if err != nil {
+		return err
+	}
+
+	attrs, err := fs.stat(ctx, p)
+	if err != nil {
+		return err
+	}
+
+	fs.lookedUp(parent, name, attrs, entry)
+	return nil
+}
+
+// Remove the given inode from its parent, after which it has no path.
+//
+// EXCLUSIVE_LOCKS_REQUIRED(fs.mu)
+func (fs *pathFileSystem) detach(in *pathInode) {
+	if in.parent == nil {
+		return
+	}
+
+	if in.parent.children[in.name] == in {
+		delete(in.parent.children, in.name)
+	}
+
+	in.parent = nil
+	in.attrs.Nlink = 0
+}
+
+// Decrement the lookup count of the inode with the given ID, forgetting it
+// when it reaches zero.
+//
+// EXCLUSIVE_LOCKS_REQUIRED(fs.mu)
+func (fs *pathFileSystem) forget(id fuseops.InodeID, n uint64) {
+	in := fs.getInodeOrDie(id)
+	if id == fuseops.RootInodeID {
+		return
+	}
+
+	if n > in.lookupCount {
+		panic(fmt.Sprintf(
+			"Inode %v: lookup count %d decremented by %d",
+			id,
+			in.lookupCount,
+			n))
+	}
+
+	in.lookupCount -= n
+	if in.lookupCount != 0 {
+		return
+	}
+
+	// Any children the kernel still knows about keep their pointers to the
+	// inode, so their paths are unaffected.
+	if in.parent != nil && in.parent.children[in.name] == in {
+		delete(in.parent.children, in.name)
+	}
+
+	delete(fs.inodes, id)
+	fs.freeIDs = append(fs.freeIDs, freeInodeID{id, in.generation})
+}
+
+// Remove the child with the given name from the given directory, if the
+// kernel knows about it.
+//
+// LOCKS_EXCLUDED(fs.mu)
+func (fs *pathFileSystem) removed(parentID fuseops.InodeID, name string) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if child := fs.getInodeOrDie(parentID).children[name]; child != nil {
+		fs.detach(child)
+	}
+}
+
+// LOCKS_EXCLUDED(fs.mu)
+func (fs *pathFileSystem) newHandle(h interface{}) fuseops.HandleID {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	id := fs.nextHandle
+	fs.nextHandle++
+	fs.handles[id] = h
+
+	return id
+}
+
+// LOCKS_EXCLUDED(fs.mu)
+func (fs *pathFileSystem) fileHandle(id fuseops.HandleID) *pathFileHandle {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	return fs.handles[id].(*pathFileHandle)
+}
+
+// LOCKS_EXCLUDED(fs.mu)
+func (fs *pathFileSystem) dirHandle(id fuseops.HandleID) *pathDirHandle {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	return fs.handles[id].(*pathDirHandle)
+}
+
+// Read the entries of the given directory, giving each the ID of the inode
+// the kernel knows it as, if any.
+//
+// LOCKS_EXCLUDED(fs.mu)
+func (fs *pathFileSystem) readDir(
+	ctx context.Context,
+	id fuseops.InodeID) ([]Dirent, error) {
+	p, err := fs.path(id)
+	if err != nil {
+		return nil, err
+	}
+
+	pathEntries, err := fs.fs.ReadDir(ctx, p)
+	if err != nil {
+		return nil, pathErrno(err)
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	dir := fs.getInodeOrDie(id)
+	entries := make([]Dirent, len(pathEntries))
+	for i, e := range pathEntries {
+		entries[i] = Dirent{
+			Offset: fuseops.DirOffset(i + 1),
+			Inode:  unknownInodeID,
+			Name:   e.Name,
+			Type:   e.Type,
+		}
+
+		if child := dir.children[e.Name]; child != nil {
+			entries[i].Inode = child.id
+		}
+	}
+
+	return entries, nil
+}
+
+////////////////////////////////////////////////////////////////////////
+// FileSystem methods
+////////////////////////////////////////////////////////////////////////
+
+func (fs *pathFileSystem) StatFS(
+	ctx context.Context,
+	op *fuseops.StatFSOp) error {
+	return pathErrno(fs.fs.StatFS(ctx, op))
+}
+
+func (fs *pathFileSystem) LookUpInode(
+	ctx context.Context,
+	op *fuseops.LookUpInodeOp) error {
+	return fs.lookUp(ctx, op.Parent, op.Name, &op.Entry)
+}
+
+func (fs *pathFileSystem) GetInodeAttributes(
+	ctx context.Context,
+	op *fuseops.GetInodeAttributesOp) error {
+	p, err := fs.path(op.Inode)
+
+	// Removed inodes keep the attributes they last had.
+	if err != nil {
+		fs.mu.Lock()
+		op.Attributes = fs.getInodeOrDie(op.Inode).attrs
+		fs.mu.Unlock()
+
+		return nil
+	}
+
+	attrs, err := fs.stat(ctx, p)
+	if err != nil {
+		return err
+	}
+
+	fs.mu.Lock()
+	fs.getInodeOrDie(op.Inode).attrs = attrs
+	fs.mu.Unlock()
+
+	op.Attributes = attrs
+	return nil
+}
+
+func (fs *pathFileSystem) SetInodeAttributes(
+	ctx context.Context,
+	op *fuseops.SetInodeAttributesOp) error {
+	p, err := fs.path(op.Inode)
+	if err != nil {
+		return err
+	}
+
+	changes := AttributeChanges{
+		Size:  op.Size,
+		Mode:  op.Mode,
+		Atime: op.Atime,
+		Mtime: op.Mtime,
+	}
+
+	if err := fs.fs.SetAttributes(ctx, p, changes); err != nil {
+		return pathErrno(err)
+	}
+
+	attrs, err := fs.stat(ctx, p)
+	if err != nil {
+		return err
+	}
+
+	fs.mu.Lock()
+	fs.getInodeOrDie(op.Inode).attrs = attrs
+	fs.mu.Unlock()
+
+	op.Attributes = attrs
+	return nil
+}
+
+func (fs *pathFileSystem) ForgetInode(
+	ctx context.Context,
+	op *fuseops.ForgetInodeOp) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	fs.forget(op.Inode, op.N)
+	return nil
+}
+
+func (fs *pathFileSystem) BatchForget(
+	ctx context.Context,
+	op *fuseops.BatchForgetOp) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	for _, e := range op.Entries {
+		fs.forget(e.Inode, e.N)
+	}
+
+	return nil
+}
+
+func (fs *pathFileSystem) MkDir(
+	ctx context.Context,
+	op *fuseops.MkDirOp) error {
+	p, err := fs.childPath(op.Parent, op.Name)
+	if err != nil {
+		return err
+	}
+
+	if err := fs.fs.Mkdir(ctx, p, op.Mode); err != nil {
+		return pathErrno(err)
+	}
+
+	return fs.lookUp(ctx, op.Parent, op.Name, &op.Entry)
+}
+
+func (fs *pathFileSystem) CreateFile(
+	ctx context.Context,
+	op *fuseops.CreateFileOp) error {
+	p, err := fs.childPath(op.Parent, op.Name)
+	if err != nil {
+		return err
+	}
+
+	f, err := fs.fs.Create(ctx, p, op.Mode)
+	if err != nil {
+		return pathErrno(err)
+	}
+
+	if err := fs.lookUp(ctx, op.Parent, op.Name, &op.Entry); err != nil {
+		f.Close()
+		return err
+	}
+
+	op.Handle = fs.newHandle(&pathFileHandle{file: f})
+	return nil
+}
+
+func (fs *pathFileSystem) CreateSymlink(
+	ctx context.Context,
+	op *fuseops.CreateSymlinkOp) error {
+	p, err := fs.childPath(op.Parent, op.Name)
+	if err != nil {
+		return err
+	}
+
+	if err := fs.fs.Symlink(ctx, op.Target, p); err != nil {
+		return pathErrno(err)
+	}
+
+	return fs.lookUp(ctx, op.Parent, op.Name, &op.Entry)
+}
+
+func (fs *pathFileSystem) Rename(
+	ctx context.Context,
+	op *fuseops.RenameOp) error {
+	oldPath, err := fs.childPath(op.OldParent, op.OldName)
+	if err != nil {
+		return err
+	}
+
+	newPath, err := fs.childPath(op.NewParent, op.NewName)
+	if err != nil {
+		return err
+	}
+
+	if err := fs.fs.Rename(ctx, oldPath, newPath); err != nil {
+		return pathErrno(err)
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	oldParent := fs.getInodeOrDie(op.OldParent)
+	newParent := fs.getInodeOrDie(op.NewParent)
+	child := oldParent.children[op.OldName]
+
+	// Anything that was at the new path has been replaced.
+	if target := newParent.children[op.NewName]; target != nil && target != child {
+		fs.detach(target)
+	}
+
+	// Move the child, and with it any of its descendants the kernel knows
+	// about.
+	if child != nil {
+		delete(oldParent.children, op.OldName)
+		child.parent = newParent
+		child.name = op.NewName
+		newParent.children[op.NewName] = child
+	}
+
+	return nil
+}
+
+func (fs *pathFileSystem) RmDir(
+	ctx context.Context,
+	op *fuseops.RmDirOp) error {
+	p, err := fs.childPath(op.Parent, op.Name)
+	if err != nil {
+		return err
+	}
+
+	if err := fs.fs.RmDir(ctx, p); err != nil {
+		return pathErrno(err)
+	}
+
+	fs.removed(op.Parent, op.Name)
+	return nil
+}
+
+func (fs *pathFileSystem) Unlink(
+	ctx context.Context,
+	op *fuseops.UnlinkOp) error {
+	p, err := fs.childPath(op.Parent, op.Name)
+	if err != nil {
+		return err
+	}
+
+	if err := fs.fs.Unlink(ctx, p); err != nil {
+		return pathErrno(err)
+	}
+
+	fs.removed(op.Parent, op.Name)
+	return nil
+}
+
+func (fs *pathFileSystem) OpenDir(
+	ctx context.Context,
+	op *fuseops.OpenDirOp) error {
+	entries, err := fs.readDir(ctx, op.Inode)
+	if err != nil {
+		return err
+	}
+
+	op.Handle = fs.newHandle(&pathDirHandle{entries: entries})
+	return nil
+}
+
+func (fs *pathFileSystem) ReadDir(
+	ctx context.Context,
+	op *fuseops.ReadDirOp) error {
+	dh := fs.dirHandle(op.Handle)
+
+	dh.mu.Lock()
+	defer dh.mu.Unlock()
+
+	// Rewinding the directory picks up any changes, as it does for a directory
+	// on disk.
+	if op.Offset == 0 {
+		entries, err := fs.readDir(ctx, op.Inode)
+		if err != nil {
+			return err
+		}
+
+		dh.entries = entries
+	}
+
+	if op.Offset > fuseops.DirOffset(len(dh.entries)) {
+		return nil
+	}
+
+	for _, e := range dh.entries[op.Offset:] {
+		n := WriteDirent(op.Dst[op.BytesRead:], e)
+		if n == 0 {
+			break
+		}
+
+		op.BytesRead += n
+	}
+
+	return nil
+}
+
+func (fs *pathFileSystem) ReleaseDirHandle(
+	ctx context.Context,
+	op *fuseops.ReleaseDirHandleOp) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	delete(fs.handles, op.Handle)
+	return nil
+}
+
+func (fs *pathFileSystem) OpenFile(
+	ctx context.Context,
+	op *fuseops.OpenFileOp) error {
+	p, err := fs.path(op.Inode)
+	if err != nil {
+		return err
+	}
+
+	f, err := fs.fs.Open(ctx, p)
+	if err != nil {
+		return pathErrno(err)
+	}
+
+	op.Handle = fs.newHandle(&pathFileHandle{file: f})
+	return nil
+}
+
+func (fs *pathFileSystem) ReadFile(
+	ctx context.Context,
+	op *fuseops.ReadFileOp) error {
+	n, err := fs.fileHandle(op.Handle).file.ReadAt(op.Dst, op.Offset)
+	op.BytesRead = n
+
+	// Reading past the end of the file is not an error for the kernel.
+	if err == io.EOF {
+		return nil
+	}
+
+	return pathErrno(err)
+}
+
+func (fs *pathFileSystem) WriteFile(
+	ctx context.Context,
+	op *fuseops.WriteFileOp) error {
+	data := op.Data
+	if op.Spliced != nil {
+		data = make([]byte, op.Spliced.Size)
+		if _, err := io.ReadFull(op.Spliced.Pipe, data); err != nil {
+			return err
+		}
+	}
+
+	_, err := fs.fileHandle(op.Handle).file.WriteAt(data, op.Offset)
+	return pathErrno(err)
+}
+
+// Sync the file if it supports it.
+func syncPathFile(f PathFile) error {
+	if s, ok := f.(interface{ Sync() error }); ok {
+		return pathErrno(s.Sync())
+	}
+
+	return nil
+}
+
+func (fs *pathFileSystem) SyncFile(
+	ctx context.Context,
+	op *fuseops.SyncFileOp) error {
+	return syncPathFile(fs.fileHandle(op.Handle).file)
+}
+
+func (fs *pathFileSystem) FlushFile(
+	ctx context.Context,
+	op *fuseops.FlushFileOp) error {
+	return syncPathFile(fs.fileHandle(op.Handle).file)
+}
+
+func (fs *pathFileSystem) ReleaseFileHandle(
+	ctx context.Context,
+	op *fuseops.ReleaseFileHandleOp) error {
+	fs.mu.Lock()
+	fh := fs.handles[op.Handle].(*pathFileHandle)
+	delete(fs.handles, op.Handle)
+	fs.mu.Unlock()
+
+	return pathErrno(fh.file.Close())
+}
+
+func (fs *pathFileSystem) ReadSymlink(
+	ctx context.Context,
+	op *fuseops.ReadSymlinkOp) error {
+	p, err := fs.path(op.Inode)
+	if err != nil {
+		return err
+	}
+
+	op.Target, err = fs.fs.ReadSymlink(ctx, p)
+	return pathErrno(err)
+}
+
+func (fs *pathFileSystem) Destroy() {
+	fs.mu.Lock()
+	for _, h := range fs.handles {
+		if fh, ok := h.(*pathFileHandle); ok {
+			fh.file.Close()
+		}
+	}
+
+	fs.handles = nil
+	fs.mu.Unlock()
+
+	fs.fs.Destroy()
+}
@@ -0,0 +1,317 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fuseutil
+
+import (
+	"context"
+	iofs "io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/jacobsa/fuse/fuseops"
+)
+
+// A PathFileSystem holding only a tree of empty files and directories.
+type fakePathFileSystem struct {
+	NotImplementedPathFileSystem
+
+	// The mode of each file and directory other than the root.
+	modes map[string]os.FileMode
+
+	// The paths passed to Stat.
+	statted []string
+}
+
+func (fs *fakePathFileSystem) Stat(
+	ctx context.Context,
+	p string) (fuseops.InodeAttributes, error) {
+	fs.statted = append(fs.statted, p)
+
+	mode, ok := fs.modes[p]
+	if p == "." {
+		mode, ok = os.ModeDir|0755, true
+	}
+
+	if !ok {
+		return fuseops.InodeAttributes{}, iofs.ErrNotExist
+	}
+
+	return fuseops.InodeAttributes{Mode: mode}, nil
+}
+
+func (fs *fakePathFileSystem) ReadDir(
+	ctx context.Context,
+	p string) ([]PathDirent, error) {
+	var entries []PathDirent
+	for child, mode := range fs.modes {
+		if path.Dir(child) == p {
+			e := PathDirent{Name: path.Base(child), Type: DT_File}
+			if mode.IsDir() {
+				e.Type = DT_Directory
+			}
+
+			entries = append(entries, e)
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	return entries, nil
+}
+
+func (fs *fakePathFileSystem) Mkdir(
+	ctx context.Context,
+	p string,
+	mode os.FileMode) error {
+	fs.modes[p] = os.ModeDir | mode
+	return nil
+}
+
+func (fs *fakePathFileSystem) SetAttributes(
+	ctx context.Context,
+	p string,
+	changes AttributeChanges) error {
+	if changes.Mode != nil {
+		fs.modes[p] = *changes.Mode
+	}
+
+	return nil
+}
+
+func (fs *fakePathFileSystem) Rename(
+	ctx context.Context,
+	oldPath string,
+	newPath string) error {
+	for p, mode := range fs.modes {
+		if p == oldPath || strings.HasPrefix(p, oldPath+"/") {
+			delete(fs.modes, p)
+			fs.modes[newPath+strings.TrimPrefix(p, oldPath)] = mode
+		}
+	}
+
+	return nil
+}
+
+func (fs *fakePathFileSystem) Unlink(
+	ctx context.Context,
+	p string) error {
+	delete(fs.modes, p)
+	return nil
+}
+
+func lookUp(
+	t *testing.T,
+	fs FileSystem,
+	parent fuseops.InodeID,
+	name string) fuseops.ChildInodeEntry {
+	t.Helper()
+
+	op := &fuseops.LookUpInodeOp{Parent: parent, Name: name}
+	if err := fs.LookUpInode(context.Background(), op); err != nil {
+		t.Fatalf("LookUpInode(%v, %q): %v", parent, name, err)
+	}
+
+	return op.Entry
+}
+
+func TestPathFileSystemLookUp(t *testing.T) {
+	ctx := context.Background()
+	fake := &fakePathFileSystem{
+		modes: map[string]os.FileMode{
+			"foo": 0644,
+			"bar": 0600,
+		},
+	}
+	fs := NewPathFileSystem(fake)
+
+	foo := lookUp(t, fs, fuseops.RootInodeID, "foo")
+	if foo.Child == fuseops.RootInodeID || foo.Attributes.Mode != 0644 {
+		t.Fatalf("Entry for foo: %+v", foo)
+	}
+
+	if foo.Attributes.Nlink != 1 {
+		t.Errorf("Nlink = %d, want 1", foo.Attributes.Nlink)
+	}
+
+	// Looking up the same path again yields the same inode.
+	if again := lookUp(t, fs, fuseops.RootInodeID, "foo"); again.Child != foo.Child {
+		t.Errorf("Second lookup of foo gave inode %v, want %v", again.Child, foo.Child)
+	}
+
+	// Missing paths are reported as ENOENT.
+	op := &fuseops.LookUpInodeOp{Parent: fuseops.RootInodeID, Name: "baz"}
+	if err := fs.LookUpInode(ctx, op); err != syscall.ENOENT {
+		t.Errorf("LookUpInode(baz): %v, want ENOENT", err)
+	}
+
+	// Once forgotten, the ID is reused with a new generation number.
+	fs.ForgetInode(ctx, &fuseops.ForgetInodeOp{Inode: foo.Child, N: 2})
+
+	bar := lookUp(t, fs, fuseops.RootInodeID, "bar")
+	if bar.Child != foo.Child || bar.Generation != foo.Generation+1 {
+		t.Errorf("Entry for bar: %+v, want inode %v generation %v",
+			bar, foo.Child, foo.Generation+1)
+	}
+}
+
+func TestPathFileSystemRename(t *testing.T) {
+	ctx := context.Background()
+	fake := &fakePathFileSystem{
+		modes: map[string]os.FileMode{
+			"other": 0644,
+		},
+	}
+	fs := NewPathFileSystem(fake)
+
+	mkDir := &fuseops.MkDirOp{Parent: fuseops.RootInodeID, Name: "dir", Mode: 0700}
+	if err := fs.MkDir(ctx, mkDir); err != nil {
+		t.Fatalf("MkDir: %v", err)
+	}
+
+	dir := mkDir.Entry.Child
+	fake.modes["dir/file"] = 0600
+	file := lookUp(t, fs, dir, "file").Child
+	other := lookUp(t, fs, fuseops.RootInodeID, "other").Child
+
+	// Renaming the directory over another file moves its known children with
+	// it.
+	err := fs.Rename(ctx, &fuseops.RenameOp{
+		OldParent: fuseops.RootInodeID,
+		OldName:   "dir",
+		NewParent: fuseops.RootInodeID,
+		NewName:   "other",
+	})
+	if err != nil {
+		t.Fatalf("Rename: %v", err)
+	}
+
+	fake.statted = nil
+	getAttrs := &fuseops.GetInodeAttributesOp{Inode: file}
+	if err := fs.GetInodeAttributes(ctx, getAttrs); err != nil {
+		t.Fatalf("GetInodeAttributes: %v", err)
+	}
+
+	if len(fake.statted) != 1 || fake.statted[0] != "other/file" {
+		t.Errorf("Stat calls: %q, want [other/file]", fake.statted)
+	}
+
+	// The replaced file keeps its last attributes, with no links.
+	fake.statted = nil
+	getAttrs = &fuseops.GetInodeAttributesOp{Inode: other}
+	if err := fs.GetInodeAttributes(ctx, getAttrs); err != nil {
+		t.Fatalf("GetInodeAttributes: %v", err)
+	}
+
+	if len(fake.statted) != 0 {
+		t.Errorf("Stat calls for replaced file: %q", fake.statted)
+	}
+
+	if getAttrs.Attributes.Mode != 0644 || getAttrs.Attributes.Nlink != 0 {
+		t.Errorf("Attributes of replaced file: %v", getAttrs.Attributes)
+	}
+
+	// Looking up the new name finds the directory's inode.
+	if e := lookUp(t, fs, fuseops.RootInodeID, "other"); e.Child != dir {
+		t.Errorf("Lookup of other gave inode %v, want %v", e.Child, dir)
+	}
+}
+
+func TestPathFileSystemSetAttributes(t *testing.T) {
+	ctx := context.Background()
+	fake := &fakePathFileSystem{
+		modes: map[string]os.FileMode{
+			"foo": 0644,
+		},
+	}
+	fs := NewPathFileSystem(fake)
+
+	foo := lookUp(t, fs, fuseops.RootInodeID, "foo").Child
+
+	mode := os.FileMode(0600)
+	setAttrs := &fuseops.SetInodeAttributesOp{Inode: foo, Mode: &mode}
+	if err := fs.SetInodeAttributes(ctx, setAttrs); err != nil {
+		t.Fatalf("SetInodeAttributes: %v", err)
+	}
+
+	if setAttrs.Attributes.Mode != 0600 {
+		t.Errorf("Attributes after SetInodeAttributes: %v", setAttrs.Attributes)
+	}
+
+	// Once the file is removed, it keeps the attributes it was given.
+	err := fs.Unlink(ctx, &fuseops.UnlinkOp{Parent: fuseops.RootInodeID, Name: "foo"})
+	if err != nil {
+		t.Fatalf("Unlink: %v", err)
+	}
+
+	getAttrs := &fuseops.GetInodeAttributesOp{Inode: foo}
+	if err := fs.GetInodeAttributes(ctx, getAttrs); err != nil {
+		t.Fatalf("GetInodeAttributes: %v", err)
+	}
+
+	if getAttrs.Attributes.Mode != 0600 {
+		t.Errorf("Attributes of removed file: %v", getAttrs.Attributes)
+	}
+}
+
+func TestPathFileSystemReadDir(t *testing.T) {
+	ctx := context.Background()
+	fake := &fakePathFileSystem{
+		modes: map[string]os.FileMode{
+			"bar":     0644,
+			"foo":     os.ModeDir | 0755,
+			"foo/baz": 0644,
+		},
+	}
+	fs := NewPathFileSystem(fake)
+
+	foo := lookUp(t, fs, fuseops.RootInodeID, "foo").Child
+
+	openDir := &fuseops.OpenDirOp{Inode: fuseops.RootInodeID}
+	if err := fs.OpenDir(ctx, openDir); err != nil {
+		t.Fatalf("OpenDir: %v", err)
+	}
+
+	readDir := &fuseops.ReadDirOp{
+		Inode:  fuseops.RootInodeID,
+		Handle: openDir.Handle,
+		Dst:    make([]byte, 1024),
+	}
+	if err := fs.ReadDir(ctx, readDir); err != nil {
+		t.Fatalf("ReadDir: %v", err)
+	}
+
+	if readDir.BytesRead == 0 {
+		t.Errorf("ReadDir returned no entries")
+	}
+
+	entries := fs.(*pathFileSystem).dirHandle(openDir.Handle).entries
+	want := []Dirent{
+		{Offset: 1, Inode: unknownInodeID, Name: "bar", Type: DT_File},
+		{Offset: 2, Inode: foo, Name: "foo", Type: DT_Directory},
+	}
+
+	if len(entries) != len(want) {
+		t.Fatalf("Entries: %+v, want %+v", entries, want)
+	}
+
+	for i := range want {
+		if entries[i] != want[i] {
+			t.Errorf("Entry %d: %+v, want %+v", i, entries[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,208 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fuseutil
+
+import (
+	"context"
+	"io"
+	"os"
+	"time"
+
+	"github.com/jacobsa/fuse"
+	"github.com/jacobsa/fuse/fuseops"
+)
+
+// A file system whose files are named by path rather than by inode ID. Use
+// NewPathFileSystem to turn one into a FileSystem, which takes care of
+// assigning inode IDs and generation numbers, counting lookups, and keeping
+// track of the path of each inode across renames.
+//
+// Paths are slash-separated and relative to the root of the file system, in
+// the style of package io/fs: the root is ".", and its child "foo" has a
+// child "bar" named "foo/bar". Paths never contain "." or ".." elements
+// other than the root itself.
+//
+// Every path is treated as a distinct inode, so hard links appear as separate
+// files. Errors may be syscall.Errno values, or errors wrapping io/fs's
+// ErrNotExist, ErrExist, ErrPermission or ErrInvalid, which are translated to
+// the corresponding errno. Any other error is reported to the kernel as EIO.
+//
+// See NotImplementedPathFileSystem for a convenient way to embed default
+// implementations for methods you don't care about.
+type PathFileSystem interface {
+	// Return information about the file system as a whole.
+	StatFS(ctx context.Context, op *fuseops.StatFSOp) error
+
+	// Return the attributes of the file, directory or symlink with the given
+	// path, without following a final symlink. A link count of zero is reported
+	// to the kernel as one.
+	Stat(ctx context.Context, path string) (fuseops.InodeAttributes, error)
+
+	// Apply the non-nil changes to the attributes of the given file.
+	SetAttributes(ctx context.Context, path string, changes AttributeChanges) error
+
+	// Return the entries of the given directory, not including "." and "..".
+	// The order is preserved when handing them to the kernel.
+	ReadDir(ctx context.Context, path string) ([]PathDirent, error)
+
+	// Return the target of the given symlink.
+	ReadSymlink(ctx context.Context, path string) (string, error)
+
+	// Open the given file, which already exists. The kernel has already
+	// checked that the caller is allowed to open it.
+	Open(ctx context.Context, path string) (PathFile, error)
+
+	// Create and open a file that doesn't already exist.
+	Create(ctx context.Context, path string, mode os.FileMode) (PathFile, error)
+
+	// Create a directory that doesn't already exist.
+	Mkdir(ctx context.Context, path string, mode os.FileMode) error
+
+	// Create a symlink with the given target that doesn't already exist.
+	Symlink(ctx context.Context, target string, path string) error
+
+	// Rename a file or directory, replacing anything at newPath as rename(2)
+	// does.
+	Rename(ctx context.Context, oldPath string, newPath string) error
+
+	// Remove the given empty directory.
+	RmDir(ctx context.Context, path string) error
+
+	// Remove the given file or symlink.
+	Unlink(ctx context.Context, path string) error
+
+	// Clean up any resources associated with the file system. No further calls
+	// to the file system will be made.
+	Destroy()
+}
+
+// An open file returned by PathFileSystem.Open or Create. Reads that reach the
+// end of the file may return io.EOF along with the bytes read. Writes to a
+// file that can't be written should return an error such as syscall.EBADF.
+//
+// If the file has a method Sync() error, it is called for fsync(2) and when
+// the file is closed by a process, and any error is returned to the process.
+type PathFile interface {
+	io.ReaderAt
+	io.WriterAt
+	io.Closer
+}
+
+// An entry in a directory returned by PathFileSystem.ReadDir.
+type PathDirent struct {
+	Name string
+
+	// The type of the entry. The zero value (DT_Unknown) is legal, but means
+	// that the kernel will need to look up the entry when the type is needed.
+	Type DirentType
+}
+
+// A set of changes to the attributes of a file. Nil fields are to be left
+// unchanged.
+type AttributeChanges struct {
+	Size  *uint64
+	Mode  *os.FileMode
+	Atime *time.Time
+	Mtime *time.Time
+}
+
+// A PathFileSystem that responds to all calls with fuse.ENOSYS. Embed this in
+// your struct to inherit default implementations for the methods you don't
+// care about, ensuring your struct will continue to implement PathFileSystem
+// even as new methods are added.
+type NotImplementedPathFileSystem struct {
+}
+
+var _ PathFileSystem = &NotImplementedPathFileSystem{}
+
+func (fs *NotImplementedPathFileSystem) StatFS(
+	ctx context.Context,
+	op *fuseops.StatFSOp) error {
+	return fuse.ENOSYS
+}
+
+func (fs *NotImplementedPathFileSystem) Stat(
+	ctx context.Context,
+	path string) (fuseops.InodeAttributes, error) {
+	return fuseops.InodeAttributes{}, fuse.ENOSYS
+}
+
+func (fs *NotImplementedPathFileSystem) SetAttributes(
+	ctx context.Context,
+	path string,
+	changes AttributeChanges) error {
+	return fuse.ENOSYS
+}
+
+func (fs *NotImplementedPathFileSystem) ReadDir(
+	ctx context.Context,
+	path string) ([]PathDirent, error) {
+	return nil, fuse.ENOSYS
+}
+
+func (fs *NotImplementedPathFileSystem) ReadSymlink(
+	ctx context.Context,
+	path string) (string, error) {
+	return "", fuse.ENOSYS
+}
+
+func (fs *NotImplementedPathFileSystem) Open(
+	ctx context.Context,
+	path string) (PathFile, error) {
+	return nil, fuse.ENOSYS
+}
+
+func (fs *NotImplementedPathFileSystem) Create(
+	ctx context.Context,
+	path string,
+	mode os.FileMode) (PathFile, error) {
+	return nil, fuse.ENOSYS
+}
+
+func (fs *NotImplementedPathFileSystem) Mkdir(
+	ctx context.Context,
+	path string,
+	mode os.FileMode) error {
+	return fuse.ENOSYS
+}
+
+func (fs *NotImplementedPathFileSystem) Symlink(
+	ctx context.Context,
+	target string,
+	path string) error {
+	return fuse.ENOSYS
+}
+
+func (fs *NotImplementedPathFileSystem) Rename(
+	ctx context.Context,
+	oldPath string,
+	newPath string) error {
+	return fuse.ENOSYS
+}
+
+func (fs *NotImplementedPathFileSystem) RmDir(
+	ctx context.Context,
+	path string) error {
+	return fuse.ENOSYS
+}
+
+func (fs *NotImplementedPathFileSystem) Unlink(
+	ctx context.Context,
+	path string) error {
+	return fuse.ENOSYS
+}
+
+func (fs *NotImplementedPathFileSystem) Destroy() {
+}
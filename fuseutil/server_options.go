@@ -0,0 +1,155 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fuseutil
+
+import (
+	"io"
+
+	"github.com/jacobsa/fuse/fuseops"
+)
+
+// An option accepted by NewFileSystemServer.
+type ServerOption func(*fileSystemServer)
+
+// WithMaxOpsInFlight limits the number of ops that the server holds at once,
+// counting from when it starts reading an op from the kernel until it has
+// replied to it. Once the limit is reached the server stops reading from
+// /dev/fuse, leaving further requests queued in the kernel, until an op
+// completes. This bounds the number of goroutines and the memory used by the
+// server however fast the kernel sends requests, with the exceptions below.
+// Zero or less means no limit, which is the default.
+//
+// Ops stop counting towards the limit while they wait for a worker (see
+// WithDataOpWorkers and WithMetadataOpWorkers), so that a burst of readahead
+// queued behind the data op workers doesn't keep metadata ops from being read.
+// The workers then bound the ops calling the file system, and the kernel
+// bounds the readahead and writeback requests it sends at once.
+//
+// Interrupts are read from /dev/fuse like any other request, so while the
+// server is saturated it can't learn that the ops it holds have been
+// interrupted. SetFileLockOp with Block set, which may wait for as long as
+// another process holds a lock, therefore neither counts towards the limit
+// nor waits for a worker: otherwise waiters could use up the slots or the
+// metadata op workers, leaving the server unable to read or handle either the
+// request releasing the lock or the interrupts for the waiters. Such waiters
+// are bounded only by the processes waiting for locks. Other ops are expected
+// to finish on their own, after which the server reads on, getting any
+// pending interrupts first.
+func WithMaxOpsInFlight(n int) ServerOption {
+	return func(s *fileSystemServer) {
+		s.opSlots = newSemaphore(n)
+	}
+}
+
+// WithDataOpWorkers limits the number of data ops (see IsDataOp) that call the
+// file system concurrently. Further data ops wait for one of them to finish,
+// without holding up metadata ops. Zero or less means no limit, which is the
+// default.
+func WithDataOpWorkers(n int) ServerOption {
+	return func(s *fileSystemServer) {
+		s.dataWorkers = newSemaphore(n)
+	}
+}
+
+// WithMetadataOpWorkers limits the number of ops other than data ops (see
+// IsDataOp) that call the file system concurrently. Further such ops wait for
+// one of them to finish, without holding up data ops. Blocking lock requests
+// aren't limited; see WithMaxOpsInFlight. Zero or less means no limit, which
+// is the default.
+func WithMetadataOpWorkers(n int) ServerOption {
+	return func(s *fileSystemServer) {
+		s.metadataWorkers = newSemaphore(n)
+	}
+}
+
+// WithSplicedWrites tells the server that the file system handles
+// WriteFileOp.Spliced, so that write data left in a pipe by
+// MountConfig.EnableSpliceWrites is passed on as is. Without it the server
+// reads such data into WriteFileOp.Data before calling WriteFile, so that
+// file systems unaware of splicing see every write the same way.
+func WithSplicedWrites() ServerOption {
+	return func(s *fileSystemServer) {
+		s.splicedWrites = true
+	}
+}
+
+// Read the data of a spliced write into op.Data, for a file system that
+// doesn't handle WriteFileOp.Spliced.
+func readSplicedData(op *fuseops.WriteFileOp) error {
+	data := make([]byte, op.Spliced.Size)
+	if _, err := io.ReadFull(op.Spliced.Pipe, data); err != nil {
+		return err
+	}
+
+	op.Data = data
+	op.Spliced = nil
+	return nil
+}
+
+// IsDataOp reports whether op is one that moves file contents, as opposed to
+// operating on the namespace or on inode metadata. Such ops may arrive in
+// large bursts due to readahead and writeback, and may each take much longer
+// than a metadata op.
+func IsDataOp(op interface{}) bool {
+	switch op.(type) {
+	case *fuseops.ReadFileOp,
+		*fuseops.WriteFileOp,
+		*fuseops.SyncFileOp,
+		*fuseops.FlushFileOp,
+		*fuseops.FallocateOp,
+		*fuseops.CopyFileRangeOp:
+		return true
+	}
+
+	return false
+}
+
+// A counting semaphore, or nil for one without a limit.
+type semaphore chan struct{}
+
+func newSemaphore(n int) semaphore {
+	if n <= 0 {
+		return nil
+	}
+
+	return make(semaphore, n)
+}
+
+func (s semaphore) acquire() {
+	if s != nil {
+		s <- struct{}{}
+	}
+}
+
+// Acquire the semaphore if that can be done without waiting, returning
+// whether it was acquired.
+func (s semaphore) tryAcquire() bool {
+	if s == nil {
+		return true
+	}
+
+	select {
+	case s <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s semaphore) release() {
+	if s != nil {
+		<-s
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fuseutil
+
+import (
+	"io"
+	"os"
+	"testing"
+
+	"github.com/jacobsa/fuse/fuseops"
+)
+
+func TestReadSplicedData(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Pipe: %v", err)
+	}
+
+	defer r.Close()
+	defer w.Close()
+
+	if _, err := w.WriteString("tacoburrito"); err != nil {
+		t.Fatalf("WriteString: %v", err)
+	}
+
+	op := &fuseops.WriteFileOp{
+		Spliced: &fuseops.SplicedData{Pipe: r, Size: 4},
+	}
+
+	if err := readSplicedData(op); err != nil {
+		t.Fatalf("readSplicedData: %v", err)
+	}
+
+	if string(op.Data) != "taco" || op.Spliced != nil {
+		t.Errorf("Data = %q, Spliced = %v", op.Data, op.Spliced)
+	}
+
+	// Only the data of the write is consumed.
+	rest := make([]byte, 7)
+	if _, err := io.ReadFull(r, rest); err != nil || string(rest) != "burrito" {
+		t.Errorf("Rest of pipe: %q, %v", rest, err)
+	}
+
+	// A short pipe is an error.
+	w.Close()
+	op = &fuseops.WriteFileOp{
+		Spliced: &fuseops.SplicedData{Pipe: r, Size: 4},
+	}
+
+	if err := readSplicedData(op); err == nil {
+		t.Errorf("readSplicedData succeeded on an empty pipe")
+	}
+}
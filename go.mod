@@ -1,6 +1,6 @@
 module github.com/jacobsa/fuse
 
-go 1.18
+go 1.21
 
 require (
 	github.com/detailyang/go-fallocate v0.0.0-20180908115635-432fa640bd2e
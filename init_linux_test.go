@@ -0,0 +1,147 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fuse
+
+import (
+	"os"
+	"syscall"
+	"testing"
+	"unsafe"
+
+	"github.com/jacobsa/fuse/internal/buffer"
+	"github.com/jacobsa/fuse/internal/fusekernel"
+)
+
+// Initialize a connection with the given config, playing a kernel with the
+// given minor version that offers the given flags, and return the connection
+// along with the flags that it asks for.
+func negotiateInit(
+	t *testing.T,
+	cfg MountConfig,
+	minor uint32,
+	offered fusekernel.InitFlags) (*Connection, fusekernel.InitFlags) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_SEQPACKET, 0)
+	if err != nil {
+		t.Fatalf("Socketpair: %v", err)
+	}
+
+	dev := os.NewFile(uintptr(fds[0]), "dev")
+	kernel := os.NewFile(uintptr(fds[1]), "kernel")
+	defer dev.Close()
+	defer kernel.Close()
+
+	c := NewTestConnection(cfg, dev)
+	sendRequest(t, kernel, 1, fusekernel.OpInit, fusekernel.InitIn{
+		Major: 7,
+		Minor: minor,
+		Flags: uint32(offered),
+	})
+
+	if err := c.Init(); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+
+	buf := make([]byte, 4096)
+	n, err := kernel.Read(buf)
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+
+	b := buf[buffer.OutMessageHeaderSize:n]
+	if len(b) < int(unsafe.Sizeof(fusekernel.InitOut{})) {
+		t.Fatalf("Short reply: %d bytes", n)
+	}
+
+	out := (*fusekernel.InitOut)(unsafe.Pointer(&b[0]))
+	return c, fusekernel.InitFlags(out.Flags)
+}
+
+func TestInitReaddirplus(t *testing.T) {
+	const plus = fusekernel.InitDoReaddirplus
+	const auto = fusekernel.InitReaddirplusAuto
+
+	testCases := []struct {
+		enable  bool
+		auto    bool
+		offered fusekernel.InitFlags
+		want    fusekernel.InitFlags
+	}{
+		// Off unless asked for.
+		{false, false, plus | auto, 0},
+
+		// Auto mode needs readdirplus proper.
+		{false, true, plus | auto, 0},
+
+		// Each is used only if the kernel supports it.
+		{true, false, plus | auto, plus},
+		{true, true, plus | auto, plus | auto},
+		{true, true, plus, plus},
+		{true, true, auto, 0},
+		{true, false, 0, 0},
+	}
+
+	for _, tc := range testCases {
+		cfg := MountConfig{
+			EnableReaddirplus:     tc.enable,
+			EnableReaddirplusAuto: tc.auto,
+		}
+
+		_, flags := negotiateInit(t, cfg, 31, tc.offered)
+		got := flags & (plus | auto)
+		if got != tc.want {
+			t.Errorf(
+				"EnableReaddirplus %v, EnableReaddirplusAuto %v, offered %v: got %v, want %v",
+				tc.enable,
+				tc.auto,
+				tc.offered,
+				got,
+				tc.want)
+		}
+	}
+}
+
+func TestInitSetxattrExt(t *testing.T) {
+	const ext = fusekernel.InitSetxattrExt
+
+	testCases := []struct {
+		enable  bool
+		minor   uint32
+		offered fusekernel.InitFlags
+		want    bool
+	}{
+		{false, 33, ext, false},
+		{true, 33, ext, true},
+
+		// A kernel new enough to support the extension may still not offer it,
+		// in which case it sends requests in the old form.
+		{true, 33, 0, false},
+
+		// Older kernels don't know about it at all.
+		{true, 31, ext, false},
+	}
+
+	for _, tc := range testCases {
+		cfg := MountConfig{EnableSetxattrExt: tc.enable}
+
+		c, flags := negotiateInit(t, cfg, tc.minor, tc.offered)
+		if got := flags&ext != 0; got != tc.want {
+			t.Errorf("%+v: flag %v, want %v", tc, got, tc.want)
+		}
+
+		if c.setxattrExt != tc.want {
+			t.Errorf("%+v: setxattrExt %v, want %v", tc, c.setxattrExt, tc.want)
+		}
+	}
+}
@@ -81,6 +81,58 @@ func (m *InMessage) Init(r io.Reader) error {
 	return nil
 }
 
+// InitHeader is like Init, but for a message whose n bytes are waiting in r
+// (for example a pipe into which the message was spliced) and may be read
+// piecemeal. It reads only the header; use ReadBody to read more of the
+// message, and Pending to find out how much is left.
+func (m *InMessage) InitHeader(r io.Reader, n int) error {
+	const headerSize = int(unsafe.Sizeof(fusekernel.InHeader{}))
+	if n < headerSize || n > len(m.storage) {
+		return fmt.Errorf("Unexpected message size: %d", n)
+	}
+
+	if _, err := io.ReadFull(r, m.storage[:headerSize]); err != nil {
+		return err
+	}
+
+	m.size = headerSize
+	m.remaining = m.storage[headerSize:headerSize]
+
+	// Check the header's length.
+	if int(m.Header().Len) != n {
+		return fmt.Errorf(
+			"Header says %d bytes, but message is %d",
+			m.Header().Len,
+			n)
+	}
+
+	return nil
+}
+
+// ReadBody reads the next n bytes of a message initialized with InitHeader
+// from r, making them available to Consume. It must be called before any
+// bytes are consumed.
+func (m *InMessage) ReadBody(r io.Reader, n int) error {
+	if n < 0 || n > m.Pending() {
+		return fmt.Errorf("ReadBody(%d) out of range (pending: %d)", n, m.Pending())
+	}
+
+	if _, err := io.ReadFull(r, m.storage[m.size:m.size+n]); err != nil {
+		return err
+	}
+
+	m.size += n
+	m.remaining = m.remaining[:len(m.remaining)+n]
+
+	return nil
+}
+
+// Pending returns the number of bytes of the message that have not been read
+// into storage. This is always zero for messages initialized with Init.
+func (m *InMessage) Pending() int {
+	return int(m.Header().Len) - m.size
+}
+
 // Return a reference to the header read in the most recent call to Init.
 func (m *InMessage) Header() *fusekernel.InHeader {
 	return (*fusekernel.InHeader)(unsafe.Pointer(&m.storage[0]))
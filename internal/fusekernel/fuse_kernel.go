@@ -46,7 +46,7 @@ const (
 	ProtoVersionMinMajor = 7
 	ProtoVersionMinMinor = 19
 	ProtoVersionMaxMajor = 7
-	ProtoVersionMaxMinor = 31
+	ProtoVersionMaxMinor = 41
 )
 
 const (
@@ -82,11 +82,11 @@ const (
 )
 
 var getattrFlagsNames = []flagName{
-	{uint32(GetattrFh), "GetattrFh"},
+	{uint64(GetattrFh), "GetattrFh"},
 }
 
 func (fl GetattrFlags) String() string {
-	return flagString(uint32(fl), getattrFlagsNames)
+	return flagString(uint64(fl), getattrFlagsNames)
 }
 
 // The SetattrValid are bit flags describing which fields in the SetattrRequest
@@ -103,9 +103,11 @@ const (
 	SetattrHandle SetattrValid = 1 << 6
 
 	// Linux only(?)
-	SetattrAtimeNow  SetattrValid = 1 << 7
-	SetattrMtimeNow  SetattrValid = 1 << 8
-	SetattrLockOwner SetattrValid = 1 << 9 // http://www.mail-archive.com/git-commits-head@vger.kernel.org/msg27852.html
+	SetattrAtimeNow    SetattrValid = 1 << 7
+	SetattrMtimeNow    SetattrValid = 1 << 8
+	SetattrLockOwner   SetattrValid = 1 << 9 // http://www.mail-archive.com/git-commits-head@vger.kernel.org/msg27852.html
+	SetattrCtime       SetattrValid = 1 << 10
+	SetattrKillSuidgid SetattrValid = 1 << 11
 
 	// OS X only
 	SetattrCrtime   SetattrValid = 1 << 28
@@ -114,40 +116,41 @@ const (
 	SetattrFlags    SetattrValid = 1 << 31
 )
 
-func (fl SetattrValid) Mode() bool      { return fl&SetattrMode != 0 }
-func (fl SetattrValid) Uid() bool       { return fl&SetattrUid != 0 }
-func (fl SetattrValid) Gid() bool       { return fl&SetattrGid != 0 }
-func (fl SetattrValid) Size() bool      { return fl&SetattrSize != 0 }
-func (fl SetattrValid) Atime() bool     { return fl&SetattrAtime != 0 }
-func (fl SetattrValid) Mtime() bool     { return fl&SetattrMtime != 0 }
-func (fl SetattrValid) Handle() bool    { return fl&SetattrHandle != 0 }
-func (fl SetattrValid) AtimeNow() bool  { return fl&SetattrAtimeNow != 0 }
-func (fl SetattrValid) MtimeNow() bool  { return fl&SetattrMtimeNow != 0 }
-func (fl SetattrValid) LockOwner() bool { return fl&SetattrLockOwner != 0 }
-func (fl SetattrValid) Crtime() bool    { return fl&SetattrCrtime != 0 }
-func (fl SetattrValid) Chgtime() bool   { return fl&SetattrChgtime != 0 }
-func (fl SetattrValid) Bkuptime() bool  { return fl&SetattrBkuptime != 0 }
-func (fl SetattrValid) Flags() bool     { return fl&SetattrFlags != 0 }
+func (fl SetattrValid) Mode() bool        { return fl&SetattrMode != 0 }
+func (fl SetattrValid) Uid() bool         { return fl&SetattrUid != 0 }
+func (fl SetattrValid) Gid() bool         { return fl&SetattrGid != 0 }
+func (fl SetattrValid) Size() bool        { return fl&SetattrSize != 0 }
+func (fl SetattrValid) Atime() bool       { return fl&SetattrAtime != 0 }
+func (fl SetattrValid) Mtime() bool       { return fl&SetattrMtime != 0 }
+func (fl SetattrValid) Handle() bool      { return fl&SetattrHandle != 0 }
+func (fl SetattrValid) AtimeNow() bool    { return fl&SetattrAtimeNow != 0 }
+func (fl SetattrValid) MtimeNow() bool    { return fl&SetattrMtimeNow != 0 }
+func (fl SetattrValid) LockOwner() bool   { return fl&SetattrLockOwner != 0 }
+func (fl SetattrValid) KillSuidgid() bool { return fl&SetattrKillSuidgid != 0 }
+func (fl SetattrValid) Crtime() bool      { return fl&SetattrCrtime != 0 }
+func (fl SetattrValid) Chgtime() bool     { return fl&SetattrChgtime != 0 }
+func (fl SetattrValid) Bkuptime() bool    { return fl&SetattrBkuptime != 0 }
+func (fl SetattrValid) Flags() bool       { return fl&SetattrFlags != 0 }
 
 func (fl SetattrValid) String() string {
-	return flagString(uint32(fl), setattrValidNames)
+	return flagString(uint64(fl), setattrValidNames)
 }
 
 var setattrValidNames = []flagName{
-	{uint32(SetattrMode), "SetattrMode"},
-	{uint32(SetattrUid), "SetattrUid"},
-	{uint32(SetattrGid), "SetattrGid"},
-	{uint32(SetattrSize), "SetattrSize"},
-	{uint32(SetattrAtime), "SetattrAtime"},
-	{uint32(SetattrMtime), "SetattrMtime"},
-	{uint32(SetattrHandle), "SetattrHandle"},
-	{uint32(SetattrAtimeNow), "SetattrAtimeNow"},
-	{uint32(SetattrMtimeNow), "SetattrMtimeNow"},
-	{uint32(SetattrLockOwner), "SetattrLockOwner"},
-	{uint32(SetattrCrtime), "SetattrCrtime"},
-	{uint32(SetattrChgtime), "SetattrChgtime"},
-	{uint32(SetattrBkuptime), "SetattrBkuptime"},
-	{uint32(SetattrFlags), "SetattrFlags"},
+	{uint64(SetattrMode), "SetattrMode"},
+	{uint64(SetattrUid), "SetattrUid"},
+	{uint64(SetattrGid), "SetattrGid"},
+	{uint64(SetattrSize), "SetattrSize"},
+	{uint64(SetattrAtime), "SetattrAtime"},
+	{uint64(SetattrMtime), "SetattrMtime"},
+	{uint64(SetattrHandle), "SetattrHandle"},
+	{uint64(SetattrAtimeNow), "SetattrAtimeNow"},
+	{uint64(SetattrMtimeNow), "SetattrMtimeNow"},
+	{uint64(SetattrLockOwner), "SetattrLockOwner"},
+	{uint64(SetattrCrtime), "SetattrCrtime"},
+	{uint64(SetattrChgtime), "SetattrChgtime"},
+	{uint64(SetattrBkuptime), "SetattrBkuptime"},
+	{uint64(SetattrFlags), "SetattrFlags"},
 }
 
 // Flags that can be seen in OpenRequest.Flags.
@@ -177,7 +180,7 @@ type OpenFlags uint32
 func (fl OpenFlags) String() string {
 	// O_RDONLY, O_RWONLY, O_RDWR are not flags
 	s := accModeName(fl & OpenAccessModeMask)
-	flags := uint32(fl &^ OpenAccessModeMask)
+	flags := uint64(fl &^ OpenAccessModeMask)
 	if flags != 0 {
 		s = s + "+" + flagString(flags, openFlagNames)
 	}
@@ -213,11 +216,11 @@ func accModeName(flags OpenFlags) string {
 }
 
 var openFlagNames = []flagName{
-	{uint32(OpenCreate), "OpenCreate"},
-	{uint32(OpenExclusive), "OpenExclusive"},
-	{uint32(OpenTruncate), "OpenTruncate"},
-	{uint32(OpenAppend), "OpenAppend"},
-	{uint32(OpenSync), "OpenSync"},
+	{uint64(OpenCreate), "OpenCreate"},
+	{uint64(OpenExclusive), "OpenExclusive"},
+	{uint64(OpenTruncate), "OpenTruncate"},
+	{uint64(OpenAppend), "OpenAppend"},
+	{uint64(OpenSync), "OpenSync"},
 }
 
 // The OpenResponseFlags are returned in the OpenResponse.
@@ -227,48 +230,75 @@ const (
 	OpenDirectIO    OpenResponseFlags = 1 << 0 // bypass page cache for this open file
 	OpenKeepCache   OpenResponseFlags = 1 << 1 // don't invalidate the data cache on open
 	OpenNonSeekable OpenResponseFlags = 1 << 2 // mark the file as non-seekable (not supported on OS X)
+	OpenPassthrough OpenResponseFlags = 1 << 7 // pass reads and writes through to OpenOut.BackingID (7.40)
 
 	OpenPurgeAttr OpenResponseFlags = 1 << 30 // OS X
 	OpenPurgeUBC  OpenResponseFlags = 1 << 31 // OS X
 )
 
 func (fl OpenResponseFlags) String() string {
-	return flagString(uint32(fl), openResponseFlagNames)
+	return flagString(uint64(fl), openResponseFlagNames)
 }
 
 var openResponseFlagNames = []flagName{
-	{uint32(OpenDirectIO), "OpenDirectIO"},
-	{uint32(OpenKeepCache), "OpenKeepCache"},
-	{uint32(OpenNonSeekable), "OpenNonSeekable"},
-	{uint32(OpenPurgeAttr), "OpenPurgeAttr"},
-	{uint32(OpenPurgeUBC), "OpenPurgeUBC"},
+	{uint64(OpenDirectIO), "OpenDirectIO"},
+	{uint64(OpenKeepCache), "OpenKeepCache"},
+	{uint64(OpenNonSeekable), "OpenNonSeekable"},
+	{uint64(OpenPassthrough), "OpenPassthrough"},
+	{uint64(OpenPurgeAttr), "OpenPurgeAttr"},
+	{uint64(OpenPurgeUBC), "OpenPurgeUBC"},
 }
 
-// The InitFlags are used in the Init exchange.
-type InitFlags uint32
+// The InitFlags are used in the Init exchange. Since protocol version 7.36,
+// flags above bit 31 are carried in the flags2 fields of InitInExt and
+// InitOut, which are valid only when InitInitExt is set; converting to and
+// from that representation is left to the user of this package.
+type InitFlags uint64
 
 const (
-	InitAsyncRead        InitFlags = 1 << 0
-	InitPosixLocks       InitFlags = 1 << 1
-	InitFileOps          InitFlags = 1 << 2
-	InitAtomicTrunc      InitFlags = 1 << 3
-	InitExportSupport    InitFlags = 1 << 4
-	InitBigWrites        InitFlags = 1 << 5
-	InitDontMask         InitFlags = 1 << 6
-	InitSpliceWrite      InitFlags = 1 << 7
-	InitSpliceMove       InitFlags = 1 << 8
-	InitSpliceRead       InitFlags = 1 << 9
-	InitFlockLocks       InitFlags = 1 << 10
-	InitHasIoctlDir      InitFlags = 1 << 11
-	InitAutoInvalData    InitFlags = 1 << 12
-	InitDoReaddirplus    InitFlags = 1 << 13
-	InitReaddirplusAuto  InitFlags = 1 << 14
-	InitAsyncDIO         InitFlags = 1 << 15
-	InitWritebackCache   InitFlags = 1 << 16
-	InitNoOpenSupport    InitFlags = 1 << 17
-	InitMaxPages         InitFlags = 1 << 22
-	InitCacheSymlinks    InitFlags = 1 << 23
-	InitNoOpendirSupport InitFlags = 1 << 24
+	InitAsyncRead         InitFlags = 1 << 0
+	InitPosixLocks        InitFlags = 1 << 1
+	InitFileOps           InitFlags = 1 << 2
+	InitAtomicTrunc       InitFlags = 1 << 3
+	InitExportSupport     InitFlags = 1 << 4
+	InitBigWrites         InitFlags = 1 << 5
+	InitDontMask          InitFlags = 1 << 6
+	InitSpliceWrite       InitFlags = 1 << 7
+	InitSpliceMove        InitFlags = 1 << 8
+	InitSpliceRead        InitFlags = 1 << 9
+	InitFlockLocks        InitFlags = 1 << 10
+	InitHasIoctlDir       InitFlags = 1 << 11
+	InitAutoInvalData     InitFlags = 1 << 12
+	InitDoReaddirplus     InitFlags = 1 << 13
+	InitReaddirplusAuto   InitFlags = 1 << 14
+	InitAsyncDIO          InitFlags = 1 << 15
+	InitWritebackCache    InitFlags = 1 << 16
+	InitNoOpenSupport     InitFlags = 1 << 17
+	InitParallelDirops    InitFlags = 1 << 18
+	InitHandleKillpriv    InitFlags = 1 << 19
+	InitPosixACL          InitFlags = 1 << 20
+	InitAbortError        InitFlags = 1 << 21
+	InitMaxPages          InitFlags = 1 << 22
+	InitCacheSymlinks     InitFlags = 1 << 23
+	InitNoOpendirSupport  InitFlags = 1 << 24
+	InitExplicitInvalData InitFlags = 1 << 25
+	InitMapAlignment      InitFlags = 1 << 26
+	InitSubmounts         InitFlags = 1 << 27
+	InitHandleKillprivV2  InitFlags = 1 << 28
+
+	// Linux only. OS X uses these bits for the flags below.
+	InitSetxattrExt       InitFlags = 1 << 29
+	InitInitExt           InitFlags = 1 << 30
+	InitInitReserved      InitFlags = 1 << 31
+	InitSecurityCtx       InitFlags = 1 << 32
+	InitHasInodeDAX       InitFlags = 1 << 33
+	InitCreateSuppGroup   InitFlags = 1 << 34
+	InitHasExpireOnly     InitFlags = 1 << 35
+	InitDirectIOAllowMmap InitFlags = 1 << 36
+	InitPassthrough       InitFlags = 1 << 37
+	InitNoExportSupport   InitFlags = 1 << 38
+	InitHasResend         InitFlags = 1 << 39
+	InitAllowIdmap        InitFlags = 1 << 40
 
 	InitCaseSensitive InitFlags = 1 << 29 // OS X only
 	InitVolRename     InitFlags = 1 << 30 // OS X only
@@ -276,43 +306,56 @@ const (
 )
 
 type flagName struct {
-	bit  uint32
+	bit  uint64
 	name string
 }
 
 var initFlagNames = []flagName{
-	{uint32(InitAsyncRead), "InitAsyncRead"},
-	{uint32(InitPosixLocks), "InitPosixLocks"},
-	{uint32(InitFileOps), "InitFileOps"},
-	{uint32(InitAtomicTrunc), "InitAtomicTrunc"},
-	{uint32(InitExportSupport), "InitExportSupport"},
-	{uint32(InitBigWrites), "InitBigWrites"},
-	{uint32(InitMaxPages), "InitMaxPages"},
-	{uint32(InitDontMask), "InitDontMask"},
-	{uint32(InitSpliceWrite), "InitSpliceWrite"},
-	{uint32(InitSpliceMove), "InitSpliceMove"},
-	{uint32(InitSpliceRead), "InitSpliceRead"},
-	{uint32(InitFlockLocks), "InitFlockLocks"},
-	{uint32(InitHasIoctlDir), "InitHasIoctlDir"},
-	{uint32(InitAutoInvalData), "InitAutoInvalData"},
-	{uint32(InitDoReaddirplus), "InitDoReaddirplus"},
-	{uint32(InitReaddirplusAuto), "InitReaddirplusAuto"},
-	{uint32(InitAsyncDIO), "InitAsyncDIO"},
-	{uint32(InitWritebackCache), "InitWritebackCache"},
-	{uint32(InitNoOpenSupport), "InitNoOpenSupport"},
-	{uint32(InitCacheSymlinks), "InitCacheSymlinks"},
-	{uint32(InitNoOpendirSupport), "InitNoOpendirSupport"},
-
-	{uint32(InitCaseSensitive), "InitCaseSensitive"},
-	{uint32(InitVolRename), "InitVolRename"},
-	{uint32(InitXtimes), "InitXtimes"},
+	{uint64(InitAsyncRead), "InitAsyncRead"},
+	{uint64(InitPosixLocks), "InitPosixLocks"},
+	{uint64(InitFileOps), "InitFileOps"},
+	{uint64(InitAtomicTrunc), "InitAtomicTrunc"},
+	{uint64(InitExportSupport), "InitExportSupport"},
+	{uint64(InitBigWrites), "InitBigWrites"},
+	{uint64(InitMaxPages), "InitMaxPages"},
+	{uint64(InitDontMask), "InitDontMask"},
+	{uint64(InitSpliceWrite), "InitSpliceWrite"},
+	{uint64(InitSpliceMove), "InitSpliceMove"},
+	{uint64(InitSpliceRead), "InitSpliceRead"},
+	{uint64(InitFlockLocks), "InitFlockLocks"},
+	{uint64(InitHasIoctlDir), "InitHasIoctlDir"},
+	{uint64(InitAutoInvalData), "InitAutoInvalData"},
+	{uint64(InitDoReaddirplus), "InitDoReaddirplus"},
+	{uint64(InitReaddirplusAuto), "InitReaddirplusAuto"},
+	{uint64(InitAsyncDIO), "InitAsyncDIO"},
+	{uint64(InitWritebackCache), "InitWritebackCache"},
+	{uint64(InitNoOpenSupport), "InitNoOpenSupport"},
+	{uint64(InitCacheSymlinks), "InitCacheSymlinks"},
+	{uint64(InitNoOpendirSupport), "InitNoOpendirSupport"},
+	{uint64(InitParallelDirops), "InitParallelDirops"},
+	{uint64(InitHandleKillpriv), "InitHandleKillpriv"},
+	{uint64(InitPosixACL), "InitPosixACL"},
+	{uint64(InitAbortError), "InitAbortError"},
+	{uint64(InitExplicitInvalData), "InitExplicitInvalData"},
+	{uint64(InitMapAlignment), "InitMapAlignment"},
+	{uint64(InitSubmounts), "InitSubmounts"},
+	{uint64(InitHandleKillprivV2), "InitHandleKillprivV2"},
+	{uint64(InitSecurityCtx), "InitSecurityCtx"},
+	{uint64(InitHasInodeDAX), "InitHasInodeDAX"},
+	{uint64(InitCreateSuppGroup), "InitCreateSuppGroup"},
+	{uint64(InitHasExpireOnly), "InitHasExpireOnly"},
+	{uint64(InitDirectIOAllowMmap), "InitDirectIOAllowMmap"},
+	{uint64(InitPassthrough), "InitPassthrough"},
+	{uint64(InitNoExportSupport), "InitNoExportSupport"},
+	{uint64(InitHasResend), "InitHasResend"},
+	{uint64(InitAllowIdmap), "InitAllowIdmap"},
 }
 
 func (fl InitFlags) String() string {
-	return flagString(uint32(fl), initFlagNames)
+	return flagString(uint64(fl), append(initFlagNames, osInitFlagNames...))
 }
 
-func flagString(f uint32, names []flagName) string {
+func flagString(f uint64, names []flagName) string {
 	var s string
 
 	if f == 0 {
@@ -335,59 +378,64 @@ func flagString(f uint32, names []flagName) string {
 type ReleaseFlags uint32
 
 const (
-	ReleaseFlush ReleaseFlags = 1 << 0
+	ReleaseFlush       ReleaseFlags = 1 << 0
+	ReleaseFlockUnlock ReleaseFlags = 1 << 1
 )
 
 func (fl ReleaseFlags) String() string {
-	return flagString(uint32(fl), releaseFlagNames)
+	return flagString(uint64(fl), releaseFlagNames)
 }
 
 var releaseFlagNames = []flagName{
-	{uint32(ReleaseFlush), "ReleaseFlush"},
+	{uint64(ReleaseFlush), "ReleaseFlush"},
+	{uint64(ReleaseFlockUnlock), "ReleaseFlockUnlock"},
 }
 
 // Opcodes
 const (
-	OpLookup      = 1
-	OpForget      = 2 // no reply
-	OpGetattr     = 3
-	OpSetattr     = 4
-	OpReadlink    = 5
-	OpSymlink     = 6
-	OpMknod       = 8
-	OpMkdir       = 9
-	OpUnlink      = 10
-	OpRmdir       = 11
-	OpRename      = 12
-	OpLink        = 13
-	OpOpen        = 14
-	OpRead        = 15
-	OpWrite       = 16
-	OpStatfs      = 17
-	OpRelease     = 18
-	OpFsync       = 20
-	OpSetxattr    = 21
-	OpGetxattr    = 22
-	OpListxattr   = 23
-	OpRemovexattr = 24
-	OpFlush       = 25
-	OpInit        = 26
-	OpOpendir     = 27
-	OpReaddir     = 28
-	OpReleasedir  = 29
-	OpFsyncdir    = 30
-	OpGetlk       = 31
-	OpSetlk       = 32
-	OpSetlkw      = 33
-	OpAccess      = 34
-	OpCreate      = 35
-	OpInterrupt   = 36
-	OpBmap        = 37
-	OpDestroy     = 38
-	OpIoctl       = 39 // Linux?
-	OpPoll        = 40 // Linux?
-	OpBatchForget = 42
-	OpFallocate   = 43
+	OpLookup        = 1
+	OpForget        = 2 // no reply
+	OpGetattr       = 3
+	OpSetattr       = 4
+	OpReadlink      = 5
+	OpSymlink       = 6
+	OpMknod         = 8
+	OpMkdir         = 9
+	OpUnlink        = 10
+	OpRmdir         = 11
+	OpRename        = 12
+	OpLink          = 13
+	OpOpen          = 14
+	OpRead          = 15
+	OpWrite         = 16
+	OpStatfs        = 17
+	OpRelease       = 18
+	OpFsync         = 20
+	OpSetxattr      = 21
+	OpGetxattr      = 22
+	OpListxattr     = 23
+	OpRemovexattr   = 24
+	OpFlush         = 25
+	OpInit          = 26
+	OpOpendir       = 27
+	OpReaddir       = 28
+	OpReleasedir    = 29
+	OpFsyncdir      = 30
+	OpGetlk         = 31
+	OpSetlk         = 32
+	OpSetlkw        = 33
+	OpAccess        = 34
+	OpCreate        = 35
+	OpInterrupt     = 36
+	OpBmap          = 37
+	OpDestroy       = 38
+	OpIoctl         = 39
+	OpPoll          = 40
+	OpBatchForget   = 42
+	OpFallocate     = 43
+	OpReaddirplus   = 44
+	OpLseek         = 46
+	OpCopyFileRange = 47
 
 	// OS X
 	OpSetvolname = 61
@@ -526,21 +574,37 @@ type setattrInCommon struct {
 }
 
 type OpenIn struct {
-	Flags  uint32
-	Unused uint32
+	Flags     uint32
+	OpenFlags uint32 // FUSE_OPEN_*; see OpenInFlags
 }
 
+// The OpenInFlags are passed in OpenIn and CreateIn.
+type OpenInFlags uint32
+
+const (
+	// Clear the setuid and setgid bits when truncating (7.33).
+	OpenKillSuidgid OpenInFlags = 1 << 0
+)
+
 type OpenOut struct {
 	Fh        uint64
 	OpenFlags uint32
-	Padding   uint32
+	BackingID int32 // 7.40; valid only with OpenPassthrough
 }
 
-type CreateIn struct {
+// BackingMap is the argument to the FUSE_DEV_IOC_BACKING_OPEN ioctl, which
+// registers a backing file for passthrough (7.40).
+type BackingMap struct {
+	Fd      int32
 	Flags   uint32
-	Mode    uint32
-	Umask   uint32
-	padding uint32
+	Padding uint64
+}
+
+type CreateIn struct {
+	Flags     uint32
+	Mode      uint32
+	Umask     uint32
+	OpenFlags uint32 // FUSE_OPEN_*; see OpenInFlags
 }
 
 func CreateInSize(p Protocol) uintptr {
@@ -556,7 +620,7 @@ type ReleaseIn struct {
 	Fh           uint64
 	Flags        uint32
 	ReleaseFlags uint32
-	LockOwner    uint32
+	LockOwner    uint64
 }
 
 type FlushIn struct {
@@ -594,11 +658,11 @@ const (
 )
 
 var readFlagNames = []flagName{
-	{uint32(ReadLockOwner), "ReadLockOwner"},
+	{uint64(ReadLockOwner), "ReadLockOwner"},
 }
 
 func (fl ReadFlags) String() string {
-	return flagString(uint32(fl), readFlagNames)
+	return flagString(uint64(fl), readFlagNames)
 }
 
 type WriteIn struct {
@@ -632,15 +696,18 @@ const (
 	WriteCache WriteFlags = 1 << 0
 	// LockOwner field is valid.
 	WriteLockOwner WriteFlags = 1 << 1
+	// Clear the setuid and setgid bits (formerly WriteKillPriv).
+	WriteKillSuidgid WriteFlags = 1 << 2
 )
 
 var writeFlagNames = []flagName{
-	{uint32(WriteCache), "WriteCache"},
-	{uint32(WriteLockOwner), "WriteLockOwner"},
+	{uint64(WriteCache), "WriteCache"},
+	{uint64(WriteLockOwner), "WriteLockOwner"},
+	{uint64(WriteKillSuidgid), "WriteKillSuidgid"},
 }
 
 func (fl WriteFlags) String() string {
-	return flagString(uint32(fl), writeFlagNames)
+	return flagString(uint64(fl), writeFlagNames)
 }
 
 const compatStatfsSize = 48
@@ -664,6 +731,21 @@ func (setxattrInCommon) GetPosition() uint32 {
 	return 0
 }
 
+// SetxattrInExt follows SetxattrIn when InitSetxattrExt was negotiated
+// (7.33).
+type SetxattrInExt struct {
+	SetxattrFlags uint32
+	Padding       uint32
+}
+
+// The SetxattrFlags are passed in SetxattrInExt.
+type SetxattrFlags uint32
+
+const (
+	// Clear the setgid bit when setting a POSIX access ACL.
+	SetxattrACLKillSgid SetxattrFlags = 1 << 0
+)
+
 type getxattrInCommon struct {
 	Size    uint32
 	Padding uint32
@@ -699,6 +781,14 @@ type LkIn struct {
 	padding uint32
 }
 
+// The LkFlags are passed in LkIn.
+type LkFlags uint32
+
+const (
+	// The lock was requested with flock(2) rather than fcntl(2).
+	LkFlock LkFlags = 1 << 0
+)
+
 func LkInSize(p Protocol) uintptr {
 	switch {
 	case p.LT(Protocol{7, 9}):
@@ -717,6 +807,75 @@ type AccessIn struct {
 	Padding uint32
 }
 
+type IoctlIn struct {
+	Fh      uint64
+	Flags   uint32
+	Cmd     uint32
+	Arg     uint64
+	InSize  uint32
+	OutSize uint32
+}
+
+type IoctlOut struct {
+	Result  int32
+	Flags   uint32
+	InIovs  uint32
+	OutIovs uint32
+}
+
+// The IoctlFlags are passed in IoctlIn and IoctlOut.
+type IoctlFlags uint32
+
+const (
+	IoctlCompat       IoctlFlags = 1 << 0 // 32-bit compat ioctl on a 64-bit machine
+	IoctlUnrestricted IoctlFlags = 1 << 1 // not restricted to well-formed ioctls (CUSE only)
+	IoctlRetry        IoctlFlags = 1 << 2 // retry with the supplied iovecs (CUSE only)
+	Ioctl32Bit        IoctlFlags = 1 << 3 // 32-bit ioctl
+	IoctlDir          IoctlFlags = 1 << 4 // the ioctl was made on a directory
+	IoctlCompatX32    IoctlFlags = 1 << 5 // x32 compat ioctl on a 64-bit machine
+)
+
+type PollIn struct {
+	Fh     uint64
+	Kh     uint64
+	Flags  uint32
+	Events uint32
+}
+
+type PollOut struct {
+	Revents uint32
+	Padding uint32
+}
+
+// The PollFlags are passed in PollIn.
+type PollFlags uint32
+
+const (
+	// Request a poll wakeup notification when the file becomes ready.
+	PollScheduleNotify PollFlags = 1 << 0
+)
+
+type LseekIn struct {
+	Fh      uint64
+	Offset  uint64
+	Whence  uint32
+	Padding uint32
+}
+
+type LseekOut struct {
+	Offset uint64
+}
+
+type CopyFileRangeIn struct {
+	FhIn      uint64
+	OffIn     uint64
+	NodeidOut uint64
+	FhOut     uint64
+	OffOut    uint64
+	Len       uint64
+	Flags     uint64
+}
+
 type InitIn struct {
 	Major        uint32
 	Minor        uint32
@@ -726,6 +885,12 @@ type InitIn struct {
 
 const InitInSize = int(unsafe.Sizeof(InitIn{}))
 
+// InitInExt follows InitIn when the kernel sets InitInitExt (7.36).
+type InitInExt struct {
+	Flags2 uint32
+	Unused [11]uint32
+}
+
 type InitOut struct {
 	Major               uint32
 	Minor               uint32
@@ -737,7 +902,9 @@ type InitOut struct {
 	TimeGran            uint32
 	MaxPages            uint16
 	MapAlignment        uint16
-	Unused              [8]uint32
+	Flags2              uint32 // 7.36; valid only with InitInitExt
+	MaxStackDepth       uint32 // 7.40
+	Unused              [6]uint32
 }
 
 type InterruptIn struct {
@@ -755,14 +922,15 @@ type BmapOut struct {
 }
 
 type InHeader struct {
-	Len     uint32
-	Opcode  uint32
-	Unique  uint64
-	Nodeid  uint64
-	Uid     uint32
-	Gid     uint32
-	Pid     uint32
-	Padding uint32
+	Len         uint32
+	Opcode      uint32
+	Unique      uint64
+	Nodeid      uint64
+	Uid         uint32
+	Gid         uint32
+	Pid         uint32
+	TotalExtlen uint16 // 7.38; in units of 8 bytes
+	Padding     uint16
 }
 
 const InHeaderSize = int(unsafe.Sizeof(InHeader{}))
@@ -789,6 +957,20 @@ const (
 	NotifyCodeInvalEntry int32 = 3
 )
 
+// The AttrFlags are passed in Attr (7.32, Linux only).
+type AttrFlags uint32
+
+const (
+	// The object is a submount root (requires InitSubmounts).
+	AttrSubmount AttrFlags = 1 << 0
+	// Enable DAX for this file in per-file DAX mode (requires InitHasInodeDAX).
+	AttrDAX AttrFlags = 1 << 1
+)
+
+type NotifyPollWakeupOut struct {
+	Kh uint64
+}
+
 type NotifyInvalInodeOut struct {
 	Ino uint64
 	Off int64
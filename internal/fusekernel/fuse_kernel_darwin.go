@@ -26,6 +26,17 @@ type Attr struct {
 	padding    uint32
 }
 
+func (a *Attr) SetDAX(dax bool) {
+	// Ignored on OS X.
+}
+
+// Bits 29-31 have their OS X meanings.
+var osInitFlagNames = []flagName{
+	{uint64(InitCaseSensitive), "InitCaseSensitive"},
+	{uint64(InitVolRename), "InitVolRename"},
+	{uint64(InitXtimes), "InitXtimes"},
+}
+
 func (a *Attr) SetCrtime(s uint64, ns uint32) {
 	a.Crtime_, a.CrtimeNsec = s, ns
 }
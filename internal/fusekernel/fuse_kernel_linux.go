@@ -18,7 +18,7 @@ type Attr struct {
 	Gid       uint32
 	Rdev      uint32
 	Blksize   uint32
-	padding   uint32
+	Flags_    uint32 // 7.32; see AttrFlags
 }
 
 func (a *Attr) Crtime() time.Time {
@@ -33,6 +33,21 @@ func (a *Attr) SetFlags(f uint32) {
 	// Ignored on Linux.
 }
 
+func (a *Attr) SetDAX(dax bool) {
+	if dax {
+		a.Flags_ |= uint32(AttrDAX)
+	} else {
+		a.Flags_ &^= uint32(AttrDAX)
+	}
+}
+
+// Bits 29-31 have their Linux meanings.
+var osInitFlagNames = []flagName{
+	{uint64(InitSetxattrExt), "InitSetxattrExt"},
+	{uint64(InitInitExt), "InitInitExt"},
+	{uint64(InitInitReserved), "InitInitReserved"},
+}
+
 type SetattrIn struct {
 	setattrInCommon
 }
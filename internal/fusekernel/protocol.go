@@ -58,6 +58,16 @@ func (a Protocol) HasOpenNonSeekable() bool {
 	return a.is710()
 }
 
+func (a Protocol) is711() bool {
+	return a.GE(Protocol{7, 11})
+}
+
+// HasPoll returns whether PollRequest and poll wakeup notifications are
+// supported.
+func (a Protocol) HasPoll() bool {
+	return a.is711()
+}
+
 func (a Protocol) is712() bool {
 	return a.GE(Protocol{7, 12})
 }
@@ -73,3 +83,88 @@ func (a Protocol) HasUmask() bool {
 func (a Protocol) HasInvalidate() bool {
 	return a.is712()
 }
+
+func (a Protocol) is714() bool {
+	return a.GE(Protocol{7, 14})
+}
+
+// HasSplice returns whether requests may be read from and replies written to
+// the device using splice(2).
+func (a Protocol) HasSplice() bool {
+	return a.is714()
+}
+
+func (a Protocol) is732() bool {
+	return a.GE(Protocol{7, 32})
+}
+
+// HasAttrFlags returns whether Attr carries AttrFlags, and whether
+// InitSubmounts is supported.
+func (a Protocol) HasAttrFlags() bool {
+	return a.is732()
+}
+
+func (a Protocol) is733() bool {
+	return a.GE(Protocol{7, 33})
+}
+
+// HasHandleKillprivV2 returns whether InitHandleKillprivV2 is supported,
+// along with the WriteKillSuidgid, SetattrKillSuidgid, and OpenKillSuidgid
+// flags that accompany it.
+func (a Protocol) HasHandleKillprivV2() bool {
+	return a.is733()
+}
+
+// HasSetxattrExt returns whether InitSetxattrExt and SetxattrInExt are
+// supported.
+func (a Protocol) HasSetxattrExt() bool {
+	return a.is733()
+}
+
+func (a Protocol) is736() bool {
+	return a.GE(Protocol{7, 36})
+}
+
+// HasInitExt returns whether InitInitExt is supported, allowing InitFlags
+// above bit 31 to be exchanged by way of InitInExt and InitOut.Flags2.
+func (a Protocol) HasInitExt() bool {
+	return a.is736()
+}
+
+// HasInodeDAX returns whether InitHasInodeDAX and AttrDAX are supported.
+func (a Protocol) HasInodeDAX() bool {
+	return a.is736()
+}
+
+func (a Protocol) is738() bool {
+	return a.GE(Protocol{7, 38})
+}
+
+// HasExpireOnly returns whether InitHasExpireOnly is supported.
+func (a Protocol) HasExpireOnly() bool {
+	return a.is738()
+}
+
+func (a Protocol) is740() bool {
+	return a.GE(Protocol{7, 40})
+}
+
+// HasPassthrough returns whether InitPassthrough and InitOut.MaxStackDepth
+// are supported.
+func (a Protocol) HasPassthrough() bool {
+	return a.is740()
+}
+
+// HasResend returns whether InitHasResend is supported.
+func (a Protocol) HasResend() bool {
+	return a.is740()
+}
+
+func (a Protocol) is741() bool {
+	return a.GE(Protocol{7, 41})
+}
+
+// HasAllowIdmap returns whether InitAllowIdmap is supported.
+func (a Protocol) HasAllowIdmap() bool {
+	return a.is741()
+}
@@ -0,0 +1,268 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fuse
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"path"
+	"reflect"
+	"runtime"
+	"time"
+
+	"github.com/jacobsa/fuse/fuseops"
+)
+
+// The keys of the attributes attached to log records, as documented on
+// MountConfig.LogHandler.
+const (
+	logKeyOp       = "op"
+	logKeyUnique   = "unique"
+	logKeyInode    = "inode"
+	logKeyHandle   = "handle"
+	logKeyPid      = "pid"
+	logKeyDuration = "duration"
+	logKeyError    = "error"
+	logKeyStacks   = "stacks"
+)
+
+// An attribute value that other handlers see as the value it resolves to,
+// but that legacyHandler prints as it was printed before LogHandler existed.
+type legacyValue struct {
+	value  slog.Value
+	legacy string
+}
+
+func (v legacyValue) LogValue() slog.Value {
+	return v.value
+}
+
+// Return the attribute naming an op. The old format identified ops by type.
+func opAttr(op interface{}) slog.Attr {
+	return slog.Any(logKeyOp, legacyValue{
+		value:  slog.StringValue(opName(op)),
+		legacy: fmt.Sprintf("%T", op),
+	})
+}
+
+// Return the attribute for an error writing the given message to the kernel.
+// The old format printed the message too.
+func writeErrorAttr(err error, msg []byte) slog.Attr {
+	return slog.Any(logKeyError, legacyValue{
+		value:  slog.AnyValue(err),
+		legacy: fmt.Sprintf("%v %v", err, msg),
+	})
+}
+
+// Return the string that the old format printed for an attribute value.
+func legacyString(v slog.Value) string {
+	if lv, ok := v.Any().(legacyValue); ok && v.Kind() == slog.KindLogValuer {
+		return lv.legacy
+	}
+
+	return v.Resolve().String()
+}
+
+// Choose the handler to which a connection for the supplied config logs, or
+// nil if it shouldn't log.
+func logHandler(cfg *MountConfig) slog.Handler {
+	if cfg.LogHandler != nil {
+		return cfg.LogHandler
+	}
+
+	if cfg.DebugLogger == nil && cfg.ErrorLogger == nil {
+		return nil
+	}
+
+	return &legacyHandler{
+		debugLogger: cfg.DebugLogger,
+		errorLogger: cfg.ErrorLogger,
+	}
+}
+
+// Return the attributes describing an op read from the kernel with the given
+// unique ID and inode.
+func opAttrs(
+	fuseID uint64,
+	inode fuseops.InodeID,
+	op interface{}) []slog.Attr {
+	attrs := []slog.Attr{
+		opAttr(op),
+		slog.Uint64(logKeyUnique, fuseID),
+		slog.Uint64(logKeyInode, uint64(inode)),
+	}
+
+	v := reflect.ValueOf(op).Elem()
+	if f := v.FieldByName("Handle"); f.IsValid() {
+		switch h := f.Interface().(type) {
+		case fuseops.HandleID:
+			attrs = append(attrs, slog.Uint64(logKeyHandle, uint64(h)))
+
+		case *fuseops.HandleID:
+			if h != nil {
+				attrs = append(attrs, slog.Uint64(logKeyHandle, uint64(*h)))
+			}
+		}
+	}
+
+	if f := v.FieldByName("OpContext"); f.IsValid() {
+		if meta, ok := f.Interface().(fuseops.OpContext); ok {
+			attrs = append(attrs, slog.Uint64(logKeyPid, uint64(meta.Pid)))
+		}
+	}
+
+	return attrs
+}
+
+// Return whether the connection's handler wants records at the given level.
+func (c *Connection) logEnabled(level slog.Level) bool {
+	return c.logHandler != nil &&
+		c.logHandler.Enabled(context.Background(), level)
+}
+
+// Log a record with the given attributes. calldepth is the depth of the
+// caller to whom the record should be attributed, with one meaning the
+// immediate caller of log.
+func (c *Connection) log(
+	level slog.Level,
+	calldepth int,
+	msg string,
+	attrs ...slog.Attr) {
+	if !c.logEnabled(level) {
+		return
+	}
+
+	// Skip runtime.Callers, as runtime.Caller would.
+	var pcs [1]uintptr
+	runtime.Callers(calldepth+1, pcs[:])
+
+	r := slog.NewRecord(time.Now(), level, msg, pcs[0])
+	r.AddAttrs(attrs...)
+	c.logHandler.Handle(context.Background(), r)
+}
+
+// A slog.Handler that writes records to the *log.Loggers in
+// MountConfig.DebugLogger and ErrorLogger in the format used before
+// LogHandler existed. Records at LevelError and above go to the error logger,
+// and the rest to the debug logger.
+type legacyHandler struct {
+	debugLogger *log.Logger
+	errorLogger *log.Logger
+
+	// Attributes added with WithAttrs.
+	attrs []slog.Attr
+}
+
+var _ slog.Handler = &legacyHandler{}
+
+func (h *legacyHandler) logger(level slog.Level) *log.Logger {
+	if level >= slog.LevelError {
+		return h.errorLogger
+	}
+
+	return h.debugLogger
+}
+
+func (h *legacyHandler) Enabled(
+	ctx context.Context,
+	level slog.Level) bool {
+	return h.logger(level) != nil
+}
+
+func (h *legacyHandler) Handle(
+	ctx context.Context,
+	r slog.Record) error {
+	l := h.logger(r.Level)
+	if l == nil {
+		return nil
+	}
+
+	// Find the attributes that the old format included.
+	var op string
+	var fuseID uint64
+	var opErr string
+	var stacks string
+	find := func(a slog.Attr) bool {
+		switch a.Key {
+		case logKeyOp:
+			op = legacyString(a.Value)
+		case logKeyUnique:
+			fuseID = a.Value.Resolve().Uint64()
+		case logKeyError:
+			opErr = legacyString(a.Value)
+		case logKeyStacks:
+			stacks = legacyString(a.Value)
+		}
+
+		return true
+	}
+
+	for _, a := range h.attrs {
+		find(a)
+	}
+
+	r.Attrs(find)
+
+	// Errors are a bare message.
+	if r.Level >= slog.LevelError {
+		msg := r.Message
+		if op != "" {
+			msg = op + " " + msg
+		}
+
+		if opErr != "" {
+			msg += ": " + opErr
+		}
+
+		if stacks != "" {
+			msg += "\n" + stacks
+		}
+
+		l.Println(msg)
+		return nil
+	}
+
+	// Debug lines are prefixed with the op ID and the file:line of the caller.
+	file, line := "???", 0
+	if r.PC != 0 {
+		frame, _ := runtime.CallersFrames([]uintptr{r.PC}).Next()
+		if frame.File != "" {
+			file, line = frame.File, frame.Line
+		}
+	}
+
+	fileLine := fmt.Sprintf("%v:%v", path.Base(file), line)
+
+	l.Println(fmt.Sprintf(
+		"Op 0x%08x %24s] %v",
+		fuseID,
+		fileLine,
+		r.Message))
+
+	return nil
+}
+
+func (h *legacyHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := *h
+	h2.attrs = append(h.attrs[:len(h.attrs):len(h.attrs)], attrs...)
+	return &h2
+}
+
+// Groups have no representation in the old format.
+func (h *legacyHandler) WithGroup(name string) slog.Handler {
+	return h
+}
@@ -0,0 +1,171 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fuse
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"log/slog"
+	"regexp"
+	"syscall"
+	"testing"
+
+	"github.com/jacobsa/fuse/fuseops"
+)
+
+func TestOpAttrs(t *testing.T) {
+	var buf bytes.Buffer
+	c := &Connection{
+		logHandler: slog.NewJSONHandler(
+			&buf,
+			&slog.HandlerOptions{Level: slog.LevelDebug}),
+	}
+
+	op := &fuseops.ReadFileOp{
+		Inode:     17,
+		Handle:    23,
+		OpContext: fuseops.OpContext{Pid: 1234},
+	}
+
+	c.log(slog.LevelError, 1, "error", append(
+		opAttrs(0x42, 17, op),
+		slog.Any(logKeyError, syscall.EIO))...)
+
+	var record map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("Unmarshal(%q): %v", buf.String(), err)
+	}
+
+	want := map[string]interface{}{
+		"level":  "ERROR",
+		"msg":    "error",
+		"op":     "ReadFile",
+		"unique": float64(0x42),
+		"inode":  float64(17),
+		"handle": float64(23),
+		"pid":    float64(1234),
+		"error":  syscall.EIO.Error(),
+	}
+
+	for k, v := range want {
+		if record[k] != v {
+			t.Errorf("%s = %#v, want %#v", k, record[k], v)
+		}
+	}
+}
+
+func TestReplyAttrs(t *testing.T) {
+	var buf bytes.Buffer
+	c, _ := newPipeConnection(t)
+	c.inFlight = make(map[uint64]*inFlightOp)
+	c.cfg.OpContext = context.Background()
+	c.logHandler = slog.NewJSONHandler(
+		&buf,
+		&slog.HandlerOptions{Level: slog.LevelDebug})
+
+	op := &fuseops.ReadFileOp{
+		Inode:     17,
+		Handle:    23,
+		OpContext: fuseops.OpContext{Pid: 1234},
+	}
+
+	ctx := beginTestOp(t, c, op)
+	ctx.Value(contextKey).(opState).inMsg.Header().Nodeid = 17
+	c.Reply(ctx, syscall.EIO)
+
+	// The debug record for the reply can be told apart from those of other
+	// ops in the same way as the one for the request. It precedes the error
+	// record.
+	var record map[string]interface{}
+	if err := json.NewDecoder(&buf).Decode(&record); err != nil {
+		t.Fatalf("Unmarshal(%q): %v", buf.String(), err)
+	}
+
+	want := map[string]interface{}{
+		"level":  "DEBUG",
+		"op":     "ReadFile",
+		"unique": float64(1),
+		"inode":  float64(17),
+		"handle": float64(23),
+		"pid":    float64(1234),
+		"error":  syscall.EIO.Error(),
+	}
+
+	for k, v := range want {
+		if record[k] != v {
+			t.Errorf("%s = %#v, want %#v", k, record[k], v)
+		}
+	}
+
+	if _, ok := record["duration"]; !ok {
+		t.Errorf("No duration in %v", record)
+	}
+}
+
+func TestLegacyHandler(t *testing.T) {
+	var debugBuf, errorBuf bytes.Buffer
+	c := &Connection{
+		logHandler: logHandler(&MountConfig{
+			DebugLogger: log.New(&debugBuf, "", 0),
+			ErrorLogger: log.New(&errorBuf, "", 0),
+		}),
+	}
+
+	op := &fuseops.LookUpInodeOp{Parent: 1, Name: "foo"}
+	c.log(slog.LevelDebug, 1, "<- "+describeRequest(op), opAttrs(0x42, 1, op)...)
+	c.log(slog.LevelError, 1, "error", append(
+		opAttrs(0x42, 1, op),
+		slog.Any(logKeyError, syscall.EIO))...)
+	c.log(
+		slog.LevelError,
+		1,
+		"writeMessage",
+		slog.Uint64(logKeyUnique, 0x42),
+		writeErrorAttr(syscall.ENOENT, []byte{16, 0, 0, 0}))
+
+	debugRE := regexp.MustCompile(
+		`^Op 0x00000042 +log_test.go:\d+\] <- LookUpInode \(parent 1, name "foo", PID 0\)\n$`)
+	if !debugRE.MatchString(debugBuf.String()) {
+		t.Errorf("Debug output: %q", debugBuf.String())
+	}
+
+	// The formats used before LogHandler existed:
+	//
+	//     c.errorLogger.Printf("%T error: %v", op, opErr)
+	//     c.errorLogger.Printf("writeMessage: %v %v", err, outMsg.OutHeaderBytes())
+	//
+	want := "*fuseops.LookUpInodeOp error: input/output error\n" +
+		"writeMessage: no such file or directory [16 0 0 0]\n"
+
+	if got := errorBuf.String(); got != want {
+		t.Errorf("Error output: %q, want %q", got, want)
+	}
+}
+
+func TestLegacyHandlerWithAttrs(t *testing.T) {
+	var errorBuf bytes.Buffer
+	h := logHandler(&MountConfig{ErrorLogger: log.New(&errorBuf, "", 0)})
+
+	// Attributes added up front are formatted as if they'd been on the record.
+	op := &fuseops.ReadFileOp{}
+	c := &Connection{logHandler: h.WithAttrs([]slog.Attr{opAttr(op)})}
+	c.log(slog.LevelError, 1, "error", slog.Any(logKeyError, syscall.EIO))
+
+	if got, want := errorBuf.String(), "*fuseops.ReadFileOp error: input/output error\n"; got != want {
+		t.Errorf("Error output: %q, want %q", got, want)
+	}
+}
@@ -68,13 +68,15 @@ func Mount(
 	// Create a Connection object wrapping the device.
 	connection, err := newConnection(
 		cfgCopy,
-		config.DebugLogger,
-		config.ErrorLogger,
+		logHandler(&cfgCopy),
 		dev)
 	if err != nil {
 		return nil, fmt.Errorf("newConnection: %v", err)
 	}
 
+	mfs.conn = connection
+	mfs.lazyUnmount = config.LazyUnmount
+
 	// Serve the connection in the background. When done, set the join status.
 	go func() {
 		server.ServeOps(connection)
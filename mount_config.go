@@ -18,8 +18,10 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"log/slog"
 	"runtime"
 	"strings"
+	"time"
 )
 
 // Optional configuration accepted by Mount.
@@ -47,6 +49,48 @@ type MountConfig struct {
 	// performed.
 	DebugLogger *log.Logger
 
+	// A handler for structured logging, which if non-nil is used in place of
+	// ErrorLogger and DebugLogger. Errors are logged at slog.LevelError, and
+	// debug information about every op at slog.LevelDebug.
+	//
+	// Records concerning an op carry the attributes "op" (its name, e.g.
+	// "LookUpInode"), "unique" (the fuse unique ID), and where applicable
+	// "inode", "handle" and "pid". Records made when the op is replied to also
+	// carry "duration", the time since the op was read, and "error" if it
+	// failed.
+	LogHandler slog.Handler
+
+	// A tracer to notify at the start and end of every op, e.g. an OpCounter
+	// or an adapter for a metrics or distributed tracing system. If nil, no
+	// tracing is performed.
+	OpTracer OpTracer
+
+	// If non-nil, called for each op read from the kernel to choose how long
+	// the file system may take to handle it. Ops that take longer have their
+	// context cancelled and are replied to with ETIMEDOUT on the file system's
+	// behalf; its eventual reply is dropped. Zero or less means no limit.
+	//
+	// This keeps a hung file system from hanging the processes using it, but
+	// the file system should still return promptly once the context is
+	// cancelled, or its goroutines will pile up. ForgetInodeOp and
+	// BatchForgetOp, which get no reply, are never timed out. Nor are ops that
+	// give the kernel an inode or a handle (LookUpInodeOp, MkDirOp, MkNodeOp,
+	// CreateFileOp, CreateSymlinkOp, CreateLinkOp, ReadDirPlusOp, OpenFileOp
+	// and OpenDirOp), since were they to succeed after the kernel had given up
+	// on them it would never forget the inode or release the handle.
+	OpTimeout func(op interface{}) time.Duration
+
+	// If positive, ops that have been in flight for longer than this are
+	// logged at slog.LevelError along with the stacks of all goroutines, to
+	// help find out what is holding them up. Each op is reported at most once.
+	WatchdogThreshold time.Duration
+
+	// Have MountedFileSystem.Shutdown unmount the file system lazily if it
+	// doesn't drain in time, detaching it from the file hierarchy straight
+	// away and cleaning up once it is no longer busy. OS X doesn't support
+	// lazy unmounting; there the unmount is forced instead.
+	LazyUnmount bool
+
 	// Linux only. OS X always behaves as if writeback caching is disabled.
 	//
 	// By default on Linux we allow the kernel to perform writeback caching
@@ -151,6 +195,109 @@ type MountConfig struct {
 	// OpenDir calls at all (Linux >= 5.1):
 	EnableNoOpendirSupport bool
 
+	// Linux only.
+	//
+	// By default the kernel manages POSIX byte-range locks (fcntl(2) F_SETLK
+	// and friends) itself, which means that they are visible only to processes
+	// on the local machine. Setting this field asks the kernel to instead send
+	// GetFileLockOp and SetFileLockOp to the file system, allowing e.g. a
+	// networked file system to make locks visible across clients.
+	//
+	// File systems that set this must implement those ops, and must release
+	// locks held by FlushFileOp.LockOwner when receiving FlushFileOp.
+	EnablePosixLocks bool
+
+	// Linux only.
+	//
+	// Like EnablePosixLocks, but for flock(2) locks, which the kernel otherwise
+	// manages locally. When set, flock(2) causes SetFileLockOp to be sent with
+	// Flock set, and locks are released by way of ReleaseFileHandleOp.
+	EnableFlockLocks bool
+
+	// Linux only.
+	//
+	// Ask the kernel to read directories with ReadDirPlusOp rather than
+	// ReadDirOp (Linux >= 3.9). File systems that set this must implement
+	// ReadDirPlus.
+	EnableReaddirplus bool
+
+	// Linux only. Requires EnableReaddirplus.
+	//
+	// Let the kernel choose between ReadDirPlusOp and ReadDirOp for each read
+	// based on whether the entries are being looked up afterward, rather than
+	// always using ReadDirPlusOp. File systems that set this must implement
+	// both ops.
+	EnableReaddirplusAuto bool
+
+	// Linux only.
+	//
+	// By default the kernel clears the setuid and setgid bits of files itself
+	// when they are written, truncated, or chowned by a user lacking
+	// CAP_FSETID, by way of a SetInodeAttributesOp changing the mode. Setting
+	// this field asks it to leave that to the file system instead, flagging
+	// the ops concerned with KillSuidgid (Linux >= 5.11). This avoids an extra
+	// round trip per write and lets the file system do the clearing
+	// atomically.
+	EnableHandleKillprivV2 bool
+
+	// Linux only.
+	//
+	// Ask the kernel to send the extended setxattr request, which allows
+	// SetXattrOp.KillSGID to be filled in (Linux >= 5.16).
+	EnableSetxattrExt bool
+
+	// Linux only.
+	//
+	// Let the file system choose which files use DAX by way of
+	// InodeAttributes.DAX, when mounted with the dax=inode option (Linux >=
+	// 5.17).
+	EnableInodeDAX bool
+
+	// Linux only.
+	//
+	// The number of /dev/fuse descriptors from which ops may be read
+	// concurrently. Values greater than one cause the connection to clone its
+	// descriptor with FUSE_DEV_IOC_CLONE (Linux >= 4.2), and servers created
+	// with fuseutil.NewFileSystemServer to run that many reader goroutines,
+	// each with its own buffers. This helps throughput on machines with many
+	// cores, at the cost of ops no longer being read in the order the kernel
+	// sent them. Zero means one.
+	NumReaders int
+
+	// Linux only.
+	//
+	// Read requests from /dev/fuse using splice(2), so that the data of write
+	// requests can be left in a pipe rather than copied into user space. The
+	// file system then receives it by way of WriteFileOp.Spliced instead of
+	// WriteFileOp.Data, and may splice it onwards into a backing file.
+	// Servers created with fuseutil.NewFileSystemServer copy it into
+	// WriteFileOp.Data all the same unless given fuseutil.WithSplicedWrites.
+	//
+	// This requires pipes larger than the default limit in
+	// /proc/sys/fs/pipe-max-size, and therefore typically CAP_SYS_RESOURCE.
+	// If they can't be created, requests are read as usual.
+	EnableSpliceWrites bool
+
+	// Linux only.
+	//
+	// Send the data for ReadFileOps that set FileData to the kernel using
+	// splice(2), without copying it through user space. The caveat about pipe
+	// sizes on EnableSpliceWrites applies here too. Without this, FileData is
+	// still honored by reading from the file into a buffer.
+	EnableSpliceReads bool
+
+	// Linux only.
+	//
+	// Let the file system hand the kernel a backing file when opening a file,
+	// by way of OpenFileOp.BackingFile and CreateFileOp.BackingFile, so that
+	// reads and writes go straight to it (Linux >= 6.9). Registering backing
+	// files requires CAP_SYS_ADMIN; without it, files are opened as usual.
+	//
+	// The kernel doesn't support passthrough together with writeback caching,
+	// so setting this implies DisableWritebackCaching when the kernel supports
+	// passthrough.
+	EnablePassthrough bool
+
 	// Disable FUSE default permissions.
 	// This is useful for situations where the backing data store (e.g., S3) doesn't
 	// actually utilise any form of qualifiable UNIX permissions.
@@ -2,15 +2,20 @@ package fuse_test
 
 import (
 	"context"
+	"errors"
 	"io/ioutil"
 	"os"
 	"path"
 	"strings"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/jacobsa/fuse"
 	"github.com/jacobsa/fuse/fuseops"
 	"github.com/jacobsa/fuse/fuseutil"
+	"github.com/jacobsa/fuse/samples/hellofs"
+	"github.com/jacobsa/timeutil"
 )
 
 ////////////////////////////////////////////////////////////////////////
@@ -93,3 +98,86 @@ func TestNonexistentMountPoint(t *testing.T) {
 		t.Errorf("Unexpected error: %v", got)
 	}
 }
+
+func TestShutdownMounted(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	server, err := hellofs.NewHelloFS(timeutil.RealClock())
+	if err != nil {
+		t.Fatalf("NewHelloFS: %v", err)
+	}
+
+	mfs, err := fuse.Mount(dir, server, &fuse.MountConfig{})
+	if err != nil {
+		t.Fatalf("fuse.Mount: %v", err)
+	}
+
+	f, err := os.Open(path.Join(dir, "hello"))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	// With the file open, the deadline passes and the file system stays
+	// mounted, refusing opens.
+	deadline, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+
+	var se *fuse.ShutdownError
+	if err := mfs.Shutdown(deadline); !errors.As(err, &se) || se.Unmounted || len(se.OpenFiles) != 1 {
+		t.Fatalf("Shutdown: %v", err)
+	}
+
+	if _, err := os.Open(path.Join(dir, "dir/world")); !errors.Is(err, syscall.ENOTCONN) {
+		t.Errorf("Open while shutting down: %v", err)
+	}
+
+	// Once the file is closed, the file system drains and is unmounted.
+	f.Close()
+	if err := mfs.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+
+	if err := mfs.Join(ctx); err != nil {
+		t.Errorf("Joining: %v", err)
+	}
+}
+
+func TestShutdownLazily(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	server, err := hellofs.NewHelloFS(timeutil.RealClock())
+	if err != nil {
+		t.Fatalf("NewHelloFS: %v", err)
+	}
+
+	mfs, err := fuse.Mount(dir, server, &fuse.MountConfig{LazyUnmount: true})
+	if err != nil {
+		t.Fatalf("fuse.Mount: %v", err)
+	}
+
+	f, err := os.Open(path.Join(dir, "hello"))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	// The file system is detached as soon as the deadline passes, and goes
+	// away once the file is closed.
+	deadline, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+
+	var se *fuse.ShutdownError
+	if err := mfs.Shutdown(deadline); !errors.As(err, &se) || !se.Unmounted || len(se.OpenFiles) != 1 {
+		t.Fatalf("Shutdown: %v", err)
+	}
+
+	if _, err := os.Stat(path.Join(dir, "hello")); !os.IsNotExist(err) {
+		t.Errorf("Stat after unmounting: %v", err)
+	}
+
+	f.Close()
+	if err := mfs.Join(ctx); err != nil {
+		t.Errorf("Joining: %v", err)
+	}
+}
@@ -14,13 +14,23 @@
 
 package fuse
 
-import "context"
+import (
+	"context"
+	"fmt"
+
+	"github.com/jacobsa/fuse/fuseops"
+)
 
 // MountedFileSystem represents the status of a mount operation, with a method
 // that waits for unmounting.
 type MountedFileSystem struct {
 	dir string
 
+	// The connection being served, and whether to unmount it lazily if
+	// Shutdown runs out of time.
+	conn        *Connection
+	lazyUnmount bool
+
 	// The result to return from Join. Not valid until the channel is closed.
 	joinStatus          error
 	joinStatusAvailable chan struct{}
@@ -47,3 +57,86 @@ func (mfs *MountedFileSystem) Join(ctx context.Context) error {
 		return ctx.Err()
 	}
 }
+
+// Shutdown gracefully unmounts the file system. It starts by refusing further
+// attempts to open files and directories, failing them with ENOTCONN, then
+// waits for the ops in flight to be replied to and for the kernel to release
+// all open handles before unmounting.
+//
+// If ctx is done first, the file system is unmounted lazily if
+// MountConfig.LazyUnmount is set, and otherwise left mounted (still refusing
+// opens) so that Shutdown may be called again. Either way a *ShutdownError is
+// returned saying what was still open. The same goes if unmounting fails, as
+// it does with EBUSY while a process has its working directory in the file
+// system.
+//
+// While the file system remains mounted after an error, it goes on refusing
+// opens until Shutdown succeeds or CancelShutdown is called.
+//
+// After Shutdown unmounts the file system, Join may be used to wait for the
+// server to finish.
+func (mfs *MountedFileSystem) Shutdown(ctx context.Context) error {
+	err := &ShutdownError{}
+
+	select {
+	case <-mfs.conn.startDraining():
+		uerr := Unmount(mfs.dir)
+		if uerr == nil {
+			return nil
+		}
+
+		err.Err = uerr
+
+	case <-ctx.Done():
+		err.Err = ctx.Err()
+		if mfs.lazyUnmount {
+			if uerr := unmountLazily(mfs.dir); uerr != nil {
+				err.Err = uerr
+			} else {
+				err.Unmounted = true
+			}
+		}
+	}
+
+	err.OpenFiles, err.OpenDirs, err.OpsInFlight = mfs.conn.drainStatus()
+	return err
+}
+
+// CancelShutdown makes a file system that is still mounted after Shutdown
+// returned an error accept opens again. It must not be called concurrently
+// with Shutdown.
+func (mfs *MountedFileSystem) CancelShutdown() {
+	mfs.conn.stopDraining()
+}
+
+// ShutdownError is returned by MountedFileSystem.Shutdown when the file
+// system didn't drain in time or couldn't be unmounted.
+type ShutdownError struct {
+	// The inodes for which file and directory handles were still open, with an
+	// entry per handle.
+	OpenFiles []fuseops.InodeID
+	OpenDirs  []fuseops.InodeID
+
+	// The names of the ops still in flight, e.g. "ReadFile".
+	OpsInFlight []string
+
+	// Whether the file system was nevertheless unmounted lazily. See
+	// MountConfig.LazyUnmount.
+	Unmounted bool
+
+	// The context's error, or the error from unmounting.
+	Err error
+}
+
+func (e *ShutdownError) Error() string {
+	return fmt.Sprintf(
+		"shutdown: %v (%d open files, %d open directories, %d ops in flight)",
+		e.Err,
+		len(e.OpenFiles),
+		len(e.OpenDirs),
+		len(e.OpsInFlight))
+}
+
+func (e *ShutdownError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,162 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fuse
+
+import (
+	"fmt"
+	"log/slog"
+	"unsafe"
+
+	"github.com/jacobsa/fuse/fuseops"
+	"github.com/jacobsa/fuse/internal/buffer"
+	"github.com/jacobsa/fuse/internal/fusekernel"
+)
+
+// The longest name that the kernel will accept in an entry invalidation
+// (cf. FUSE_NAME_MAX in fs/fuse/fuse_i.h).
+const maxNotifyNameLen = 1024
+
+// InvalidateInode asks the kernel to drop its cached attributes for the given
+// inode, along with the page cache contents in the byte range [offset,
+// offset+length). A negative offset invalidates only the attributes, and a
+// length of zero or less extends the range to the end of the file.
+//
+// This is useful for file systems whose contents may change without the
+// kernel's knowledge (for example because they are backed by remote storage),
+// as an alternative to returning short expiration times from every op.
+//
+// If the kernel doesn't currently know about the inode, the kernel returns
+// ENOENT. That error can generally be ignored.
+//
+// If writeback caching is enabled, the kernel first writes back any dirty
+// pages in the range, and this method does not return until it has done so.
+// Therefore, as with InvalidateEntry, it must not be called from within the
+// handler for an op on the same inode, or while holding a lock that such a
+// handler may need, or the kernel and the file system may deadlock.
+//
+// LOCKS_EXCLUDED(c.mu)
+func (c *Connection) InvalidateInode(
+	inode fuseops.InodeID,
+	offset int64,
+	length int64) error {
+	if !c.protocol.HasInvalidate() {
+		return ENOSYS
+	}
+
+	r := c.readers[0]
+	m := r.getOutMessage()
+	defer r.putOutMessage(m)
+
+	out := (*fusekernel.NotifyInvalInodeOut)(m.Grow(
+		int(unsafe.Sizeof(fusekernel.NotifyInvalInodeOut{}))))
+	out.Ino = uint64(inode)
+	out.Off = offset
+	out.Len = length
+
+	return c.writeNotification(r, m, fusekernel.NotifyCodeInvalInode)
+}
+
+// InvalidateEntry asks the kernel to drop its cached dentry for the child with
+// the given name within the given parent directory, along with the cached
+// attributes of the parent. The next access to the name will cause a fresh
+// LookUpInodeOp to be sent.
+//
+// If the kernel doesn't currently have such an entry cached, the kernel
+// returns ENOENT. That error can generally be ignored.
+//
+// To avoid deadlocks, this must not be called from within the handler for an
+// op that involves the parent directory (e.g. LookUpInodeOp, MkDirOp,
+// UnlinkOp, RenameOp, or ReadDirOp), or while holding a lock that such a
+// handler may need.
+//
+// LOCKS_EXCLUDED(c.mu)
+func (c *Connection) InvalidateEntry(
+	parent fuseops.InodeID,
+	name string) error {
+	if !c.protocol.HasInvalidate() {
+		return ENOSYS
+	}
+
+	if len(name) > maxNotifyNameLen {
+		return fmt.Errorf("Name too long: %d bytes", len(name))
+	}
+
+	r := c.readers[0]
+	m := r.getOutMessage()
+	defer r.putOutMessage(m)
+
+	out := (*fusekernel.NotifyInvalEntryOut)(m.Grow(
+		int(unsafe.Sizeof(fusekernel.NotifyInvalEntryOut{}))))
+	out.Parent = uint64(parent)
+	out.Namelen = uint32(len(name))
+
+	// The kernel expects the name to be NUL-terminated.
+	m.AppendString(name)
+	m.Append([]byte{0})
+
+	return c.writeNotification(r, m, fusekernel.NotifyCodeInvalEntry)
+}
+
+// NotifyPollWakeup tells the kernel that the file polled with the given
+// handle may have become ready, causing it to wake any waiters and send a
+// fresh PollOp. See notes on fuseops.PollOp.
+//
+// Unlike the invalidation methods, this may safely be called from within an
+// op handler.
+//
+// LOCKS_EXCLUDED(c.mu)
+func (c *Connection) NotifyPollWakeup(kh fuseops.PollHandle) error {
+	if !c.protocol.HasPoll() {
+		return ENOSYS
+	}
+
+	r := c.readers[0]
+	m := r.getOutMessage()
+	defer r.putOutMessage(m)
+
+	out := (*fusekernel.NotifyPollWakeupOut)(m.Grow(
+		int(unsafe.Sizeof(fusekernel.NotifyPollWakeupOut{}))))
+	out.Kh = uint64(kh)
+
+	return c.writeNotification(r, m, fusekernel.NotifyCodePoll)
+}
+
+// Fill in the header for a notification whose body has already been written
+// into m, then send it to the kernel by way of the given reader (any of them
+// will do). Notifications are distinguished from replies by a zero unique ID,
+// and carry their notification code in the error field of the header.
+func (c *Connection) writeNotification(
+	r *reader,
+	m *buffer.OutMessage,
+	code int32) error {
+	h := m.OutHeader()
+	h.Unique = 0
+	h.Error = code
+	h.Len = uint32(m.Len())
+
+	if c.logEnabled(slog.LevelDebug) {
+		c.log(
+			slog.LevelDebug,
+			2,
+			fmt.Sprintf("-> Notify (code %d, %d bytes)", code, h.Len))
+	}
+
+	if m.Sglist != nil {
+		_, err := writev(int(r.dev.Fd()), m.Sglist)
+		return err
+	}
+
+	return r.writeMessage(m.OutHeaderBytes())
+}
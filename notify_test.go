@@ -0,0 +1,154 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fuse
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"testing"
+
+	"github.com/jacobsa/fuse/internal/fusekernel"
+)
+
+// Create a connection whose device is the write end of a pipe, returning the
+// read end so that the test can inspect what was sent to the "kernel".
+func newPipeConnection(t *testing.T) (*Connection, *os.File) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("os.Pipe: %v", err)
+	}
+
+	t.Cleanup(func() {
+		r.Close()
+		w.Close()
+	})
+
+	c := &Connection{
+		protocol: fusekernel.Protocol{
+			Major: fusekernel.ProtoVersionMaxMajor,
+			Minor: fusekernel.ProtoVersionMaxMinor,
+		},
+		readers: []*reader{{dev: w}},
+	}
+
+	return c, r
+}
+
+func readNotification(t *testing.T, r *os.File) (fusekernel.OutHeader, []byte) {
+	buf := make([]byte, 4096)
+	n, err := r.Read(buf)
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+
+	var h fusekernel.OutHeader
+	if err := binary.Read(bytes.NewReader(buf[:n]), binary.LittleEndian, &h); err != nil {
+		t.Fatalf("binary.Read: %v", err)
+	}
+
+	if got, want := int(h.Len), n; got != want {
+		t.Errorf("h.Len = %d, want %d", got, want)
+	}
+
+	if h.Unique != 0 {
+		t.Errorf("h.Unique = %d, want 0", h.Unique)
+	}
+
+	return h, buf[binary.Size(h):n]
+}
+
+func TestInvalidateInode(t *testing.T) {
+	c, r := newPipeConnection(t)
+
+	if err := c.InvalidateInode(17, 4096, 8192); err != nil {
+		t.Fatalf("InvalidateInode: %v", err)
+	}
+
+	h, body := readNotification(t, r)
+	if got, want := h.Error, fusekernel.NotifyCodeInvalInode; got != want {
+		t.Errorf("h.Error = %d, want %d", got, want)
+	}
+
+	var out fusekernel.NotifyInvalInodeOut
+	if err := binary.Read(bytes.NewReader(body), binary.LittleEndian, &out); err != nil {
+		t.Fatalf("binary.Read: %v", err)
+	}
+
+	want := fusekernel.NotifyInvalInodeOut{Ino: 17, Off: 4096, Len: 8192}
+	if out != want {
+		t.Errorf("out = %+v, want %+v", out, want)
+	}
+}
+
+func TestInvalidateEntry(t *testing.T) {
+	c, r := newPipeConnection(t)
+
+	if err := c.InvalidateEntry(23, "taco"); err != nil {
+		t.Fatalf("InvalidateEntry: %v", err)
+	}
+
+	h, body := readNotification(t, r)
+	if got, want := h.Error, fusekernel.NotifyCodeInvalEntry; got != want {
+		t.Errorf("h.Error = %d, want %d", got, want)
+	}
+
+	// parent (8 bytes), namelen (4 bytes), padding (4 bytes), "taco\x00"
+	if got, want := len(body), 16+5; got != want {
+		t.Fatalf("len(body) = %d, want %d", got, want)
+	}
+
+	if got, want := binary.LittleEndian.Uint64(body[0:8]), uint64(23); got != want {
+		t.Errorf("parent = %d, want %d", got, want)
+	}
+
+	if got, want := binary.LittleEndian.Uint32(body[8:12]), uint32(4); got != want {
+		t.Errorf("namelen = %d, want %d", got, want)
+	}
+
+	if got, want := string(body[16:]), "taco\x00"; got != want {
+		t.Errorf("name = %q, want %q", got, want)
+	}
+}
+
+func TestInvalidateEntry_NameTooLong(t *testing.T) {
+	c, _ := newPipeConnection(t)
+
+	name := string(bytes.Repeat([]byte("a"), maxNotifyNameLen+1))
+	if err := c.InvalidateEntry(23, name); err == nil {
+		t.Errorf("InvalidateEntry succeeded with a %d-byte name", len(name))
+	}
+}
+
+func TestNotifyPollWakeup(t *testing.T) {
+	c, r := newPipeConnection(t)
+
+	if err := c.NotifyPollWakeup(0x1234); err != nil {
+		t.Fatalf("NotifyPollWakeup: %v", err)
+	}
+
+	h, body := readNotification(t, r)
+	if got, want := h.Error, fusekernel.NotifyCodePoll; got != want {
+		t.Errorf("h.Error = %d, want %d", got, want)
+	}
+
+	if got, want := len(body), 8; got != want {
+		t.Fatalf("len(body) = %d, want %d", got, want)
+	}
+
+	if got, want := binary.LittleEndian.Uint64(body), uint64(0x1234); got != want {
+		t.Errorf("kh = %#x, want %#x", got, want)
+	}
+}
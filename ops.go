@@ -45,4 +45,5 @@ type initOp struct {
 	MaxBackground uint16
 	MaxWrite      uint32
 	MaxPages      uint16
+	MaxStackDepth uint32
 }
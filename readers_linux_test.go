@@ -0,0 +1,73 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fuse_test
+
+import (
+	"context"
+	"os"
+	"path"
+	"sync"
+	"testing"
+
+	"github.com/jacobsa/fuse"
+	"github.com/jacobsa/fuse/samples/hellofs"
+	"github.com/jacobsa/timeutil"
+)
+
+func TestSeveralReaders(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	server, err := hellofs.NewHelloFS(timeutil.RealClock())
+	if err != nil {
+		t.Fatalf("NewHelloFS: %v", err)
+	}
+
+	// Mount with cloned descriptors.
+	mfs, err := fuse.Mount(dir, server, &fuse.MountConfig{NumReaders: 4})
+	if err != nil {
+		t.Fatalf("fuse.Mount: %v", err)
+	}
+
+	defer func() {
+		if err := mfs.Join(ctx); err != nil {
+			t.Errorf("Joining: %v", err)
+		}
+	}()
+
+	defer fuse.Unmount(mfs.Dir())
+
+	// Read the files from many goroutines at once, so that requests arrive on
+	// several descriptors and each reply has to go back on the right one.
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < 100; j++ {
+				for _, name := range []string{"hello", "dir/world"} {
+					contents, err := os.ReadFile(path.Join(dir, name))
+					if err != nil || string(contents) != "Hello, world!" {
+						t.Errorf("ReadFile(%q): %q, %v", name, contents, err)
+						return
+					}
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+}
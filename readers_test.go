@@ -0,0 +1,204 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fuse
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jacobsa/fuse/fuseops"
+	"github.com/jacobsa/fuse/internal/fusekernel"
+)
+
+// Create a connection with the given number of readers, each reading from a
+// pipe, returning the write ends so that the test can play the kernel. The
+// readers aren't made available to ReadOp.
+func newMultiReaderConnection(t *testing.T, n int) (*Connection, []*os.File) {
+	c := &Connection{
+		protocol: fusekernel.Protocol{
+			Major: fusekernel.ProtoVersionMaxMajor,
+			Minor: fusekernel.ProtoVersionMaxMinor,
+		},
+		idleReaders: make(chan *reader, n),
+		inFlight:    make(map[uint64]*inFlightOp),
+	}
+
+	c.cfg.OpContext = context.Background()
+
+	var kernel []*os.File
+	for i := 0; i < n; i++ {
+		r, w, err := os.Pipe()
+		if err != nil {
+			t.Fatalf("os.Pipe: %v", err)
+		}
+
+		t.Cleanup(func() {
+			r.Close()
+			w.Close()
+		})
+
+		c.readers = append(c.readers, &reader{dev: r})
+		kernel = append(kernel, w)
+	}
+
+	return c, kernel
+}
+
+// Send a request with the given ID to a reader.
+func sendRequest(
+	t *testing.T,
+	w *os.File,
+	fuseID uint64,
+	opcode uint32,
+	body ...interface{}) {
+	var b bytes.Buffer
+	for _, v := range body {
+		if err := binary.Write(&b, binary.LittleEndian, v); err != nil {
+			t.Fatalf("binary.Write: %v", err)
+		}
+	}
+
+	h := fusekernel.InHeader{
+		Len:    uint32(fusekernel.InHeaderSize + b.Len()),
+		Opcode: opcode,
+		Unique: fuseID,
+		Nodeid: uint64(fuseops.RootInodeID),
+	}
+
+	var msg bytes.Buffer
+	binary.Write(&msg, binary.LittleEndian, h)
+	msg.Write(b.Bytes())
+
+	if _, err := w.Write(msg.Bytes()); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+}
+
+// Wait until the condition, checked with c.mu held, holds.
+func waitFor(t *testing.T, c *Connection, cond func() bool) {
+	t.Helper()
+
+	for deadline := time.Now().Add(10 * time.Second); ; {
+		c.mu.Lock()
+		ok := cond()
+		c.mu.Unlock()
+
+		if ok {
+			return
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out")
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+}
+
+type readOpResult struct {
+	ctx context.Context
+	op  interface{}
+	err error
+}
+
+// Call ReadOp in the background.
+func readOpAsync(c *Connection) <-chan readOpResult {
+	results := make(chan readOpResult, 1)
+	go func() {
+		ctx, op, err := c.ReadOp()
+		results <- readOpResult{ctx, op, err}
+	}()
+
+	return results
+}
+
+func TestInterruptBeforeRequest(t *testing.T) {
+	c, kernel := newMultiReaderConnection(t, 2)
+	r0, r1 := c.readers[0], c.readers[1]
+
+	// Let the first reader start reading, so that it might be about to return
+	// the request when the interrupt arrives.
+	c.idleReaders <- r0
+	request := readOpAsync(c)
+	waitFor(t, c, func() bool { return r0.reading })
+
+	// The second reader sees the interrupt first, and holds on to it.
+	c.idleReaders <- r1
+	readOpAsync(c)
+	sendRequest(t, kernel[1], 3, fusekernel.OpInterrupt, fusekernel.InterruptIn{Unique: 2})
+	waitFor(t, c, func() bool { return len(c.parkedInterrupts) == 1 })
+
+	// The request then turns up already interrupted.
+	sendRequest(t, kernel[0], 2, fusekernel.OpGetattr, fusekernel.GetattrIn{})
+
+	res := <-request
+	if res.err != nil {
+		t.Fatalf("ReadOp: %v", res.err)
+	}
+
+	if _, ok := res.op.(*fuseops.GetInodeAttributesOp); !ok {
+		t.Fatalf("Unexpected op: %#v", res.op)
+	}
+
+	if res.ctx.Err() != context.Canceled {
+		t.Errorf("ctx.Err() = %v, want context.Canceled", res.ctx.Err())
+	}
+
+	if len(c.parkedInterrupts) != 0 {
+		t.Errorf("Interrupts still parked: %v", c.parkedInterrupts)
+	}
+}
+
+func TestInterruptAfterReply(t *testing.T) {
+	c, kernel := newMultiReaderConnection(t, 2)
+	r0, r1 := c.readers[0], c.readers[1]
+
+	// An interrupt for a request that no other reader is in the middle of
+	// reading must be for one that has already been replied to, and is
+	// dropped.
+	c.handleInterrupt(r1, 2)
+	if len(c.parkedInterrupts) != 0 {
+		t.Fatalf("Interrupt parked: %v", c.parkedInterrupts)
+	}
+
+	// If another reader is reading, the interrupt waits only until it has
+	// dealt with whatever it read.
+	c.idleReaders <- r0
+	request := readOpAsync(c)
+	waitFor(t, c, func() bool { return r0.reading })
+
+	c.handleInterrupt(r1, 4)
+	if len(c.parkedInterrupts) != 1 {
+		t.Fatalf("Interrupt not parked")
+	}
+
+	sendRequest(t, kernel[0], 6, fusekernel.OpGetattr, fusekernel.GetattrIn{})
+	res := <-request
+	if res.err != nil {
+		t.Fatalf("ReadOp: %v", res.err)
+	}
+
+	if res.ctx.Err() != nil {
+		t.Errorf("Unrelated op interrupted: %v", res.ctx.Err())
+	}
+
+	if len(c.parkedInterrupts) != 0 {
+		t.Errorf("Interrupts still parked: %v", c.parkedInterrupts)
+	}
+}
@@ -0,0 +1,467 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package archivefs implements a read-only file system that serves the
+// contents of a tar or zip archive, without extracting it.
+package archivefs
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/jacobsa/fuse"
+	"github.com/jacobsa/fuse/fuseops"
+	"github.com/jacobsa/fuse/fuseutil"
+)
+
+// The contents of an archive never change, so the kernel may cache
+// everything it learns about them indefinitely.
+const cacheExpiration = 365 * 24 * time.Hour
+
+// Where to find the contents of a regular file.
+type fileContents interface {
+	// Return a reader for the contents, which is closed when the file is
+	// released if it implements io.Closer.
+	open() (io.ReaderAt, error)
+}
+
+// Contents stored uncompressed within the archive.
+type sectionContents struct {
+	r    io.ReaderAt
+	off  int64
+	size int64
+}
+
+func (c *sectionContents) open() (io.ReaderAt, error) {
+	return c, nil
+}
+
+func (c *sectionContents) ReadAt(p []byte, off int64) (int, error) {
+	if off >= c.size {
+		return 0, io.EOF
+	}
+
+	short := false
+	if remaining := c.size - off; int64(len(p)) > remaining {
+		p = p[:remaining]
+		short = true
+	}
+
+	n, err := c.r.ReadAt(p, c.off+off)
+	if err == nil && short {
+		err = io.EOF
+	}
+
+	return n, err
+}
+
+type archiveFS struct {
+	fuseutil.NotImplementedFileSystem
+
+	// The inode table, indexed by ID. It doesn't change once built.
+	inodes []*inode
+
+	// The archive file, if the file system owns it.
+	closer io.Closer
+
+	mu sync.Mutex
+
+	// Readers for open files.
+	//
+	// GUARDED_BY(mu)
+	handles    map[fuseops.HandleID]io.ReaderAt
+	nextHandle fuseops.HandleID
+}
+
+// NewArchiveServer creates a file system server that serves the contents of
+// the archive at the given path. See NewFileSystem for the supported
+// formats. The archive is closed when the file system is destroyed.
+//
+// It should be mounted with MountConfig.ReadOnly set. If
+// MountConfig.UseVectoredRead is set as well, file contents are handed to the
+// kernel without being copied where the archive allows it.
+func NewArchiveServer(
+	path string,
+	opts ...fuseutil.ServerOption) (fuse.Server, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	fs, err := NewFileSystem(f, fi.Size())
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	fs.(*archiveFS).closer = f
+	return fuseutil.NewFileSystemServer(fs, opts...), nil
+}
+
+// NewFileSystem returns a read-only file system serving the contents of the
+// archive in r, which has the given size. The archive may be a zip file, a tar
+// file, or a gzip-compressed tar file; the format is detected from its
+// contents.
+//
+// The archive is indexed up front, which for a compressed tar file means
+// decompressing it once. Afterwards, reads from files stored uncompressed go
+// straight to r. Compressed tar files made of many independently compressed
+// members, as written by bgzip and the like, can be read efficiently at any
+// offset; others are efficiently read only in order. The same goes for
+// compressed zip members.
+//
+// Members of the archive are given the permissions, owners and times that it
+// records for them, with the user running the server owning directories and
+// zip members, which don't record an owner. The PAX records of tar archives
+// written by GNU tar or bsdtar with extended attributes supply those. Hard
+// links and symlinks are supported, while sparse tar members are not. When
+// several members have the same path, the last one wins, as when extracting
+// the archive.
+func NewFileSystem(r io.ReaderAt, size int64) (fuseutil.FileSystem, error) {
+	b := newBuilder()
+
+	magic := make([]byte, 4)
+	n, _ := r.ReadAt(magic, 0)
+	magic = magic[:n]
+
+	var err error
+	switch {
+	case bytes.HasPrefix(magic, []byte("PK\x03\x04")),
+		bytes.HasPrefix(magic, []byte("PK\x05\x06")):
+		err = indexZip(b, r, size)
+
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		var gz *gzipReaderAt
+		gz, err = newGzipReaderAt(r, size)
+		if err == nil {
+			err = indexTar(b, gz, gz.size)
+		}
+
+	default:
+		err = indexTar(b, r, size)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	fs := &archiveFS{
+		inodes:     b.finish(),
+		handles:    make(map[fuseops.HandleID]io.ReaderAt),
+		nextHandle: 1,
+	}
+
+	return fs, nil
+}
+
+////////////////////////////////////////////////////////////////////////
+// Helpers
+////////////////////////////////////////////////////////////////////////
+
+func (fs *archiveFS) getInodeOrDie(id fuseops.InodeID) *inode {
+	if id == 0 || int(id) >= len(fs.inodes) {
+		panic("Unknown inode")
+	}
+
+	return fs.inodes[id]
+}
+
+// LOCKS_EXCLUDED(fs.mu)
+func (fs *archiveFS) getHandle(h fuseops.HandleID) io.ReaderAt {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	r, ok := fs.handles[h]
+	if !ok {
+		panic("Unknown handle")
+	}
+
+	return r
+}
+
+////////////////////////////////////////////////////////////////////////
+// FileSystem methods
+////////////////////////////////////////////////////////////////////////
+
+func (fs *archiveFS) StatFS(
+	ctx context.Context,
+	op *fuseops.StatFSOp) error {
+	return nil
+}
+
+func (fs *archiveFS) LookUpInode(
+	ctx context.Context,
+	op *fuseops.LookUpInodeOp) error {
+	parent := fs.getInodeOrDie(op.Parent)
+
+	id, ok := parent.children[op.Name]
+	if !ok {
+		return fuse.ENOENT
+	}
+
+	op.Entry.Child = id
+	op.Entry.Attributes = fs.inodes[id].attrs
+	op.Entry.AttributesExpiration = time.Now().Add(cacheExpiration)
+	op.Entry.EntryExpiration = op.Entry.AttributesExpiration
+
+	return nil
+}
+
+func (fs *archiveFS) GetInodeAttributes(
+	ctx context.Context,
+	op *fuseops.GetInodeAttributesOp) error {
+	op.Attributes = fs.getInodeOrDie(op.Inode).attrs
+	op.AttributesExpiration = time.Now().Add(cacheExpiration)
+
+	return nil
+}
+
+func (fs *archiveFS) ForgetInode(
+	ctx context.Context,
+	op *fuseops.ForgetInodeOp) error {
+	// The inode table is fixed, so there's nothing to do.
+	return nil
+}
+
+func (fs *archiveFS) BatchForget(
+	ctx context.Context,
+	op *fuseops.BatchForgetOp) error {
+	return nil
+}
+
+func (fs *archiveFS) OpenDir(
+	ctx context.Context,
+	op *fuseops.OpenDirOp) error {
+	if !fs.getInodeOrDie(op.Inode).attrs.Mode.IsDir() {
+		return fuse.ENOTDIR
+	}
+
+	return nil
+}
+
+func (fs *archiveFS) ReadDir(
+	ctx context.Context,
+	op *fuseops.ReadDirOp) error {
+	entries := fs.getInodeOrDie(op.Inode).entries
+
+	if op.Offset > fuseops.DirOffset(len(entries)) {
+		return fuse.EINVAL
+	}
+
+	for _, e := range entries[op.Offset:] {
+		n := fuseutil.WriteDirent(op.Dst[op.BytesRead:], e)
+		if n == 0 {
+			break
+		}
+
+		op.BytesRead += n
+	}
+
+	return nil
+}
+
+func (fs *archiveFS) ReleaseDirHandle(
+	ctx context.Context,
+	op *fuseops.ReleaseDirHandleOp) error {
+	return nil
+}
+
+func (fs *archiveFS) OpenFile(
+	ctx context.Context,
+	op *fuseops.OpenFileOp) error {
+	in := fs.getInodeOrDie(op.Inode)
+	if in.contents == nil {
+		return fuse.EINVAL
+	}
+
+	r, err := in.contents.open()
+	if err != nil {
+		return err
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	op.Handle = fs.nextHandle
+	fs.nextHandle++
+	fs.handles[op.Handle] = r
+
+	// The contents never change, so there's no need to drop cached pages
+	// when the file is opened again.
+	op.KeepPageCache = true
+
+	return nil
+}
+
+func (fs *archiveFS) ReadFile(
+	ctx context.Context,
+	op *fuseops.ReadFileOp) error {
+	r := fs.getHandle(op.Handle)
+	size := int64(fs.getInodeOrDie(op.Inode).attrs.Size)
+
+	if op.Offset >= size {
+		return nil
+	}
+
+	n := op.Size
+	if op.Dst != nil {
+		n = int64(len(op.Dst))
+	}
+
+	if remaining := size - op.Offset; n > remaining {
+		n = remaining
+	}
+
+	// Where the contents are stored uncompressed in a file, let the connection
+	// read them itself, splicing them if it can. Where they're in the members
+	// of a compressed tar file and the kernel accepts vectored reads, hand it
+	// the cached members without copying them.
+	if s, ok := r.(*sectionContents); ok {
+		switch ar := s.r.(type) {
+		case *os.File:
+			op.FileData = &fuseops.FileRange{
+				File:   ar,
+				Offset: s.off + op.Offset,
+				Size:   n,
+			}
+
+			return nil
+
+		case *gzipReaderAt:
+			if op.Dst == nil {
+				chunks, err := ar.readChunks(s.off+op.Offset, n)
+				if err != nil {
+					return err
+				}
+
+				op.Data = chunks
+				for _, c := range chunks {
+					op.BytesRead += len(c)
+				}
+
+				return nil
+			}
+		}
+	}
+
+	dst := op.Dst
+	if dst == nil {
+		dst = make([]byte, n)
+	}
+
+	var err error
+	op.BytesRead, err = r.ReadAt(dst[:n], op.Offset)
+	if op.Dst == nil {
+		op.Data = [][]byte{dst[:op.BytesRead]}
+	}
+
+	// Don't return EOF errors; we just indicate EOF to fuse using a short read.
+	if err == io.EOF {
+		return nil
+	}
+
+	return err
+}
+
+func (fs *archiveFS) ReleaseFileHandle(
+	ctx context.Context,
+	op *fuseops.ReleaseFileHandleOp) error {
+	fs.mu.Lock()
+	r := fs.handles[op.Handle]
+	delete(fs.handles, op.Handle)
+	fs.mu.Unlock()
+
+	if c, ok := r.(io.Closer); ok {
+		return c.Close()
+	}
+
+	return nil
+}
+
+func (fs *archiveFS) ReadSymlink(
+	ctx context.Context,
+	op *fuseops.ReadSymlinkOp) error {
+	in := fs.getInodeOrDie(op.Inode)
+	if in.attrs.Mode&os.ModeSymlink == 0 {
+		return fuse.EINVAL
+	}
+
+	op.Target = in.target
+	return nil
+}
+
+func (fs *archiveFS) GetXattr(
+	ctx context.Context,
+	op *fuseops.GetXattrOp) error {
+	value, ok := fs.getInodeOrDie(op.Inode).xattrs[op.Name]
+	if !ok {
+		return fuse.ENOATTR
+	}
+
+	op.BytesRead = len(value)
+	if len(op.Dst) >= len(value) {
+		copy(op.Dst, value)
+	} else if len(op.Dst) != 0 {
+		return syscall.ERANGE
+	}
+
+	return nil
+}
+
+func (fs *archiveFS) ListXattr(
+	ctx context.Context,
+	op *fuseops.ListXattrOp) error {
+	xattrs := fs.getInodeOrDie(op.Inode).xattrs
+
+	keys := make([]string, 0, len(xattrs))
+	for key := range xattrs {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	dst := op.Dst[:]
+	for _, key := range keys {
+		keyLen := len(key) + 1
+
+		if len(dst) >= keyLen {
+			copy(dst, key)
+			dst[len(key)] = 0
+			dst = dst[keyLen:]
+		} else if len(op.Dst) != 0 {
+			return syscall.ERANGE
+		}
+		op.BytesRead += keyLen
+	}
+
+	return nil
+}
+
+func (fs *archiveFS) Destroy() {
+	if fs.closer != nil {
+		fs.closer.Close()
+	}
+}
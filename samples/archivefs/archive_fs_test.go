@@ -0,0 +1,364 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package archivefs_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	iofs "io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/jacobsa/fuse/fuseops"
+	"github.com/jacobsa/fuse/fuseutil"
+	"github.com/jacobsa/fuse/samples/archivefs"
+)
+
+var mtime = time.Date(2015, 3, 7, 0, 0, 0, 0, time.UTC)
+
+// A file whose contents differ at every offset that a test reads from.
+var bigContents = strings.Repeat("0123456789abcdef", 4096)
+
+// Write a tar archive with a bit of everything.
+func makeTar(t *testing.T) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+
+	add := func(hdr *tar.Header, contents string) {
+		hdr.ModTime = mtime
+		hdr.Size = int64(len(contents))
+		hdr.Format = tar.FormatPAX
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatalf("WriteHeader(%s): %v", hdr.Name, err)
+		}
+
+		if _, err := tw.Write([]byte(contents)); err != nil {
+			t.Fatalf("Write(%s): %v", hdr.Name, err)
+		}
+	}
+
+	add(&tar.Header{Name: "dir/", Typeflag: tar.TypeDir, Mode: 0750}, "")
+	add(&tar.Header{Name: "dir/foo", Typeflag: tar.TypeReg, Mode: 0640}, "taco")
+	add(&tar.Header{
+		Name:     "dir/bar",
+		Typeflag: tar.TypeReg,
+		Mode:     0644,
+		PAXRecords: map[string]string{
+			"SCHILY.xattr.user.food": "burrito",
+		},
+	}, "enchilada")
+	add(&tar.Header{Name: "implicit/big", Typeflag: tar.TypeReg, Mode: 0644}, bigContents)
+	add(&tar.Header{Name: "hardlink", Typeflag: tar.TypeLink, Linkname: "dir/foo"}, "")
+	add(&tar.Header{Name: "symlink", Typeflag: tar.TypeSymlink, Linkname: "dir/bar", Mode: 0777}, "")
+
+	// A later member replaces an earlier one.
+	add(&tar.Header{Name: "replaced", Typeflag: tar.TypeReg, Mode: 0644}, "old")
+	add(&tar.Header{Name: "replaced", Typeflag: tar.TypeReg, Mode: 0644}, "new")
+
+	if err := tw.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	return buf.Bytes()
+}
+
+// Compress the data as a gzip file with a member for every chunk of the given
+// size.
+func gzipMembers(t *testing.T, data []byte, chunkSize int) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	for len(data) > 0 {
+		n := chunkSize
+		if n > len(data) {
+			n = len(data)
+		}
+
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(data[:n]); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+
+		if err := zw.Close(); err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+
+		data = data[n:]
+	}
+
+	return buf.Bytes()
+}
+
+func newFileSystem(t *testing.T, archive []byte) fuseutil.FileSystem {
+	t.Helper()
+
+	fs, err := archivefs.NewFileSystem(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		t.Fatalf("NewFileSystem: %v", err)
+	}
+
+	return fs
+}
+
+func lookUp(
+	t *testing.T,
+	fs fuseutil.FileSystem,
+	parent fuseops.InodeID,
+	name string) fuseops.ChildInodeEntry {
+	t.Helper()
+
+	op := &fuseops.LookUpInodeOp{Parent: parent, Name: name}
+	if err := fs.LookUpInode(context.Background(), op); err != nil {
+		t.Fatalf("LookUpInode(%q): %v", name, err)
+	}
+
+	return op.Entry
+}
+
+// Check the contents of the tar archive written by makeTar.
+func checkTar(t *testing.T, fs fuseutil.FileSystem) {
+	t.Helper()
+
+	fsys := fuseutil.NewIOFS(fs)
+	if err := fstest.TestFS(fsys, "dir/foo", "dir/bar", "implicit/big", "hardlink", "symlink", "replaced"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"dir/foo":      "taco",
+		"hardlink":     "taco",
+		"symlink":      "enchilada",
+		"implicit/big": bigContents,
+		"replaced":     "new",
+	}
+
+	for name, contents := range want {
+		got, err := iofs.ReadFile(fsys, name)
+		if err != nil || string(got) != contents {
+			t.Errorf("ReadFile(%q): %.20q, %v", name, got, err)
+		}
+	}
+
+	dir := lookUp(t, fs, fuseops.RootInodeID, "dir")
+	if dir.Attributes.Mode != os.ModeDir|0750 || dir.Attributes.Nlink != 2 {
+		t.Errorf("dir attributes: %v", dir.Attributes)
+	}
+
+	foo := lookUp(t, fs, dir.Child, "foo")
+	hardlink := lookUp(t, fs, fuseops.RootInodeID, "hardlink")
+	if hardlink.Child != foo.Child || foo.Attributes.Nlink != 2 {
+		t.Errorf("hardlink: %v, foo: %v", hardlink, foo)
+	}
+
+	if !foo.Attributes.Mtime.Equal(mtime) || foo.Attributes.Mode != 0640 {
+		t.Errorf("foo attributes: %v", foo.Attributes)
+	}
+
+	implicit := lookUp(t, fs, fuseops.RootInodeID, "implicit")
+	if implicit.Attributes.Mode != os.ModeDir|0555 {
+		t.Errorf("implicit attributes: %v", implicit.Attributes)
+	}
+
+	bar := lookUp(t, fs, dir.Child, "bar")
+	getXattr := &fuseops.GetXattrOp{
+		Inode: bar.Child,
+		Name:  "user.food",
+		Dst:   make([]byte, 16),
+	}
+
+	if err := fs.GetXattr(context.Background(), getXattr); err != nil {
+		t.Fatalf("GetXattr: %v", err)
+	}
+
+	if got := string(getXattr.Dst[:getXattr.BytesRead]); got != "burrito" {
+		t.Errorf("GetXattr: %q", got)
+	}
+
+	listXattr := &fuseops.ListXattrOp{Inode: bar.Child, Dst: make([]byte, 16)}
+	if err := fs.ListXattr(context.Background(), listXattr); err != nil {
+		t.Fatalf("ListXattr: %v", err)
+	}
+
+	if got := string(listXattr.Dst[:listXattr.BytesRead]); got != "user.food\x00" {
+		t.Errorf("ListXattr: %q", got)
+	}
+}
+
+// Read the given file with a vectored read, returning the slices of data.
+func readVectored(
+	t *testing.T,
+	fs fuseutil.FileSystem,
+	inode fuseops.InodeID,
+	offset int64,
+	size int64) [][]byte {
+	t.Helper()
+	ctx := context.Background()
+
+	openFile := &fuseops.OpenFileOp{Inode: inode}
+	if err := fs.OpenFile(ctx, openFile); err != nil {
+		t.Fatalf("OpenFile: %v", err)
+	}
+
+	defer fs.ReleaseFileHandle(ctx, &fuseops.ReleaseFileHandleOp{Handle: openFile.Handle})
+
+	op := &fuseops.ReadFileOp{
+		Inode:  inode,
+		Handle: openFile.Handle,
+		Offset: offset,
+		Size:   size,
+	}
+
+	if err := fs.ReadFile(ctx, op); err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+
+	if got := string(bytes.Join(op.Data, nil)); got != bigContents[offset:offset+size] ||
+		op.BytesRead != int(size) {
+		t.Errorf("ReadFile(%d, %d): %d bytes, %.20q", offset, size, op.BytesRead, got)
+	}
+
+	return op.Data
+}
+
+func TestTar(t *testing.T) {
+	checkTar(t, newFileSystem(t, makeTar(t)))
+}
+
+func TestTarFile(t *testing.T) {
+	// Files stored in a tar file on disk are read by the connection.
+	path := filepath.Join(t.TempDir(), "archive.tar")
+	if err := os.WriteFile(path, makeTar(t), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fs, err := archivefs.NewFileSystem(f, fi.Size())
+	if err != nil {
+		t.Fatalf("NewFileSystem: %v", err)
+	}
+
+	checkTar(t, fs)
+}
+
+func TestGzippedTar(t *testing.T) {
+	// A gzip file written in one go.
+	checkTar(t, newFileSystem(t, gzipMembers(t, makeTar(t), 1<<30)))
+}
+
+func TestIndexedGzippedTar(t *testing.T) {
+	fs := newFileSystem(t, gzipMembers(t, makeTar(t), 1000))
+	checkTar(t, fs)
+
+	// A vectored read spanning several members returns the data of each.
+	implicit := lookUp(t, fs, fuseops.RootInodeID, "implicit")
+	big := lookUp(t, fs, implicit.Child, "big")
+	if data := readVectored(t, fs, big.Child, 5000, 4096); len(data) < 4 {
+		t.Errorf("Vectored read returned %d slices", len(data))
+	}
+}
+
+func TestZip(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+
+	add := func(hdr *zip.FileHeader, contents string) {
+		hdr.Modified = mtime
+		w, err := zw.CreateHeader(hdr)
+		if err != nil {
+			t.Fatalf("CreateHeader(%s): %v", hdr.Name, err)
+		}
+
+		if _, err := w.Write([]byte(contents)); err != nil {
+			t.Fatalf("Write(%s): %v", hdr.Name, err)
+		}
+	}
+
+	stored := &zip.FileHeader{Name: "dir/stored", Method: zip.Store}
+	stored.SetMode(0640)
+	add(stored, "taco")
+	add(&zip.FileHeader{Name: "dir/deflated", Method: zip.Deflate}, bigContents)
+
+	symlink := &zip.FileHeader{Name: "symlink", Method: zip.Store}
+	symlink.SetMode(os.ModeSymlink | 0777)
+	add(symlink, "dir/stored")
+
+	if err := zw.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	fs := newFileSystem(t, buf.Bytes())
+	fsys := fuseutil.NewIOFS(fs)
+	if err := fstest.TestFS(fsys, "dir/stored", "dir/deflated", "symlink"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"dir/stored":   "taco",
+		"dir/deflated": bigContents,
+		"symlink":      "taco",
+	}
+
+	for name, contents := range want {
+		got, err := iofs.ReadFile(fsys, name)
+		if err != nil || string(got) != contents {
+			t.Errorf("ReadFile(%q): %.20q, %v", name, got, err)
+		}
+	}
+
+	dir := lookUp(t, fs, fuseops.RootInodeID, "dir")
+	if dir.Attributes.Mode != os.ModeDir|0555 {
+		t.Errorf("dir mode: %v", dir.Attributes.Mode)
+	}
+
+	if stored := lookUp(t, fs, dir.Child, "stored"); stored.Attributes.Mode != 0640 {
+		t.Errorf("stored mode: %v", stored.Attributes.Mode)
+	}
+
+	// Reads going backwards within a compressed member start again.
+	f, err := fsys.Open("dir/deflated")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	defer f.Close()
+
+	for _, off := range []int64{50000, 10, 20} {
+		p := make([]byte, 100)
+		if _, err := f.(io.ReaderAt).ReadAt(p, off); err != nil || string(p) != bigContents[off:off+100] {
+			t.Errorf("ReadAt(%d): %q, %v", off, p, err)
+		}
+	}
+}
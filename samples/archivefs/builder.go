@@ -0,0 +1,271 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package archivefs
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jacobsa/fuse/fuseops"
+	"github.com/jacobsa/fuse/fuseutil"
+)
+
+// An entry in the inode table.
+type inode struct {
+	attrs fuseops.InodeAttributes
+
+	// For directories, the children by name, and the entries to return from
+	// ReadDir in order of name. The latter are filled in by builder.finish.
+	children map[string]fuseops.InodeID
+	entries  []fuseutil.Dirent
+
+	// For symlinks, the target.
+	target string
+
+	// Extended attributes.
+	xattrs map[string][]byte
+
+	// For regular files, where to find the contents.
+	contents fileContents
+}
+
+// Builds the inode table from the members of an archive, in order.
+type builder struct {
+	// The inodes, indexed by ID. Entry zero is unused.
+	inodes []*inode
+
+	// The ID of the file at each path.
+	paths map[string]fuseops.InodeID
+
+	// The owner of directories that don't appear in the archive.
+	uid uint32
+	gid uint32
+}
+
+func newBuilder() *builder {
+	b := &builder{
+		paths: map[string]fuseops.InodeID{".": fuseops.RootInodeID},
+		uid:   uint32(os.Getuid()),
+		gid:   uint32(os.Getgid()),
+	}
+
+	root := b.implicitDir(time.Time{})
+	root.children = make(map[string]fuseops.InodeID)
+	b.inodes = []*inode{nil, root}
+
+	return b
+}
+
+// Clean the name of an archive member into a path relative to the root in
+// the style of io/fs, returning false if it lies outside the root.
+func cleanPath(name string) (string, bool) {
+	p := path.Clean(strings.TrimLeft(name, "/"))
+	if p == ".." || strings.HasPrefix(p, "../") {
+		return "", false
+	}
+
+	return p, true
+}
+
+// Return a directory for a path that appears in the archive only as the
+// parent of other members.
+func (b *builder) implicitDir(mtime time.Time) *inode {
+	return &inode{
+		attrs: fuseops.InodeAttributes{
+			Mode:  os.ModeDir | 0555,
+			Atime: mtime,
+			Mtime: mtime,
+			Ctime: mtime,
+			Uid:   b.uid,
+			Gid:   b.gid,
+		},
+	}
+}
+
+func (b *builder) newInode(in *inode) fuseops.InodeID {
+	if in.attrs.Mode.IsDir() {
+		in.children = make(map[string]fuseops.InodeID)
+	} else {
+		in.attrs.Nlink = 1
+	}
+
+	b.inodes = append(b.inodes, in)
+	return fuseops.InodeID(len(b.inodes) - 1)
+}
+
+// Return the ID of the directory with the given path, creating it and its
+// ancestors if they don't exist yet.
+func (b *builder) dir(p string, mtime time.Time) (fuseops.InodeID, error) {
+	if id, ok := b.paths[p]; ok {
+		if !b.inodes[id].attrs.Mode.IsDir() {
+			return 0, fmt.Errorf("%s: not a directory", p)
+		}
+
+		return id, nil
+	}
+
+	parent, err := b.dir(path.Dir(p), mtime)
+	if err != nil {
+		return 0, err
+	}
+
+	id := b.newInode(b.implicitDir(mtime))
+	b.link(parent, p, id)
+
+	return id, nil
+}
+
+// Give the inode with the given ID a name within the given directory.
+func (b *builder) link(parent fuseops.InodeID, p string, id fuseops.InodeID) {
+	b.inodes[parent].children[path.Base(p)] = id
+	b.paths[p] = id
+}
+
+// Remove the file at the given path, along with the paths of any descendants.
+func (b *builder) remove(p string) {
+	id := b.paths[p]
+	parent := b.paths[path.Dir(p)]
+
+	delete(b.inodes[parent].children, path.Base(p))
+	delete(b.paths, p)
+	b.inodes[id].attrs.Nlink--
+
+	if b.inodes[id].attrs.Mode.IsDir() {
+		for q := range b.paths {
+			if strings.HasPrefix(q, p+"/") {
+				delete(b.paths, q)
+			}
+		}
+	}
+}
+
+// Add a member of the archive at the given path. As when extracting an
+// archive, a later member replaces an earlier one with the same path, except
+// that a directory replacing a directory only updates its attributes.
+func (b *builder) add(p string, in *inode) error {
+	// An entry for the root only supplies its attributes.
+	if p == "." {
+		if in.attrs.Mode.IsDir() {
+			root := b.inodes[fuseops.RootInodeID]
+			root.attrs = in.attrs
+			root.xattrs = in.xattrs
+		}
+
+		return nil
+	}
+
+	parent, err := b.dir(path.Dir(p), in.attrs.Mtime)
+	if err != nil {
+		return err
+	}
+
+	if id, ok := b.paths[p]; ok {
+		existing := b.inodes[id]
+		if existing.attrs.Mode.IsDir() && in.attrs.Mode.IsDir() {
+			existing.attrs = in.attrs
+			existing.xattrs = in.xattrs
+			return nil
+		}
+
+		b.remove(p)
+	}
+
+	b.link(parent, p, b.newInode(in))
+	return nil
+}
+
+// Add a hard link at the given path to the file at the target path, which
+// must already have been added.
+func (b *builder) addLink(p string, target string) error {
+	id, ok := b.paths[target]
+	if !ok || b.inodes[id].attrs.Mode.IsDir() {
+		return fmt.Errorf("%s: bad link target %q", p, target)
+	}
+
+	in := b.inodes[id]
+	parent, err := b.dir(path.Dir(p), in.attrs.Mtime)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := b.paths[p]; ok {
+		b.remove(p)
+	}
+
+	b.link(parent, p, id)
+	in.attrs.Nlink++
+
+	return nil
+}
+
+// Fill in directory entries and link counts, and return the finished inode
+// table.
+func (b *builder) finish() []*inode {
+	for _, in := range b.inodes[fuseops.RootInodeID:] {
+		if !in.attrs.Mode.IsDir() {
+			continue
+		}
+
+		names := make([]string, 0, len(in.children))
+		for name := range in.children {
+			names = append(names, name)
+		}
+
+		sort.Strings(names)
+
+		in.attrs.Nlink = 2
+		in.entries = make([]fuseutil.Dirent, len(names))
+		for i, name := range names {
+			id := in.children[name]
+			child := b.inodes[id]
+
+			if child.attrs.Mode.IsDir() {
+				in.attrs.Nlink++
+			}
+
+			in.entries[i] = fuseutil.Dirent{
+				Offset: fuseops.DirOffset(i + 1),
+				Inode:  id,
+				Name:   name,
+				Type:   direntType(child.attrs.Mode),
+			}
+		}
+	}
+
+	return b.inodes
+}
+
+func direntType(mode os.FileMode) fuseutil.DirentType {
+	switch mode & os.ModeType {
+	case 0:
+		return fuseutil.DT_File
+	case os.ModeDir:
+		return fuseutil.DT_Directory
+	case os.ModeSymlink:
+		return fuseutil.DT_Link
+	case os.ModeNamedPipe:
+		return fuseutil.DT_FIFO
+	case os.ModeDevice:
+		return fuseutil.DT_Block
+	case os.ModeDevice | os.ModeCharDevice:
+		return fuseutil.DT_Char
+	}
+
+	return fuseutil.DT_Unknown
+}
@@ -0,0 +1,298 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package archivefs
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"sort"
+	"sync"
+)
+
+// Members no larger than this are decompressed whole and cached, and at most
+// this many of them are cached at once.
+const (
+	maxCachedMemberSize = 4 << 20
+	maxCachedMembers    = 16
+)
+
+// A gzip member: a part of a gzip file that can be decompressed on its own.
+type gzipMember struct {
+	// The offset of the member within the gzip file.
+	compressedOff int64
+
+	// The offset and size of the member's data within the decompressed
+	// stream.
+	off  int64
+	size int64
+}
+
+type cachedMember struct {
+	index int
+	data  []byte
+}
+
+// A decompressor positioned within a member.
+type gzipCursor struct {
+	index int
+	z     *gzip.Reader
+
+	// The offset within the decompressed stream of the next byte z returns.
+	off int64
+}
+
+// An io.ReaderAt for the decompressed contents of a gzip file, which uses an
+// index of the file's members to avoid decompressing it from the start for
+// each read.
+//
+// A gzip file written in one go has a single member, and then reads are
+// efficient only when sequential. Seekable formats made of many small
+// members, such as those written by bgzip or for eStargz, can be read
+// efficiently in any order.
+type gzipReaderAt struct {
+	r              io.ReaderAt
+	compressedSize int64
+
+	// The members of the file, in order, and the total decompressed size.
+	members []gzipMember
+	size    int64
+
+	// Decompressing holds mu, so only one read makes progress at a time.
+	mu sync.Mutex
+
+	// Recently read members that are small enough to cache, least recently used
+	// first.
+	//
+	// GUARDED_BY(mu)
+	cache []cachedMember
+
+	// The decompressor used for the last read of a member too large to cache,
+	// if any.
+	//
+	// GUARDED_BY(mu)
+	cursor *gzipCursor
+}
+
+// A reader that counts the bytes read from it. It implements io.ByteReader so
+// that the gzip package reads no more than it needs.
+type countingReader struct {
+	r *bufio.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+func (cr *countingReader) ReadByte() (byte, error) {
+	b, err := cr.r.ReadByte()
+	if err == nil {
+		cr.n++
+	}
+
+	return b, err
+}
+
+// Index the members of the gzip file in r, which has the given size. This
+// decompresses the whole file once.
+func newGzipReaderAt(r io.ReaderAt, size int64) (*gzipReaderAt, error) {
+	g := &gzipReaderAt{
+		r:              r,
+		compressedSize: size,
+	}
+
+	cr := &countingReader{r: bufio.NewReader(io.NewSectionReader(r, 0, size))}
+	z, err := gzip.NewReader(cr)
+	if err != nil {
+		return nil, err
+	}
+
+	var compressedOff int64
+	for {
+		z.Multistream(false)
+		n, err := io.Copy(io.Discard, z)
+		if err != nil {
+			return nil, err
+		}
+
+		g.members = append(g.members, gzipMember{
+			compressedOff: compressedOff,
+			off:           g.size,
+			size:          n,
+		})
+
+		g.size += n
+
+		// The next member, if any, starts where this one's trailer ended.
+		compressedOff = cr.n
+		if err := z.Reset(cr); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	return g, nil
+}
+
+// Return a decompressor for the member with the given index.
+func (g *gzipReaderAt) openMember(i int) (*gzip.Reader, error) {
+	off := g.members[i].compressedOff
+	z, err := gzip.NewReader(io.NewSectionReader(g.r, off, g.compressedSize-off))
+	if err != nil {
+		return nil, err
+	}
+
+	z.Multistream(false)
+	return z, nil
+}
+
+// Return the decompressed data of the member with the given index, which is
+// small enough to cache.
+//
+// EXCLUSIVE_LOCKS_REQUIRED(g.mu)
+func (g *gzipReaderAt) cachedMember(i int) ([]byte, error) {
+	for j, c := range g.cache {
+		if c.index == i {
+			g.cache = append(append(g.cache[:j:j], g.cache[j+1:]...), c)
+			return c.data, nil
+		}
+	}
+
+	z, err := g.openMember(i)
+	if err != nil {
+		return nil, err
+	}
+
+	defer z.Close()
+
+	data := make([]byte, g.members[i].size)
+	if _, err := io.ReadFull(z, data); err != nil {
+		return nil, err
+	}
+
+	if len(g.cache) == maxCachedMembers {
+		g.cache = g.cache[1:]
+	}
+
+	g.cache = append(g.cache, cachedMember{index: i, data: data})
+	return data, nil
+}
+
+// Fill p with the data at the given offset, which lies within the member with
+// the given index, using the cursor.
+//
+// EXCLUSIVE_LOCKS_REQUIRED(g.mu)
+func (g *gzipReaderAt) readFromCursor(i int, p []byte, off int64) error {
+	c := g.cursor
+	if c == nil || c.index != i || c.off > off {
+		z, err := g.openMember(i)
+		if err != nil {
+			return err
+		}
+
+		if c != nil {
+			c.z.Close()
+		}
+
+		c = &gzipCursor{index: i, z: z, off: g.members[i].off}
+		g.cursor = c
+	}
+
+	// Skip forward to the offset, and read.
+	if _, err := io.CopyN(io.Discard, c.z, off-c.off); err != nil {
+		g.cursor = nil
+		return err
+	}
+
+	c.off = off
+
+	n, err := io.ReadFull(c.z, p)
+	c.off += int64(n)
+	if err != nil {
+		g.cursor = nil
+		return err
+	}
+
+	return nil
+}
+
+// Return up to n bytes of data from the given offset, as slices which are
+// either shared with the cache or freshly allocated. The caller must not
+// modify them.
+func (g *gzipReaderAt) readChunks(off int64, n int64) ([][]byte, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	end := off + n
+	if end > g.size {
+		end = g.size
+	}
+
+	var chunks [][]byte
+	for off < end {
+		i := sort.Search(len(g.members), func(i int) bool {
+			m := g.members[i]
+			return m.off+m.size > off
+		})
+
+		m := g.members[i]
+		size := end - off
+		if m.off+m.size < end {
+			size = m.off + m.size - off
+		}
+
+		if m.size <= maxCachedMemberSize {
+			data, err := g.cachedMember(i)
+			if err != nil {
+				return nil, err
+			}
+
+			chunks = append(chunks, data[off-m.off:off-m.off+size])
+		} else {
+			chunk := make([]byte, size)
+			if err := g.readFromCursor(i, chunk, off); err != nil {
+				return nil, err
+			}
+
+			chunks = append(chunks, chunk)
+		}
+
+		off += size
+	}
+
+	return chunks, nil
+}
+
+func (g *gzipReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	chunks, err := g.readChunks(off, int64(len(p)))
+	if err != nil {
+		return 0, err
+	}
+
+	var n int
+	for _, c := range chunks {
+		n += copy(p[n:], c)
+	}
+
+	if n < len(p) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
@@ -0,0 +1,158 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package archivefs
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/jacobsa/fuse/fuseops"
+)
+
+// The prefix of PAX records holding extended attributes, as written by GNU
+// tar and bsdtar.
+const paxXattrPrefix = "SCHILY.xattr."
+
+// Add the members of the tar archive in r, which has the given size, to the
+// inode table. The contents of regular files are read from r in place.
+func indexTar(b *builder, r io.ReaderAt, size int64) error {
+	// The tar reader seeks past the contents of each member, so only the
+	// headers are read.
+	sr := io.NewSectionReader(r, 0, size)
+	tr := tar.NewReader(sr)
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		p, ok := cleanPath(hdr.Name)
+		if !ok {
+			return fmt.Errorf("%s: path outside the archive root", hdr.Name)
+		}
+
+		if isSparse(hdr) {
+			return fmt.Errorf("%s: sparse files are not supported", hdr.Name)
+		}
+
+		in := &inode{
+			attrs:  tarAttributes(hdr),
+			xattrs: tarXattrs(hdr),
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeLink:
+			target, ok := cleanPath(hdr.Linkname)
+			if !ok {
+				return fmt.Errorf("%s: link target outside the archive root", hdr.Name)
+			}
+
+			if err := b.addLink(p, target); err != nil {
+				return err
+			}
+
+			continue
+
+		case tar.TypeReg:
+			off, err := sr.Seek(0, io.SeekCurrent)
+			if err != nil {
+				return err
+			}
+
+			in.contents = &sectionContents{r: r, off: off, size: hdr.Size}
+
+		case tar.TypeSymlink:
+			in.target = hdr.Linkname
+
+		case tar.TypeDir, tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+
+		default:
+			// Skip anything else, such as the vendor-specific types.
+			continue
+		}
+
+		if err := b.add(p, in); err != nil {
+			return err
+		}
+	}
+}
+
+// Does the header describe a sparse file, in either the old GNU format or
+// one of the PAX formats?
+func isSparse(hdr *tar.Header) bool {
+	if hdr.Typeflag == tar.TypeGNUSparse {
+		return true
+	}
+
+	for k := range hdr.PAXRecords {
+		if strings.HasPrefix(k, "GNU.sparse.") {
+			return true
+		}
+	}
+
+	return false
+}
+
+func tarAttributes(hdr *tar.Header) fuseops.InodeAttributes {
+	attrs := fuseops.InodeAttributes{
+		Mode:  hdr.FileInfo().Mode(),
+		Atime: hdr.AccessTime,
+		Mtime: hdr.ModTime,
+		Ctime: hdr.ChangeTime,
+		Uid:   uint32(hdr.Uid),
+		Gid:   uint32(hdr.Gid),
+	}
+
+	switch hdr.Typeflag {
+	case tar.TypeReg:
+		attrs.Size = uint64(hdr.Size)
+
+	case tar.TypeSymlink:
+		attrs.Size = uint64(len(hdr.Linkname))
+	}
+
+	// Only some formats record the access and change times.
+	if attrs.Atime.IsZero() {
+		attrs.Atime = attrs.Mtime
+	}
+
+	if attrs.Ctime.IsZero() {
+		attrs.Ctime = attrs.Mtime
+	}
+
+	return attrs
+}
+
+func tarXattrs(hdr *tar.Header) map[string][]byte {
+	var xattrs map[string][]byte
+	for k, v := range hdr.PAXRecords {
+		if name := strings.TrimPrefix(k, paxXattrPrefix); name != k {
+			if xattrs == nil {
+				xattrs = make(map[string][]byte)
+			}
+
+			xattrs[name] = []byte(v)
+		}
+	}
+
+	return xattrs
+}
@@ -0,0 +1,211 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package archivefs
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/jacobsa/fuse/fuseops"
+)
+
+// The longest symlink target read from a zip archive.
+const maxZipSymlinkSize = 4096
+
+// Add the members of the zip archive in r, which has the given size, to the
+// inode table. The contents of stored members are read from r in place;
+// compressed members are decompressed as they are read.
+func indexZip(b *builder, r io.ReaderAt, size int64) error {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range zr.File {
+		p, ok := cleanPath(f.Name)
+		if !ok {
+			return fmt.Errorf("%s: path outside the archive root", f.Name)
+		}
+
+		in := &inode{
+			attrs: zipAttributes(f, b),
+		}
+
+		switch mode := in.attrs.Mode; {
+		case mode.IsRegular():
+			contents, err := zipFileContents(r, f)
+			if err != nil {
+				return err
+			}
+
+			in.contents = contents
+
+		case mode&os.ModeSymlink != 0:
+			target, err := readZipSymlink(f)
+			if err != nil {
+				return err
+			}
+
+			in.target = target
+			in.attrs.Size = uint64(len(target))
+
+		case mode.IsDir():
+
+		default:
+			continue
+		}
+
+		if err := b.add(p, in); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func zipAttributes(f *zip.File, b *builder) fuseops.InodeAttributes {
+	mode := f.Mode()
+
+	// Archives written on systems without Unix permissions don't record them.
+	if mode.Perm() == 0 {
+		if mode.IsDir() {
+			mode |= 0555
+		} else {
+			mode |= 0444
+		}
+	}
+
+	mtime := f.Modified
+	return fuseops.InodeAttributes{
+		Size:  f.UncompressedSize64,
+		Mode:  mode,
+		Atime: mtime,
+		Mtime: mtime,
+		Ctime: mtime,
+		Uid:   b.uid,
+		Gid:   b.gid,
+	}
+}
+
+func zipFileContents(r io.ReaderAt, f *zip.File) (fileContents, error) {
+	if f.Method == zip.Store {
+		off, err := f.DataOffset()
+		if err != nil {
+			return nil, err
+		}
+
+		return &sectionContents{
+			r:    r,
+			off:  off,
+			size: int64(f.UncompressedSize64),
+		}, nil
+	}
+
+	return &zipContents{f: f}, nil
+}
+
+func readZipSymlink(f *zip.File) (string, error) {
+	if f.UncompressedSize64 > maxZipSymlinkSize {
+		return "", fmt.Errorf("%s: symlink target too long", f.Name)
+	}
+
+	rc, err := f.Open()
+	if err != nil {
+		return "", err
+	}
+
+	defer rc.Close()
+
+	target, err := io.ReadAll(rc)
+	if err != nil {
+		return "", err
+	}
+
+	return string(target), nil
+}
+
+// The contents of a compressed zip member.
+type zipContents struct {
+	f *zip.File
+}
+
+func (c *zipContents) open() (io.ReaderAt, error) {
+	rc, err := c.f.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	return &zipReader{f: c.f, rc: rc}, nil
+}
+
+// An io.ReaderAt for a compressed zip member, which decompresses it
+// sequentially and starts again from the beginning when a read goes
+// backwards.
+type zipReader struct {
+	f *zip.File
+
+	mu sync.Mutex
+
+	// The decompressor, and the offset of the next byte it returns.
+	//
+	// GUARDED_BY(mu)
+	rc     io.ReadCloser
+	offset int64
+}
+
+func (z *zipReader) ReadAt(p []byte, off int64) (int, error) {
+	if off >= int64(z.f.UncompressedSize64) {
+		return 0, io.EOF
+	}
+
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	if off < z.offset {
+		rc, err := z.f.Open()
+		if err != nil {
+			return 0, err
+		}
+
+		z.rc.Close()
+		z.rc = rc
+		z.offset = 0
+	}
+
+	skipped, err := io.CopyN(io.Discard, z.rc, off-z.offset)
+	z.offset += skipped
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := io.ReadFull(z.rc, p)
+	z.offset += int64(n)
+
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+
+	return n, err
+}
+
+func (z *zipReader) Close() error {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	return z.rc.Close()
+}
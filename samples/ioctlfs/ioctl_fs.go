@@ -0,0 +1,168 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ioctlfs
+
+import (
+	"context"
+	"os"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"github.com/jacobsa/fuse"
+	"github.com/jacobsa/fuse/fuseops"
+	"github.com/jacobsa/fuse/fuseutil"
+)
+
+// Ioctl commands understood by the counter file, encoded as by the _IOR, _IOW
+// and _IO macros of linux/ioctl.h.
+const (
+	// _IOR('C', 1, uint64): copy the current value of the counter out to the
+	// caller.
+	GetCounter = 2<<30 | 8<<16 | 'C'<<8 | 1
+
+	// _IOW('C', 2, uint64): add the caller's value to the counter.
+	AddToCounter = 1<<30 | 8<<16 | 'C'<<8 | 2
+
+	// _IO('C', 3): set the counter to the integer argument, returning one from
+	// ioctl(2) if the counter was previously non-zero and zero otherwise.
+	ResetCounter = 'C'<<8 | 3
+)
+
+// Create a file system containing a single device-like file named "counter",
+// which holds a 64-bit counter that can be manipulated only with the ioctls
+// defined above.
+//
+// Linux only: OS X doesn't send ioctls to FUSE file systems.
+func NewIoctlFS() fuse.Server {
+	return fuseutil.NewFileSystemServer(&ioctlFS{})
+}
+
+const counterInode = fuseops.RootInodeID + 1
+
+type ioctlFS struct {
+	fuseutil.NotImplementedFileSystem
+
+	mu      sync.Mutex
+	counter uint64 // GUARDED_BY(mu)
+}
+
+////////////////////////////////////////////////////////////////////////
+// Helpers
+////////////////////////////////////////////////////////////////////////
+
+func attributes(inode fuseops.InodeID) fuseops.InodeAttributes {
+	if inode == fuseops.RootInodeID {
+		return fuseops.InodeAttributes{
+			Nlink: 1,
+			Mode:  os.ModeDir | 0555,
+		}
+	}
+
+	return fuseops.InodeAttributes{
+		Nlink: 1,
+		Mode:  0600,
+	}
+}
+
+// Ioctl data is in host byte order.
+func encode(v uint64) []byte {
+	b := make([]byte, 8)
+	copy(b, (*[8]byte)(unsafe.Pointer(&v))[:])
+	return b
+}
+
+func decode(b []byte) (v uint64) {
+	copy((*[8]byte)(unsafe.Pointer(&v))[:], b)
+	return v
+}
+
+////////////////////////////////////////////////////////////////////////
+// FileSystem methods
+////////////////////////////////////////////////////////////////////////
+
+func (fs *ioctlFS) StatFS(
+	ctx context.Context,
+	op *fuseops.StatFSOp) error {
+	return nil
+}
+
+func (fs *ioctlFS) LookUpInode(
+	ctx context.Context,
+	op *fuseops.LookUpInodeOp) error {
+	if op.Parent != fuseops.RootInodeID || op.Name != "counter" {
+		return fuse.ENOENT
+	}
+
+	op.Entry.Child = counterInode
+	op.Entry.Attributes = attributes(counterInode)
+
+	return nil
+}
+
+func (fs *ioctlFS) GetInodeAttributes(
+	ctx context.Context,
+	op *fuseops.GetInodeAttributesOp) error {
+	switch op.Inode {
+	case fuseops.RootInodeID, counterInode:
+		op.Attributes = attributes(op.Inode)
+		return nil
+
+	default:
+		return fuse.ENOENT
+	}
+}
+
+func (fs *ioctlFS) OpenFile(
+	ctx context.Context,
+	op *fuseops.OpenFileOp) error {
+	return nil
+}
+
+// LOCKS_EXCLUDED(fs.mu)
+func (fs *ioctlFS) Ioctl(
+	ctx context.Context,
+	op *fuseops.IoctlOp) error {
+	if op.Inode != counterInode {
+		return syscall.ENOTTY
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	switch op.Cmd {
+	case GetCounter:
+		op.OutData = encode(fs.counter)
+
+	case AddToCounter:
+		if len(op.InData) != 8 {
+			return fuse.EINVAL
+		}
+
+		fs.counter += decode(op.InData)
+
+	case ResetCounter:
+		if fs.counter != 0 {
+			op.Result = 1
+		}
+
+		fs.counter = op.Arg
+
+	default:
+		return syscall.ENOTTY
+	}
+
+	return nil
+}
@@ -0,0 +1,113 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ioctlfs_test
+
+import (
+	"os"
+	"path"
+	"syscall"
+	"testing"
+	"unsafe"
+
+	"github.com/jacobsa/fuse/samples"
+	"github.com/jacobsa/fuse/samples/ioctlfs"
+	. "github.com/jacobsa/ogletest"
+)
+
+func TestIoctlFS(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+type IoctlFSTest struct {
+	samples.SampleTest
+
+	f *os.File
+}
+
+func init() { RegisterTestSuite(&IoctlFSTest{}) }
+
+func (t *IoctlFSTest) SetUp(ti *TestInfo) {
+	var err error
+
+	t.Server = ioctlfs.NewIoctlFS()
+	t.SampleTest.SetUp(ti)
+
+	t.f, err = os.Open(path.Join(t.Dir, "counter"))
+	AssertEq(nil, err)
+	t.ToClose = append(t.ToClose, t.f)
+}
+
+func (t *IoctlFSTest) ioctl(cmd uintptr, arg uintptr) (uintptr, error) {
+	r, _, errno := syscall.Syscall(syscall.SYS_IOCTL, t.f.Fd(), cmd, arg)
+	if errno != 0 {
+		return r, errno
+	}
+
+	return r, nil
+}
+
+func (t *IoctlFSTest) get() uint64 {
+	var v uint64
+	_, err := t.ioctl(ioctlfs.GetCounter, uintptr(unsafe.Pointer(&v)))
+	AssertEq(nil, err)
+
+	return v
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *IoctlFSTest) InitiallyZero() {
+	ExpectEq(0, t.get())
+}
+
+func (t *IoctlFSTest) Add() {
+	var err error
+
+	v := uint64(17)
+	_, err = t.ioctl(ioctlfs.AddToCounter, uintptr(unsafe.Pointer(&v)))
+	AssertEq(nil, err)
+
+	v = 25
+	_, err = t.ioctl(ioctlfs.AddToCounter, uintptr(unsafe.Pointer(&v)))
+	AssertEq(nil, err)
+
+	ExpectEq(42, t.get())
+}
+
+func (t *IoctlFSTest) Reset() {
+	var r uintptr
+	var err error
+
+	// The counter starts at zero, so the first reset reports that.
+	r, err = t.ioctl(ioctlfs.ResetCounter, 100)
+	AssertEq(nil, err)
+	ExpectEq(0, r)
+	ExpectEq(100, t.get())
+
+	r, err = t.ioctl(ioctlfs.ResetCounter, 0)
+	AssertEq(nil, err)
+	ExpectEq(1, r)
+	ExpectEq(0, t.get())
+}
+
+func (t *IoctlFSTest) UnknownCommand() {
+	var v uint64
+	_, err := t.ioctl(2<<30|8<<16|'C'<<8|17, uintptr(unsafe.Pointer(&v)))
+	ExpectEq(syscall.ENOTTY, err)
+}
@@ -0,0 +1,191 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package loopbackfs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/jacobsa/fuse/fuseops"
+	"golang.org/x/sys/unix"
+)
+
+// Identifies a file in the backing file system.
+type devIno struct {
+	dev uint64
+	ino uint64
+}
+
+// An inode that the kernel knows about, referring to a file in the backing
+// file system by way of an O_PATH descriptor. Operations on the inode are
+// performed relative to the descriptor, so that they keep working when the
+// file is renamed, and can't be redirected by someone replacing a parent
+// directory with a symlink.
+type inode struct {
+	id  fuseops.InodeID
+	key devIno
+
+	// An O_PATH descriptor for the file.
+	fd int
+
+	// The file type bits of the file's mode (S_IFMT).
+	fileType uint32
+
+	// The number of lookups the kernel holds.
+	//
+	// GUARDED_BY(loopbackFS.mu)
+	lookupCount uint64
+}
+
+func (in *inode) isSymlink() bool {
+	return in.fileType == unix.S_IFLNK
+}
+
+// Return a path through which the file can be opened or modified by calls
+// that don't accept a descriptor. It refers to the file itself, even when it
+// is a symlink.
+func (in *inode) procPath() string {
+	return fmt.Sprintf("/proc/self/fd/%d", in.fd)
+}
+
+// Stat the file without following symlinks.
+func (in *inode) stat() (unix.Stat_t, error) {
+	var st unix.Stat_t
+	err := unix.Fstatat(in.fd, "", &st, unix.AT_EMPTY_PATH|unix.AT_SYMLINK_NOFOLLOW)
+	return st, err
+}
+
+// Convert the result of stat(2) to the attributes the kernel expects.
+func attributesFromStat(st *unix.Stat_t) fuseops.InodeAttributes {
+	return fuseops.InodeAttributes{
+		Size:   uint64(st.Size),
+		Nlink:  uint32(st.Nlink),
+		Mode:   fileMode(st.Mode),
+		Atime:  time.Unix(st.Atim.Unix()),
+		Mtime:  time.Unix(st.Mtim.Unix()),
+		Ctime:  time.Unix(st.Ctim.Unix()),
+		Crtime: time.Unix(st.Ctim.Unix()),
+		Uid:    st.Uid,
+		Gid:    st.Gid,
+	}
+}
+
+// Convert a mode as returned by stat(2) to an os.FileMode.
+func fileMode(mode uint32) os.FileMode {
+	m := os.FileMode(mode & 0777)
+	switch mode & unix.S_IFMT {
+	case unix.S_IFDIR:
+		m |= os.ModeDir
+	case unix.S_IFLNK:
+		m |= os.ModeSymlink
+	case unix.S_IFCHR:
+		m |= os.ModeDevice | os.ModeCharDevice
+	case unix.S_IFBLK:
+		m |= os.ModeDevice
+	case unix.S_IFIFO:
+		m |= os.ModeNamedPipe
+	case unix.S_IFSOCK:
+		m |= os.ModeSocket
+	}
+
+	if mode&unix.S_ISUID != 0 {
+		m |= os.ModeSetuid
+	}
+
+	if mode&unix.S_ISGID != 0 {
+		m |= os.ModeSetgid
+	}
+
+	if mode&unix.S_ISVTX != 0 {
+		m |= os.ModeSticky
+	}
+
+	return m
+}
+
+// Convert the permission bits of an os.FileMode to a mode for chmod(2) and
+// friends.
+func permBits(m os.FileMode) uint32 {
+	mode := uint32(m.Perm())
+	if m&os.ModeSetuid != 0 {
+		mode |= unix.S_ISUID
+	}
+
+	if m&os.ModeSetgid != 0 {
+		mode |= unix.S_ISGID
+	}
+
+	if m&os.ModeSticky != 0 {
+		mode |= unix.S_ISVTX
+	}
+
+	return mode
+}
+
+// Clear the file's setuid bit, and its setgid bit if it is group-executable,
+// as the kernel does when a file is modified by a user lacking CAP_FSETID.
+// Running as root, the server has that capability, so the backing file
+// system won't do so itself.
+func (in *inode) killSuidgid() error {
+	st, err := in.stat()
+	if err != nil {
+		return err
+	}
+
+	mode := st.Mode &^ unix.S_IFMT
+	if mode&unix.S_IXGRP != 0 {
+		mode &^= unix.S_ISGID
+	}
+
+	mode &^= unix.S_ISUID
+	if mode == st.Mode&^unix.S_IFMT {
+		return nil
+	}
+
+	return unix.Fchmodat(unix.AT_FDCWD, in.procPath(), mode, 0)
+}
+
+// Convert an os.FileMode to a mode for mknod(2), including the file type.
+func nodeMode(m os.FileMode) uint32 {
+	mode := permBits(m)
+	switch {
+	case m&os.ModeNamedPipe != 0:
+		mode |= unix.S_IFIFO
+	case m&os.ModeSocket != 0:
+		mode |= unix.S_IFSOCK
+	case m&os.ModeCharDevice != 0:
+		mode |= unix.S_IFCHR
+	case m&os.ModeDevice != 0:
+		mode |= unix.S_IFBLK
+	default:
+		mode |= unix.S_IFREG
+	}
+
+	return mode
+}
+
+// Return the errno underlying err, so that the kernel sees it rather than
+// EIO. Errors without one are returned unchanged.
+func errno(err error) error {
+	var e syscall.Errno
+	if errors.As(err, &e) {
+		return e
+	}
+
+	return err
+}
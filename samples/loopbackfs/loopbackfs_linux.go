@@ -0,0 +1,927 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package loopbackfs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"github.com/jacobsa/fuse"
+	"github.com/jacobsa/fuse/fuseops"
+	"github.com/jacobsa/fuse/fuseutil"
+	"golang.org/x/sys/unix"
+)
+
+// Inode IDs from here on are handed out to files whose own inode numbers
+// can't be used, because they clash with the root's ID or with a file on
+// another device.
+const spareInodeIDs = fuseops.InodeID(1 << 63)
+
+type loopbackFS struct {
+	fuseutil.NotImplementedFileSystem
+
+	// The directory being mirrored.
+	root string
+
+	mu sync.Mutex
+
+	// The inodes that the kernel knows about, by ID and by the identity of
+	// the backing file. Where possible the ID is the backing file's inode
+	// number, so that it agrees with the inode numbers reported by ReadDir.
+	//
+	// INVARIANT: For each k/v in inodes, v.id == k and byKey[v.key] == v
+	//
+	// GUARDED_BY(mu)
+	inodes      map[fuseops.InodeID]*inode
+	byKey       map[devIno]*inode
+	nextSpareID fuseops.InodeID
+
+	// Open file and directory handles.
+	//
+	// GUARDED_BY(mu)
+	handles    map[fuseops.HandleID]*handle
+	nextHandle fuseops.HandleID
+}
+
+// An open file or directory.
+type handle struct {
+	file *os.File
+
+	mu sync.Mutex
+
+	// For directories, the entries read by the last ReadDir at offset zero.
+	//
+	// GUARDED_BY(mu)
+	entries []fuseutil.Dirent
+}
+
+// NewLoopbackServer creates a file system server that mirrors the directory
+// at the given path, passing reads and writes through to it. Files created
+// in the mirror are created in the directory; when running as root they are
+// owned by the creating user, and otherwise by the user running the server.
+//
+// A mirrored directory tree spanning several file systems works, but inode
+// numbers reported by ReadDir may then be inaccurate.
+//
+// The server honours KillSuidgid, so that files modified through the mirror by
+// users other than root lose their setuid and setgid bits even when the server
+// runs as root. It may be mounted with MountConfig.EnableHandleKillprivV2.
+//
+// With MountConfig.EnableSpliceWrites, written data is spliced from the pipe
+// it arrives in straight into the backing file.
+func NewLoopbackServer(root string) (fuse.Server, error) {
+	fs, err := newLoopbackFS(root)
+	if err != nil {
+		return nil, err
+	}
+
+	return fuseutil.NewFileSystemServer(fs, fuseutil.WithSplicedWrites()), nil
+}
+
+func newLoopbackFS(root string) (*loopbackFS, error) {
+	fd, err := unix.Open(root, unix.O_PATH|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: root, Err: err}
+	}
+
+	rootInode := &inode{
+		id:       fuseops.RootInodeID,
+		fd:       fd,
+		fileType: unix.S_IFDIR,
+	}
+
+	st, err := rootInode.stat()
+	if err != nil {
+		unix.Close(fd)
+		return nil, &os.PathError{Op: "stat", Path: root, Err: err}
+	}
+
+	rootInode.key = devIno{dev: st.Dev, ino: st.Ino}
+
+	fs := &loopbackFS{
+		root: root,
+		inodes: map[fuseops.InodeID]*inode{
+			fuseops.RootInodeID: rootInode,
+		},
+		byKey: map[devIno]*inode{
+			rootInode.key: rootInode,
+		},
+		nextSpareID: spareInodeIDs,
+		handles:     make(map[fuseops.HandleID]*handle),
+		nextHandle:  1,
+	}
+
+	return fs, nil
+}
+
+////////////////////////////////////////////////////////////////////////
+// Helpers
+////////////////////////////////////////////////////////////////////////
+
+// Find the inode with the given ID, which the kernel must know about.
+//
+// LOCKS_EXCLUDED(fs.mu)
+func (fs *loopbackFS) getInode(id fuseops.InodeID) *inode {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	in := fs.inodes[id]
+	if in == nil {
+		panic(fmt.Sprintf("Unknown inode: %v", id))
+	}
+
+	return in
+}
+
+// Find the handle with the given ID, which must be open.
+//
+// LOCKS_EXCLUDED(fs.mu)
+func (fs *loopbackFS) getHandle(id fuseops.HandleID) *handle {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	h := fs.handles[id]
+	if h == nil {
+		panic(fmt.Sprintf("Unknown handle: %v", id))
+	}
+
+	return h
+}
+
+// LOCKS_EXCLUDED(fs.mu)
+func (fs *loopbackFS) newHandle(f *os.File) fuseops.HandleID {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	id := fs.nextHandle
+	fs.nextHandle++
+	fs.handles[id] = &handle{file: f}
+
+	return id
+}
+
+// Choose an ID for a new inode for the backing file with the given inode
+// number.
+//
+// EXCLUSIVE_LOCKS_REQUIRED(fs.mu)
+func (fs *loopbackFS) chooseID(ino uint64) fuseops.InodeID {
+	id := fuseops.InodeID(ino)
+	if id > fuseops.RootInodeID && id < spareInodeIDs && fs.inodes[id] == nil {
+		return id
+	}
+
+	id = fs.nextSpareID
+	fs.nextSpareID++
+	return id
+}
+
+// Look up the child of the given directory with the given name, filling in
+// the entry and incrementing the child's lookup count.
+//
+// LOCKS_EXCLUDED(fs.mu)
+func (fs *loopbackFS) lookUp(
+	parent *inode,
+	name string,
+	entry *fuseops.ChildInodeEntry) error {
+	fd, err := unix.Openat(
+		parent.fd,
+		name,
+		unix.O_PATH|unix.O_NOFOLLOW|unix.O_CLOEXEC,
+		0)
+	if err != nil {
+		return err
+	}
+
+	child := &inode{fd: fd}
+	st, err := child.stat()
+	if err != nil {
+		unix.Close(fd)
+		return err
+	}
+
+	entry.Attributes = attributesFromStat(&st)
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	// Reuse the existing inode if the kernel already knows about this file.
+	key := devIno{dev: st.Dev, ino: st.Ino}
+	if existing := fs.byKey[key]; existing != nil {
+		unix.Close(fd)
+		child = existing
+	} else {
+		child.id = fs.chooseID(st.Ino)
+		child.key = key
+		child.fileType = st.Mode & unix.S_IFMT
+
+		fs.inodes[child.id] = child
+		fs.byKey[key] = child
+	}
+
+	child.lookupCount++
+	entry.Child = child.id
+
+	return nil
+}
+
+// Decrement the lookup count of the inode with the given ID, forgetting it
+// when it reaches zero.
+//
+// EXCLUSIVE_LOCKS_REQUIRED(fs.mu)
+func (fs *loopbackFS) forget(id fuseops.InodeID, n uint64) {
+	in := fs.inodes[id]
+	if in == nil || id == fuseops.RootInodeID {
+		return
+	}
+
+	if n > in.lookupCount {
+		n = in.lookupCount
+	}
+
+	in.lookupCount -= n
+	if in.lookupCount == 0 {
+		unix.Close(in.fd)
+		delete(fs.inodes, id)
+		delete(fs.byKey, in.key)
+	}
+}
+
+// When running as root, give a file that was just created in the given
+// directory to the user who created it, as the kernel would.
+func chown(
+	parent *inode,
+	name string,
+	opCtx fuseops.OpContext) error {
+	if os.Geteuid() != 0 {
+		return nil
+	}
+
+	return unix.Fchownat(
+		parent.fd,
+		name,
+		int(opCtx.Uid),
+		int(opCtx.Gid),
+		unix.AT_SYMLINK_NOFOLLOW)
+}
+
+// Open the given file for reading and writing if possible, and otherwise for
+// whichever of the two is allowed. The kernel has already checked that the
+// caller may open it the way they asked.
+func openFile(in *inode) (*os.File, error) {
+	var fd int
+	var err error
+	for _, flags := range []int{unix.O_RDWR, unix.O_RDONLY, unix.O_WRONLY} {
+		fd, err = unix.Open(in.procPath(), flags|unix.O_CLOEXEC, 0)
+		if err != unix.EACCES && err != unix.EROFS && err != unix.ETXTBSY {
+			break
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return os.NewFile(uintptr(fd), in.procPath()), nil
+}
+
+// Return a duplicate of f for handing to the kernel as a backing file, or nil
+// if that isn't possible.
+func backingFile(f *os.File) *os.File {
+	fd, err := unix.FcntlInt(f.Fd(), unix.F_DUPFD_CLOEXEC, 0)
+	if err != nil {
+		return nil
+	}
+
+	return os.NewFile(uintptr(fd), f.Name())
+}
+
+// Read all of the entries in the directory, other than "." and "..".
+//
+// LOCKS_EXCLUDED(fs.mu)
+func (fs *loopbackFS) readDirents(dir *inode, f *os.File) ([]fuseutil.Dirent, error) {
+	fd := int(f.Fd())
+	if _, err := unix.Seek(fd, 0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	type rawDirent struct {
+		name string
+		ino  uint64
+		typ  uint8
+	}
+
+	var raw []rawDirent
+	buf := make([]byte, 8192)
+	nameOffset := int(unsafe.Offsetof(syscall.Dirent{}.Name))
+	for {
+		n, err := unix.Getdents(fd, buf)
+		if err != nil {
+			return nil, err
+		}
+
+		if n == 0 {
+			break
+		}
+
+		for off := 0; off < n; {
+			d := (*syscall.Dirent)(unsafe.Pointer(&buf[off]))
+			name := buf[off+nameOffset : off+int(d.Reclen)]
+			off += int(d.Reclen)
+
+			for i, c := range name {
+				if c == 0 {
+					name = name[:i]
+					break
+				}
+			}
+
+			if string(name) == "." || string(name) == ".." {
+				continue
+			}
+
+			raw = append(raw, rawDirent{string(name), d.Ino, d.Type})
+		}
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	entries := make([]fuseutil.Dirent, len(raw))
+	for i, r := range raw {
+		id := fuseops.InodeID(r.ino)
+		if in := fs.byKey[devIno{dev: dir.key.dev, ino: r.ino}]; in != nil {
+			id = in.id
+		}
+
+		entries[i] = fuseutil.Dirent{
+			Offset: fuseops.DirOffset(i + 1),
+			Inode:  id,
+			Name:   r.name,
+			Type:   fuseutil.DirentType(r.typ),
+		}
+	}
+
+	return entries, nil
+}
+
+////////////////////////////////////////////////////////////////////////
+// FileSystem methods
+////////////////////////////////////////////////////////////////////////
+
+func (fs *loopbackFS) StatFS(
+	ctx context.Context,
+	op *fuseops.StatFSOp) error {
+	var st unix.Statfs_t
+	if err := unix.Statfs(fs.root, &st); err != nil {
+		return err
+	}
+
+	op.BlockSize = uint32(st.Bsize)
+	op.Blocks = st.Blocks
+	op.BlocksFree = st.Bfree
+	op.BlocksAvailable = st.Bavail
+	op.IoSize = uint32(st.Bsize)
+	op.Inodes = st.Files
+	op.InodesFree = st.Ffree
+
+	return nil
+}
+
+func (fs *loopbackFS) LookUpInode(
+	ctx context.Context,
+	op *fuseops.LookUpInodeOp) error {
+	return fs.lookUp(fs.getInode(op.Parent), op.Name, &op.Entry)
+}
+
+func (fs *loopbackFS) GetInodeAttributes(
+	ctx context.Context,
+	op *fuseops.GetInodeAttributesOp) error {
+	st, err := fs.getInode(op.Inode).stat()
+	if err != nil {
+		return err
+	}
+
+	op.Attributes = attributesFromStat(&st)
+	return nil
+}
+
+func (fs *loopbackFS) SetInodeAttributes(
+	ctx context.Context,
+	op *fuseops.SetInodeAttributesOp) error {
+	in := fs.getInode(op.Inode)
+
+	if op.Mode != nil && !in.isSymlink() {
+		if err := unix.Fchmodat(unix.AT_FDCWD, in.procPath(), permBits(*op.Mode), 0); err != nil {
+			return err
+		}
+	}
+
+	if op.KillSuidgid && !in.isSymlink() {
+		if err := in.killSuidgid(); err != nil {
+			return err
+		}
+	}
+
+	if op.Size != nil {
+		var err error
+		if op.Handle != nil {
+			err = fs.getHandle(*op.Handle).file.Truncate(int64(*op.Size))
+		} else {
+			err = unix.Truncate(in.procPath(), int64(*op.Size))
+		}
+
+		if err != nil {
+			return errno(err)
+		}
+	}
+
+	if op.Atime != nil || op.Mtime != nil {
+		ts := []unix.Timespec{
+			{Nsec: unix.UTIME_OMIT},
+			{Nsec: unix.UTIME_OMIT},
+		}
+
+		if op.Atime != nil {
+			ts[0] = unix.NsecToTimespec(op.Atime.UnixNano())
+		}
+
+		if op.Mtime != nil {
+			ts[1] = unix.NsecToTimespec(op.Mtime.UnixNano())
+		}
+
+		var err error
+		if in.isSymlink() {
+			err = unix.UtimesNanoAt(in.fd, "", ts, unix.AT_EMPTY_PATH)
+		} else {
+			err = unix.UtimesNanoAt(unix.AT_FDCWD, in.procPath(), ts, 0)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	st, err := in.stat()
+	if err != nil {
+		return err
+	}
+
+	op.Attributes = attributesFromStat(&st)
+	return nil
+}
+
+func (fs *loopbackFS) ForgetInode(
+	ctx context.Context,
+	op *fuseops.ForgetInodeOp) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	fs.forget(op.Inode, op.N)
+	return nil
+}
+
+func (fs *loopbackFS) BatchForget(
+	ctx context.Context,
+	op *fuseops.BatchForgetOp) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	for _, e := range op.Entries {
+		fs.forget(e.Inode, e.N)
+	}
+
+	return nil
+}
+
+func (fs *loopbackFS) MkDir(
+	ctx context.Context,
+	op *fuseops.MkDirOp) error {
+	parent := fs.getInode(op.Parent)
+	if err := unix.Mkdirat(parent.fd, op.Name, permBits(op.Mode)); err != nil {
+		return err
+	}
+
+	if err := chown(parent, op.Name, op.OpContext); err != nil {
+		return err
+	}
+
+	return fs.lookUp(parent, op.Name, &op.Entry)
+}
+
+func (fs *loopbackFS) MkNode(
+	ctx context.Context,
+	op *fuseops.MkNodeOp) error {
+	parent := fs.getInode(op.Parent)
+	if err := unix.Mknodat(parent.fd, op.Name, nodeMode(op.Mode), 0); err != nil {
+		return err
+	}
+
+	if err := chown(parent, op.Name, op.OpContext); err != nil {
+		return err
+	}
+
+	return fs.lookUp(parent, op.Name, &op.Entry)
+}
+
+func (fs *loopbackFS) CreateFile(
+	ctx context.Context,
+	op *fuseops.CreateFileOp) error {
+	parent := fs.getInode(op.Parent)
+	fd, err := unix.Openat(
+		parent.fd,
+		op.Name,
+		unix.O_RDWR|unix.O_CREAT|unix.O_EXCL|unix.O_CLOEXEC,
+		permBits(op.Mode))
+	if err != nil {
+		return err
+	}
+
+	f := os.NewFile(uintptr(fd), op.Name)
+	if err := chown(parent, op.Name, op.OpContext); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := fs.lookUp(parent, op.Name, &op.Entry); err != nil {
+		f.Close()
+		return err
+	}
+
+	op.Handle = fs.newHandle(f)
+	op.BackingFile = backingFile(f)
+
+	return nil
+}
+
+func (fs *loopbackFS) CreateSymlink(
+	ctx context.Context,
+	op *fuseops.CreateSymlinkOp) error {
+	parent := fs.getInode(op.Parent)
+	if err := unix.Symlinkat(op.Target, parent.fd, op.Name); err != nil {
+		return err
+	}
+
+	if err := chown(parent, op.Name, op.OpContext); err != nil {
+		return err
+	}
+
+	return fs.lookUp(parent, op.Name, &op.Entry)
+}
+
+func (fs *loopbackFS) CreateLink(
+	ctx context.Context,
+	op *fuseops.CreateLinkOp) error {
+	parent := fs.getInode(op.Parent)
+	target := fs.getInode(op.Target)
+
+	err := unix.Linkat(
+		unix.AT_FDCWD,
+		target.procPath(),
+		parent.fd,
+		op.Name,
+		unix.AT_SYMLINK_FOLLOW)
+	if err != nil {
+		return err
+	}
+
+	return fs.lookUp(parent, op.Name, &op.Entry)
+}
+
+func (fs *loopbackFS) Rename(
+	ctx context.Context,
+	op *fuseops.RenameOp) error {
+	return unix.Renameat(
+		fs.getInode(op.OldParent).fd,
+		op.OldName,
+		fs.getInode(op.NewParent).fd,
+		op.NewName)
+}
+
+func (fs *loopbackFS) RmDir(
+	ctx context.Context,
+	op *fuseops.RmDirOp) error {
+	return unix.Unlinkat(fs.getInode(op.Parent).fd, op.Name, unix.AT_REMOVEDIR)
+}
+
+func (fs *loopbackFS) Unlink(
+	ctx context.Context,
+	op *fuseops.UnlinkOp) error {
+	return unix.Unlinkat(fs.getInode(op.Parent).fd, op.Name, 0)
+}
+
+func (fs *loopbackFS) OpenDir(
+	ctx context.Context,
+	op *fuseops.OpenDirOp) error {
+	in := fs.getInode(op.Inode)
+	fd, err := unix.Openat(in.fd, ".", unix.O_RDONLY|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+
+	op.Handle = fs.newHandle(os.NewFile(uintptr(fd), in.procPath()))
+	return nil
+}
+
+func (fs *loopbackFS) ReadDir(
+	ctx context.Context,
+	op *fuseops.ReadDirOp) error {
+	h := fs.getHandle(op.Handle)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	// Take a fresh snapshot when the directory is read from the start.
+	if op.Offset == 0 || h.entries == nil {
+		entries, err := fs.readDirents(fs.getInode(op.Inode), h.file)
+		if err != nil {
+			return err
+		}
+
+		h.entries = entries
+	}
+
+	if op.Offset > fuseops.DirOffset(len(h.entries)) {
+		return nil
+	}
+
+	for _, e := range h.entries[op.Offset:] {
+		n := fuseutil.WriteDirent(op.Dst[op.BytesRead:], e)
+		if n == 0 {
+			break
+		}
+
+		op.BytesRead += n
+	}
+
+	return nil
+}
+
+func (fs *loopbackFS) ReleaseDirHandle(
+	ctx context.Context,
+	op *fuseops.ReleaseDirHandleOp) error {
+	fs.mu.Lock()
+	h := fs.handles[op.Handle]
+	delete(fs.handles, op.Handle)
+	fs.mu.Unlock()
+
+	return errno(h.file.Close())
+}
+
+func (fs *loopbackFS) OpenFile(
+	ctx context.Context,
+	op *fuseops.OpenFileOp) error {
+	in := fs.getInode(op.Inode)
+	if op.KillSuidgid {
+		if err := in.killSuidgid(); err != nil {
+			return err
+		}
+	}
+
+	f, err := openFile(in)
+	if err != nil {
+		return err
+	}
+
+	op.Handle = fs.newHandle(f)
+	op.BackingFile = backingFile(f)
+
+	return nil
+}
+
+func (fs *loopbackFS) ReadFile(
+	ctx context.Context,
+	op *fuseops.ReadFileOp) error {
+	// Let the connection read the data straight from the file, splicing it
+	// when it can.
+	op.FileData = &fuseops.FileRange{
+		File:   fs.getHandle(op.Handle).file,
+		Offset: op.Offset,
+		Size:   op.Size,
+	}
+
+	return nil
+}
+
+func (fs *loopbackFS) WriteFile(
+	ctx context.Context,
+	op *fuseops.WriteFileOp) error {
+	f := fs.getHandle(op.Handle).file
+
+	if op.KillSuidgid {
+		if err := fs.getInode(op.Inode).killSuidgid(); err != nil {
+			return err
+		}
+	}
+
+	if op.Spliced != nil {
+		off := op.Offset
+		for remaining := op.Spliced.Size; remaining > 0; {
+			n, err := unix.Splice(
+				int(op.Spliced.Pipe.Fd()),
+				nil,
+				int(f.Fd()),
+				&off,
+				remaining,
+				unix.SPLICE_F_MOVE)
+			if err != nil {
+				return err
+			}
+
+			if n == 0 {
+				return fuse.EIO
+			}
+
+			remaining -= int(n)
+		}
+
+		return nil
+	}
+
+	_, err := f.WriteAt(op.Data, op.Offset)
+	return errno(err)
+}
+
+func (fs *loopbackFS) SyncFile(
+	ctx context.Context,
+	op *fuseops.SyncFileOp) error {
+	return errno(fs.getHandle(op.Handle).file.Sync())
+}
+
+func (fs *loopbackFS) FlushFile(
+	ctx context.Context,
+	op *fuseops.FlushFileOp) error {
+	// Closing a duplicate of the descriptor has the side effects that close(2)
+	// has for the caller, such as releasing POSIX locks and reporting errors
+	// from delayed writes on network file systems.
+	fd, err := unix.Dup(int(fs.getHandle(op.Handle).file.Fd()))
+	if err != nil {
+		return err
+	}
+
+	return unix.Close(fd)
+}
+
+func (fs *loopbackFS) ReleaseFileHandle(
+	ctx context.Context,
+	op *fuseops.ReleaseFileHandleOp) error {
+	fs.mu.Lock()
+	h := fs.handles[op.Handle]
+	delete(fs.handles, op.Handle)
+	fs.mu.Unlock()
+
+	return errno(h.file.Close())
+}
+
+func (fs *loopbackFS) ReadSymlink(
+	ctx context.Context,
+	op *fuseops.ReadSymlinkOp) error {
+	in := fs.getInode(op.Inode)
+	for size := 256; ; size *= 2 {
+		buf := make([]byte, size)
+		n, err := unix.Readlinkat(in.fd, "", buf)
+		if err != nil {
+			return err
+		}
+
+		if n < size {
+			op.Target = string(buf[:n])
+			return nil
+		}
+	}
+}
+
+// Extended attributes are reached through /proc/self/fd, which for symlinks
+// would follow the link. There is no race-free way to reach the symlink's own
+// attributes, which in any case can't include user attributes.
+
+func (fs *loopbackFS) GetXattr(
+	ctx context.Context,
+	op *fuseops.GetXattrOp) error {
+	in := fs.getInode(op.Inode)
+	if in.isSymlink() {
+		return fuse.ENOATTR
+	}
+
+	n, err := unix.Getxattr(in.procPath(), op.Name, op.Dst)
+	if err != nil {
+		return err
+	}
+
+	op.BytesRead = n
+	return nil
+}
+
+func (fs *loopbackFS) ListXattr(
+	ctx context.Context,
+	op *fuseops.ListXattrOp) error {
+	in := fs.getInode(op.Inode)
+	if in.isSymlink() {
+		return nil
+	}
+
+	n, err := unix.Listxattr(in.procPath(), op.Dst)
+	if err != nil {
+		return err
+	}
+
+	op.BytesRead = n
+	return nil
+}
+
+func (fs *loopbackFS) SetXattr(
+	ctx context.Context,
+	op *fuseops.SetXattrOp) error {
+	in := fs.getInode(op.Inode)
+	if in.isSymlink() {
+		return syscall.EPERM
+	}
+
+	return unix.Setxattr(in.procPath(), op.Name, op.Value, int(op.Flags))
+}
+
+func (fs *loopbackFS) RemoveXattr(
+	ctx context.Context,
+	op *fuseops.RemoveXattrOp) error {
+	in := fs.getInode(op.Inode)
+	if in.isSymlink() {
+		return syscall.EPERM
+	}
+
+	return unix.Removexattr(in.procPath(), op.Name)
+}
+
+func (fs *loopbackFS) Fallocate(
+	ctx context.Context,
+	op *fuseops.FallocateOp) error {
+	return unix.Fallocate(
+		int(fs.getHandle(op.Handle).file.Fd()),
+		op.Mode,
+		int64(op.Offset),
+		int64(op.Length))
+}
+
+func (fs *loopbackFS) SeekFile(
+	ctx context.Context,
+	op *fuseops.SeekFileOp) error {
+	off, err := unix.Seek(
+		int(fs.getHandle(op.Handle).file.Fd()),
+		op.Offset,
+		op.Whence)
+	if err != nil {
+		return err
+	}
+
+	op.NewOffset = off
+	return nil
+}
+
+func (fs *loopbackFS) CopyFileRange(
+	ctx context.Context,
+	op *fuseops.CopyFileRangeOp) error {
+	srcOff := int64(op.SrcOffset)
+	dstOff := int64(op.DstOffset)
+
+	n, err := unix.CopyFileRange(
+		int(fs.getHandle(op.SrcHandle).file.Fd()),
+		&srcOff,
+		int(fs.getHandle(op.DstHandle).file.Fd()),
+		&dstOff,
+		int(op.Length),
+		int(op.Flags))
+	if err != nil {
+		return err
+	}
+
+	op.BytesCopied = uint64(n)
+	return nil
+}
+
+func (fs *loopbackFS) Destroy() {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	for _, h := range fs.handles {
+		h.file.Close()
+	}
+
+	for _, in := range fs.inodes {
+		unix.Close(in.fd)
+	}
+}
@@ -0,0 +1,475 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package loopbackfs_test
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/jacobsa/fuse/fusetesting"
+	"github.com/jacobsa/fuse/samples"
+	"github.com/jacobsa/fuse/samples/loopbackfs"
+	. "github.com/jacobsa/oglematchers"
+	. "github.com/jacobsa/ogletest"
+	"github.com/jacobsa/timeutil"
+)
+
+func TestLoopbackFS(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+type LoopbackFSTest struct {
+	samples.SampleTest
+
+	// The directory being mirrored.
+	backing string
+}
+
+func init() { RegisterTestSuite(&LoopbackFSTest{}) }
+
+func (t *LoopbackFSTest) SetUp(ti *TestInfo) {
+	var err error
+
+	t.backing, err = ioutil.TempDir("", "loopbackfs_test")
+	AssertEq(nil, err)
+
+	t.Server, err = loopbackfs.NewLoopbackServer(t.backing)
+	AssertEq(nil, err)
+
+	t.SampleTest.SetUp(ti)
+}
+
+func (t *LoopbackFSTest) TearDown() {
+	t.SampleTest.TearDown()
+
+	err := os.RemoveAll(t.backing)
+	if err != nil {
+		panic(err)
+	}
+}
+
+////////////////////////////////////////////////////////////////////////
+// Test functions
+////////////////////////////////////////////////////////////////////////
+
+func (t *LoopbackFSTest) WritesReachBackingFile() {
+	err := ioutil.WriteFile(path.Join(t.Dir, "foo"), []byte("taco"), 0640)
+	AssertEq(nil, err)
+
+	contents, err := ioutil.ReadFile(path.Join(t.backing, "foo"))
+	AssertEq(nil, err)
+	ExpectEq("taco", string(contents))
+
+	fi, err := os.Stat(path.Join(t.backing, "foo"))
+	AssertEq(nil, err)
+	ExpectEq(os.FileMode(0640), fi.Mode())
+}
+
+func (t *LoopbackFSTest) ReadsSeeBackingFile() {
+	err := ioutil.WriteFile(path.Join(t.backing, "foo"), []byte("burrito"), 0600)
+	AssertEq(nil, err)
+
+	contents, err := ioutil.ReadFile(path.Join(t.Dir, "foo"))
+	AssertEq(nil, err)
+	ExpectEq("burrito", string(contents))
+}
+
+func (t *LoopbackFSTest) InodeNumbersMatchBackingFiles() {
+	err := ioutil.WriteFile(path.Join(t.backing, "foo"), nil, 0600)
+	AssertEq(nil, err)
+
+	backingFI, err := os.Stat(path.Join(t.backing, "foo"))
+	AssertEq(nil, err)
+
+	fi, err := os.Stat(path.Join(t.Dir, "foo"))
+	AssertEq(nil, err)
+
+	ExpectEq(
+		backingFI.Sys().(*syscall.Stat_t).Ino,
+		fi.Sys().(*syscall.Stat_t).Ino)
+}
+
+func (t *LoopbackFSTest) ReadDir() {
+	AssertEq(nil, os.Mkdir(path.Join(t.backing, "dir"), 0700))
+	AssertEq(nil, ioutil.WriteFile(path.Join(t.backing, "foo"), nil, 0600))
+	AssertEq(nil, os.Symlink("foo", path.Join(t.backing, "bar")))
+
+	entries, err := ioutil.ReadDir(t.Dir)
+	AssertEq(nil, err)
+	AssertEq(3, len(entries))
+
+	ExpectEq("bar", entries[0].Name())
+	ExpectEq(os.ModeSymlink, entries[0].Mode()&os.ModeType)
+
+	ExpectEq("dir", entries[1].Name())
+	ExpectTrue(entries[1].IsDir())
+
+	ExpectEq("foo", entries[2].Name())
+	ExpectTrue(entries[2].Mode().IsRegular())
+}
+
+func (t *LoopbackFSTest) MkdirAndRmdir() {
+	err := os.Mkdir(path.Join(t.Dir, "dir"), 0750)
+	AssertEq(nil, err)
+
+	fi, err := os.Stat(path.Join(t.backing, "dir"))
+	AssertEq(nil, err)
+	ExpectEq(os.ModeDir|0750, fi.Mode())
+
+	err = os.Remove(path.Join(t.Dir, "dir"))
+	AssertEq(nil, err)
+
+	_, err = os.Stat(path.Join(t.backing, "dir"))
+	ExpectTrue(os.IsNotExist(err))
+}
+
+func (t *LoopbackFSTest) RmdirNotEmpty() {
+	AssertEq(nil, os.MkdirAll(path.Join(t.backing, "dir/sub"), 0700))
+
+	err := os.Remove(path.Join(t.Dir, "dir"))
+	ExpectThat(err, Error(HasSubstr("not empty")))
+}
+
+func (t *LoopbackFSTest) Rename() {
+	AssertEq(nil, os.Mkdir(path.Join(t.Dir, "dir"), 0700))
+	AssertEq(nil, ioutil.WriteFile(path.Join(t.Dir, "foo"), []byte("taco"), 0600))
+
+	err := os.Rename(path.Join(t.Dir, "foo"), path.Join(t.Dir, "dir/bar"))
+	AssertEq(nil, err)
+
+	_, err = os.Stat(path.Join(t.backing, "foo"))
+	ExpectTrue(os.IsNotExist(err))
+
+	contents, err := ioutil.ReadFile(path.Join(t.Dir, "dir/bar"))
+	AssertEq(nil, err)
+	ExpectEq("taco", string(contents))
+}
+
+func (t *LoopbackFSTest) Symlink() {
+	err := os.Symlink("some/target", path.Join(t.Dir, "link"))
+	AssertEq(nil, err)
+
+	target, err := os.Readlink(path.Join(t.backing, "link"))
+	AssertEq(nil, err)
+	ExpectEq("some/target", target)
+
+	target, err = os.Readlink(path.Join(t.Dir, "link"))
+	AssertEq(nil, err)
+	ExpectEq("some/target", target)
+}
+
+func (t *LoopbackFSTest) Hardlink() {
+	AssertEq(nil, ioutil.WriteFile(path.Join(t.Dir, "foo"), []byte("taco"), 0600))
+
+	err := os.Link(path.Join(t.Dir, "foo"), path.Join(t.Dir, "bar"))
+	AssertEq(nil, err)
+
+	fi, err := os.Stat(path.Join(t.Dir, "foo"))
+	AssertEq(nil, err)
+	ExpectEq(2, fi.Sys().(*syscall.Stat_t).Nlink)
+
+	backingFI, err := os.Stat(path.Join(t.backing, "bar"))
+	AssertEq(nil, err)
+	ExpectEq(2, backingFI.Sys().(*syscall.Stat_t).Nlink)
+}
+
+func (t *LoopbackFSTest) SetAttributes() {
+	name := path.Join(t.Dir, "foo")
+	AssertEq(nil, ioutil.WriteFile(name, []byte("taco"), 0600))
+
+	AssertEq(nil, os.Truncate(name, 2))
+	AssertEq(nil, os.Chmod(name, 0444))
+
+	mtime := time.Date(2012, 8, 15, 22, 56, 0, 0, time.Local)
+	AssertEq(nil, os.Chtimes(name, mtime, mtime))
+
+	fi, err := os.Stat(path.Join(t.backing, "foo"))
+	AssertEq(nil, err)
+	ExpectEq(2, fi.Size())
+	ExpectEq(os.FileMode(0444), fi.Mode())
+	ExpectThat(fi.ModTime(), timeutil.TimeEq(mtime))
+}
+
+func (t *LoopbackFSTest) Xattrs() {
+	name := path.Join(t.Dir, "foo")
+	AssertEq(nil, ioutil.WriteFile(name, nil, 0600))
+
+	err := syscall.Setxattr(name, "user.taco", []byte("burrito"), 0)
+	if err == syscall.ENOTSUP {
+		// The backing file system doesn't support user attributes.
+		return
+	}
+
+	AssertEq(nil, err)
+
+	buf := make([]byte, 64)
+	n, err := syscall.Getxattr(path.Join(t.backing, "foo"), "user.taco", buf)
+	AssertEq(nil, err)
+	ExpectEq("burrito", string(buf[:n]))
+
+	err = syscall.Removexattr(name, "user.taco")
+	AssertEq(nil, err)
+
+	_, err = syscall.Getxattr(name, "user.taco", buf)
+	ExpectEq(syscall.ENODATA, err)
+}
+
+func (t *LoopbackFSTest) StatFS() {
+	var want, got syscall.Statfs_t
+	AssertEq(nil, syscall.Statfs(t.backing, &want))
+	AssertEq(nil, syscall.Statfs(t.Dir, &got))
+
+	ExpectEq(want.Bsize, got.Bsize)
+	ExpectEq(want.Blocks, got.Blocks)
+	ExpectEq(want.Files, got.Files)
+}
+
+func (t *LoopbackFSTest) ReadsPastEndOfFile() {
+	var err error
+	var n int
+	buf := make([]byte, 1024)
+
+	f, err := os.Create(path.Join(t.Dir, "foo"))
+	t.ToClose = append(t.ToClose, f)
+	AssertEq(nil, err)
+
+	n, err = f.Write([]byte("taco"))
+	AssertEq(nil, err)
+	AssertEq(4, n)
+
+	n, err = f.ReadAt(buf[:4], 2)
+	AssertEq(io.EOF, err)
+	ExpectEq("co", string(buf[:n]))
+
+	n, err = f.ReadAt(buf[:4], 100)
+	AssertEq(io.EOF, err)
+	ExpectEq(0, n)
+}
+
+func (t *LoopbackFSTest) WriteStartsPastEndOfFile() {
+	f, err := os.Create(path.Join(t.Dir, "foo"))
+	t.ToClose = append(t.ToClose, f)
+	AssertEq(nil, err)
+
+	n, err := f.WriteAt([]byte("taco"), 2)
+	AssertEq(nil, err)
+	AssertEq(4, n)
+
+	contents, err := ioutil.ReadAll(f)
+	AssertEq(nil, err)
+	ExpectEq("\x00\x00taco", string(contents))
+}
+
+func (t *LoopbackFSTest) WriteOverlapsEndOfFile() {
+	f, err := os.Create(path.Join(t.Dir, "foo"))
+	t.ToClose = append(t.ToClose, f)
+	AssertEq(nil, err)
+
+	// Make it 4 bytes long, and write the range [2, 6).
+	AssertEq(nil, f.Truncate(4))
+
+	n, err := f.WriteAt([]byte("taco"), 2)
+	AssertEq(nil, err)
+	AssertEq(4, n)
+
+	contents, err := ioutil.ReadAll(f)
+	AssertEq(nil, err)
+	ExpectEq("\x00\x00taco", string(contents))
+}
+
+func (t *LoopbackFSTest) WriteStartsAtEndOfFile() {
+	f, err := os.Create(path.Join(t.Dir, "foo"))
+	t.ToClose = append(t.ToClose, f)
+	AssertEq(nil, err)
+
+	// Make it 2 bytes long, and write the range [2, 6).
+	AssertEq(nil, f.Truncate(2))
+
+	n, err := f.WriteAt([]byte("taco"), 2)
+	AssertEq(nil, err)
+	AssertEq(4, n)
+
+	contents, err := ioutil.ReadAll(f)
+	AssertEq(nil, err)
+	ExpectEq("\x00\x00taco", string(contents))
+}
+
+func (t *LoopbackFSTest) WriteStartsPastEndOfFile_AppendMode() {
+	f, err := os.OpenFile(
+		path.Join(t.Dir, "foo"),
+		os.O_RDWR|os.O_APPEND|os.O_CREATE,
+		0600)
+
+	t.ToClose = append(t.ToClose, f)
+	AssertEq(nil, err)
+
+	n, err := f.Write([]byte("111"))
+	AssertEq(nil, err)
+	AssertEq(3, n)
+
+	// On Linux, pwrite(2) on a file opened with O_APPEND appends regardless of
+	// the offset.
+	n, err = syscall.Pwrite(int(f.Fd()), []byte("222"), 6)
+	AssertEq(nil, err)
+	AssertEq(3, n)
+
+	contents, err := ioutil.ReadFile(path.Join(t.backing, "foo"))
+	AssertEq(nil, err)
+	ExpectEq("111222", string(contents))
+}
+
+func (t *LoopbackFSTest) WriteAtDoesntChangeOffset_NotAppendMode() {
+	f, err := os.Create(path.Join(t.Dir, "foo"))
+	t.ToClose = append(t.ToClose, f)
+	AssertEq(nil, err)
+
+	AssertEq(nil, f.Truncate(16))
+
+	_, err = f.Seek(4, 0)
+	AssertEq(nil, err)
+
+	n, err := f.WriteAt([]byte("taco"), 10)
+	AssertEq(nil, err)
+	AssertEq(4, n)
+
+	// We should still be at offset 4.
+	offset, err := f.Seek(0, 1)
+	AssertEq(nil, err)
+	ExpectEq(4, offset)
+}
+
+func (t *LoopbackFSTest) WriteAtDoesntChangeOffset_AppendMode() {
+	f, err := os.OpenFile(
+		path.Join(t.Dir, "foo"),
+		os.O_RDWR|os.O_APPEND|os.O_CREATE,
+		0600)
+
+	t.ToClose = append(t.ToClose, f)
+	AssertEq(nil, err)
+
+	AssertEq(nil, f.Truncate(16))
+
+	_, err = f.Seek(4, 0)
+	AssertEq(nil, err)
+
+	n, err := syscall.Pwrite(int(f.Fd()), []byte("taco"), 10)
+	AssertEq(nil, err)
+	AssertEq(4, n)
+
+	// We should still be at offset 4.
+	offset, err := f.Seek(0, 1)
+	AssertEq(nil, err)
+	ExpectEq(4, offset)
+}
+
+func (t *LoopbackFSTest) AppendMode() {
+	buf := make([]byte, 1024)
+
+	fileName := path.Join(t.Dir, "foo")
+	AssertEq(nil, ioutil.WriteFile(fileName, []byte("Jello, "), 0600))
+
+	f, err := os.OpenFile(fileName, os.O_RDWR|os.O_APPEND, 0600)
+	t.ToClose = append(t.ToClose, f)
+	AssertEq(nil, err)
+
+	// Seek to somewhere silly and then write. The offset should be updated to
+	// point at the end of the file.
+	off, err := f.Seek(2, 0)
+	AssertEq(nil, err)
+	AssertEq(2, off)
+
+	n, err := f.Write([]byte("world!"))
+	AssertEq(nil, err)
+	AssertEq(6, n)
+
+	off, err = f.Seek(0, 1)
+	AssertEq(nil, err)
+	ExpectEq(13, off)
+
+	// A random write appends too on Linux, without updating the offset.
+	n, err = syscall.Pwrite(int(f.Fd()), []byte("H"), 0)
+	AssertEq(nil, err)
+	AssertEq(1, n)
+
+	off, err = f.Seek(0, 1)
+	AssertEq(nil, err)
+	ExpectEq(13, off)
+
+	n, err = f.ReadAt(buf, 0)
+	AssertEq(io.EOF, err)
+	ExpectEq("Jello, world!H", string(buf[:n]))
+
+	contents, err := ioutil.ReadFile(path.Join(t.backing, "foo"))
+	AssertEq(nil, err)
+	ExpectEq("Jello, world!H", string(contents))
+}
+
+func (t *LoopbackFSTest) HardLinkDirectory() {
+	dirName := path.Join(t.Dir, "dir")
+	AssertEq(nil, os.Mkdir(dirName, 0700))
+
+	err := os.Link(dirName, path.Join(t.Dir, "other"))
+	ExpectThat(err, Error(HasSubstr("not permitted")))
+}
+
+func (t *LoopbackFSTest) RmdirWhileOpenedForReading() {
+	AssertEq(nil, os.Mkdir(path.Join(t.Dir, "dir"), 0700))
+
+	f, err := os.Open(path.Join(t.Dir, "dir"))
+	t.ToClose = append(t.ToClose, f)
+	AssertEq(nil, err)
+
+	AssertEq(nil, os.Remove(path.Join(t.Dir, "dir")))
+	AssertEq(nil, os.MkdirAll(path.Join(t.Dir, "dir/foo"), 0700))
+
+	// The open handle should not see the contents of the new directory.
+	names, err := f.Readdirnames(0)
+	if err != nil {
+		ExpectThat(err, Error(HasSubstr("no such file")))
+	} else {
+		ExpectThat(names, ElementsAre())
+	}
+}
+
+func (t *LoopbackFSTest) CreateInParallel_NoTruncate() {
+	fusetesting.RunCreateInParallelTest_NoTruncate(t.Ctx, t.Dir)
+}
+
+func (t *LoopbackFSTest) CreateInParallel_Truncate() {
+	fusetesting.RunCreateInParallelTest_Truncate(t.Ctx, t.Dir)
+}
+
+func (t *LoopbackFSTest) CreateInParallel_Exclusive() {
+	fusetesting.RunCreateInParallelTest_Exclusive(t.Ctx, t.Dir)
+}
+
+func (t *LoopbackFSTest) MkdirInParallel() {
+	fusetesting.RunMkdirInParallelTest(t.Ctx, t.Dir)
+}
+
+func (t *LoopbackFSTest) SymlinkInParallel() {
+	fusetesting.RunSymlinkInParallelTest(t.Ctx, t.Dir)
+}
+
+func (t *LoopbackFSTest) HardlinkInParallel() {
+	fusetesting.RunHardlinkInParallelTest(t.Ctx, t.Dir)
+}
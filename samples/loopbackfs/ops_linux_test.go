@@ -0,0 +1,272 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Tests that call the file system directly, for behaviour that is awkward to
+// reach through a mount.
+
+package loopbackfs
+
+import (
+	"context"
+	"os"
+	"path"
+	"syscall"
+	"testing"
+
+	"github.com/jacobsa/fuse/fuseops"
+	"golang.org/x/sys/unix"
+)
+
+// Create a file system mirroring a temporary directory holding a file with
+// the given name, contents and mode.
+func newTestFS(
+	t *testing.T,
+	name string,
+	contents string,
+	mode os.FileMode) (*loopbackFS, string) {
+	dir := t.TempDir()
+	p := path.Join(dir, name)
+
+	if err := os.WriteFile(p, []byte(contents), 0600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	// Set the mode explicitly, since WriteFile is subject to the umask and
+	// can't set the setuid and setgid bits.
+	if err := os.Chmod(p, mode); err != nil {
+		t.Fatalf("Chmod: %v", err)
+	}
+
+	fs, err := newLoopbackFS(dir)
+	if err != nil {
+		t.Fatalf("newLoopbackFS: %v", err)
+	}
+
+	t.Cleanup(fs.Destroy)
+	return fs, dir
+}
+
+// Look up and open the named child of the root.
+func openTestFile(
+	t *testing.T,
+	fs *loopbackFS,
+	name string) (fuseops.InodeID, fuseops.HandleID) {
+	ctx := context.Background()
+
+	lookUp := &fuseops.LookUpInodeOp{Parent: fuseops.RootInodeID, Name: name}
+	if err := fs.LookUpInode(ctx, lookUp); err != nil {
+		t.Fatalf("LookUpInode: %v", err)
+	}
+
+	open := &fuseops.OpenFileOp{Inode: lookUp.Entry.Child}
+	if err := fs.OpenFile(ctx, open); err != nil {
+		t.Fatalf("OpenFile: %v", err)
+	}
+
+	return lookUp.Entry.Child, open.Handle
+}
+
+func statMode(t *testing.T, p string) os.FileMode {
+	fi, err := os.Stat(p)
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+
+	return fi.Mode()
+}
+
+func TestKillSuidgid(t *testing.T) {
+	ctx := context.Background()
+	fs, dir := newTestFS(t, "foo", "taco", os.ModeSetuid|os.ModeSetgid|0755)
+	p := path.Join(dir, "foo")
+	inode, handle := openTestFile(t, fs, "foo")
+
+	// A change of owner or size by a user other than root.
+	err := fs.SetInodeAttributes(ctx, &fuseops.SetInodeAttributesOp{
+		Inode:       inode,
+		KillSuidgid: true,
+	})
+	if err != nil {
+		t.Fatalf("SetInodeAttributes: %v", err)
+	}
+
+	if m := statMode(t, p); m != 0755 {
+		t.Errorf("Mode after SetInodeAttributes: %v", m)
+	}
+
+	// A write. The setgid bit of a file that isn't group-executable marks it
+	// for mandatory locking, and stays.
+	if err := os.Chmod(p, os.ModeSetuid|os.ModeSetgid|0745); err != nil {
+		t.Fatalf("Chmod: %v", err)
+	}
+
+	err = fs.WriteFile(ctx, &fuseops.WriteFileOp{
+		Inode:       inode,
+		Handle:      handle,
+		Data:        []byte("burrito"),
+		KillSuidgid: true,
+	})
+	if err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	if m := statMode(t, p); m != os.ModeSetgid|0745 {
+		t.Errorf("Mode after WriteFile: %v", m)
+	}
+
+	// An open that truncates.
+	if err := os.Chmod(p, os.ModeSetuid|os.ModeSetgid|0755); err != nil {
+		t.Fatalf("Chmod: %v", err)
+	}
+
+	err = fs.OpenFile(ctx, &fuseops.OpenFileOp{Inode: inode, KillSuidgid: true})
+	if err != nil {
+		t.Fatalf("OpenFile: %v", err)
+	}
+
+	if m := statMode(t, p); m != 0755 {
+		t.Errorf("Mode after OpenFile: %v", m)
+	}
+
+	// Without KillSuidgid, the bits stay.
+	if err := os.Chmod(p, os.ModeSetuid|0755); err != nil {
+		t.Fatalf("Chmod: %v", err)
+	}
+
+	err = fs.WriteFile(ctx, &fuseops.WriteFileOp{
+		Inode:  inode,
+		Handle: handle,
+		Data:   []byte("queso"),
+	})
+	if err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	if m := statMode(t, p); m != os.ModeSetuid|0755 {
+		t.Errorf("Mode after plain WriteFile: %v", m)
+	}
+}
+
+func TestCopyFileRange(t *testing.T) {
+	ctx := context.Background()
+	fs, dir := newTestFS(t, "src", "tacoburrito", 0600)
+	if err := os.WriteFile(path.Join(dir, "dst"), []byte("enchilada"), 0600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	srcInode, srcHandle := openTestFile(t, fs, "src")
+	dstInode, dstHandle := openTestFile(t, fs, "dst")
+
+	op := &fuseops.CopyFileRangeOp{
+		SrcInode:  srcInode,
+		SrcHandle: srcHandle,
+		SrcOffset: 4,
+		DstInode:  dstInode,
+		DstHandle: dstHandle,
+		DstOffset: 2,
+		Length:    4,
+	}
+
+	if err := fs.CopyFileRange(ctx, op); err != nil {
+		t.Fatalf("CopyFileRange: %v", err)
+	}
+
+	if op.BytesCopied != 4 {
+		t.Errorf("BytesCopied = %d, want 4", op.BytesCopied)
+	}
+
+	contents, err := os.ReadFile(path.Join(dir, "dst"))
+	if err != nil || string(contents) != "enburrada" {
+		t.Errorf("ReadFile: %q, %v", contents, err)
+	}
+}
+
+func TestFallocate(t *testing.T) {
+	ctx := context.Background()
+	fs, dir := newTestFS(t, "foo", "taco", 0600)
+	inode, handle := openTestFile(t, fs, "foo")
+
+	// Allocating space past the end extends the file, unless asked not to.
+	err := fs.Fallocate(ctx, &fuseops.FallocateOp{
+		Inode:  inode,
+		Handle: handle,
+		Offset: 0,
+		Length: 8192,
+		Mode:   unix.FALLOC_FL_KEEP_SIZE,
+	})
+	if err == syscall.EOPNOTSUPP {
+		t.Skip("The backing file system doesn't support fallocate")
+	}
+
+	if err != nil {
+		t.Fatalf("Fallocate: %v", err)
+	}
+
+	fi, err := os.Stat(path.Join(dir, "foo"))
+	if err != nil || fi.Size() != 4 {
+		t.Fatalf("Stat: %v, %v", fi, err)
+	}
+
+	err = fs.Fallocate(ctx, &fuseops.FallocateOp{
+		Inode:  inode,
+		Handle: handle,
+		Offset: 0,
+		Length: 8192,
+	})
+	if err != nil {
+		t.Fatalf("Fallocate: %v", err)
+	}
+
+	fi, err = os.Stat(path.Join(dir, "foo"))
+	if err != nil || fi.Size() != 8192 {
+		t.Fatalf("Stat: %v, %v", fi, err)
+	}
+}
+
+func TestSeekFile(t *testing.T) {
+	ctx := context.Background()
+	fs, _ := newTestFS(t, "foo", "taco", 0600)
+	inode, handle := openTestFile(t, fs, "foo")
+
+	testCases := []struct {
+		offset int64
+		whence int
+		want   int64
+		err    error
+	}{
+		{0, unix.SEEK_DATA, 0, nil},
+		{1, unix.SEEK_HOLE, 4, nil},
+		{4, unix.SEEK_DATA, 0, syscall.ENXIO},
+		{-1, unix.SEEK_END, 3, nil},
+	}
+
+	for _, tc := range testCases {
+		op := &fuseops.SeekFileOp{
+			Inode:  inode,
+			Handle: handle,
+			Offset: tc.offset,
+			Whence: tc.whence,
+		}
+
+		err := fs.SeekFile(ctx, op)
+		if err != tc.err {
+			t.Errorf("SeekFile(%d, %d): %v, want %v", tc.offset, tc.whence, err, tc.err)
+			continue
+		}
+
+		if err == nil && op.NewOffset != tc.want {
+			t.Errorf("SeekFile(%d, %d) = %d, want %d", tc.offset, tc.whence, op.NewOffset, tc.want)
+		}
+	}
+}
@@ -17,7 +17,9 @@ package memfs
 import (
 	"fmt"
 	"io"
+	"math"
 	"os"
+	"syscall"
 	"time"
 
 	"github.com/jacobsa/fuse"
@@ -65,6 +67,32 @@ type inode struct {
 
 	// extended attributes and values
 	xattrs map[string][]byte
+
+	// flock(2) locks held on this inode, indexed by lock owner. See notes on
+	// fuseops.SetFileLockOp.Flock.
+	//
+	// INVARIANT: For each v, v == LockRead || v == LockWrite
+	// INVARIANT: If any v == LockWrite, len(flocks) == 1
+	flocks map[uint64]fuseops.LockType
+
+	// POSIX record locks held on this inode, along with their owners. See notes
+	// on fuseops.SetFileLockOp.Owner.
+	//
+	// INVARIANT: For each l, l.Type == LockRead || l.Type == LockWrite
+	// INVARIANT: For each l, l.Start <= l.End
+	// INVARIANT: No two locks with the same owner overlap.
+	posixLocks []posixLock
+}
+
+// A POSIX record lock held on an inode.
+type posixLock struct {
+	owner uint64
+	fuseops.FileLock
+}
+
+// Return whether the lock overlaps the given range.
+func (l *posixLock) overlaps(start uint64, end uint64) bool {
+	return l.Start <= end && start <= l.End
 }
 
 ////////////////////////////////////////////////////////////////////////
@@ -83,6 +111,7 @@ func newInode(attrs fuseops.InodeAttributes) *inode {
 	return &inode{
 		attrs:  attrs,
 		xattrs: make(map[string][]byte),
+		flocks: make(map[uint64]fuseops.LockType),
 	}
 }
 
@@ -139,6 +168,40 @@ func (in *inode) CheckInvariants() {
 		panic(fmt.Sprintf("Unexpected target length: %d", len(in.target)))
 	}
 
+	// INVARIANT: For each v, v == LockRead || v == LockWrite
+	// INVARIANT: If any v == LockWrite, len(flocks) == 1
+	for owner, t := range in.flocks {
+		switch t {
+		case fuseops.LockRead:
+		case fuseops.LockWrite:
+			if len(in.flocks) != 1 {
+				panic(fmt.Sprintf("Shared write lock for owner %#x", owner))
+			}
+
+		default:
+			panic(fmt.Sprintf("Unexpected lock type for owner %#x: %v", owner, t))
+		}
+	}
+
+	// INVARIANT: For each l, l.Type == LockRead || l.Type == LockWrite
+	// INVARIANT: For each l, l.Start <= l.End
+	// INVARIANT: No two locks with the same owner overlap.
+	for i, l := range in.posixLocks {
+		if l.Type != fuseops.LockRead && l.Type != fuseops.LockWrite {
+			panic(fmt.Sprintf("Unexpected lock type for owner %#x: %v", l.owner, l.Type))
+		}
+
+		if l.Start > l.End {
+			panic(fmt.Sprintf("Unexpected lock range: [%d, %d]", l.Start, l.End))
+		}
+
+		for _, other := range in.posixLocks[i+1:] {
+			if other.owner == l.owner && other.overlaps(l.Start, l.End) {
+				panic(fmt.Sprintf("Overlapping locks for owner %#x", l.owner))
+			}
+		}
+	}
+
 	return
 }
 
@@ -316,6 +379,56 @@ func (in *inode) ReadAt(p []byte, off int64) (int, error) {
 	return n, nil
 }
 
+// The granularity at which Seek reports holes: any aligned block of this size
+// consisting entirely of zeroes is treated as a hole.
+const holeBlockSize = 4096
+
+// Return whether the block of the file's contents containing off is a hole.
+func (in *inode) isHole(off int64) bool {
+	start := off - off%holeBlockSize
+	end := start + holeBlockSize
+	if end > int64(len(in.contents)) {
+		end = int64(len(in.contents))
+	}
+
+	for _, b := range in.contents[start:end] {
+		if b != 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Find the next data or hole at or after the given offset, as for lseek(2)
+// with fuseops.SeekData or fuseops.SeekHole.
+//
+// REQUIRES: in.isFile()
+func (in *inode) Seek(off int64, whence int) (int64, error) {
+	if !in.isFile() {
+		panic("Seek called on non-file.")
+	}
+
+	size := int64(len(in.contents))
+	if off < 0 || off >= size {
+		return 0, syscall.ENXIO
+	}
+
+	wantHole := whence == fuseops.SeekHole
+	for pos := off; pos < size; pos += holeBlockSize - pos%holeBlockSize {
+		if in.isHole(pos) == wantHole {
+			return pos, nil
+		}
+	}
+
+	// The end of the file is an implicit hole, and there is no data beyond it.
+	if wantHole {
+		return size, nil
+	}
+
+	return 0, syscall.ENXIO
+}
+
 // Write to the file's contents. See documentation for ioutil.WriterAt.
 //
 // REQUIRES: in.isFile()
@@ -381,6 +494,95 @@ func (in *inode) SetAttributes(
 	}
 }
 
+// Apply a flock(2) operation on behalf of the given owner. Return false
+// without changing anything if the operation conflicts with a lock held by a
+// different owner.
+func (in *inode) Flock(owner uint64, t fuseops.LockType) bool {
+	if t == fuseops.LockUnlock {
+		delete(in.flocks, owner)
+		return true
+	}
+
+	for other, otherType := range in.flocks {
+		if other == owner {
+			continue
+		}
+
+		if t == fuseops.LockWrite || otherType == fuseops.LockWrite {
+			return false
+		}
+	}
+
+	in.flocks[owner] = t
+	return true
+}
+
+// Return a POSIX lock held by an owner other than the given one that
+// conflicts with the supplied lock, or a lock of type LockUnlock if there is
+// none.
+func (in *inode) TestPosixLock(owner uint64, l fuseops.FileLock) fuseops.FileLock {
+	for _, held := range in.posixLocks {
+		if held.owner == owner || !held.overlaps(l.Start, l.End) {
+			continue
+		}
+
+		if l.Type == fuseops.LockWrite || held.Type == fuseops.LockWrite {
+			return held.FileLock
+		}
+	}
+
+	return fuseops.FileLock{Type: fuseops.LockUnlock}
+}
+
+// Acquire or release a POSIX lock on behalf of the given owner, replacing any
+// part of the owner's existing locks that the new one covers. Return false
+// without changing anything if the lock conflicts with one held by a
+// different owner.
+func (in *inode) SetPosixLock(owner uint64, l fuseops.FileLock) bool {
+	if l.Type != fuseops.LockUnlock {
+		if in.TestPosixLock(owner, l).Type != fuseops.LockUnlock {
+			return false
+		}
+	}
+
+	// Cut the range out of the owner's existing locks.
+	var kept []posixLock
+	for _, held := range in.posixLocks {
+		if held.owner != owner || !held.overlaps(l.Start, l.End) {
+			kept = append(kept, held)
+			continue
+		}
+
+		if held.Start < l.Start {
+			before := held
+			before.End = l.Start - 1
+			kept = append(kept, before)
+		}
+
+		if held.End > l.End {
+			after := held
+			after.Start = l.End + 1
+			kept = append(kept, after)
+		}
+	}
+
+	if l.Type != fuseops.LockUnlock {
+		kept = append(kept, posixLock{owner: owner, FileLock: l})
+	}
+
+	in.posixLocks = kept
+	return true
+}
+
+// Release all POSIX locks held by the given owner.
+func (in *inode) ReleasePosixLocks(owner uint64) {
+	in.SetPosixLock(owner, fuseops.FileLock{
+		Start: 0,
+		End:   math.MaxUint64,
+		Type:  fuseops.LockUnlock,
+	})
+}
+
 func (in *inode) Fallocate(mode uint32, offset uint64, length uint64) error {
 	if mode != 0 {
 		return fuse.ENOSYS
@@ -0,0 +1,83 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memfs
+
+import (
+	"context"
+	"os"
+	"testing"
+	"testing/fstest"
+
+	"github.com/jacobsa/fuse/fuseops"
+	"github.com/jacobsa/fuse/fuseutil"
+)
+
+// Check memfs against io/fs's expectations of a file system, going through
+// NewIOFS rather than a mount.
+func TestIOFS(t *testing.T) {
+	ctx := context.Background()
+	fs := newMemFS(uint32(os.Getuid()), uint32(os.Getgid()))
+	opContext := fuseops.OpContext{Pid: uint32(os.Getpid())}
+
+	mkDir := &fuseops.MkDirOp{
+		Parent:    fuseops.RootInodeID,
+		Name:      "dir",
+		Mode:      os.ModeDir | 0700,
+		OpContext: opContext,
+	}
+	if err := fs.MkDir(ctx, mkDir); err != nil {
+		t.Fatalf("MkDir: %v", err)
+	}
+
+	create := &fuseops.CreateFileOp{
+		Parent:    mkDir.Entry.Child,
+		Name:      "foo",
+		Mode:      0600,
+		OpContext: opContext,
+	}
+	if err := fs.CreateFile(ctx, create); err != nil {
+		t.Fatalf("CreateFile: %v", err)
+	}
+
+	write := &fuseops.WriteFileOp{
+		Inode:     create.Entry.Child,
+		Handle:    create.Handle,
+		Data:      []byte("taco"),
+		OpContext: opContext,
+	}
+	if err := fs.WriteFile(ctx, write); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	symlink := &fuseops.CreateSymlinkOp{
+		Parent:    fuseops.RootInodeID,
+		Name:      "link",
+		Target:    "dir/foo",
+		OpContext: opContext,
+	}
+	if err := fs.CreateSymlink(ctx, symlink); err != nil {
+		t.Fatalf("CreateSymlink: %v", err)
+	}
+
+	fsys := fuseutil.NewIOFS(fs)
+	if err := fstest.TestFS(fsys, "dir/foo", "link"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The symlink is followed, and the file can't be searched.
+	if _, err := fsys.Open("link/bar"); err == nil {
+		t.Error("Opening a path through a file succeeded")
+	}
+}
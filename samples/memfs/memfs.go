@@ -18,6 +18,7 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"math"
 	"os"
 	"syscall"
 	"time"
@@ -61,6 +62,10 @@ type memFS struct {
 	// INVARIANT: This is all and only indices i of 'inodes' such that i >
 	// fuseops.RootInodeID and inodes[i] == nil
 	freeInodes []fuseops.InodeID // GUARDED_BY(mu)
+
+	// Closed and replaced whenever a flock(2) or POSIX lock changes, in order to
+	// wake up any blocking SetFileLock calls so that they can try again.
+	locksChanged chan struct{} // GUARDED_BY(mu)
 }
 
 // Create a file system that stores data and metadata in memory.
@@ -71,11 +76,18 @@ type memFS struct {
 func NewMemFS(
 	uid uint32,
 	gid uint32) fuse.Server {
+	return fuseutil.NewFileSystemServer(newMemFS(uid, gid))
+}
+
+func newMemFS(
+	uid uint32,
+	gid uint32) *memFS {
 	// Set up the basic struct.
 	fs := &memFS{
-		inodes: make([]*inode, fuseops.RootInodeID+1),
-		uid:    uid,
-		gid:    gid,
+		inodes:       make([]*inode, fuseops.RootInodeID+1),
+		uid:          uid,
+		gid:          gid,
+		locksChanged: make(chan struct{}),
 	}
 
 	// Set up the root inode.
@@ -90,7 +102,7 @@ func NewMemFS(
 	// Set up invariant checking.
 	fs.mu = syncutil.NewInvariantMutex(fs.checkInvariants)
 
-	return fuseutil.NewFileSystemServer(fs)
+	return fs
 }
 
 ////////////////////////////////////////////////////////////////////////
@@ -175,6 +187,14 @@ func (fs *memFS) allocateInode(
 	return id, inode
 }
 
+// Wake up any goroutines waiting for a lock to change.
+//
+// LOCKS_REQUIRED(fs.mu)
+func (fs *memFS) notifyLocksChanged() {
+	close(fs.locksChanged)
+	fs.locksChanged = make(chan struct{})
+}
+
 // LOCKS_REQUIRED(fs.mu)
 func (fs *memFS) deallocateInode(id fuseops.InodeID) {
 	fs.freeInodes = append(fs.freeInodes, id)
@@ -654,6 +674,54 @@ func (fs *memFS) ReadDir(
 	return nil
 }
 
+func (fs *memFS) ReadDirPlus(
+	ctx context.Context,
+	op *fuseops.ReadDirPlusOp) error {
+	if op.OpContext.Pid == 0 {
+		return fuse.EINVAL
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	// Grab the directory.
+	inode := fs.getInodeOrDie(op.Inode)
+	if !inode.isDir() {
+		panic("ReadDirPlus called on non-directory.")
+	}
+
+	// As in LookUpInode, the kernel can cache as long as it wants.
+	expiration := time.Now().Add(365 * 24 * time.Hour)
+
+	for i := int(op.Offset); i < len(inode.entries); i++ {
+		e := inode.entries[i]
+
+		// Skip unused entries.
+		if e.Type == fuseutil.DT_Unknown {
+			continue
+		}
+
+		d := fuseutil.DirentPlus{
+			Dirent: e,
+			Entry: fuseops.ChildInodeEntry{
+				Child:                e.Inode,
+				Attributes:           fs.getInodeOrDie(e.Inode).attrs,
+				AttributesExpiration: expiration,
+				EntryExpiration:      expiration,
+			},
+		}
+
+		n := fuseutil.WriteDirentPlus(op.Dst[op.BytesRead:], d)
+		if n == 0 {
+			break
+		}
+
+		op.BytesRead += n
+	}
+
+	return nil
+}
+
 func (fs *memFS) OpenFile(
 	ctx context.Context,
 	op *fuseops.OpenFileOp) error {
@@ -728,6 +796,17 @@ func (fs *memFS) FlushFile(
 		// FlushFileOp should have a valid pid in context.
 		return fuse.EINVAL
 	}
+
+	// Closing any file descriptor releases the POSIX locks held by its owner.
+	if op.LockOwner != 0 {
+		fs.mu.Lock()
+		defer fs.mu.Unlock()
+
+		inode := fs.getInodeOrDie(op.Inode)
+		inode.ReleasePosixLocks(op.LockOwner)
+		fs.notifyLocksChanged()
+	}
+
 	return
 }
 
@@ -860,3 +939,126 @@ func (fs *memFS) Fallocate(ctx context.Context,
 	inode.Fallocate(op.Mode, op.Offset, op.Length)
 	return nil
 }
+
+func (fs *memFS) SeekFile(
+	ctx context.Context,
+	op *fuseops.SeekFileOp) error {
+	if op.Whence != fuseops.SeekData && op.Whence != fuseops.SeekHole {
+		return fuse.EINVAL
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	inode := fs.getInodeOrDie(op.Inode)
+
+	var err error
+	op.NewOffset, err = inode.Seek(op.Offset, op.Whence)
+	return err
+}
+
+func (fs *memFS) CopyFileRange(
+	ctx context.Context,
+	op *fuseops.CopyFileRangeOp) error {
+	if op.OpContext.Pid == 0 {
+		return fuse.EINVAL
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	src := fs.getInodeOrDie(op.SrcInode)
+	dst := fs.getInodeOrDie(op.DstInode)
+
+	// Copy no more than the source has, and no more than fits in the reply.
+	length := op.Length
+	if length > math.MaxUint32 {
+		length = math.MaxUint32
+	}
+
+	size := uint64(len(src.contents))
+	if op.SrcOffset >= size {
+		return nil
+	}
+
+	if length > size-op.SrcOffset {
+		length = size - op.SrcOffset
+	}
+
+	// Take a copy, since the source and destination may be the same file.
+	data := make([]byte, length)
+	copy(data, src.contents[op.SrcOffset:])
+
+	n, err := dst.WriteAt(data, int64(op.DstOffset))
+	op.BytesCopied = uint64(n)
+	return err
+}
+
+func (fs *memFS) GetFileLock(
+	ctx context.Context,
+	op *fuseops.GetFileLockOp) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	inode := fs.getInodeOrDie(op.Inode)
+	op.Conflict = inode.TestPosixLock(op.Owner, op.Lock)
+
+	return nil
+}
+
+func (fs *memFS) SetFileLock(
+	ctx context.Context,
+	op *fuseops.SetFileLockOp) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	for {
+		inode := fs.getInodeOrDie(op.Inode)
+
+		var ok bool
+		if op.Flock {
+			ok = inode.Flock(op.Owner, op.Lock.Type)
+		} else {
+			ok = inode.SetPosixLock(op.Owner, op.Lock)
+		}
+
+		if ok {
+			fs.notifyLocksChanged()
+			return nil
+		}
+
+		if !op.Block {
+			return syscall.EAGAIN
+		}
+
+		// Wait for something to change, then try again.
+		changed := fs.locksChanged
+		fs.mu.Unlock()
+
+		select {
+		case <-changed:
+			fs.mu.Lock()
+
+		case <-ctx.Done():
+			fs.mu.Lock()
+			return ctx.Err()
+		}
+	}
+}
+
+func (fs *memFS) ReleaseFileHandle(
+	ctx context.Context,
+	op *fuseops.ReleaseFileHandleOp) error {
+	if !op.FlockUnlock {
+		return nil
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	inode := fs.getInodeOrDie(op.Inode)
+	inode.Flock(op.LockOwner, fuseops.LockUnlock)
+	fs.notifyLocksChanged()
+
+	return nil
+}
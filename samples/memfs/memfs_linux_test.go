@@ -0,0 +1,174 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memfs_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"syscall"
+	"time"
+
+	. "github.com/jacobsa/ogletest"
+	"golang.org/x/sys/unix"
+)
+
+////////////////////////////////////////////////////////////////////////
+// copy_file_range(2)
+////////////////////////////////////////////////////////////////////////
+
+func (t *MemFSTest) CopyFileRange() {
+	var err error
+	var n int
+
+	src, err := os.Create(path.Join(t.Dir, "src"))
+	t.ToClose = append(t.ToClose, src)
+	AssertEq(nil, err)
+
+	_, err = src.Write([]byte("tacoburrito"))
+	AssertEq(nil, err)
+
+	dst, err := os.Create(path.Join(t.Dir, "dst"))
+	t.ToClose = append(t.ToClose, dst)
+	AssertEq(nil, err)
+
+	_, err = dst.Write([]byte("enchilada"))
+	AssertEq(nil, err)
+
+	// Copy into the middle of the destination.
+	srcOff := int64(4)
+	dstOff := int64(2)
+	n, err = unix.CopyFileRange(int(src.Fd()), &srcOff, int(dst.Fd()), &dstOff, 4, 0)
+	AssertEq(nil, err)
+	ExpectEq(4, n)
+
+	contents, err := os.ReadFile(path.Join(t.Dir, "dst"))
+	AssertEq(nil, err)
+	ExpectEq("enburrada", string(contents))
+
+	// Copy past the end of the destination, running out of source data.
+	srcOff = 8
+	dstOff = 7
+	n, err = unix.CopyFileRange(int(src.Fd()), &srcOff, int(dst.Fd()), &dstOff, 100, 0)
+	AssertEq(nil, err)
+	ExpectEq(3, n)
+
+	contents, err = os.ReadFile(path.Join(t.Dir, "dst"))
+	AssertEq(nil, err)
+	ExpectEq("enburraito", string(contents))
+}
+
+////////////////////////////////////////////////////////////////////////
+// POSIX locks
+////////////////////////////////////////////////////////////////////////
+
+type PosixLockTest struct {
+	memFSTest
+}
+
+func init() { RegisterTestSuite(&PosixLockTest{}) }
+
+func (t *PosixLockTest) SetUp(ti *TestInfo) {
+	t.MountConfig.EnablePosixLocks = true
+	t.memFSTest.SetUp(ti)
+}
+
+// Open a file twice. Open file description locks (cf. fcntl(2)) taken through
+// the two files have different owners, even within this process.
+func (t *PosixLockTest) openTwice() (f1 *os.File, f2 *os.File) {
+	fileName := path.Join(t.Dir, "foo")
+
+	err := ioutil.WriteFile(fileName, []byte("taco"), 0600)
+	AssertEq(nil, err)
+
+	f1, err = os.OpenFile(fileName, os.O_RDWR, 0)
+	AssertEq(nil, err)
+	t.ToClose = append(t.ToClose, f1)
+
+	f2, err = os.OpenFile(fileName, os.O_RDWR, 0)
+	AssertEq(nil, err)
+	t.ToClose = append(t.ToClose, f2)
+
+	return f1, f2
+}
+
+func (t *PosixLockTest) RangesConflict() {
+	f1, f2 := t.openTwice()
+
+	lk := unix.Flock_t{Type: unix.F_WRLCK, Start: 0, Len: 10}
+	err := unix.FcntlFlock(f1.Fd(), unix.F_OFD_SETLK, &lk)
+	AssertEq(nil, err)
+
+	// An overlapping lock through the other file conflicts.
+	lk = unix.Flock_t{Type: unix.F_RDLCK, Start: 5, Len: 10}
+	err = unix.FcntlFlock(f2.Fd(), unix.F_OFD_SETLK, &lk)
+	ExpectEq(syscall.EAGAIN, err)
+
+	lk = unix.Flock_t{Type: unix.F_RDLCK, Start: 5, Len: 10}
+	err = unix.FcntlFlock(f2.Fd(), unix.F_OFD_GETLK, &lk)
+	AssertEq(nil, err)
+	ExpectEq(unix.F_WRLCK, lk.Type)
+	ExpectEq(0, lk.Start)
+	ExpectEq(10, lk.Len)
+
+	// A lock on a disjoint range doesn't.
+	lk = unix.Flock_t{Type: unix.F_WRLCK, Start: 10, Len: 10}
+	err = unix.FcntlFlock(f2.Fd(), unix.F_OFD_SETLK, &lk)
+	ExpectEq(nil, err)
+
+	// Unlocking part of the first lock frees that part only.
+	lk = unix.Flock_t{Type: unix.F_UNLCK, Start: 0, Len: 5}
+	err = unix.FcntlFlock(f1.Fd(), unix.F_OFD_SETLK, &lk)
+	AssertEq(nil, err)
+
+	lk = unix.Flock_t{Type: unix.F_WRLCK, Start: 0, Len: 5}
+	err = unix.FcntlFlock(f2.Fd(), unix.F_OFD_SETLK, &lk)
+	ExpectEq(nil, err)
+
+	lk = unix.Flock_t{Type: unix.F_WRLCK, Start: 5, Len: 1}
+	err = unix.FcntlFlock(f2.Fd(), unix.F_OFD_SETLK, &lk)
+	ExpectEq(syscall.EAGAIN, err)
+}
+
+func (t *PosixLockTest) BlockingWaitsForRelease() {
+	f1, f2 := t.openTwice()
+
+	lk := unix.Flock_t{Type: unix.F_WRLCK}
+	err := unix.FcntlFlock(f1.Fd(), unix.F_OFD_SETLK, &lk)
+	AssertEq(nil, err)
+
+	// Start a blocking acquisition in the background.
+	acquired := make(chan error, 1)
+	go func() {
+		lk := unix.Flock_t{Type: unix.F_WRLCK}
+		acquired <- unix.FcntlFlock(f2.Fd(), unix.F_OFD_SETLKW, &lk)
+	}()
+
+	// It shouldn't succeed while the lock is held.
+	select {
+	case err := <-acquired:
+		AddFailure("Acquired lock early, err: %v", err)
+		AbortTest()
+
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// Releasing the lock should allow it through.
+	lk = unix.Flock_t{Type: unix.F_UNLCK}
+	err = unix.FcntlFlock(f1.Fd(), unix.F_OFD_SETLK, &lk)
+	AssertEq(nil, err)
+
+	ExpectEq(nil, <-acquired)
+}
@@ -1886,6 +1886,51 @@ func (t *MemFSTest) RemoveXAttr() {
 	AssertEq(fuse.ENOATTR, err)
 }
 
+func (t *MemFSTest) SeekDataAndHole() {
+	// OS X doesn't send lseek to the file system.
+	if runtime.GOOS == "darwin" {
+		return
+	}
+
+	var err error
+	var off int64
+
+	// Create a file with data in its first and fourth blocks, and zeroes in
+	// between.
+	f, err := os.Create(path.Join(t.Dir, "foo"))
+	t.ToClose = append(t.ToClose, f)
+	AssertEq(nil, err)
+
+	_, err = f.WriteAt([]byte("taco"), 0)
+	AssertEq(nil, err)
+
+	_, err = f.WriteAt([]byte("burrito"), 3*4096)
+	AssertEq(nil, err)
+
+	fd := int(f.Fd())
+
+	off, err = unix.Seek(fd, 0, unix.SEEK_DATA)
+	AssertEq(nil, err)
+	ExpectEq(0, off)
+
+	off, err = unix.Seek(fd, 0, unix.SEEK_HOLE)
+	AssertEq(nil, err)
+	ExpectEq(4096, off)
+
+	off, err = unix.Seek(fd, 4100, unix.SEEK_DATA)
+	AssertEq(nil, err)
+	ExpectEq(3*4096, off)
+
+	// The end of the file is an implicit hole.
+	off, err = unix.Seek(fd, 3*4096+2, unix.SEEK_HOLE)
+	AssertEq(nil, err)
+	ExpectEq(3*4096+len("burrito"), off)
+
+	// Seeking past the end fails.
+	_, err = unix.Seek(fd, 4*4096, unix.SEEK_DATA)
+	ExpectEq(syscall.ENXIO, err)
+}
+
 ////////////////////////////////////////////////////////////////////////
 // Mknod
 ////////////////////////////////////////////////////////////////////////
@@ -2001,3 +2046,169 @@ func (t *MknodTest) Fallocate_Larger() {
 	AssertEq(nil, err)
 	ExpectEq("taco\x00\x00", string(contents))
 }
+
+////////////////////////////////////////////////////////////////////////
+// flock(2)
+////////////////////////////////////////////////////////////////////////
+
+type FlockTest struct {
+	memFSTest
+}
+
+func init() { RegisterTestSuite(&FlockTest{}) }
+
+func (t *FlockTest) SetUp(ti *TestInfo) {
+	t.MountConfig.EnableFlockLocks = true
+	t.memFSTest.SetUp(ti)
+}
+
+func (t *FlockTest) openTwice() (f1 *os.File, f2 *os.File) {
+	fileName := path.Join(t.Dir, "foo")
+
+	err := ioutil.WriteFile(fileName, []byte("taco"), 0600)
+	AssertEq(nil, err)
+
+	// Separate opens give separate lock owners.
+	f1, err = os.Open(fileName)
+	AssertEq(nil, err)
+	t.ToClose = append(t.ToClose, f1)
+
+	f2, err = os.Open(fileName)
+	AssertEq(nil, err)
+	t.ToClose = append(t.ToClose, f2)
+
+	return f1, f2
+}
+
+func (t *FlockTest) ExclusiveExcludesOthers() {
+	f1, f2 := t.openTwice()
+
+	err := syscall.Flock(int(f1.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	AssertEq(nil, err)
+
+	err = syscall.Flock(int(f2.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	ExpectEq(syscall.EWOULDBLOCK, err)
+
+	err = syscall.Flock(int(f2.Fd()), syscall.LOCK_SH|syscall.LOCK_NB)
+	ExpectEq(syscall.EWOULDBLOCK, err)
+
+	// Once released, the other file can take the lock.
+	err = syscall.Flock(int(f1.Fd()), syscall.LOCK_UN)
+	AssertEq(nil, err)
+
+	err = syscall.Flock(int(f2.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	ExpectEq(nil, err)
+}
+
+func (t *FlockTest) SharedLocksCoexist() {
+	f1, f2 := t.openTwice()
+
+	err := syscall.Flock(int(f1.Fd()), syscall.LOCK_SH|syscall.LOCK_NB)
+	AssertEq(nil, err)
+
+	err = syscall.Flock(int(f2.Fd()), syscall.LOCK_SH|syscall.LOCK_NB)
+	ExpectEq(nil, err)
+
+	err = syscall.Flock(int(f2.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	ExpectEq(syscall.EWOULDBLOCK, err)
+}
+
+func (t *FlockTest) BlockingWaitsForRelease() {
+	f1, f2 := t.openTwice()
+
+	err := syscall.Flock(int(f1.Fd()), syscall.LOCK_EX)
+	AssertEq(nil, err)
+
+	// Start a blocking acquisition in the background.
+	acquired := make(chan error, 1)
+	go func() {
+		acquired <- syscall.Flock(int(f2.Fd()), syscall.LOCK_EX)
+	}()
+
+	// It shouldn't succeed while the lock is held.
+	select {
+	case err := <-acquired:
+		AddFailure("Acquired lock early, err: %v", err)
+		AbortTest()
+
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// Releasing the lock should allow it through.
+	err = syscall.Flock(int(f1.Fd()), syscall.LOCK_UN)
+	AssertEq(nil, err)
+
+	ExpectEq(nil, <-acquired)
+}
+
+func (t *FlockTest) CloseReleasesLock() {
+	f1, f2 := t.openTwice()
+
+	err := syscall.Flock(int(f1.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	AssertEq(nil, err)
+
+	// Closing the only file descriptor for the first open file should release
+	// its lock, by way of ReleaseFileHandleOp. The release is asynchronous, so
+	// wait for it to show up.
+	err = f1.Close()
+	AssertEq(nil, err)
+
+	for i, c := range t.ToClose {
+		if c == f1 {
+			t.ToClose[i] = nil
+		}
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		err = syscall.Flock(int(f2.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err != syscall.EWOULDBLOCK || time.Now().After(deadline) {
+			break
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	ExpectEq(nil, err)
+}
+
+////////////////////////////////////////////////////////////////////////
+// readdirplus
+////////////////////////////////////////////////////////////////////////
+
+type ReaddirplusTest struct {
+	memFSTest
+}
+
+func init() { RegisterTestSuite(&ReaddirplusTest{}) }
+
+func (t *ReaddirplusTest) SetUp(ti *TestInfo) {
+	t.MountConfig.EnableReaddirplus = true
+	t.memFSTest.SetUp(ti)
+}
+
+func (t *ReaddirplusTest) ReadDirAndStat() {
+	var err error
+
+	// Create a file and a directory.
+	err = ioutil.WriteFile(path.Join(t.Dir, "foo"), []byte("taco"), 0600)
+	AssertEq(nil, err)
+
+	err = os.Mkdir(path.Join(t.Dir, "bar"), 0700)
+	AssertEq(nil, err)
+
+	// Listing the directory should give the attributes of each entry, which
+	// the kernel got along with the names. Entries come in the order they
+	// were created.
+	entries, err := fusetesting.ReadDirPicky(t.Dir)
+	AssertEq(nil, err)
+	AssertEq(2, len(entries))
+
+	ExpectEq("foo", entries[0].Name())
+	ExpectEq(4, entries[0].Size())
+	ExpectEq(0600, entries[0].Mode())
+
+	ExpectEq("bar", entries[1].Name())
+	ExpectTrue(entries[1].IsDir())
+	ExpectEq(os.ModeDir|0700, entries[1].Mode())
+}
@@ -0,0 +1,352 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Tests that call the file system directly, for behaviour that is awkward to
+// reach through a mount.
+
+package memfs
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/jacobsa/fuse/fuseops"
+	"github.com/jacobsa/fuse/internal/fusekernel"
+)
+
+var testOpContext = fuseops.OpContext{Pid: uint32(os.Getpid())}
+
+// Create a file with the given name and contents in the root of the file
+// system, returning its inode and an open handle.
+func createTestFile(
+	t *testing.T,
+	fs *memFS,
+	name string,
+	contents string) (fuseops.InodeID, fuseops.HandleID) {
+	ctx := context.Background()
+
+	create := &fuseops.CreateFileOp{
+		Parent:    fuseops.RootInodeID,
+		Name:      name,
+		Mode:      0600,
+		OpContext: testOpContext,
+	}
+	if err := fs.CreateFile(ctx, create); err != nil {
+		t.Fatalf("CreateFile: %v", err)
+	}
+
+	write := &fuseops.WriteFileOp{
+		Inode:     create.Entry.Child,
+		Handle:    create.Handle,
+		Data:      []byte(contents),
+		OpContext: testOpContext,
+	}
+	if err := fs.WriteFile(ctx, write); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	return create.Entry.Child, create.Handle
+}
+
+func TestCopyFileRange(t *testing.T) {
+	ctx := context.Background()
+	fs := newMemFS(uint32(os.Getuid()), uint32(os.Getgid()))
+	srcInode, srcHandle := createTestFile(t, fs, "src", "tacoburrito")
+	dstInode, dstHandle := createTestFile(t, fs, "dst", "enchilada")
+
+	testCases := []struct {
+		srcInode  fuseops.InodeID
+		srcOffset uint64
+		dstInode  fuseops.InodeID
+		dstOffset uint64
+		length    uint64
+		want      uint64
+		src       string
+		dst       string
+	}{
+		// Within the destination.
+		{srcInode, 4, dstInode, 2, 4, 4, "tacoburrito", "enburrada"},
+
+		// Past the end of the destination, running out of source data.
+		{srcInode, 8, dstInode, 7, 100, 3, "tacoburrito", "enburraito"},
+
+		// Starting past the end of the source.
+		{srcInode, 100, dstInode, 0, 4, 0, "tacoburrito", "enburraito"},
+
+		// Within the same file.
+		{srcInode, 0, srcInode, 7, 4, 4, "tacoburtaco", "enburraito"},
+	}
+
+	handles := map[fuseops.InodeID]fuseops.HandleID{
+		srcInode: srcHandle,
+		dstInode: dstHandle,
+	}
+
+	for i, tc := range testCases {
+		op := &fuseops.CopyFileRangeOp{
+			SrcInode:  tc.srcInode,
+			SrcHandle: handles[tc.srcInode],
+			SrcOffset: tc.srcOffset,
+			DstInode:  tc.dstInode,
+			DstHandle: handles[tc.dstInode],
+			DstOffset: tc.dstOffset,
+			Length:    tc.length,
+			OpContext: testOpContext,
+		}
+
+		if err := fs.CopyFileRange(ctx, op); err != nil {
+			t.Fatalf("%d: CopyFileRange: %v", i, err)
+		}
+
+		if op.BytesCopied != tc.want {
+			t.Errorf("%d: BytesCopied = %d, want %d", i, op.BytesCopied, tc.want)
+		}
+
+		if got := string(fs.inodes[srcInode].contents); got != tc.src {
+			t.Errorf("%d: src = %q, want %q", i, got, tc.src)
+		}
+
+		if got := string(fs.inodes[dstInode].contents); got != tc.dst {
+			t.Errorf("%d: dst = %q, want %q", i, got, tc.dst)
+		}
+	}
+}
+
+func TestPosixLocks(t *testing.T) {
+	ctx := context.Background()
+	fs := newMemFS(uint32(os.Getuid()), uint32(os.Getgid()))
+	inode, handle := createTestFile(t, fs, "foo", "taco")
+
+	setLock := func(owner uint64, typ fuseops.LockType, start, end uint64) error {
+		return fs.SetFileLock(ctx, &fuseops.SetFileLockOp{
+			Inode:  inode,
+			Handle: handle,
+			Owner:  owner,
+			Lock: fuseops.FileLock{
+				Start: start,
+				End:   end,
+				Type:  typ,
+				Pid:   uint32(owner),
+			},
+			OpContext: testOpContext,
+		})
+	}
+
+	getLock := func(owner uint64, typ fuseops.LockType, start, end uint64) fuseops.FileLock {
+		op := &fuseops.GetFileLockOp{
+			Inode:     inode,
+			Handle:    handle,
+			Owner:     owner,
+			Lock:      fuseops.FileLock{Start: start, End: end, Type: typ},
+			OpContext: testOpContext,
+		}
+
+		if err := fs.GetFileLock(ctx, op); err != nil {
+			t.Fatalf("GetFileLock: %v", err)
+		}
+
+		return op.Conflict
+	}
+
+	// Owner 1 write-locks [0, 9] and owner 2 read-locks [20, 29].
+	if err := setLock(1, fuseops.LockWrite, 0, 9); err != nil {
+		t.Fatalf("SetFileLock: %v", err)
+	}
+
+	if err := setLock(2, fuseops.LockRead, 20, 29); err != nil {
+		t.Fatalf("SetFileLock: %v", err)
+	}
+
+	// Overlapping locks conflict, unless both are read locks.
+	if err := setLock(2, fuseops.LockRead, 5, 14); err != syscall.EAGAIN {
+		t.Errorf("SetFileLock: %v, want EAGAIN", err)
+	}
+
+	if err := setLock(3, fuseops.LockRead, 25, 34); err != nil {
+		t.Errorf("SetFileLock: %v", err)
+	}
+
+	want := fuseops.FileLock{Start: 0, End: 9, Type: fuseops.LockWrite, Pid: 1}
+	if got := getLock(2, fuseops.LockRead, 5, 14); got != want {
+		t.Errorf("GetFileLock = %+v, want %+v", got, want)
+	}
+
+	// An owner's own locks never conflict.
+	if got := getLock(1, fuseops.LockWrite, 0, 9); got.Type != fuseops.LockUnlock {
+		t.Errorf("GetFileLock = %+v, want no conflict", got)
+	}
+
+	// Unlocking the middle of a lock splits it.
+	if err := setLock(1, fuseops.LockUnlock, 3, 5); err != nil {
+		t.Fatalf("SetFileLock: %v", err)
+	}
+
+	if got := getLock(2, fuseops.LockWrite, 3, 5); got.Type != fuseops.LockUnlock {
+		t.Errorf("GetFileLock = %+v, want no conflict", got)
+	}
+
+	want = fuseops.FileLock{Start: 6, End: 9, Type: fuseops.LockWrite, Pid: 1}
+	if got := getLock(2, fuseops.LockRead, 5, 14); got != want {
+		t.Errorf("GetFileLock = %+v, want %+v", got, want)
+	}
+
+	// A blocking request waits until the conflicting lock is released, here by
+	// its owner closing a file descriptor.
+	acquired := make(chan error, 1)
+	go func() {
+		acquired <- fs.SetFileLock(ctx, &fuseops.SetFileLockOp{
+			Inode:     inode,
+			Handle:    handle,
+			Owner:     2,
+			Lock:      fuseops.FileLock{Start: 0, End: 9, Type: fuseops.LockWrite},
+			Block:     true,
+			OpContext: testOpContext,
+		})
+	}()
+
+	select {
+	case err := <-acquired:
+		t.Fatalf("Acquired lock early, err: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	err := fs.FlushFile(ctx, &fuseops.FlushFileOp{
+		Inode:     inode,
+		Handle:    handle,
+		LockOwner: 1,
+		OpContext: testOpContext,
+	})
+	if err != nil {
+		t.Fatalf("FlushFile: %v", err)
+	}
+
+	if err := <-acquired; err != nil {
+		t.Errorf("SetFileLock: %v", err)
+	}
+
+	// Cancelling a blocking request makes it give up.
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+
+	err = fs.SetFileLock(ctx, &fuseops.SetFileLockOp{
+		Inode:     inode,
+		Handle:    handle,
+		Owner:     1,
+		Lock:      fuseops.FileLock{Start: 0, End: 9, Type: fuseops.LockRead},
+		Block:     true,
+		OpContext: testOpContext,
+	})
+	if err != context.Canceled {
+		t.Errorf("SetFileLock: %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestReadDirPlus(t *testing.T) {
+	ctx := context.Background()
+	fs := newMemFS(uint32(os.Getuid()), uint32(os.Getgid()))
+	foo, _ := createTestFile(t, fs, "foo", "taco")
+	bar, _ := createTestFile(t, fs, "bar", "burrito")
+
+	// Leave a gap in the directory's entries.
+	createTestFile(t, fs, "baz", "")
+	unlink := &fuseops.UnlinkOp{
+		Parent:    fuseops.RootInodeID,
+		Name:      "baz",
+		OpContext: testOpContext,
+	}
+	if err := fs.Unlink(ctx, unlink); err != nil {
+		t.Fatalf("Unlink: %v", err)
+	}
+
+	readDirPlus := func(offset fuseops.DirOffset, size int) []byte {
+		op := &fuseops.ReadDirPlusOp{
+			Inode:     fuseops.RootInodeID,
+			Offset:    offset,
+			Dst:       make([]byte, size),
+			OpContext: testOpContext,
+		}
+
+		if err := fs.ReadDirPlus(ctx, op); err != nil {
+			t.Fatalf("ReadDirPlus: %v", err)
+		}
+
+		return op.Dst[:op.BytesRead]
+	}
+
+	// Each record is a fuse_entry_out followed by a fuse_dirent and the name,
+	// padded to eight bytes. The names here are short enough for that to make
+	// the record a fixed size.
+	const entrySize = int(unsafe.Sizeof(fusekernel.EntryOut{}))
+	const recordSize = entrySize + fusekernel.DirentSize + 8
+
+	type entry struct {
+		inode  fuseops.InodeID
+		size   uint64
+		name   string
+		offset fuseops.DirOffset
+	}
+
+	parse := func(b []byte) (entries []entry) {
+		for ; len(b) >= recordSize; b = b[recordSize:] {
+			out := (*fusekernel.EntryOut)(unsafe.Pointer(&b[0]))
+			d := (*fusekernel.Dirent)(unsafe.Pointer(&b[entrySize]))
+			name := b[entrySize+fusekernel.DirentSize:][:d.Namelen]
+
+			if out.Nodeid != d.Ino {
+				t.Errorf("Nodeid %d doesn't match Ino %d", out.Nodeid, d.Ino)
+			}
+
+			entries = append(entries, entry{
+				fuseops.InodeID(out.Nodeid),
+				out.Attr.Size,
+				string(name),
+				fuseops.DirOffset(d.Off),
+			})
+		}
+
+		if len(b) != 0 {
+			t.Errorf("%d trailing bytes", len(b))
+		}
+
+		return entries
+	}
+
+	want := []entry{
+		{foo, 4, "foo", 1},
+		{bar, 7, "bar", 2},
+	}
+
+	if got := parse(readDirPlus(0, 4096)); !reflect.DeepEqual(got, want) {
+		t.Errorf("Entries = %+v, want %+v", got, want)
+	}
+
+	// Reading resumes from the offset of the last entry returned, and stops
+	// short rather than splitting an entry.
+	if got := parse(readDirPlus(0, recordSize+1)); !reflect.DeepEqual(got, want[:1]) {
+		t.Errorf("Entries = %+v, want %+v", got, want[:1])
+	}
+
+	if got := parse(readDirPlus(1, 4096)); !reflect.DeepEqual(got, want[1:]) {
+		t.Errorf("Entries = %+v, want %+v", got, want[1:])
+	}
+
+	if got := parse(readDirPlus(2, 4096)); len(got) != 0 {
+		t.Errorf("Entries = %+v, want none", got)
+	}
+}
@@ -27,6 +27,7 @@ import (
 var fPhysicalPath = flag.String("path", "", "Physical path to loopback.")
 var fMountPoint = flag.String("mount_point", "", "Path to mount point.")
 
+var fPassthrough = flag.Bool("passthrough", false, "Pass reads through to the physical files.")
 var fDebug = flag.Bool("debug", false, "Enable debug logging.")
 
 func main() {
@@ -48,14 +49,20 @@ func main() {
 		log.Fatalf("Failed to create mount point at '%v'", *fMountPoint)
 	}
 
-	server, err := roloopbackfs.NewReadonlyLoopbackServer(*fPhysicalPath, errorLogger)
+	newServer := roloopbackfs.NewReadonlyLoopbackServer
+	if *fPassthrough {
+		newServer = roloopbackfs.NewReadonlyLoopbackPassthroughServer
+	}
+
+	server, err := newServer(*fPhysicalPath, errorLogger)
 	if err != nil {
 		log.Fatalf("makeFS: %v", err)
 	}
 
 	cfg := &fuse.MountConfig{
-		ReadOnly:    true,
-		ErrorLogger: errorLogger,
+		ReadOnly:          true,
+		EnablePassthrough: *fPassthrough,
+		ErrorLogger:       errorLogger,
 	}
 
 	if *fDebug {
@@ -30,6 +30,9 @@ type readonlyLoopbackFs struct {
 	loopbackPath string
 	inodes       *sync.Map
 	logger       *log.Logger
+
+	// Whether to offer the kernel backing files for passthrough.
+	passthrough bool
 }
 
 var _ fuseutil.FileSystem = &readonlyLoopbackFs{}
@@ -37,7 +40,17 @@ var _ fuseutil.FileSystem = &readonlyLoopbackFs{}
 // Create a file system that mirrors an existing physical path, in a readonly mode
 
 func NewReadonlyLoopbackServer(loopbackPath string, logger *log.Logger) (server fuse.Server, err error) {
+	return newServer(loopbackPath, logger, false)
+}
+
+// Like NewReadonlyLoopbackServer, but offer the kernel the physical file
+// whenever a file is opened, so that reads can be passed through to it. The
+// file system should be mounted with MountConfig.EnablePassthrough set.
+func NewReadonlyLoopbackPassthroughServer(loopbackPath string, logger *log.Logger) (server fuse.Server, err error) {
+	return newServer(loopbackPath, logger, true)
+}
 
+func newServer(loopbackPath string, logger *log.Logger, passthrough bool) (server fuse.Server, err error) {
 	if _, err = os.Stat(loopbackPath); err != nil {
 		return nil, err
 	}
@@ -52,6 +65,7 @@ func NewReadonlyLoopbackServer(loopbackPath string, logger *log.Logger) (server
 		loopbackPath: loopbackPath,
 		inodes:       inodes,
 		logger:       logger,
+		passthrough:  passthrough,
 	})
 	return
 }
@@ -140,6 +154,25 @@ func (fs *readonlyLoopbackFs) OpenFile(
 	ctx context.Context,
 	op *fuseops.OpenFileOp) error {
 	// Allow opening any file.
+	if !fs.passthrough {
+		return nil
+	}
+
+	var entry, found = fs.inodes.Load(op.Inode)
+	if !found {
+		return fuse.ENOENT
+	}
+
+	// Offer the kernel the underlying file, so that it can serve reads without
+	// going through ReadFile. The connection closes it once it has replied. If
+	// it can't be opened, reads go through ReadFile as usual.
+	file, err := os.Open(entry.(Inode).Path())
+	if err != nil {
+		fs.logger.Printf("fs.OpenFile for '%v': %v", entry, err)
+		return nil
+	}
+
+	op.BackingFile = file
 	return nil
 }
 
@@ -39,10 +39,14 @@ func TestReadonlyLoopbackFS(t *testing.T) { RunTests(t) }
 type ReadonlyLoopbackFSTest struct {
 	samples.SampleTest
 	physicalPath string
+
+	// Whether to serve reads by passthrough where possible.
+	passthrough bool
 }
 
 func init() {
 	RegisterTestSuite(&ReadonlyLoopbackFSTest{})
+	RegisterTestSuite(&PassthroughTest{})
 	rand.Seed(time.Now().UnixNano())
 }
 
@@ -61,7 +65,12 @@ func (t *ReadonlyLoopbackFSTest) SetUp(ti *TestInfo) {
 
 	t.fillPhysicalFS()
 
-	t.Server, err = roloopbackfs.NewReadonlyLoopbackServer(
+	newServer := roloopbackfs.NewReadonlyLoopbackServer
+	if t.passthrough {
+		newServer = roloopbackfs.NewReadonlyLoopbackPassthroughServer
+	}
+
+	t.Server, err = newServer(
 		t.physicalPath,
 		log.New(os.Stdout, "", 0),
 	)
@@ -176,3 +185,19 @@ func (t *ReadonlyLoopbackFSTest) ReadFile() {
 	AssertEq(nil, err)
 	AssertEq(20, len(bytes))
 }
+
+////////////////////////////////////////////////////////////////////////
+// Passthrough
+////////////////////////////////////////////////////////////////////////
+
+// The same tests, with reads passed through to the physical files where the
+// kernel and our privileges allow it.
+type PassthroughTest struct {
+	ReadonlyLoopbackFSTest
+}
+
+func (t *PassthroughTest) SetUp(ti *TestInfo) {
+	t.MountConfig.EnablePassthrough = true
+	t.passthrough = true
+	t.ReadonlyLoopbackFSTest.SetUp(ti)
+}
@@ -0,0 +1,450 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fuse_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/jacobsa/fuse"
+	"github.com/jacobsa/fuse/fuseops"
+	"github.com/jacobsa/fuse/fuseutil"
+	"github.com/jacobsa/fuse/internal/fusekernel"
+)
+
+////////////////////////////////////////////////////////////////////////
+// Fake kernel
+////////////////////////////////////////////////////////////////////////
+
+// Plays the kernel's part for a server, over a socket that keeps message
+// boundaries as /dev/fuse does.
+type fakeKernel struct {
+	t   *testing.T
+	dev *os.File
+}
+
+// Serve ops from a fake kernel with the given server until the test ends.
+func serveFake(t *testing.T, cfg fuse.MountConfig, server fuse.Server) *fakeKernel {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_SEQPACKET, 0)
+	if err != nil {
+		t.Fatalf("Socketpair: %v", err)
+	}
+
+	// Let the kernel's end have deadlines, so that a server that never replies
+	// fails the test rather than hanging it.
+	if err := syscall.SetNonblock(fds[1], true); err != nil {
+		t.Fatalf("SetNonblock: %v", err)
+	}
+
+	dev := os.NewFile(uintptr(fds[0]), "dev")
+	k := &fakeKernel{t: t, dev: os.NewFile(uintptr(fds[1]), "kernel")}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		server.ServeOps(fuse.NewTestConnection(cfg, dev))
+	}()
+
+	// Hang up once the test is done with the server, and wait for it to
+	// notice.
+	t.Cleanup(func() {
+		k.dev.Close()
+		<-done
+		dev.Close()
+	})
+
+	return k
+}
+
+// Send a request for the given inode.
+func (k *fakeKernel) send(
+	unique uint64,
+	opcode uint32,
+	inode fuseops.InodeID,
+	body ...interface{}) {
+	var b bytes.Buffer
+	h := fusekernel.InHeader{
+		Opcode: opcode,
+		Unique: unique,
+		Nodeid: uint64(inode),
+	}
+
+	binary.Write(&b, binary.LittleEndian, h)
+	for _, v := range body {
+		binary.Write(&b, binary.LittleEndian, v)
+	}
+
+	msg := b.Bytes()
+	binary.LittleEndian.PutUint32(msg, uint32(len(msg)))
+
+	if _, err := k.dev.Write(msg); err != nil {
+		k.t.Fatalf("Write: %v", err)
+	}
+}
+
+// Read the next reply.
+func (k *fakeKernel) reply() fusekernel.OutHeader {
+	k.t.Helper()
+
+	buf := make([]byte, 1<<17)
+	k.dev.SetReadDeadline(time.Now().Add(10 * time.Second))
+	n, err := k.dev.Read(buf)
+	if err != nil {
+		k.t.Fatalf("Read: %v", err)
+	}
+
+	var h fusekernel.OutHeader
+	binary.Read(bytes.NewReader(buf[:n]), binary.LittleEndian, &h)
+	return h
+}
+
+////////////////////////////////////////////////////////////////////////
+// Blocking file system
+////////////////////////////////////////////////////////////////////////
+
+// A file system whose GetInodeAttributes, ReadFile, ForgetInode and
+// SetFileLock announce each call and then wait to be released. SetFileLock
+// also returns early if interrupted.
+type blockingFS struct {
+	fuseutil.NotImplementedFileSystem
+
+	// Receives the inode of each call as it starts.
+	started chan fuseops.InodeID
+
+	mu sync.Mutex
+
+	// Closed to let calls for the inode return.
+	//
+	// GUARDED_BY(mu)
+	release map[fuseops.InodeID]chan struct{}
+
+	// Set once all calls may return.
+	//
+	// GUARDED_BY(mu)
+	unblocked bool
+}
+
+func newBlockingFS() *blockingFS {
+	return &blockingFS{
+		started: make(chan fuseops.InodeID, 100),
+		release: make(map[fuseops.InodeID]chan struct{}),
+	}
+}
+
+// LOCKS_EXCLUDED(fs.mu)
+func (fs *blockingFS) gate(id fuseops.InodeID) chan struct{} {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	g := fs.release[id]
+	if g == nil {
+		g = make(chan struct{})
+		fs.release[id] = g
+
+		if fs.unblocked {
+			close(g)
+		}
+	}
+
+	return g
+}
+
+// Let calls for the inode return.
+func (fs *blockingFS) unblock(id fuseops.InodeID) {
+	close(fs.gate(id))
+}
+
+// Let all calls return, so that the server can finish when the test does.
+//
+// LOCKS_EXCLUDED(fs.mu)
+func (fs *blockingFS) unblockAll() {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	fs.unblocked = true
+	for _, g := range fs.release {
+		select {
+		case <-g:
+		default:
+			close(g)
+		}
+	}
+}
+
+func (fs *blockingFS) block(id fuseops.InodeID) {
+	fs.started <- id
+	<-fs.gate(id)
+}
+
+func (fs *blockingFS) GetInodeAttributes(
+	ctx context.Context,
+	op *fuseops.GetInodeAttributesOp) error {
+	fs.block(op.Inode)
+	return nil
+}
+
+func (fs *blockingFS) ReadFile(
+	ctx context.Context,
+	op *fuseops.ReadFileOp) error {
+	fs.block(op.Inode)
+	return nil
+}
+
+func (fs *blockingFS) ForgetInode(
+	ctx context.Context,
+	op *fuseops.ForgetInodeOp) error {
+	fs.block(op.Inode)
+	return nil
+}
+
+func (fs *blockingFS) SetFileLock(
+	ctx context.Context,
+	op *fuseops.SetFileLockOp) error {
+	fs.started <- op.Inode
+
+	select {
+	case <-fs.gate(op.Inode):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Return the inode of the next call to start.
+func (fs *blockingFS) next(t *testing.T) fuseops.InodeID {
+	t.Helper()
+
+	select {
+	case id := <-fs.started:
+		return id
+	case <-time.After(10 * time.Second):
+		t.Fatal("Timed out waiting for a call")
+		return 0
+	}
+}
+
+// Check that no further call starts.
+func (fs *blockingFS) expectNone(t *testing.T) {
+	t.Helper()
+
+	select {
+	case id := <-fs.started:
+		t.Fatalf("Unexpected call for inode %d", id)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func TestInodeSerialization(t *testing.T) {
+	fs := newBlockingFS()
+	defer fs.unblockAll()
+
+	k := serveFake(t, fuse.MountConfig{}, fuseutil.NewFileSystemServer(
+		fs,
+		fuseutil.WithInodeSerialization()))
+
+	// Two ops for one inode, and one for another.
+	k.send(1, fusekernel.OpGetattr, 2, fusekernel.GetattrIn{})
+	if id := fs.next(t); id != 2 {
+		t.Fatalf("Started inode %d, want 2", id)
+	}
+
+	k.send(2, fusekernel.OpGetattr, 2, fusekernel.GetattrIn{})
+	k.send(3, fusekernel.OpGetattr, 3, fusekernel.GetattrIn{})
+
+	// The unrelated op goes ahead, while the other waits its turn.
+	if id := fs.next(t); id != 3 {
+		t.Fatalf("Started inode %d, want 3", id)
+	}
+
+	fs.expectNone(t)
+
+	// A forget for the busy inode waits too, but doesn't hold up reading.
+	k.send(4, fusekernel.OpForget, 2, fusekernel.ForgetIn{Nlookup: 1})
+	k.send(5, fusekernel.OpGetattr, 4, fusekernel.GetattrIn{})
+	if id := fs.next(t); id != 4 {
+		t.Fatalf("Started inode %d, want 4", id)
+	}
+
+	fs.expectNone(t)
+
+	// Once the first op for inode 2 returns, the rest follow one at a time.
+	// The gate stays open, so each returns as soon as it starts.
+	fs.unblock(3)
+	fs.unblock(4)
+	fs.unblock(2)
+
+	for i := 0; i < 2; i++ {
+		if id := fs.next(t); id != 2 {
+			t.Fatalf("Started inode %d, want 2", id)
+		}
+	}
+
+	// Every op but the forget gets a reply.
+	replies := make(map[uint64]bool)
+	for i := 0; i < 4; i++ {
+		h := k.reply()
+		if h.Error != 0 {
+			t.Errorf("Reply to %d: error %d", h.Unique, h.Error)
+		}
+
+		replies[h.Unique] = true
+	}
+
+	for _, unique := range []uint64{1, 2, 3, 5} {
+		if !replies[unique] {
+			t.Errorf("No reply to %d", unique)
+		}
+	}
+}
+
+func TestMaxOpsInFlight(t *testing.T) {
+	fs := newBlockingFS()
+	defer fs.unblockAll()
+
+	k := serveFake(t, fuse.MountConfig{}, fuseutil.NewFileSystemServer(
+		fs,
+		fuseutil.WithMaxOpsInFlight(2)))
+
+	k.send(1, fusekernel.OpGetattr, 2, fusekernel.GetattrIn{})
+	k.send(2, fusekernel.OpGetattr, 3, fusekernel.GetattrIn{})
+	k.send(3, fusekernel.OpGetattr, 4, fusekernel.GetattrIn{})
+
+	// Only two ops are taken from the kernel.
+	started := map[fuseops.InodeID]bool{fs.next(t): true, fs.next(t): true}
+	if !started[2] || !started[3] {
+		t.Fatalf("Started %v, want 2 and 3", started)
+	}
+
+	fs.expectNone(t)
+
+	// Once one is replied to, the third is read.
+	fs.unblock(2)
+	if h := k.reply(); h.Unique != 1 {
+		t.Fatalf("Reply to %d, want 1", h.Unique)
+	}
+
+	if id := fs.next(t); id != 4 {
+		t.Fatalf("Started inode %d, want 4", id)
+	}
+}
+
+func TestDataOpsDontStarveMetadataOps(t *testing.T) {
+	fs := newBlockingFS()
+	defer fs.unblockAll()
+
+	k := serveFake(t, fuse.MountConfig{}, fuseutil.NewFileSystemServer(
+		fs,
+		fuseutil.WithMaxOpsInFlight(2),
+		fuseutil.WithDataOpWorkers(1)))
+
+	// A burst of reads, more than the limit on ops in flight, of which one at
+	// a time may call the file system.
+	for i := 0; i < 4; i++ {
+		k.send(uint64(i+1), fusekernel.OpRead, fuseops.InodeID(i+2), fusekernel.ReadIn{Size: 1})
+	}
+
+	k.send(5, fusekernel.OpGetattr, 10, fusekernel.GetattrIn{})
+
+	// The reads waiting for the worker don't keep the metadata op from being
+	// read and handled.
+	var reads int
+	var getattr bool
+	for i := 0; i < 2; i++ {
+		if id := fs.next(t); id == 10 {
+			getattr = true
+		} else {
+			reads++
+		}
+	}
+
+	if !getattr || reads != 1 {
+		t.Fatalf("Started %d reads and getattr %v", reads, getattr)
+	}
+
+	fs.expectNone(t)
+}
+
+func TestBlockingLockInterrupted(t *testing.T) {
+	fs := newBlockingFS()
+	defer fs.unblockAll()
+
+	k := serveFake(t, fuse.MountConfig{}, fuseutil.NewFileSystemServer(
+		fs,
+		fuseutil.WithMaxOpsInFlight(1)))
+
+	// A lock request waits for the lock for as long as it takes.
+	k.send(1, fusekernel.OpSetlkw, 2, fusekernel.LkIn{})
+	if id := fs.next(t); id != 2 {
+		t.Fatalf("Started inode %d, want 2", id)
+	}
+
+	// Meanwhile other ops, and the interrupt for the lock request, are still
+	// read.
+	k.send(2, fusekernel.OpGetattr, 3, fusekernel.GetattrIn{})
+	if id := fs.next(t); id != 3 {
+		t.Fatalf("Started inode %d, want 3", id)
+	}
+
+	fs.unblock(3)
+	if h := k.reply(); h.Unique != 2 || h.Error != 0 {
+		t.Fatalf("Reply %+v, want success for 2", h)
+	}
+
+	k.send(3, fusekernel.OpInterrupt, 0, fusekernel.InterruptIn{Unique: 1})
+	if h := k.reply(); h.Unique != 1 || h.Error != -int32(syscall.EINTR) {
+		t.Fatalf("Reply %+v, want EINTR for 1", h)
+	}
+}
+
+func TestBlockingLockHoldsNoWorker(t *testing.T) {
+	fs := newBlockingFS()
+	defer fs.unblockAll()
+
+	k := serveFake(t, fuse.MountConfig{}, fuseutil.NewFileSystemServer(
+		fs,
+		fuseutil.WithMetadataOpWorkers(1)))
+
+	// A lock request waits for the lock.
+	var wait fusekernel.LkIn
+	wait.Lk.Type = syscall.F_WRLCK
+	k.send(1, fusekernel.OpSetlkw, 2, wait)
+	if id := fs.next(t); id != 2 {
+		t.Fatalf("Started inode %d, want 2", id)
+	}
+
+	// The request releasing the lock still reaches the file system.
+	var unlock fusekernel.LkIn
+	unlock.Lk.Type = syscall.F_UNLCK
+	k.send(2, fusekernel.OpSetlk, 2, unlock)
+	if id := fs.next(t); id != 2 {
+		t.Fatalf("Started inode %d, want 2", id)
+	}
+
+	fs.unblock(2)
+	replies := map[uint64]bool{k.reply().Unique: true, k.reply().Unique: true}
+	if !replies[1] || !replies[2] {
+		t.Fatalf("Replies to %v, want 1 and 2", replies)
+	}
+}
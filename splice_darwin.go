@@ -0,0 +1,62 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fuse
+
+import (
+	"errors"
+	"os"
+
+	"github.com/jacobsa/fuse/fuseops"
+	"github.com/jacobsa/fuse/internal/buffer"
+	"github.com/jacobsa/fuse/internal/fusekernel"
+)
+
+var errSpliceUnsupported = errors.New("splice(2) is not supported on OS X")
+
+// A pipe used for moving data to and from /dev/fuse with splice(2). Splicing
+// is never enabled on OS X, so none are ever created.
+type pipe struct {
+	r *os.File
+	w *os.File
+}
+
+func newPipe() (*pipe, error) {
+	return nil, errSpliceUnsupported
+}
+
+func (p *pipe) buffered() (int, error) {
+	return 0, errSpliceUnsupported
+}
+
+func (p *pipe) close() {
+	p.r.Close()
+	p.w.Close()
+}
+
+func (r *reader) readSplicedMessage(
+	protocol fusekernel.Protocol) (*buffer.InMessage, *pipe, error) {
+	return nil, nil, errSpliceUnsupported
+}
+
+func (r *reader) spliceFileData(fr fuseops.FileRange) (*pipe, int, error) {
+	return nil, 0, errSpliceUnsupported
+}
+
+func (r *reader) writeSplicedMessage(
+	header []byte,
+	data *pipe,
+	n int) error {
+	return errSpliceUnsupported
+}
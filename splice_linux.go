@@ -0,0 +1,220 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fuse
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+
+	"github.com/jacobsa/fuse/fuseops"
+	"github.com/jacobsa/fuse/internal/buffer"
+	"github.com/jacobsa/fuse/internal/fusekernel"
+	"golang.org/x/sys/unix"
+)
+
+// The capacity we ask for when creating pipes. The kernel insists that an
+// entire message fit in the pipe, and counts its capacity in pages: one for
+// the header and arguments, plus one for each page of data, which need not be
+// page-aligned.
+var pipeSize = buffer.MaxWriteSize + 2*os.Getpagesize()
+
+// A pipe used for moving data to and from /dev/fuse with splice(2).
+type pipe struct {
+	r *os.File
+	w *os.File
+}
+
+// Create a pipe with room for any message.
+func newPipe() (*pipe, error) {
+	var fds [2]int
+	if err := unix.Pipe2(fds[:], unix.O_CLOEXEC); err != nil {
+		return nil, fmt.Errorf("Pipe2: %v", err)
+	}
+
+	if _, err := unix.FcntlInt(uintptr(fds[0]), unix.F_SETPIPE_SZ, pipeSize); err != nil {
+		unix.Close(fds[0])
+		unix.Close(fds[1])
+		return nil, fmt.Errorf("F_SETPIPE_SZ: %v", err)
+	}
+
+	p := &pipe{
+		r: os.NewFile(uintptr(fds[0]), "pipe"),
+		w: os.NewFile(uintptr(fds[1]), "pipe"),
+	}
+
+	return p, nil
+}
+
+// Return the number of bytes waiting to be read from the pipe. (TIOCINQ is
+// the same request as FIONREAD.)
+func (p *pipe) buffered() (int, error) {
+	return unix.IoctlGetInt(int(p.r.Fd()), unix.TIOCINQ)
+}
+
+func (p *pipe) close() {
+	p.r.Close()
+	p.w.Close()
+}
+
+// Splice up to n bytes from one descriptor to another, looping past EINTR.
+func splice(
+	dst *os.File,
+	src *os.File,
+	srcOff *int64,
+	n int) (int, error) {
+	for {
+		written, err := unix.Splice(
+			int(src.Fd()),
+			srcOff,
+			int(dst.Fd()),
+			nil,
+			n,
+			unix.SPLICE_F_MOVE)
+
+		if err == syscall.EINTR {
+			continue
+		}
+
+		return int(written), err
+	}
+}
+
+// Like readMessage, but splices the message into a pipe first. The data of a
+// write request is left in the pipe, which is returned along with the
+// message; the caller must later return it with putPipe. For other requests
+// the returned pipe is nil.
+func (r *reader) readSplicedMessage(
+	protocol fusekernel.Protocol) (*buffer.InMessage, *pipe, error) {
+	p, err := r.getPipe()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	n, err := splice(p.w, r.dev, nil, pipeSize)
+	if err != nil {
+		r.putPipe(p)
+		if err == syscall.ENODEV {
+			err = io.EOF
+		}
+
+		return nil, nil, err
+	}
+
+	m := r.getInMessage()
+	if err := readFromPipe(m, p, n, protocol); err != nil {
+		r.putInMessage(m)
+		r.putPipe(p)
+		return nil, nil, err
+	}
+
+	if m.Pending() == 0 {
+		r.putPipe(p)
+		p = nil
+	}
+
+	return m, p, nil
+}
+
+// Read the spliced message of size n from the supplied pipe into m, leaving
+// the data of a write request in the pipe.
+func readFromPipe(
+	m *buffer.InMessage,
+	p *pipe,
+	n int,
+	protocol fusekernel.Protocol) error {
+	if err := m.InitHeader(p.r, n); err != nil {
+		return err
+	}
+
+	body := m.Pending()
+	if m.Header().Opcode == fusekernel.OpWrite {
+		if in := int(fusekernel.WriteInSize(protocol)); in < body {
+			body = in
+		}
+	}
+
+	return m.ReadBody(p.r, body)
+}
+
+// Splice the data described by fr into a pipe, returning the pipe and the
+// number of bytes it holds, which is less than fr.Size at end of file. The
+// caller must later return the pipe with putPipe.
+func (r *reader) spliceFileData(fr fuseops.FileRange) (*pipe, int, error) {
+	p, err := r.getPipe()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	off := fr.Offset
+	var n int
+	for int64(n) < fr.Size {
+		written, err := splice(p.w, fr.File, &off, int(fr.Size)-n)
+		if err != nil {
+			r.putPipe(p)
+			return nil, 0, err
+		}
+
+		// EOF
+		if written == 0 {
+			break
+		}
+
+		n += written
+	}
+
+	return p, n, nil
+}
+
+// Write a message consisting of the supplied header followed by the n bytes
+// of data held in the supplied pipe, without copying the data.
+func (r *reader) writeSplicedMessage(
+	header []byte,
+	data *pipe,
+	n int) error {
+	// The kernel wants the whole message in a single pipe, so assemble it in
+	// another.
+	p, err := r.getPipe()
+	if err != nil {
+		return err
+	}
+
+	defer r.putPipe(p)
+
+	if _, err := p.w.Write(header); err != nil {
+		return err
+	}
+
+	for spliced := 0; spliced < n; {
+		written, err := splice(p.w, data.r, nil, n-spliced)
+		if err != nil {
+			return err
+		}
+
+		spliced += written
+	}
+
+	written, err := splice(r.dev, p.r, nil, len(header)+n)
+	if err != nil {
+		return err
+	}
+
+	if written != len(header)+n {
+		return fmt.Errorf("Spliced %d bytes; expected %d", written, len(header)+n)
+	}
+
+	return nil
+}
@@ -0,0 +1,414 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fuse
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"path"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/jacobsa/fuse/fuseops"
+	"github.com/jacobsa/fuse/internal/buffer"
+	"github.com/jacobsa/fuse/internal/fusekernel"
+	"golang.org/x/sys/unix"
+)
+
+func TestReadFromPipe_Write(t *testing.T) {
+	protocol := fusekernel.Protocol{Major: 7, Minor: 31}
+	payload := []byte("taco burrito enchilada")
+
+	// Build a write request.
+	var body bytes.Buffer
+	binary.Write(&body, binary.LittleEndian, fusekernel.WriteIn{
+		Fh:     17,
+		Offset: 23,
+		Size:   uint32(len(payload)),
+	})
+	body.Write(payload)
+
+	h := fusekernel.InHeader{
+		Len:    uint32(fusekernel.InHeaderSize + body.Len()),
+		Opcode: fusekernel.OpWrite,
+		Unique: 1,
+		Nodeid: 2,
+	}
+
+	var msg bytes.Buffer
+	binary.Write(&msg, binary.LittleEndian, h)
+	msg.Write(body.Bytes())
+
+	// Stick it in a pipe, as if spliced from the kernel.
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Pipe: %v", err)
+	}
+
+	p := &pipe{r: r, w: w}
+	defer p.close()
+
+	if _, err := w.Write(msg.Bytes()); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	m := buffer.NewInMessage()
+	if err := readFromPipe(m, p, msg.Len(), protocol); err != nil {
+		t.Fatalf("readFromPipe: %v", err)
+	}
+
+	// The payload should have been left in the pipe.
+	if got, want := m.Pending(), len(payload); got != want {
+		t.Errorf("Pending = %d, want %d", got, want)
+	}
+
+	if n, err := p.buffered(); err != nil || n != len(payload) {
+		t.Errorf("buffered = (%d, %v), want %d", n, err, len(payload))
+	}
+
+	var outMsg buffer.OutMessage
+	outMsg.Reset()
+
+	op, err := convertInMessage(&MountConfig{}, m, &outMsg, protocol, false)
+	if err != nil {
+		t.Fatalf("convertInMessage: %v", err)
+	}
+
+	wo := op.(*fuseops.WriteFileOp)
+	if wo.Data != nil {
+		t.Errorf("Data = %q, want nil", wo.Data)
+	}
+
+	if wo.Handle != 17 || wo.Offset != 23 {
+		t.Errorf("Handle, Offset = %d, %d, want 17, 23", wo.Handle, wo.Offset)
+	}
+}
+
+func TestReadFromPipe_Other(t *testing.T) {
+	protocol := fusekernel.Protocol{Major: 7, Minor: 31}
+
+	var msg bytes.Buffer
+	binary.Write(&msg, binary.LittleEndian, fusekernel.InHeader{
+		Len:    uint32(fusekernel.InHeaderSize) + 4,
+		Opcode: fusekernel.OpLookup,
+		Unique: 1,
+		Nodeid: 1,
+	})
+	msg.WriteString("foo\x00")
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Pipe: %v", err)
+	}
+
+	p := &pipe{r: r, w: w}
+	defer p.close()
+
+	if _, err := w.Write(msg.Bytes()); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	m := buffer.NewInMessage()
+	if err := readFromPipe(m, p, msg.Len(), protocol); err != nil {
+		t.Fatalf("readFromPipe: %v", err)
+	}
+
+	// Everything should have been read.
+	if got := m.Pending(); got != 0 {
+		t.Errorf("Pending = %d, want 0", got)
+	}
+
+	if got, want := m.Len(), uintptr(4); got != want {
+		t.Errorf("Len = %d, want %d", got, want)
+	}
+}
+
+// Create a connection whose device is a FIFO, returning another descriptor for
+// the same FIFO with which the test can play the kernel in both directions.
+// Requests and replies share the FIFO, so each reply must be read before the
+// next request is sent.
+func newFIFOConnection(t *testing.T) (*Connection, *os.File) {
+	name := path.Join(t.TempDir(), "dev")
+	if err := unix.Mkfifo(name, 0600); err != nil {
+		t.Fatalf("Mkfifo: %v", err)
+	}
+
+	// Opening a FIFO for both reading and writing doesn't wait for a peer.
+	dev, err := os.OpenFile(name, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatalf("OpenFile: %v", err)
+	}
+
+	kernel, err := os.OpenFile(name, os.O_RDWR, 0)
+	if err != nil {
+		dev.Close()
+		t.Fatalf("OpenFile: %v", err)
+	}
+
+	t.Cleanup(func() {
+		dev.Close()
+		kernel.Close()
+	})
+
+	// Pipes with room for the largest messages may be bigger than the user is
+	// allowed to create, and the messages here are small.
+	oldPipeSize := pipeSize
+	pipeSize = 1 << 16
+	t.Cleanup(func() { pipeSize = oldPipeSize })
+
+	return NewTestConnection(MountConfig{}, dev), kernel
+}
+
+// Read the next reply, returning its header and body.
+func readReply(t *testing.T, kernel *os.File) (fusekernel.OutHeader, []byte) {
+	t.Helper()
+
+	buf := make([]byte, 4096)
+	kernel.SetReadDeadline(time.Now().Add(10 * time.Second))
+	n, err := kernel.Read(buf)
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+
+	var h fusekernel.OutHeader
+	if err := binary.Read(bytes.NewReader(buf[:n]), binary.LittleEndian, &h); err != nil {
+		t.Fatalf("binary.Read: %v", err)
+	}
+
+	if int(h.Len) != n {
+		t.Errorf("Len = %d, want %d", h.Len, n)
+	}
+
+	return h, buf[buffer.OutMessageHeaderSize:n]
+}
+
+func TestReadSplicedMessage(t *testing.T) {
+	c, kernel := newFIFOConnection(t)
+	r := c.readers[0]
+	payload := "taco burrito enchilada"
+
+	// The data of a write is left in the pipe.
+	sendRequest(
+		t,
+		kernel,
+		1,
+		fusekernel.OpWrite,
+		fusekernel.WriteIn{Fh: 17, Offset: 23, Size: uint32(len(payload))},
+		[]byte(payload))
+
+	m, p, err := r.readSplicedMessage(c.protocol)
+	if err != nil {
+		t.Fatalf("readSplicedMessage: %v", err)
+	}
+
+	if got := m.Header().Opcode; got != fusekernel.OpWrite {
+		t.Errorf("Opcode = %d, want %d", got, fusekernel.OpWrite)
+	}
+
+	if p == nil {
+		t.Fatal("No pipe for a write")
+	}
+
+	data := make([]byte, m.Pending())
+	if _, err := io.ReadFull(p.r, data); err != nil {
+		t.Fatalf("ReadFull: %v", err)
+	}
+
+	if string(data) != payload {
+		t.Errorf("Data = %q, want %q", data, payload)
+	}
+
+	r.putPipe(p)
+	r.putInMessage(m)
+
+	// Other requests are read in full.
+	sendRequest(t, kernel, 2, fusekernel.OpLookup, []byte("foo\x00"))
+
+	m, p, err = r.readSplicedMessage(c.protocol)
+	if err != nil {
+		t.Fatalf("readSplicedMessage: %v", err)
+	}
+
+	if p != nil {
+		t.Errorf("Unexpected pipe for a lookup")
+	}
+
+	if got := m.Header().Unique; got != 2 {
+		t.Errorf("Unique = %d, want 2", got)
+	}
+
+	if got, want := m.Len(), uintptr(4); got != want {
+		t.Errorf("Len = %d, want %d", got, want)
+	}
+}
+
+func TestWriteSplicedMessage(t *testing.T) {
+	c, kernel := newFIFOConnection(t)
+	r := c.readers[0]
+	payload := "taco burrito enchilada"
+
+	data, err := r.getPipe()
+	if err != nil {
+		t.Fatalf("getPipe: %v", err)
+	}
+
+	defer r.putPipe(data)
+
+	if _, err := data.w.Write([]byte(payload)); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	var header bytes.Buffer
+	binary.Write(&header, binary.LittleEndian, fusekernel.OutHeader{
+		Len:    uint32(buffer.OutMessageHeaderSize + len(payload)),
+		Unique: 17,
+	})
+
+	if err := r.writeSplicedMessage(header.Bytes(), data, len(payload)); err != nil {
+		t.Fatalf("writeSplicedMessage: %v", err)
+	}
+
+	h, body := readReply(t, kernel)
+	if h.Unique != 17 || h.Error != 0 {
+		t.Errorf("Header = %+v", h)
+	}
+
+	if string(body) != payload {
+		t.Errorf("Body = %q, want %q", body, payload)
+	}
+
+	// The data pipe has been emptied.
+	if n, err := data.buffered(); err != nil || n != 0 {
+		t.Errorf("buffered = (%d, %v), want 0", n, err)
+	}
+}
+
+func TestSplicedWriteFileOp(t *testing.T) {
+	c, kernel := newFIFOConnection(t)
+	c.spliceWrites = true
+	payload := "taco burrito enchilada"
+
+	sendRequest(
+		t,
+		kernel,
+		1,
+		fusekernel.OpWrite,
+		fusekernel.WriteIn{Fh: 17, Offset: 23, Size: uint32(len(payload))},
+		[]byte(payload))
+
+	ctx, op, err := c.ReadOp()
+	if err != nil {
+		t.Fatalf("ReadOp: %v", err)
+	}
+
+	wo, ok := op.(*fuseops.WriteFileOp)
+	if !ok {
+		t.Fatalf("Unexpected op type: %T", op)
+	}
+
+	if wo.Handle != 17 || wo.Offset != 23 || wo.Data != nil {
+		t.Errorf("op = %+v", *wo)
+	}
+
+	if wo.Spliced == nil || wo.Spliced.Size != len(payload) {
+		t.Fatalf("Spliced = %+v", wo.Spliced)
+	}
+
+	// The file system moves the data on from the pipe.
+	data := make([]byte, wo.Spliced.Size)
+	if _, err := io.ReadFull(wo.Spliced.Pipe, data); err != nil {
+		t.Fatalf("ReadFull: %v", err)
+	}
+
+	if string(data) != payload {
+		t.Errorf("Data = %q, want %q", data, payload)
+	}
+
+	c.Reply(ctx, nil)
+
+	// The kernel hears that all of the data was written.
+	h, body := readReply(t, kernel)
+	if h.Unique != 1 || h.Error != 0 {
+		t.Errorf("Header = %+v", h)
+	}
+
+	if got, want := len(body), int(unsafe.Sizeof(fusekernel.WriteOut{})); got != want {
+		t.Fatalf("len = %d, want %d", got, want)
+	}
+
+	if out := (*fusekernel.WriteOut)(unsafe.Pointer(&body[0])); out.Size != uint32(len(payload)) {
+		t.Errorf("Size = %d, want %d", out.Size, len(payload))
+	}
+}
+
+func TestSplicedReadFileOp(t *testing.T) {
+	c, kernel := newFIFOConnection(t)
+	c.spliceReads = true
+
+	f, err := os.Create(path.Join(t.TempDir(), "foo"))
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	defer f.Close()
+
+	if _, err := f.WriteString("tacoburrito"); err != nil {
+		t.Fatalf("WriteString: %v", err)
+	}
+
+	testCases := []struct {
+		size uint32
+		want string
+	}{
+		// The range is cut down to the size of the read.
+		{4, "cobu"},
+
+		// A range past the end of the file gives a short read.
+		{100, "coburrito"},
+	}
+
+	for i, tc := range testCases {
+		unique := uint64(i + 1)
+		sendRequest(t, kernel, unique, fusekernel.OpRead, fusekernel.ReadIn{
+			Fh:   3,
+			Size: tc.size,
+		})
+
+		ctx, op, err := c.ReadOp()
+		if err != nil {
+			t.Fatalf("ReadOp: %v", err)
+		}
+
+		ro, ok := op.(*fuseops.ReadFileOp)
+		if !ok {
+			t.Fatalf("Unexpected op type: %T", op)
+		}
+
+		ro.FileData = &fuseops.FileRange{File: f, Offset: 2, Size: 1000}
+		c.Reply(ctx, nil)
+
+		h, body := readReply(t, kernel)
+		if h.Unique != unique || h.Error != 0 {
+			t.Errorf("%d: Header = %+v", i, h)
+		}
+
+		if string(body) != tc.want {
+			t.Errorf("%d: Body = %q, want %q", i, body, tc.want)
+		}
+	}
+}
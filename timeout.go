@@ -0,0 +1,175 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fuse
+
+import (
+	"context"
+	"log/slog"
+	"runtime"
+	"syscall"
+	"time"
+
+	"github.com/jacobsa/fuse/fuseops"
+)
+
+// Return whether the op may be timed out. Those whose reply gives the kernel
+// a reference to an inode or a handle may not: the kernel wouldn't know about
+// it if the file system succeeded after the timeout, and would never forget or
+// release it.
+func mayTimeOut(op interface{}) bool {
+	switch op.(type) {
+	case *fuseops.LookUpInodeOp,
+		*fuseops.MkDirOp,
+		*fuseops.MkNodeOp,
+		*fuseops.CreateFileOp,
+		*fuseops.CreateSymlinkOp,
+		*fuseops.CreateLinkOp,
+		*fuseops.ReadDirPlusOp,
+		*fuseops.OpenFileOp,
+		*fuseops.OpenDirOp:
+		return false
+	}
+
+	return true
+}
+
+// Reply to an op whose timeout (see MountConfig.OpTimeout) expired before the
+// file system replied, unless it has since done so. The op's messages are
+// left alone, since the file system may still be using them; they are
+// reclaimed when it eventually calls Reply.
+//
+// LOCKS_EXCLUDED(c.mu)
+func (c *Connection) timeOut(ctx context.Context) {
+	// If Reply gets to the op first its messages may be reused at any moment,
+	// so stick to the in-flight state.
+	state := ctx.Value(contextKey).(opState)
+	f := state.flight
+	if !f.replied.CompareAndSwap(false, true) {
+		return
+	}
+
+	fuseID := f.fuseID
+	latency := time.Since(f.start)
+
+	// The kernel considers a handle released whatever the reply, so stop
+	// waiting for it before checking whether the connection has drained.
+	switch f.op.(type) {
+	case *fuseops.ReleaseFileHandleOp, *fuseops.ReleaseDirHandleOp:
+		c.trackHandles(f.op, nil)
+	}
+
+	// Forget about the op, cancelling its context in case the deadline hasn't
+	// done so yet, so that the kernel may reuse its ID.
+	c.finishOp(f.opCode, fuseID)
+
+	c.log(
+		slog.LevelError,
+		1,
+		"timed out",
+		append(
+			opAttrs(fuseID, f.inode, f.op),
+			slog.Duration(logKeyDuration, latency))...)
+
+	// Send the error in a message of our own.
+	r := state.reader
+	m := r.getOutMessage()
+	defer r.putOutMessage(m)
+
+	h := m.OutHeader()
+	h.Unique = fuseID
+	h.Error = -int32(syscall.ETIMEDOUT)
+	h.Len = uint32(m.Len())
+
+	if err := r.writeMessage(m.OutHeaderBytes()); err != nil {
+		c.log(
+			slog.LevelError,
+			1,
+			"writeMessage",
+			slog.Uint64(logKeyUnique, fuseID),
+			slog.Any(logKeyError, err))
+	}
+
+	if c.cfg.OpTracer != nil {
+		c.cfg.OpTracer.FinishOp(ctx, state.traceInfo, latency, syscall.ETIMEDOUT)
+	}
+}
+
+// Check every so often for ops that have been in flight for longer than the
+// threshold, until the connection is closed. See
+// MountConfig.WatchdogThreshold.
+func (c *Connection) watchdog(threshold time.Duration) {
+	ticker := time.NewTicker(threshold / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.closed:
+			return
+
+		case <-ticker.C:
+			c.reportStuckOps(threshold)
+		}
+	}
+}
+
+// Log each op that has been in flight for longer than the threshold and
+// hasn't already been reported, followed by the stacks of all goroutines if
+// there were any.
+//
+// LOCKS_EXCLUDED(c.mu)
+func (c *Connection) reportStuckOps(threshold time.Duration) {
+	var stuck [][]slog.Attr
+
+	c.mu.Lock()
+	for fuseID, f := range c.inFlight {
+		age := time.Since(f.start)
+		if f.reported || age < threshold {
+			continue
+		}
+
+		f.reported = true
+		stuck = append(stuck, append(
+			opAttrs(fuseID, f.inode, f.op),
+			slog.Duration(logKeyDuration, age)))
+	}
+	c.mu.Unlock()
+
+	if len(stuck) == 0 {
+		return
+	}
+
+	for _, attrs := range stuck {
+		c.log(slog.LevelError, 1, "stuck", attrs...)
+	}
+
+	c.log(
+		slog.LevelError,
+		1,
+		"goroutines",
+		slog.String(logKeyStacks, allStacks()))
+}
+
+// Return the stacks of all goroutines, as formatted by runtime.Stack.
+func allStacks() string {
+	buf := make([]byte, 1<<16)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			return string(buf[:n])
+		}
+
+		buf = make([]byte, 2*len(buf))
+	}
+}
@@ -0,0 +1,210 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fuse
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"log/slog"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/jacobsa/fuse/fuseops"
+	"github.com/jacobsa/fuse/internal/fusekernel"
+)
+
+// Set up an op on the connection the way ReadOp would, but without starting
+// its timer.
+func beginTestOp(t *testing.T, c *Connection, op interface{}) context.Context {
+	inMsg := newInMessage(t, fusekernel.OpLookup, []byte("taco\x00"))
+	r := c.readers[0]
+
+	ctx, flight := c.beginOp(
+		inMsg.Header().Opcode,
+		inMsg.Header().Unique,
+		fuseops.RootInodeID,
+		op,
+		time.Now())
+
+	return context.WithValue(ctx, contextKey, opState{
+		inMsg:  inMsg,
+		outMsg: r.getOutMessage(),
+		op:     op,
+		reader: r,
+		start:  flight.start,
+		flight: flight,
+	})
+}
+
+func TestOpTimeout(t *testing.T) {
+	c, r := newPipeConnection(t)
+	c.inFlight = make(map[uint64]*inFlightOp)
+	c.cfg.OpContext = context.Background()
+	c.cfg.OpTimeout = func(op interface{}) time.Duration { return time.Hour }
+
+	ctx := beginTestOp(t, c, &fuseops.GetInodeAttributesOp{Inode: fuseops.RootInodeID})
+	if _, ok := ctx.Deadline(); !ok {
+		t.Fatalf("No deadline on the op's context")
+	}
+
+	c.timeOut(ctx)
+
+	if ctx.Err() == nil {
+		t.Errorf("The op's context wasn't cancelled")
+	}
+
+	if len(c.inFlight) != 0 {
+		t.Errorf("The op is still in flight")
+	}
+
+	// The kernel should have been told that the op timed out.
+	buf := make([]byte, 4096)
+	n, err := r.Read(buf)
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+
+	var h fusekernel.OutHeader
+	if err := binary.Read(bytes.NewReader(buf[:n]), binary.LittleEndian, &h); err != nil {
+		t.Fatalf("binary.Read: %v", err)
+	}
+
+	want := fusekernel.OutHeader{
+		Len:    uint32(n),
+		Error:  -int32(syscall.ETIMEDOUT),
+		Unique: 1,
+	}
+
+	if h != want {
+		t.Errorf("h = %+v, want %+v", h, want)
+	}
+
+	// The file system's eventual reply should be dropped, so that the next
+	// thing the kernel sees is this notification.
+	c.Reply(ctx, nil)
+	if err := c.InvalidateInode(17, 0, 0); err != nil {
+		t.Fatalf("InvalidateInode: %v", err)
+	}
+
+	readNotification(t, r)
+}
+
+func TestNoTimeoutForOpsGivingReferences(t *testing.T) {
+	c, _ := newPipeConnection(t)
+	c.inFlight = make(map[uint64]*inFlightOp)
+	c.cfg.OpContext = context.Background()
+	c.cfg.OpTimeout = func(op interface{}) time.Duration { return time.Hour }
+
+	// Were these to succeed after timing out, the file system would be left
+	// with a lookup count or a handle that the kernel doesn't know about.
+	ops := []interface{}{
+		&fuseops.LookUpInodeOp{},
+		&fuseops.MkDirOp{},
+		&fuseops.MkNodeOp{},
+		&fuseops.CreateFileOp{},
+		&fuseops.CreateSymlinkOp{},
+		&fuseops.CreateLinkOp{},
+		&fuseops.ReadDirPlusOp{},
+		&fuseops.OpenFileOp{},
+		&fuseops.OpenDirOp{},
+	}
+
+	for _, op := range ops {
+		ctx := beginTestOp(t, c, op)
+		if _, ok := ctx.Deadline(); ok {
+			t.Errorf("%T has a deadline", op)
+		}
+
+		if f := ctx.Value(contextKey).(opState).flight; f.timeout != 0 {
+			t.Errorf("%T has timeout %v", op, f.timeout)
+		}
+
+		c.Reply(ctx, syscall.ENOENT)
+	}
+}
+
+func TestTimedOutReleaseDrains(t *testing.T) {
+	testCases := []struct {
+		open    interface{}
+		release interface{}
+	}{
+		{
+			&fuseops.OpenFileOp{Inode: 17, Handle: 3},
+			&fuseops.ReleaseFileHandleOp{Handle: 3},
+		},
+		{
+			&fuseops.OpenDirOp{Inode: 17, Handle: 3},
+			&fuseops.ReleaseDirHandleOp{Handle: 3},
+		},
+	}
+
+	for _, tc := range testCases {
+		c, _ := newPipeConnection(t)
+		c.inFlight = make(map[uint64]*inFlightOp)
+		c.cfg.OpContext = context.Background()
+		c.cfg.OpTimeout = func(op interface{}) time.Duration { return time.Hour }
+
+		c.Reply(beginTestOp(t, c, tc.open), nil)
+		drained := c.startDraining()
+
+		// The kernel has given up on the release, so the handle is gone as far
+		// as it is concerned, even though the file system hasn't replied.
+		ctx := beginTestOp(t, c, tc.release)
+		c.timeOut(ctx)
+
+		if !isClosed(drained) {
+			files, dirs, ops := c.drainStatus()
+			t.Errorf("%T: not drained; files %v, dirs %v, ops %v", tc.release, files, dirs, ops)
+		}
+
+		c.Reply(ctx, nil)
+	}
+}
+
+func TestWatchdog(t *testing.T) {
+	var buf bytes.Buffer
+	c, _ := newPipeConnection(t)
+	c.inFlight = make(map[uint64]*inFlightOp)
+	c.cfg.OpContext = context.Background()
+	c.logHandler = slog.NewTextHandler(&buf, nil)
+
+	beginTestOp(t, c, &fuseops.LookUpInodeOp{Parent: fuseops.RootInodeID, Name: "taco"})
+
+	// Nothing is stuck yet.
+	c.reportStuckOps(time.Hour)
+	if buf.Len() != 0 {
+		t.Fatalf("Unexpected output: %q", buf.String())
+	}
+
+	c.reportStuckOps(0)
+	out := buf.String()
+	if !strings.Contains(out, "msg=stuck op=LookUpInode unique=1") {
+		t.Errorf("Stuck op not reported: %q", out)
+	}
+
+	if !strings.Contains(out, "TestWatchdog") {
+		t.Errorf("Goroutine stacks not reported: %q", out)
+	}
+
+	// Each op is reported only once.
+	buf.Reset()
+	c.reportStuckOps(0)
+	if buf.Len() != 0 {
+		t.Errorf("Unexpected output: %q", buf.String())
+	}
+}
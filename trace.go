@@ -0,0 +1,206 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fuse
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/jacobsa/fuse/fuseops"
+)
+
+// OpInfo describes an op for the benefit of an OpTracer.
+type OpInfo struct {
+	// The name of the op's type, without the "Op" suffix, e.g. "LookUpInode".
+	Name string
+
+	// The "unique" ID that the kernel assigned to the request.
+	FuseID uint64
+
+	// The inode named in the request's header. For ops that concern a child
+	// of a directory, such as LookUpInodeOp, this is the parent.
+	Inode fuseops.InodeID
+
+	// The op itself, e.g. a *fuseops.LookUpInodeOp. Tracers must not modify
+	// it, or retain it past the call to FinishOp.
+	Op interface{}
+}
+
+// OpTracer is notified at the start and end of every op that the Connection
+// returns from ReadOp, for feeding metrics systems and distributed tracing.
+// See MountConfig.OpTracer.
+//
+// Its methods may be called concurrently, and should be fast; they are called
+// inline with reading ops and sending replies.
+type OpTracer interface {
+	// StartOp is called when an op has been read from the kernel, before it is
+	// returned by ReadOp. The context it returns is the one handed to the file
+	// system, so a tracer may use it to carry e.g. a span.
+	StartOp(ctx context.Context, info OpInfo) context.Context
+
+	// FinishOp is called when the op has been replied to, with a context
+	// derived from the one returned by StartOp, the time elapsed since
+	// StartOp, and the errno sent to the kernel (zero for success, and for
+	// ops such as ForgetInodeOp that get no reply).
+	FinishOp(
+		ctx context.Context,
+		info OpInfo,
+		latency time.Duration,
+		errno syscall.Errno)
+}
+
+// OpStats holds the statistics that an OpCounter keeps for a single type of
+// op.
+type OpStats struct {
+	// The number of ops that finished, and how many of them failed.
+	Count  uint64
+	Errors uint64
+
+	// A count of the failed ops by errno.
+	Errnos map[syscall.Errno]uint64
+
+	// The total and maximum time between StartOp and FinishOp.
+	TotalLatency time.Duration
+	MaxLatency   time.Duration
+}
+
+// OpCounter is an OpTracer that keeps per-op-type counts and latencies in
+// memory. Create one with NewOpCounter, and read it with Snapshot or Dump.
+type OpCounter struct {
+	mu sync.Mutex
+
+	// GUARDED_BY(mu)
+	stats map[string]*OpStats
+}
+
+var _ OpTracer = &OpCounter{}
+
+// NewOpCounter creates an empty OpCounter.
+func NewOpCounter() *OpCounter {
+	return &OpCounter{
+		stats: make(map[string]*OpStats),
+	}
+}
+
+// StartOp does nothing; everything is recorded by FinishOp.
+func (c *OpCounter) StartOp(
+	ctx context.Context,
+	info OpInfo) context.Context {
+	return ctx
+}
+
+// LOCKS_EXCLUDED(c.mu)
+func (c *OpCounter) FinishOp(
+	ctx context.Context,
+	info OpInfo,
+	latency time.Duration,
+	errno syscall.Errno) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s := c.stats[info.Name]
+	if s == nil {
+		s = &OpStats{Errnos: make(map[syscall.Errno]uint64)}
+		c.stats[info.Name] = s
+	}
+
+	s.Count++
+	if errno != 0 {
+		s.Errors++
+		s.Errnos[errno]++
+	}
+
+	s.TotalLatency += latency
+	if latency > s.MaxLatency {
+		s.MaxLatency = latency
+	}
+}
+
+// Snapshot returns a copy of the statistics gathered so far, keyed by op name
+// (see OpInfo.Name).
+//
+// LOCKS_EXCLUDED(c.mu)
+func (c *OpCounter) Snapshot() map[string]OpStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	m := make(map[string]OpStats, len(c.stats))
+	for name, s := range c.stats {
+		cp := *s
+		cp.Errnos = make(map[syscall.Errno]uint64, len(s.Errnos))
+		for errno, n := range s.Errnos {
+			cp.Errnos[errno] = n
+		}
+
+		m[name] = cp
+	}
+
+	return m
+}
+
+// Reset discards the statistics gathered so far.
+//
+// LOCKS_EXCLUDED(c.mu)
+func (c *OpCounter) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.stats = make(map[string]*OpStats)
+}
+
+// Dump writes a human-readable table of the statistics gathered so far to w,
+// one line per op type in order of name.
+func (c *OpCounter) Dump(w io.Writer) error {
+	snapshot := c.Snapshot()
+
+	names := make([]string, 0, len(snapshot))
+	for name := range snapshot {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	_, err := fmt.Fprintf(
+		w,
+		"%-20s %10s %10s %12s %12s\n",
+		"op", "count", "errors", "mean", "max")
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		s := snapshot[name]
+		mean := s.TotalLatency / time.Duration(s.Count)
+
+		_, err := fmt.Fprintf(
+			w,
+			"%-20s %10d %10d %12v %12v\n",
+			name,
+			s.Count,
+			s.Errors,
+			mean,
+			s.MaxLatency)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
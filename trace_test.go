@@ -0,0 +1,78 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fuse
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/jacobsa/fuse/fuseops"
+)
+
+func TestOpCounter(t *testing.T) {
+	c := NewOpCounter()
+	ctx := context.Background()
+
+	lookUp := OpInfo{Name: "LookUpInode", FuseID: 1, Op: &fuseops.LookUpInodeOp{}}
+	getAttr := OpInfo{Name: "GetInodeAttributes", FuseID: 2, Op: &fuseops.GetInodeAttributesOp{}}
+
+	c.FinishOp(c.StartOp(ctx, lookUp), lookUp, 2*time.Millisecond, 0)
+	c.FinishOp(c.StartOp(ctx, lookUp), lookUp, 4*time.Millisecond, syscall.ENOENT)
+	c.FinishOp(c.StartOp(ctx, getAttr), getAttr, time.Millisecond, 0)
+
+	snapshot := c.Snapshot()
+	if len(snapshot) != 2 {
+		t.Fatalf("len(snapshot) = %d, want 2", len(snapshot))
+	}
+
+	s := snapshot["LookUpInode"]
+	if s.Count != 2 || s.Errors != 1 || s.Errnos[syscall.ENOENT] != 1 {
+		t.Errorf("LookUpInode stats = %+v", s)
+	}
+
+	if s.TotalLatency != 6*time.Millisecond || s.MaxLatency != 4*time.Millisecond {
+		t.Errorf("LookUpInode latencies = %v, %v", s.TotalLatency, s.MaxLatency)
+	}
+
+	// The snapshot must not alias the counter's state.
+	s.Errnos[syscall.EIO] = 1
+	if c.Snapshot()["LookUpInode"].Errnos[syscall.EIO] != 0 {
+		t.Errorf("Snapshot aliases the counter's errno map")
+	}
+
+	var buf bytes.Buffer
+	if err := c.Dump(&buf); err != nil {
+		t.Fatalf("Dump: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("Dump output has %d lines:\n%s", len(lines), buf.String())
+	}
+
+	if !strings.HasPrefix(lines[1], "GetInodeAttributes ") ||
+		!strings.HasPrefix(lines[2], "LookUpInode ") {
+		t.Errorf("Dump output not sorted by name:\n%s", buf.String())
+	}
+
+	c.Reset()
+	if len(c.Snapshot()) != 0 {
+		t.Errorf("Snapshot not empty after Reset")
+	}
+}
@@ -7,11 +7,19 @@ import (
 )
 
 func unmount(dir string) error {
+	return runFusermount("-u", dir)
+}
+
+func unmountLazily(dir string) error {
+	return runFusermount("-u", "-z", dir)
+}
+
+func runFusermount(args ...string) error {
 	fusermount, err := findFusermount()
 	if err != nil {
 		return err
 	}
-	cmd := exec.Command(fusermount, "-u", dir)
+	cmd := exec.Command(fusermount, args...)
 	output, err := cmd.CombinedOutput()
 	if err != nil {
 		if len(output) > 0 {
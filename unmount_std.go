@@ -6,6 +6,8 @@ package fuse
 import (
 	"os"
 	"syscall"
+
+	"golang.org/x/sys/unix"
 )
 
 func unmount(dir string) error {
@@ -15,3 +17,11 @@ func unmount(dir string) error {
 
 	return nil
 }
+
+func unmountLazily(dir string) error {
+	if err := unix.Unmount(dir, unix.MNT_FORCE); err != nil {
+		return &os.PathError{Op: "unmount", Path: dir, Err: err}
+	}
+
+	return nil
+}